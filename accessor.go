@@ -0,0 +1,412 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/bbredesen/gltf"
+)
+
+func componentSize(compType gltf.ComponentTypeEnum) int {
+	switch compType {
+	case gltf.BYTE, gltf.UNSIGNED_BYTE:
+		return 1
+	case gltf.SHORT, gltf.UNSIGNED_SHORT:
+		return 2
+	case gltf.UNSIGNED_INT, gltf.FLOAT:
+		return 4
+	}
+	return 0
+}
+
+func componentCount(accType gltf.AccessorTypeEnum) int {
+	switch accType {
+	case gltf.SCALAR:
+		return 1
+	case gltf.VEC2:
+		return 2
+	case gltf.VEC3:
+		return 3
+	case gltf.VEC4, gltf.MAT2:
+		return 4
+	case gltf.MAT3:
+		return 9
+	case gltf.MAT4:
+		return 16
+	}
+	return 0
+}
+
+func elementSize(acc *gltf.ResolvedAccessor) int {
+	return componentSize(acc.ComponentType) * componentCount(acc.Type)
+}
+
+// accessorStride returns the distance in bytes between consecutive elements of an accessor in its buffer view. The
+// stride comes from the buffer view if it is set, otherwise the elements are tightly packed.
+func accessorStride(acc *gltf.ResolvedAccessor) int {
+	if acc.BufferView != nil && acc.BufferView.ByteStride != 0 {
+		return acc.BufferView.ByteStride
+	}
+	return elementSize(acc)
+}
+
+// checkAccessor returns an error if reading every element of an accessor would go outside its buffer view, or the
+// view outside its buffer, so that the readers below can index the data without further checks. validate reports the
+// same problems in more detail, see checkAccessorBounds.
+func checkAccessor(doc *gltf.ResolvedGlTF, acc *gltf.ResolvedAccessor) error {
+	size := elementSize(acc)
+	if size == 0 {
+		return fmt.Errorf("invalid accessor type %v or component type %s", acc.Type, componentTypeName(acc.ComponentType))
+	}
+	if acc.Count < 0 {
+		return fmt.Errorf("invalid accessor count %d", acc.Count)
+	}
+	if acc.BufferView == nil || acc.Count == 0 {
+		return nil
+	}
+
+	stride := accessorStride(acc)
+	if stride < size {
+		return fmt.Errorf("byteStride %d is less than the element size %d", stride, size)
+	}
+	return checkViewRange(doc, acc.BufferView, acc.ByteOffset, stride*(acc.Count-1)+size)
+}
+
+// checkViewRange returns an error unless length bytes at offset lie within a buffer view, and the view lies within
+// its buffer.
+func checkViewRange(doc *gltf.ResolvedGlTF, view *gltf.ResolvedBufferView, offset, length int) error {
+	buffer := view.BufferView.Buffer
+	if buffer < 0 || buffer >= len(doc.Buffers) {
+		return fmt.Errorf("buffer view refers to buffer %d, which does not exist", buffer)
+	}
+	if bufLen := len(doc.Buffers[buffer].Data); view.ByteOffset < 0 || view.ByteLength < 0 || view.ByteOffset+view.ByteLength > bufLen {
+		return fmt.Errorf("buffer view bytes %d..%d are outside buffer %d, which has %d bytes",
+			view.ByteOffset, view.ByteOffset+view.ByteLength, buffer, bufLen)
+	}
+	if offset < 0 || offset+length > view.ByteLength {
+		return fmt.Errorf("data needs bytes %d..%d of its buffer view, which has %d bytes", offset, offset+length, view.ByteLength)
+	}
+	return nil
+}
+
+// accessorView returns the bytes of the buffer view from where the accessor starts, and the distance in bytes between
+// consecutive elements. Sparse accessors, and accessors without a buffer view, are materialized into a new, tightly
+// packed slice. The accessor is checked first, so every element lies within the returned data.
+func accessorView(doc *gltf.ResolvedGlTF, acc *gltf.ResolvedAccessor) (data []byte, stride int, err error) {
+	if err := checkAccessor(doc, acc); err != nil {
+		return nil, 0, err
+	}
+	if acc.Sparse != nil || acc.BufferView == nil {
+		data, err := materializeAccessor(doc, acc)
+		return data, elementSize(acc), err
+	}
+
+	view := acc.BufferView
+	buf := doc.Buffers[view.BufferView.Buffer].Data
+	return buf[view.ByteOffset+acc.ByteOffset : view.ByteOffset+view.ByteLength], accessorStride(acc), nil
+}
+
+// materializeAccessor returns the elements of an accessor tightly packed, in their stored component type. Elements
+// come from the buffer view, or are zero if there is none, and then any sparse values replace the elements at their
+// indices. The accessor must have passed checkAccessor; the sparse indices and values are checked here.
+func materializeAccessor(doc *gltf.ResolvedGlTF, acc *gltf.ResolvedAccessor) ([]byte, error) {
+	size := elementSize(acc)
+	rval := make([]byte, acc.Count*size)
+
+	if acc.BufferView != nil {
+		buf := doc.Buffers[acc.BufferView.BufferView.Buffer].Data
+		data := buf[acc.BufferView.ByteOffset+acc.ByteOffset:]
+		stride := accessorStride(acc)
+		for i := 0; i < acc.Count; i++ {
+			copy(rval[i*size:(i+1)*size], data[i*stride:])
+		}
+	}
+
+	sparse := acc.Sparse
+	if sparse == nil {
+		return rval, nil
+	}
+
+	if sparse.Count < 1 || sparse.Count > acc.Count {
+		return nil, fmt.Errorf("sparse count %d is not in 1..%d", sparse.Count, acc.Count)
+	}
+	indexView, valueView := sparse.Indices.BufferView, sparse.Values.BufferView
+	if indexView == nil || valueView == nil {
+		return nil, errors.New("sparse indices or values have no buffer view")
+	}
+	switch sparse.Indices.ComponentType {
+	case gltf.UNSIGNED_BYTE, gltf.UNSIGNED_SHORT, gltf.UNSIGNED_INT:
+	default:
+		return nil, fmt.Errorf("invalid sparse index component type %s", componentTypeName(sparse.Indices.ComponentType))
+	}
+	indexSize := componentSize(sparse.Indices.ComponentType)
+	if err := checkViewRange(doc, indexView, sparse.Indices.ByteOffset, sparse.Count*indexSize); err != nil {
+		return nil, fmt.Errorf("sparse indices: %w", err)
+	}
+	if err := checkViewRange(doc, valueView, sparse.Values.ByteOffset, sparse.Count*size); err != nil {
+		return nil, fmt.Errorf("sparse values: %w", err)
+	}
+
+	values := doc.Buffers[valueView.BufferView.Buffer].Data[valueView.ByteOffset+sparse.Values.ByteOffset:]
+
+	indices := readSparseIndices(doc, acc)
+	for k, index := range indices {
+		if index >= acc.Count {
+			return nil, fmt.Errorf("sparse index %d is out of range for %d elements", index, acc.Count)
+		}
+		if k > 0 && index <= indices[k-1] {
+			return nil, fmt.Errorf("sparse index %d follows %d, indices must be strictly increasing", index, indices[k-1])
+		}
+		copy(rval[index*size:(index+1)*size], values[k*size:])
+	}
+
+	return rval, nil
+}
+
+// readSparseIndices returns the sparse indices of an accessor. Their component type and range in the buffer view must
+// already have been checked.
+func readSparseIndices(doc *gltf.ResolvedGlTF, acc *gltf.ResolvedAccessor) []int {
+	sparse := acc.Sparse
+	view := sparse.Indices.BufferView
+	data := doc.Buffers[view.BufferView.Buffer].Data[view.ByteOffset+sparse.Indices.ByteOffset:]
+	indexSize := componentSize(sparse.Indices.ComponentType)
+
+	rval := make([]int, sparse.Count)
+	for k := range rval {
+		switch sparse.Indices.ComponentType {
+		case gltf.UNSIGNED_BYTE:
+			rval[k] = int(data[k])
+		case gltf.UNSIGNED_SHORT:
+			rval[k] = int(binary.LittleEndian.Uint16(data[k*indexSize:]))
+		case gltf.UNSIGNED_INT:
+			rval[k] = int(binary.LittleEndian.Uint32(data[k*indexSize:]))
+		}
+	}
+	return rval
+}
+
+// readVec3 reads a VEC3 accessor, e.g. POSITION or NORMAL, into host memory. Integer components, used by quantized
+// meshes, are normalized if the accessor is. Like the other readers below, it returns nothing if acc is nil or has the
+// wrong type, and an error if the data is out of range, see checkAccessor.
+func readVec3(doc *gltf.ResolvedGlTF, acc *gltf.ResolvedAccessor) ([][3]float32, error) {
+	if acc == nil || acc.Type != gltf.VEC3 {
+		return nil, nil
+	}
+
+	data, stride, err := accessorView(doc, acc)
+	if err != nil {
+		return nil, err
+	}
+	rval := make([][3]float32, acc.Count)
+	for i := range rval {
+		elem := data[i*stride:]
+		for c := 0; c < 3; c++ {
+			rval[i][c] = readComponent(elem, acc.ComponentType, acc.Normalized, c)
+		}
+	}
+	return rval, nil
+}
+
+// readTangent reads a TANGENT accessor, which is a VEC4 of FLOAT, or of normalized BYTE or SHORT in quantized meshes,
+// with the bitangent sign in w.
+func readTangent(doc *gltf.ResolvedGlTF, acc *gltf.ResolvedAccessor) ([][4]float32, error) {
+	if acc == nil || acc.Type != gltf.VEC4 {
+		return nil, nil
+	}
+
+	data, stride, err := accessorView(doc, acc)
+	if err != nil {
+		return nil, err
+	}
+	rval := make([][4]float32, acc.Count)
+	for i := range rval {
+		elem := data[i*stride:]
+		for c := 0; c < 4; c++ {
+			rval[i][c] = readComponent(elem, acc.ComponentType, acc.Normalized, c)
+		}
+	}
+	return rval, nil
+}
+
+// readIndices reads an index accessor of any of the allowed component types, widening the values to uint32.
+func readIndices(doc *gltf.ResolvedGlTF, acc *gltf.ResolvedAccessor) ([]uint32, error) {
+	if acc == nil {
+		return nil, nil
+	}
+
+	data, stride, err := accessorView(doc, acc)
+	if err != nil {
+		return nil, err
+	}
+	rval := make([]uint32, acc.Count)
+	for i := range rval {
+		elem := data[i*stride:]
+		switch acc.ComponentType {
+		case gltf.UNSIGNED_BYTE:
+			rval[i] = uint32(elem[0])
+		case gltf.UNSIGNED_SHORT:
+			rval[i] = uint32(binary.LittleEndian.Uint16(elem))
+		case gltf.UNSIGNED_INT:
+			rval[i] = binary.LittleEndian.Uint32(elem)
+		}
+	}
+	return rval, nil
+}
+
+// attributeError adds the name of a primitive attribute to an error from reading it. It returns nil if err is nil.
+func attributeError(key gltf.AttributeKey, err error) error {
+	if err == nil {
+		return nil
+	}
+	return fmt.Errorf("%s: %w", key, err)
+}
+
+// readComponent reads component i of an element as a float. If normalized is set, integer components are normalized
+// per the glTF rules for normalized accessors, e.g. UNSIGNED_BYTE maps 0..255 to 0..1 and SHORT maps -32767..32767 to
+// -1..1. Otherwise they are converted to float unchanged.
+func readComponent(elem []byte, compType gltf.ComponentTypeEnum, normalized bool, i int) float32 {
+	if !normalized {
+		switch compType {
+		case gltf.UNSIGNED_BYTE:
+			return float32(elem[i])
+		case gltf.BYTE:
+			return float32(int8(elem[i]))
+		case gltf.UNSIGNED_SHORT:
+			return float32(binary.LittleEndian.Uint16(elem[i*2:]))
+		case gltf.SHORT:
+			return float32(int16(binary.LittleEndian.Uint16(elem[i*2:])))
+		case gltf.UNSIGNED_INT:
+			return float32(binary.LittleEndian.Uint32(elem[i*4:]))
+		}
+	}
+
+	switch compType {
+	case gltf.FLOAT:
+		return math.Float32frombits(binary.LittleEndian.Uint32(elem[i*4:]))
+	case gltf.UNSIGNED_BYTE:
+		return float32(elem[i]) / 255
+	case gltf.BYTE:
+		return float32(math.Max(float64(int8(elem[i]))/127, -1))
+	case gltf.UNSIGNED_SHORT:
+		return float32(binary.LittleEndian.Uint16(elem[i*2:])) / 65535
+	case gltf.SHORT:
+		return float32(math.Max(float64(int16(binary.LittleEndian.Uint16(elem[i*2:])))/32767, -1))
+	}
+	return 0
+}
+
+// readColor reads a COLOR_n accessor, which may be a VEC3 or VEC4 of FLOAT or of normalized UNSIGNED_BYTE or
+// UNSIGNED_SHORT. VEC3 colors get an alpha of 1. Integer colors are always normalized, even if the file leaves out the
+// flag the spec requires.
+func readColor(doc *gltf.ResolvedGlTF, acc *gltf.ResolvedAccessor) ([][4]float32, error) {
+	if acc == nil || (acc.Type != gltf.VEC3 && acc.Type != gltf.VEC4) {
+		return nil, nil
+	}
+
+	n := componentCount(acc.Type)
+	data, stride, err := accessorView(doc, acc)
+	if err != nil {
+		return nil, err
+	}
+	rval := make([][4]float32, acc.Count)
+	for i := range rval {
+		elem := data[i*stride:]
+		rval[i][3] = 1
+		for c := 0; c < n; c++ {
+			rval[i][c] = readComponent(elem, acc.ComponentType, true, c)
+		}
+	}
+	return rval, nil
+}
+
+// readTexCoord reads a TEXCOORD_n accessor, which may be a VEC2 of FLOAT or of normalized UNSIGNED_BYTE or
+// UNSIGNED_SHORT, or in quantized meshes of BYTE or SHORT, normalized or not.
+func readTexCoord(doc *gltf.ResolvedGlTF, acc *gltf.ResolvedAccessor) ([][2]float32, error) {
+	if acc == nil || acc.Type != gltf.VEC2 {
+		return nil, nil
+	}
+
+	data, stride, err := accessorView(doc, acc)
+	if err != nil {
+		return nil, err
+	}
+	rval := make([][2]float32, acc.Count)
+	for i := range rval {
+		elem := data[i*stride:]
+		for c := 0; c < 2; c++ {
+			rval[i][c] = readComponent(elem, acc.ComponentType, acc.Normalized, c)
+		}
+	}
+	return rval, nil
+}
+
+// readJoints reads a JOINTS_n accessor, which is a VEC4 of UNSIGNED_BYTE or UNSIGNED_SHORT joint indices.
+func readJoints(doc *gltf.ResolvedGlTF, acc *gltf.ResolvedAccessor) ([][4]uint32, error) {
+	if acc == nil || acc.Type != gltf.VEC4 {
+		return nil, nil
+	}
+
+	data, stride, err := accessorView(doc, acc)
+	if err != nil {
+		return nil, err
+	}
+	rval := make([][4]uint32, acc.Count)
+	for i := range rval {
+		elem := data[i*stride:]
+		for c := 0; c < 4; c++ {
+			switch acc.ComponentType {
+			case gltf.UNSIGNED_BYTE:
+				rval[i][c] = uint32(elem[c])
+			case gltf.UNSIGNED_SHORT:
+				rval[i][c] = uint32(binary.LittleEndian.Uint16(elem[c*2:]))
+			}
+		}
+	}
+	return rval, nil
+}
+
+// readWeights reads a WEIGHTS_n accessor, which is a VEC4 of FLOAT or of normalized UNSIGNED_BYTE or UNSIGNED_SHORT.
+// Like colors, integer weights are always normalized.
+func readWeights(doc *gltf.ResolvedGlTF, acc *gltf.ResolvedAccessor) ([][4]float32, error) {
+	if acc == nil || acc.Type != gltf.VEC4 {
+		return nil, nil
+	}
+
+	data, stride, err := accessorView(doc, acc)
+	if err != nil {
+		return nil, err
+	}
+	rval := make([][4]float32, acc.Count)
+	for i := range rval {
+		elem := data[i*stride:]
+		for c := 0; c < 4; c++ {
+			rval[i][c] = readComponent(elem, acc.ComponentType, true, c)
+		}
+	}
+	return rval, nil
+}
+
+// readFloats reads every component of every element of an accessor as a float, element by element. Integer
+// components are normalized if the accessor is, which glTF requires for the integer types allowed in animation
+// outputs; quantized morph target deltas may be either.
+func readFloats(doc *gltf.ResolvedGlTF, acc *gltf.ResolvedAccessor) ([]float32, error) {
+	if acc == nil {
+		return nil, nil
+	}
+
+	n := componentCount(acc.Type)
+	data, stride, err := accessorView(doc, acc)
+	if err != nil {
+		return nil, err
+	}
+	rval := make([]float32, acc.Count*n)
+	for i := 0; i < acc.Count; i++ {
+		elem := data[i*stride:]
+		for c := 0; c < n; c++ {
+			rval[i*n+c] = readComponent(elem, acc.ComponentType, acc.Normalized, c)
+		}
+	}
+	return rval, nil
+}
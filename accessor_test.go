@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bbredesen/gltf"
+)
+
+// loadTestModel writes a glTF document to a temporary file, with buffer as its only buffer, and loads it.
+func loadTestModel(t *testing.T, document string, buffer []byte) *gltf.ResolvedGlTF {
+	t.Helper()
+	resolved, err := loadModels([]string{writeTestModel(t, document, buffer)})
+	if err != nil {
+		t.Fatalf("loadModels: %s", err)
+	}
+	return resolved
+}
+
+// writeTestModel writes a glTF document to a temporary file, with buffer as its only buffer in a file next to it, and
+// returns the file name.
+func writeTestModel(t *testing.T, document string, buffer []byte) string {
+	t.Helper()
+
+	var doc jsonObject
+	if err := json.Unmarshal([]byte(document), &doc); err != nil {
+		t.Fatalf("invalid test document: %s", err)
+	}
+	doc["asset"] = jsonObject{"version": "2.0"}
+	doc["buffers"] = []any{jsonObject{"byteLength": len(buffer), "uri": "test.bin"}}
+	data, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "test.bin"), buffer, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(dir, "test.gltf")
+	if err := os.WriteFile(filename, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+// littleEndian encodes values of fixed size types one after another.
+func littleEndian(values ...any) []byte {
+	var buf bytes.Buffer
+	for _, v := range values {
+		if err := binary.Write(&buf, binary.LittleEndian, v); err != nil {
+			panic(err)
+		}
+	}
+	return buf.Bytes()
+}
+
+// accessorTestBuffer holds the data of accessorTestDocument, one buffer view per line. View 8 has no line, as it
+// starts at the sparse data and runs past the end of the buffer.
+var accessorTestBuffer = littleEndian(
+	[]float32{1, 2, 3, -4, 5.5, 6},
+	[]uint16{0, 1, 65535, 0},
+	[]uint8{0, 1, 2, 255},
+	int16(32767), int16(-32767), []uint8{255, 0, 51, 255}, int16(0), int16(-32768), []uint8{0, 255, 0, 128},
+	[]int8{127, -127, -128, 0},
+	[]uint16{1, 2, 3, 40000},
+	[]uint32{7, 70000},
+	[]float32{0, 1, 0, -1},
+	[]uint16{0, 2},
+	[]float32{8, 9},
+	[]uint16{2, 0},
+	[]uint16{1, 1},
+)
+
+const accessorTestDocument = `{
+	"bufferViews": [
+		{"buffer": 0, "byteOffset": 0, "byteLength": 24},
+		{"buffer": 0, "byteOffset": 24, "byteLength": 6},
+		{"buffer": 0, "byteOffset": 32, "byteLength": 4},
+		{"buffer": 0, "byteOffset": 36, "byteLength": 16, "byteStride": 8},
+		{"buffer": 0, "byteOffset": 52, "byteLength": 4},
+		{"buffer": 0, "byteOffset": 56, "byteLength": 8},
+		{"buffer": 0, "byteOffset": 64, "byteLength": 8},
+		{"buffer": 0, "byteOffset": 72, "byteLength": 16},
+		{"buffer": 0, "byteOffset": 88, "byteLength": 24},
+		{"buffer": 0, "byteOffset": 88, "byteLength": 4},
+		{"buffer": 0, "byteOffset": 92, "byteLength": 8},
+		{"buffer": 0, "byteOffset": 100, "byteLength": 4},
+		{"buffer": 0, "byteOffset": 104, "byteLength": 4}
+	],
+	"accessors": [
+		{"bufferView": 0, "componentType": 5126, "type": "VEC3", "count": 2},
+		{"bufferView": 1, "componentType": 5123, "type": "SCALAR", "count": 3},
+		{"bufferView": 2, "componentType": 5121, "type": "SCALAR", "count": 4},
+		{"bufferView": 3, "componentType": 5122, "type": "VEC2", "count": 2, "normalized": true},
+		{"bufferView": 3, "byteOffset": 4, "componentType": 5121, "type": "VEC4", "count": 2, "normalized": true},
+		{"bufferView": 4, "componentType": 5120, "type": "VEC3", "count": 1, "normalized": true},
+		{"bufferView": 5, "componentType": 5123, "type": "VEC4", "count": 1},
+		{"bufferView": 6, "componentType": 5125, "type": "SCALAR", "count": 2},
+		{"bufferView": 7, "componentType": 5126, "type": "VEC4", "count": 1},
+		{"componentType": 5126, "type": "SCALAR", "count": 3},
+		{"bufferView": 3, "componentType": 5122, "type": "VEC2", "count": 2},
+		{"bufferView": 0, "componentType": 5126, "type": "VEC3", "count": 3},
+		{"bufferView": 0, "byteOffset": 4, "componentType": 5126, "type": "VEC3", "count": 2},
+		{"bufferView": 8, "componentType": 5126, "type": "VEC4", "count": 1},
+		{"bufferView": 3, "componentType": 5126, "type": "VEC3", "count": 2},
+		{"bufferView": 0, "componentType": 5126, "type": "SCALAR", "count": 6,
+			"sparse": {"count": 2, "indices": {"bufferView": 9, "componentType": 5123}, "values": {"bufferView": 10}}},
+		{"componentType": 5126, "type": "SCALAR", "count": 3,
+			"sparse": {"count": 2, "indices": {"bufferView": 9, "componentType": 5123}, "values": {"bufferView": 10}}},
+		{"bufferView": 0, "componentType": 5126, "type": "SCALAR", "count": 6,
+			"sparse": {"count": 3, "indices": {"bufferView": 9, "componentType": 5123}, "values": {"bufferView": 10}}},
+		{"bufferView": 0, "componentType": 5126, "type": "SCALAR", "count": 6,
+			"sparse": {"count": 2, "indices": {"bufferView": 9, "componentType": 5123}, "values": {"bufferView": 10, "byteOffset": 4}}},
+		{"componentType": 5126, "type": "SCALAR", "count": 1,
+			"sparse": {"count": 2, "indices": {"bufferView": 9, "componentType": 5123}, "values": {"bufferView": 10}}},
+		{"componentType": 5126, "type": "SCALAR", "count": 2,
+			"sparse": {"count": 2, "indices": {"bufferView": 9, "componentType": 5123}, "values": {"bufferView": 10}}},
+		{"componentType": 5126, "type": "SCALAR", "count": 3,
+			"sparse": {"count": 2, "indices": {"bufferView": 11, "componentType": 5123}, "values": {"bufferView": 10}}},
+		{"componentType": 5126, "type": "SCALAR", "count": 3,
+			"sparse": {"count": 2, "indices": {"bufferView": 12, "componentType": 5123}, "values": {"bufferView": 10}}}
+	]
+}`
+
+// TestAccessorReaders reads each accessor of accessorTestDocument and compares the result, as formatted by %v, to the
+// expected values.
+func TestAccessorReaders(t *testing.T) {
+	doc := loadTestModel(t, accessorTestDocument, accessorTestBuffer)
+
+	type reader func(*gltf.ResolvedGlTF, *gltf.ResolvedAccessor) (any, error)
+	vec3 := func(doc *gltf.ResolvedGlTF, acc *gltf.ResolvedAccessor) (any, error) { return readVec3(doc, acc) }
+	tangent := func(doc *gltf.ResolvedGlTF, acc *gltf.ResolvedAccessor) (any, error) { return readTangent(doc, acc) }
+	indices := func(doc *gltf.ResolvedGlTF, acc *gltf.ResolvedAccessor) (any, error) { return readIndices(doc, acc) }
+	color := func(doc *gltf.ResolvedGlTF, acc *gltf.ResolvedAccessor) (any, error) { return readColor(doc, acc) }
+	texCoord := func(doc *gltf.ResolvedGlTF, acc *gltf.ResolvedAccessor) (any, error) { return readTexCoord(doc, acc) }
+	joints := func(doc *gltf.ResolvedGlTF, acc *gltf.ResolvedAccessor) (any, error) { return readJoints(doc, acc) }
+	weights := func(doc *gltf.ResolvedGlTF, acc *gltf.ResolvedAccessor) (any, error) { return readWeights(doc, acc) }
+	floats := func(doc *gltf.ResolvedGlTF, acc *gltf.ResolvedAccessor) (any, error) { return readFloats(doc, acc) }
+
+	tests := []struct {
+		name     string
+		read     reader
+		accessor int
+		want     string // empty if the read should fail
+	}{
+		{"float positions", vec3, 0, "[[1 2 3] [-4 5.5 6]]"},
+		{"float positions as floats", floats, 0, "[1 2 3 -4 5.5 6]"},
+		{"wrong type", vec3, 1, "[]"},
+		{"short indices", indices, 1, "[0 1 65535]"},
+		{"byte indices", indices, 2, "[0 1 2 255]"},
+		{"int indices", indices, 7, "[7 70000]"},
+		{"normalized short texcoords", texCoord, 3, "[[1 -1] [0 -1]]"},
+		{"interleaved normalized colors", color, 4, "[[1 0 0.2 1] [0 1 0 0.5019608]]"},
+		{"byte colors as weights", weights, 4, "[[1 0 0.2 1] [0 1 0 0.5019608]]"},
+		{"quantized normal", vec3, 5, "[[1 -1 -1]]"},
+		{"short joints", joints, 6, "[[1 2 3 40000]]"},
+		{"float tangent", tangent, 8, "[[0 1 0 -1]]"},
+		{"no buffer view", floats, 9, "[0 0 0]"},
+		{"unnormalized shorts", floats, 10, "[32767 -32767 0 -32768]"},
+		{"count past the view", vec3, 11, ""},
+		{"offset past the view", vec3, 12, ""},
+		{"view past the buffer", tangent, 13, ""},
+		{"stride less than the element size", vec3, 14, ""},
+		// Sparse values replace elements 0 and 2, of the buffer view or of zeros.
+		{"sparse", floats, 15, "[8 2 9 -4 5.5 6]"},
+		{"sparse without a buffer view", floats, 16, "[8 0 9]"},
+		{"sparse indices past the view", floats, 17, ""},
+		{"sparse values past the view", floats, 18, ""},
+		{"sparse count past the accessor", floats, 19, ""},
+		{"sparse index past the accessor", floats, 20, ""},
+		{"sparse indices decreasing", floats, 21, ""},
+		{"sparse indices repeated", floats, 22, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := test.read(doc, doc.Accessors[test.accessor])
+			switch {
+			case test.want == "" && err == nil:
+				t.Errorf("read %v, want an error", got)
+			case test.want != "" && err != nil:
+				t.Errorf("read failed: %s", err)
+			case test.want != "":
+				if s := fmt.Sprint(got); s != test.want {
+					t.Errorf("read %s, want %s", s, test.want)
+				}
+			}
+		})
+	}
+}
+
+func TestReadNilAccessor(t *testing.T) {
+	doc := &gltf.ResolvedGlTF{}
+	if v, err := readVec3(doc, nil); v != nil || err != nil {
+		t.Errorf("readVec3(nil) = %v, %v; want nil, nil", v, err)
+	}
+	if v, err := readIndices(doc, nil); v != nil || err != nil {
+		t.Errorf("readIndices(nil) = %v, %v; want nil, nil", v, err)
+	}
+}
@@ -0,0 +1,513 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/bbredesen/gltf"
+	"github.com/chewxy/math32"
+)
+
+// interpolation is an animation sampler's interpolation mode.
+type interpolation int
+
+const (
+	interpolationLinear interpolation = iota
+	interpolationStep
+	interpolationCubicSpline
+)
+
+func parseInterpolation(s string) interpolation {
+	switch s {
+	case "STEP":
+		return interpolationStep
+	case "CUBICSPLINE":
+		return interpolationCubicSpline
+	}
+	// LINEAR is the default when the property is absent.
+	return interpolationLinear
+}
+
+// animationPath is the node property targeted by an animation channel.
+type animationPath int
+
+const (
+	pathTranslation animationPath = iota
+	pathRotation
+	pathScale
+	pathWeights
+)
+
+func parseAnimationPath(s string) (animationPath, bool) {
+	switch s {
+	case "translation":
+		return pathTranslation, true
+	case "rotation":
+		return pathRotation, true
+	case "scale":
+		return pathScale, true
+	case "weights":
+		return pathWeights, true
+	}
+	return 0, false
+}
+
+// animationSampler holds the keyframes of one glTF animation sampler. values is flat, with components floats per
+// element. For CUBICSPLINE there are three elements per keyframe: in-tangent, value, out-tangent.
+type animationSampler struct {
+	times         []float32
+	values        []float32
+	components    int
+	interpolation interpolation
+}
+
+// sample writes the value at time t to out, which must have room for s.components floats. Times before the first
+// keyframe or after the last are clamped to it. If rotation is set, the value is a quaternion: LINEAR uses slerp, and
+// the result is normalized.
+func (s *animationSampler) sample(t float32, rotation bool, out []float32) {
+	n := s.components
+	out = out[:n]
+
+	// value returns element i of keyframe k. Only CUBICSPLINE has more than one element per keyframe.
+	value := func(k, i int) []float32 {
+		if s.interpolation == interpolationCubicSpline {
+			return s.values[(3*k+i)*n : (3*k+i+1)*n]
+		}
+		return s.values[k*n : (k+1)*n]
+	}
+	const inTangent, keyValue, outTangent = 0, 1, 2
+
+	last := len(s.times) - 1
+	if last < 0 {
+		return
+	}
+
+	// k is the last keyframe at or before t.
+	k := sort.Search(len(s.times), func(i int) bool { return s.times[i] > t }) - 1
+	switch {
+	case k < 0:
+		copy(out, value(0, keyValue))
+		return
+	case k >= last:
+		copy(out, value(last, keyValue))
+		return
+	}
+
+	t0, t1 := s.times[k], s.times[k+1]
+	td := t1 - t0
+	var u float32
+	if td > 0 {
+		u = (t - t0) / td
+	}
+
+	switch s.interpolation {
+	case interpolationStep:
+		copy(out, value(k, keyValue))
+		return
+
+	case interpolationCubicSpline:
+		// Hermite spline, per the glTF spec appendix on interpolation.
+		u2, u3 := u*u, u*u*u
+		h00 := 2*u3 - 3*u2 + 1
+		h10 := u3 - 2*u2 + u
+		h01 := -2*u3 + 3*u2
+		h11 := u3 - u2
+
+		v0, b0 := value(k, keyValue), value(k, outTangent)
+		v1, a1 := value(k+1, keyValue), value(k+1, inTangent)
+		for i := range out {
+			out[i] = h00*v0[i] + td*h10*b0[i] + h01*v1[i] + td*h11*a1[i]
+		}
+
+	default:
+		v0, v1 := value(k, keyValue), value(k+1, keyValue)
+		if rotation {
+			q := slerp([4]float32{v0[0], v0[1], v0[2], v0[3]}, [4]float32{v1[0], v1[1], v1[2], v1[3]}, u)
+			copy(out, q[:])
+			return
+		}
+		for i := range out {
+			out[i] = v0[i] + (v1[i]-v0[i])*u
+		}
+	}
+
+	if rotation {
+		q := normalizeQuat([4]float32{out[0], out[1], out[2], out[3]})
+		copy(out, q[:])
+	}
+}
+
+// slerp interpolates between unit quaternions a and b along the shorter arc.
+func slerp(a, b [4]float32, t float32) [4]float32 {
+	d := a[0]*b[0] + a[1]*b[1] + a[2]*b[2] + a[3]*b[3]
+	if d < 0 {
+		d = -d
+		b = [4]float32{-b[0], -b[1], -b[2], -b[3]}
+	}
+
+	// Nearly parallel quaternions make the angle ill-conditioned; a normalized lerp is indistinguishable there.
+	wa, wb := 1-t, t
+	if d < 0.9995 {
+		theta := math32.Acos(d)
+		sinTheta := math32.Sin(theta)
+		wa = math32.Sin((1-t)*theta) / sinTheta
+		wb = math32.Sin(t*theta) / sinTheta
+	}
+
+	var r [4]float32
+	for i := range r {
+		r[i] = wa*a[i] + wb*b[i]
+	}
+	return normalizeQuat(r)
+}
+
+func normalizeQuat(q [4]float32) [4]float32 {
+	l := math32.Sqrt(q[0]*q[0] + q[1]*q[1] + q[2]*q[2] + q[3]*q[3])
+	if l == 0 {
+		return [4]float32{0, 0, 0, 1}
+	}
+	return [4]float32{q[0] / l, q[1] / l, q[2] / l, q[3] / l}
+}
+
+// animationChannel drives one property of one node.
+type animationChannel struct {
+	node    *gltf.ResolvedNode
+	path    animationPath
+	sampler *animationSampler
+}
+
+// Animation is a glTF animation with its keyframes decoded.
+type Animation struct {
+	Name string
+	// Duration is the time of the last keyframe of any channel, in seconds.
+	Duration float32
+
+	channels []animationChannel
+}
+
+// loadAnimations decodes every animation in the document. Channels that target a property the viewer doesn't animate,
+// or that have no readable keyframes, are dropped.
+func loadAnimations(doc *gltf.ResolvedGlTF) []*Animation {
+	var rval []*Animation
+
+	for i, a := range doc.Animations {
+		anim := &Animation{Name: a.Name}
+		if anim.Name == "" {
+			anim.Name = "animation " + strconv.Itoa(i)
+		}
+
+		// Channels often share a sampler, so decode each one only once.
+		samplers := make(map[*gltf.ResolvedAnimationSampler]*animationSampler)
+
+		for _, ch := range a.Channels {
+			path, ok := parseAnimationPath(string(ch.Target.Path))
+			if !ok || ch.Target.Node == nil || ch.Sampler == nil || ch.Sampler.Input == nil || ch.Sampler.Output == nil {
+				continue
+			}
+
+			s, ok := samplers[ch.Sampler]
+			if !ok {
+				s = decodeSampler(doc, ch.Sampler)
+				samplers[ch.Sampler] = s
+			}
+			if s == nil {
+				continue
+			}
+
+			perKey := 1
+			if s.interpolation == interpolationCubicSpline {
+				perKey = 3
+			}
+			if path == pathWeights && len(s.times) > 0 {
+				// The output is SCALAR, with one element per morph target for each keyframe.
+				s.components = len(s.values) / (len(s.times) * perKey)
+			}
+			if len(s.times) == 0 || s.components == 0 || len(s.values) < len(s.times)*perKey*s.components {
+				continue
+			}
+
+			anim.channels = append(anim.channels, animationChannel{node: ch.Target.Node, path: path, sampler: s})
+			anim.Duration = math32.Max(anim.Duration, s.times[len(s.times)-1])
+		}
+
+		rval = append(rval, anim)
+	}
+
+	return rval
+}
+
+// decodeSampler reads the keyframes of a sampler, or returns nil if its accessors can't be read.
+func decodeSampler(doc *gltf.ResolvedGlTF, gs *gltf.ResolvedAnimationSampler) *animationSampler {
+	times, err := readFloats(doc, gs.Input)
+	if err != nil {
+		return nil
+	}
+	values, err := readFloats(doc, gs.Output)
+	if err != nil {
+		return nil
+	}
+	return &animationSampler{
+		times:         times,
+		values:        values,
+		components:    componentCount(gs.Output.Type),
+		interpolation: parseInterpolation(string(gs.Interpolation)),
+	}
+}
+
+// AnimationPlayer plays one of a document's animations on a scene.
+type AnimationPlayer struct {
+	Animations []*Animation
+	// Current is the index of the selected animation, or -1 if there are none.
+	Current int
+
+	// Time is the playback position in seconds, and Speed scales the time passed to Advance.
+	Time, Speed   float32
+	Playing, Loop bool
+
+	// WeightOverrides fixes morph target weights by target index, for every node with morph targets, regardless of
+	// the animation.
+	WeightOverrides map[int]float32
+
+	root  *SceneNode
+	nodes map[*gltf.ResolvedNode]*SceneNode
+	// morphNodes are the scene nodes with morph targets, depth first in scene order, so that Weight reads them in the
+	// same order every time rather than in map order.
+	morphNodes []*SceneNode
+}
+
+// AnimationOptions are the initial player settings, e.g. from the command line.
+type AnimationOptions struct {
+	// Name selects an animation by name or index. Empty means the first animation.
+	Name string
+	// Time is the starting playback position in seconds.
+	Time   float32
+	Speed  float32
+	NoLoop bool
+	// Weights overrides morph target weights by target index.
+	Weights map[int]float32
+}
+
+// NewAnimationPlayer returns a player for the animations in doc, acting on scene. The first animation, if any, is
+// selected and playing, and loops at normal speed.
+func NewAnimationPlayer(doc *gltf.ResolvedGlTF, scene *SceneNode) *AnimationPlayer {
+	p := &AnimationPlayer{
+		Animations:      loadAnimations(doc),
+		Current:         -1,
+		Speed:           1,
+		Playing:         true,
+		Loop:            true,
+		root:            scene,
+		WeightOverrides: make(map[int]float32),
+		nodes:           indexSceneNodes(scene),
+		morphNodes:      findMorphNodes(scene),
+	}
+
+	if len(p.Animations) > 0 {
+		p.Current = 0
+	}
+	return p
+}
+
+// Configure applies opts and poses the scene at the resulting playback position. If the named animation doesn't
+// exist, the rest of opts still applies to the current one, and the error is returned.
+func (p *AnimationPlayer) Configure(opts AnimationOptions) error {
+	var err error
+	if opts.Name != "" {
+		err = p.Select(opts.Name)
+	}
+	if opts.Speed != 0 {
+		p.Speed = opts.Speed
+	}
+	p.Loop = !opts.NoLoop
+	p.Time = opts.Time
+	for target, w := range opts.Weights {
+		p.WeightOverrides[target] = w
+	}
+	p.Apply()
+	return err
+}
+
+// Select picks the animation to play, either by name or by index, and rewinds it. Nodes animated by the previous
+// selection are returned to their rest pose.
+func (p *AnimationPlayer) Select(nameOrIndex string) error {
+	index := -1
+	for i, a := range p.Animations {
+		if a.Name == nameOrIndex {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		i, err := strconv.Atoi(nameOrIndex)
+		if err != nil || i < 0 || i >= len(p.Animations) {
+			return fmt.Errorf("no animation named %q; the file has %d animations", nameOrIndex, len(p.Animations))
+		}
+		index = i
+	}
+
+	p.SelectIndex(index)
+	return nil
+}
+
+// SelectIndex picks the animation to play by index and rewinds it.
+func (p *AnimationPlayer) SelectIndex(index int) {
+	if a := p.Animation(); a != nil {
+		for _, ch := range a.channels {
+			if n := p.nodes[ch.node]; n != nil {
+				n.BaseTransform = nodeLocalTransform(ch.node).toVkm()
+				n.Weights = defaultMorphWeights(ch.node)
+			}
+		}
+	}
+
+	p.Current = index
+	p.Time = 0
+	p.Apply()
+}
+
+// Animation returns the selected animation, or nil.
+func (p *AnimationPlayer) Animation() *Animation {
+	if p.Current < 0 || p.Current >= len(p.Animations) {
+		return nil
+	}
+	return p.Animations[p.Current]
+}
+
+// Advance moves the playback position by dt, scaled by Speed, if playing. At the end of the animation it either wraps
+// around or stops, depending on Loop. Negative speeds play backwards.
+func (p *AnimationPlayer) Advance(dt time.Duration) {
+	a := p.Animation()
+	if a == nil || !p.Playing {
+		return
+	}
+
+	p.Time += float32(dt.Seconds()) * p.Speed
+
+	switch {
+	case a.Duration <= 0:
+		p.Time = 0
+	case p.Loop:
+		p.Time = math32.Mod(p.Time, a.Duration)
+		if p.Time < 0 {
+			p.Time += a.Duration
+		}
+	case p.Time >= a.Duration:
+		p.Time, p.Playing = a.Duration, false
+	case p.Time <= 0:
+		p.Time, p.Playing = 0, false
+	}
+}
+
+// Apply poses the scene at the current playback position by setting the BaseTransform and Weights of each animated
+// node, then updates every CurrentTransform. Properties without a channel keep their value from the file. Weight
+// overrides are applied last.
+func (p *AnimationPlayer) Apply() {
+	defer p.root.UpdateTransforms()
+	defer p.applyWeightOverrides()
+
+	a := p.Animation()
+	if a == nil {
+		return
+	}
+
+	type trs struct {
+		t [3]float32
+		r [4]float32
+		s [3]float32
+	}
+	poses := make(map[*gltf.ResolvedNode]*trs)
+
+	var value []float32
+	for _, ch := range a.channels {
+		if len(value) < ch.sampler.components {
+			value = make([]float32, ch.sampler.components)
+		}
+		ch.sampler.sample(p.Time, ch.path == pathRotation, value)
+
+		if ch.path == pathWeights {
+			if n := p.nodes[ch.node]; n != nil {
+				copy(n.Weights, value[:ch.sampler.components])
+			}
+			continue
+		}
+
+		pose := poses[ch.node]
+		if pose == nil {
+			pose = &trs{}
+			pose.t, pose.r, pose.s = nodeTRS(ch.node)
+			poses[ch.node] = pose
+		}
+
+		switch ch.path {
+		case pathTranslation:
+			copy(pose.t[:], value[:3])
+		case pathRotation:
+			copy(pose.r[:], value[:4])
+		case pathScale:
+			copy(pose.s[:], value[:3])
+		}
+	}
+
+	for node, pose := range poses {
+		if n := p.nodes[node]; n != nil {
+			n.BaseTransform = composeTRS(pose.t, pose.r, pose.s).toVkm()
+		}
+	}
+}
+
+// applyWeightOverrides sets the overridden morph target weights of every node that has that many targets.
+func (p *AnimationPlayer) applyWeightOverrides() {
+	if len(p.WeightOverrides) == 0 {
+		return
+	}
+	for _, n := range p.morphNodes {
+		for target, w := range p.WeightOverrides {
+			if target >= 0 && target < len(n.Weights) {
+				n.Weights[target] = w
+			}
+		}
+	}
+}
+
+// MorphTargetCount returns the largest number of morph targets of any node in the scene.
+func (p *AnimationPlayer) MorphTargetCount() int {
+	var count int
+	for _, n := range p.morphNodes {
+		if len(n.Weights) > count {
+			count = len(n.Weights)
+		}
+	}
+	return count
+}
+
+// Weight returns the current weight of a morph target: its override if it has one, otherwise its weight on the
+// first node in scene order with that many targets, or 0.
+func (p *AnimationPlayer) Weight(target int) float32 {
+	if w, ok := p.WeightOverrides[target]; ok {
+		return w
+	}
+	for _, n := range p.morphNodes {
+		if target < len(n.Weights) {
+			return n.Weights[target]
+		}
+	}
+	return 0
+}
+
+// findMorphNodes returns the descendants of root that have morph targets, depth first.
+func findMorphNodes(root *SceneNode) []*SceneNode {
+	var rval []*SceneNode
+	var walk func(n *SceneNode)
+	walk = func(n *SceneNode) {
+		if len(n.Weights) > 0 {
+			rval = append(rval, n)
+		}
+		for _, child := range n.Children {
+			walk(child)
+		}
+	}
+	walk(root)
+	return rval
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/chewxy/math32"
+)
+
+const animationEpsilon = 1e-5
+
+func floatsNear(a, b []float32) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if math32.Abs(a[i]-b[i]) > animationEpsilon {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSamplerInterpolation(t *testing.T) {
+	step := &animationSampler{
+		times:         []float32{0, 1, 2},
+		values:        []float32{0, 10, 20},
+		components:    1,
+		interpolation: interpolationStep,
+	}
+	linear := &animationSampler{
+		times:         []float32{0, 1, 3},
+		values:        []float32{0, 0, 0, 2, 4, 6, 0, 0, 0},
+		components:    3,
+		interpolation: interpolationLinear,
+	}
+	// Keyframes are in-tangent, value, out-tangent. With zero tangents the curve is smoothstep between the values; with
+	// a slope of 1 at both ends and values 0 and 1 over one second, it is the straight line.
+	cubicFlat := &animationSampler{
+		times:         []float32{0, 1},
+		values:        []float32{0, 0, 0, 0, 1, 0},
+		components:    1,
+		interpolation: interpolationCubicSpline,
+	}
+	cubicLine := &animationSampler{
+		times:         []float32{0, 1},
+		values:        []float32{1, 0, 1, 1, 1, 1},
+		components:    1,
+		interpolation: interpolationCubicSpline,
+	}
+	// Keyframes two seconds apart, so the tangents are scaled by the interval: the out-tangent of 1 per second
+	// contributes 2 * h10(0.5) = 0.25 at the midpoint.
+	cubicScaled := &animationSampler{
+		times:         []float32{0, 2},
+		values:        []float32{0, 0, 1, 0, 0, 0},
+		components:    1,
+		interpolation: interpolationCubicSpline,
+	}
+
+	tests := []struct {
+		name    string
+		sampler *animationSampler
+		t       float32
+		want    []float32
+	}{
+		{"step at key", step, 1, []float32{10}},
+		{"step between keys", step, 1.99, []float32{10}},
+		{"step before start", step, -1, []float32{0}},
+		{"step after end", step, 5, []float32{20}},
+		{"linear midpoint", linear, 0.5, []float32{1, 2, 3}},
+		{"linear uneven interval", linear, 2.5, []float32{0.5, 1, 1.5}},
+		{"linear at last key", linear, 3, []float32{0, 0, 0}},
+		{"linear after end", linear, 10, []float32{0, 0, 0}},
+		{"cubic flat tangents", cubicFlat, 0.25, []float32{0.15625}},
+		{"cubic flat midpoint", cubicFlat, 0.5, []float32{0.5}},
+		{"cubic straight line", cubicLine, 0.3, []float32{0.3}},
+		{"cubic tangent scaled by interval", cubicScaled, 1, []float32{0.25}},
+		{"cubic at last key", cubicScaled, 2, []float32{0}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := make([]float32, test.sampler.components)
+			test.sampler.sample(test.t, false, got)
+			if !floatsNear(got, test.want) {
+				t.Errorf("sample(%g) = %v, want %v", test.t, got, test.want)
+			}
+		})
+	}
+}
+
+func TestSamplerRotation(t *testing.T) {
+	s2 := math32.Sqrt(2) / 2
+	// Rotations of 0 and 90 degrees about Z. The second keyframe is negated, which is the same rotation, so the
+	// sampler must still take the short way round.
+	s := &animationSampler{
+		times:         []float32{0, 1},
+		values:        []float32{0, 0, 0, 1, 0, 0, -s2, -s2},
+		components:    4,
+		interpolation: interpolationLinear,
+	}
+
+	got := make([]float32, 4)
+	s.sample(0.5, true, got)
+	// 45 degrees about Z.
+	want := []float32{0, 0, math32.Sin(math32.Pi / 8), math32.Cos(math32.Pi / 8)}
+	if !floatsNear(got, want) {
+		t.Errorf("sample(0.5) = %v, want %v", got, want)
+	}
+
+	// Normalized lerp would give the same midpoint; a third of the way along tells slerp apart from it.
+	s.sample(1.0/3, true, got)
+	want = []float32{0, 0, math32.Sin(math32.Pi / 12), math32.Cos(math32.Pi / 12)}
+	if !floatsNear(got, want) {
+		t.Errorf("sample(1/3) = %v, want %v", got, want)
+	}
+}
+
+func TestSlerp(t *testing.T) {
+	identity := [4]float32{0, 0, 0, 1}
+	// 180 degrees about X.
+	half := [4]float32{1, 0, 0, 0}
+
+	tests := []struct {
+		name string
+		a, b [4]float32
+		t    float32
+		want [4]float32
+	}{
+		{"start", identity, half, 0, identity},
+		{"end", identity, half, 1, half},
+		{"quarter", identity, half, 0.25, [4]float32{math32.Sin(math32.Pi / 8), 0, 0, math32.Cos(math32.Pi / 8)}},
+		{"equal", half, half, 0.5, half},
+		{"opposite sign", identity, [4]float32{0, 0, 0, -1}, 0.5, identity},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := slerp(test.a, test.b, test.t)
+			if !floatsNear(got[:], test.want[:]) {
+				t.Errorf("slerp(%v, %v, %g) = %v, want %v", test.a, test.b, test.t, got, test.want)
+			}
+		})
+	}
+}
+
+func TestAdvance(t *testing.T) {
+	tests := []struct {
+		name        string
+		loop        bool
+		speed       float32
+		start       float32
+		dt          time.Duration
+		wantTime    float32
+		wantPlaying bool
+	}{
+		{"within", true, 1, 0.5, 500 * time.Millisecond, 1, true},
+		{"wraps", true, 1, 1.5, time.Second, 0.5, true},
+		{"wraps several times", true, 1, 0, 4500 * time.Millisecond, 0.5, true},
+		{"wraps backwards", true, -1, 0.5, time.Second, 1.5, true},
+		{"speed", true, 2, 0, 250 * time.Millisecond, 0.5, true},
+		{"clamps at end", false, 1, 1.5, time.Second, 2, false},
+		{"clamps at start", false, -1, 0.5, time.Second, 0, false},
+		{"no time passed", false, 1, 1, 0, 1, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := &AnimationPlayer{
+				Animations: []*Animation{{Name: "test", Duration: 2}},
+				Current:    0,
+				Time:       test.start,
+				Speed:      test.speed,
+				Playing:    true,
+				Loop:       test.loop,
+			}
+			p.Advance(test.dt)
+			if math32.Abs(p.Time-test.wantTime) > animationEpsilon || p.Playing != test.wantPlaying {
+				t.Errorf("after Advance(%v), time %g playing %v; want %g, %v", test.dt, p.Time, p.Playing, test.wantTime, test.wantPlaying)
+			}
+		})
+	}
+
+	t.Run("paused", func(t *testing.T) {
+		p := &AnimationPlayer{Animations: []*Animation{{Duration: 2}}, Speed: 1, Time: 1}
+		p.Advance(time.Second)
+		if p.Time != 1 {
+			t.Errorf("paused player moved to %g", p.Time)
+		}
+	})
+}
+
+func TestMorphWeights(t *testing.T) {
+	// Two nodes with morph targets and one without, the second nested under a plain node so that scene order is not
+	// the order of the root's children.
+	root := &SceneNode{}
+	plain := &SceneNode{Parent: root}
+	first := &SceneNode{Parent: root, Weights: []float32{0.1, 0.2}}
+	second := &SceneNode{Parent: plain, Weights: []float32{0.5, 0.6, 0.7}}
+	plain.Children = []*SceneNode{second}
+	root.Children = []*SceneNode{first, plain}
+
+	p := &AnimationPlayer{WeightOverrides: make(map[int]float32), morphNodes: findMorphNodes(root)}
+	if got := p.MorphTargetCount(); got != 3 {
+		t.Errorf("MorphTargetCount() = %d, want 3", got)
+	}
+
+	// Every call reads the same node, the first one in scene order with enough targets.
+	for i := 0; i < 10; i++ {
+		if got := []float32{p.Weight(0), p.Weight(1), p.Weight(2), p.Weight(3)}; !floatsNear(got, []float32{0.1, 0.2, 0.7, 0}) {
+			t.Fatalf("weights are %v, want [0.1 0.2 0.7 0]", got)
+		}
+	}
+
+	// An override applies to every node with that target, and is what Weight reports.
+	p.WeightOverrides[1] = 0.9
+	p.WeightOverrides[2] = 0.4
+	p.applyWeightOverrides()
+	if !floatsNear(first.Weights, []float32{0.1, 0.9}) || !floatsNear(second.Weights, []float32{0.5, 0.9, 0.4}) {
+		t.Errorf("overridden weights are %v and %v, want [0.1 0.9] and [0.5 0.9 0.4]", first.Weights, second.Weights)
+	}
+	if got := p.Weight(1); got != 0.9 {
+		t.Errorf("Weight(1) = %g, want the override 0.9", got)
+	}
+}
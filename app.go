@@ -1,6 +1,9 @@
 package main
 
 import (
+	"fmt"
+	"os"
+	"time"
 	"unsafe"
 
 	"github.com/bbredesen/gltf"
@@ -8,7 +11,6 @@ import (
 	"github.com/bbredesen/gltf-viewer/vkctx"
 	"github.com/bbredesen/go-vk"
 	"github.com/bbredesen/vkm"
-	"golang.org/x/sys/windows"
 )
 
 // func init() {
@@ -17,13 +19,14 @@ import (
 // }
 
 type App struct {
-	winapp   *shared.Win32App
+	winapp   shared.Window
 	messages chan shared.WindowMessage
 
 	vkctx.Context
 	VulkanPipeline
 
 	currentImage uint32
+	currentFrame int
 
 	// vertexBuffer, indexBuffer             vk.Buffer
 	// vertexBufferMemory, indexBufferMemory vk.DeviceMemory
@@ -33,91 +36,255 @@ type App struct {
 	// quadVertStart, quadIndsStart int
 
 	buffers        []vk.Buffer
-	bufferMemories []vk.DeviceMemory
+	bufferMemories []*vkctx.Allocation
+
+	// Per-draw uniform data, see drawdata.go
+	primitives     map[*gltf.ResolvedPrimitive]*primitiveResources
+	drawDataStride uint32
+	drawDataBuffer vk.Buffer
+	drawDataMemory *vkctx.Allocation
+	drawDataPool   vk.DescriptorPool
+	drawDataSet    vk.DescriptorSet
+
+	// Textures and per-material descriptor sets, see textures.go
+	textures       map[textureKey]*textureImage
+	whiteTexture   *textureImage
+	samplers       map[*gltf.ResolvedSampler]vk.Sampler
+	defaultSampler vk.Sampler
+	materialPool   vk.DescriptorPool
+	materialSets   map[*gltf.ResolvedMaterial]vk.DescriptorSet
+
+	// Image-based lighting maps and the per-frame scene uniform data, see environment.go
+	envIrradiance, envSpecular, envBRDFLUT *textureImage
+	envSampler                             vk.Sampler
+	sceneDataStride                        uint32
+	sceneDataBuffer                        vk.Buffer
+	sceneDataMemory                        *vkctx.Allocation
+	sceneDataMapped                        []byte
+	scenePool                              vk.DescriptorPool
+	sceneSets                              []vk.DescriptorSet
+
+	// Joint matrices for skinned nodes, see joints.go
+	skins       *sceneSkins
+	jointLayout frameBlockLayout
+	jointBuffer vk.Buffer
+	jointMemory *vkctx.Allocation
+	jointMapped []byte
+
+	// Morph target weights for each node and deltas for each primitive, see morph.go
+	morphWeightLayout frameBlockLayout
+	morphWeightBuffer vk.Buffer
+	morphWeightMemory *vkctx.Allocation
+	morphWeightMapped []byte
+	morphDeltaBuffer  vk.Buffer
+	morphDeltaMemory  *vkctx.Allocation
+	morphDeltaSize    uint32
+
+	// Set 3, holding the joint and morph buffers, see joints.go
+	deformPool vk.DescriptorPool
+	deformSet  vk.DescriptorSet
+
+	// ModelDir is the directory containing the glTF file, used to resolve relative image URIs.
+	ModelDir string
+	// Animation holds the initial animation settings, applied when the model is loaded.
+	Animation AnimationOptions
 
 	modelDoc *gltf.ResolvedGlTF
 
 	cameras []vkm.Mat
 	scene   *SceneNode
+
+	minimized, framebufferResized bool
+
+	animation *AnimationPlayer
+
+	orbit     *OrbitCamera
+	lastMouse shared.MouseState
+	keysDown  map[byte]bool
+	// morphTarget is the morph target whose weight the arrow keys adjust.
+	morphTarget int
+
+	// uploads collects buffer and image uploads while a model loads, see beginUploads.
+	uploads *uploadBatch
+
+	// renderErr is the error that stopped drawing, see drawFrame.
+	renderErr error
 }
 
 func NewApp() *App {
 	c := make(chan shared.WindowMessage, 32)
 
 	return &App{
-		winapp:   shared.NewWin32App(c),
+		winapp:   shared.NewWindow(c),
 		messages: c,
+		orbit:    NewOrbitCamera(),
 	}
 }
 
-func (app *App) Initialize() {
-	app.winapp.ClassName = "gltf-viewer"
-	app.winapp.Width, app.winapp.Height = 800, 800
-	app.winapp.Initialize("gltf-viewer")
+// Initialize opens the window and sets up Vulkan to draw into it. If that fails, whatever was created is destroyed
+// again, and Teardown must not be called.
+func (app *App) Initialize() error {
+	app.winapp.SetSize(800, 800)
+	if err := app.winapp.Initialize("gltf-viewer"); err != nil {
+		return fmt.Errorf("could not open the window: %w", err)
+	}
 
-	app.EnableApiLayers = append(app.EnableApiLayers, "VK_LAYER_KHRONOS_validation")
 	app.EnableInstanceExtensions = app.winapp.GetRequiredInstanceExtensions()
 
 	app.EnableDeviceExtensions = append(app.EnableDeviceExtensions, vk.KHR_SWAPCHAIN_EXTENSION_NAME, vk.EXT_ROBUSTNESS_2_EXTENSION_NAME)
 
-	app.Context.Initialize(windows.Handle(app.winapp.HInstance), windows.HWND(app.winapp.HWnd))
+	if err := app.Context.Initialize(app.winapp); err != nil {
+		app.winapp.Shutdown()
+		return fmt.Errorf("could not initialize Vulkan: %w", err)
+	}
+
+	if err := app.VulkanPipeline.Initialize(&app.Context); err != nil {
+		app.Context.Teardown()
+		app.winapp.Shutdown()
+		return fmt.Errorf("could not create the render pipeline: %w", err)
+	}
+
+	app.winapp.OnResize(app.onResize)
+	return nil
+}
+
+// onResize is called by the main loop when the window size changes. The swapchain is rebuilt at the start of the next
+// frame, rather than here, so that it also happens for out-of-date results from acquire or present.
+func (app *App) onResize(width, height uint32) {
+	app.minimized = width == 0 || height == 0
+	app.framebufferResized = true
+}
+
+// recreateSwapchain rebuilds everything that depends on the swapchain extent. The pipeline itself uses dynamic
+// viewport and scissor state, so it can be kept.
+func (app *App) recreateSwapchain() error {
+	app.framebufferResized = false
 
-	app.VulkanPipeline.Initialize(&app.Context)
+	extent := app.winapp.Extent()
+	if extent.Width == 0 || extent.Height == 0 {
+		// Minimized; a swapchain can't be created with a zero extent. Try again after the next resize.
+		app.minimized = true
+		return nil
+	}
+
+	// The framebuffers may still be in use by frames in flight.
+	if err := vk.DeviceWaitIdle(app.Device); err != nil {
+		return fmt.Errorf("could not wait for the device to be idle: %w", err)
+	}
+	app.destroyFramebuffers()
+	if err := app.Context.RecreateSwapchain(); err != nil {
+		return fmt.Errorf("could not recreate the swapchain: %w", err)
+	}
+	return app.CreateFramebuffers()
 }
 
 func (app *App) Teardown() {
 	vk.DeviceWaitIdle(app.ctx.Device)
 
+	app.destroyDrawData()
+	app.destroyMaterials()
+	app.destroyEnvironment()
+	vk.DestroyDescriptorPool(app.Device, app.deformPool, nil)
+	app.destroyJointBuffer()
+	app.destroyMorphBuffers()
 	app.destroyBuffers()
 
 	app.VulkanPipeline.Teardown()
 	app.Context.Teardown()
+
+	// The window outlives the surface; headless renders never initialized it.
+	if app.winapp.IsInitialized() {
+		app.winapp.Shutdown()
+	}
 }
 
+// drawFrame is the draw function for the main loop. A failure to draw is reported once, after which the window stays
+// open but nothing more is drawn, and the error is kept in renderErr.
 func (app *App) drawFrame() {
-	vk.WaitForFences(app.ctx.Device, []vk.Fence{app.ctx.InFlightFence}, true, ^uint64(0))
+	if app.minimized || app.renderErr != nil {
+		// Nothing to draw into, or nothing more will be drawn; don't spin the CPU until the window is restored or closed.
+		time.Sleep(10 * time.Millisecond)
+		return
+	}
+	if err := app.renderFrame(); err != nil {
+		app.renderErr = err
+		fmt.Fprintf(os.Stderr, "error drawing frame: %s\n", err.Error())
+	}
+}
+
+func (app *App) renderFrame() error {
+	if app.framebufferResized {
+		if err := app.recreateSwapchain(); err != nil {
+			return err
+		}
+		if app.minimized {
+			return nil
+		}
+	}
+
+	frame := app.currentFrame
+	inFlight := app.ctx.InFlightFences[frame]
+	imageAvailable, renderFinished := app.ctx.ImageAvailableSemaphores[frame], app.ctx.RenderFinishedSemaphores[frame]
+	cb := app.ctx.CommandBuffers[frame]
+
+	// Wait until the GPU is done with this frame's command buffer and semaphores. With more than one frame in flight,
+	// the CPU can record this frame while the GPU is still executing the previous ones.
+	vk.WaitForFences(app.ctx.Device, []vk.Fence{inFlight}, true, ^uint64(0))
 
 	var err error
-	if app.currentImage, err = vk.AcquireNextImageKHR(app.ctx.Device, app.ctx.Swapchain, ^uint64(0), app.ctx.ImageAvailableSemaphore, vk.Fence(vk.NULL_HANDLE)); err != nil {
-		if err == vk.SUBOPTIMAL_KHR || err == vk.ERROR_OUT_OF_DATE_KHR {
-			// app.vp.recreateSwapchain()
-			return
+	if app.currentImage, err = vk.AcquireNextImageKHR(app.ctx.Device, app.ctx.Swapchain, ^uint64(0), imageAvailable, vk.Fence(vk.NULL_HANDLE)); err != nil {
+		if err == vk.ERROR_OUT_OF_DATE_KHR {
+			return app.recreateSwapchain()
+		} else if err == vk.SUBOPTIMAL_KHR {
+			// The image was acquired and the semaphore will be signaled, so draw and present it, then recreate.
+			app.framebufferResized = true
 		} else {
-			panic("Could not acquire next image! " + err.Error())
+			return fmt.Errorf("could not acquire next image: %w", err)
 		}
 	}
 
-	vk.ResetFences(app.ctx.Device, []vk.Fence{app.ctx.InFlightFence})
+	// If an earlier frame that is still in flight rendered to this image, wait for it too. This only happens when the
+	// swapchain returns images out of order, or has fewer images than frames in flight.
+	if imgFence := app.ctx.ImagesInFlight[app.currentImage]; imgFence != vk.Fence(vk.NULL_HANDLE) && imgFence != inFlight {
+		vk.WaitForFences(app.ctx.Device, []vk.Fence{imgFence}, true, ^uint64(0))
+	}
+	app.ctx.ImagesInFlight[app.currentImage] = inFlight
 
-	// Somewhere in here update animations before recording commands
+	vk.ResetFences(app.ctx.Device, []vk.Fence{inFlight})
 
-	vk.ResetCommandBuffer(app.ctx.CommandBuffers[app.currentImage], 0)
-	app.recordRenderingCommands(app.ctx.CommandBuffers[app.currentImage])
+	vk.ResetCommandBuffer(cb, 0)
+	app.recordRenderingCommands(cb)
 
 	// app.updateUniformBuffer(app.currentImage)
 
 	submitInfo := vk.SubmitInfo{
-		PWaitSemaphores:   []vk.Semaphore{app.ctx.ImageAvailableSemaphore},
+		PWaitSemaphores:   []vk.Semaphore{imageAvailable},
 		PWaitDstStageMask: []vk.PipelineStageFlags{vk.PIPELINE_STAGE_COLOR_ATTACHMENT_OUTPUT_BIT},
-		PCommandBuffers:   []vk.CommandBuffer{app.ctx.CommandBuffers[app.currentImage]},
-		PSignalSemaphores: []vk.Semaphore{app.ctx.RenderFinishedSemaphore},
+		PCommandBuffers:   []vk.CommandBuffer{cb},
+		PSignalSemaphores: []vk.Semaphore{renderFinished},
 	}
 
-	if err := vk.QueueSubmit(app.ctx.GraphicsQueue, []vk.SubmitInfo{submitInfo}, app.ctx.InFlightFence); err != nil {
-		panic("Could not submit to graphics queue! " + err.Error())
+	if err := vk.QueueSubmit(app.ctx.GraphicsQueue, []vk.SubmitInfo{submitInfo}, inFlight); err != nil {
+		return fmt.Errorf("could not submit to graphics queue: %w", err)
 	}
 
 	// Present the drawn image
 	presentInfo := vk.PresentInfoKHR{
-		PWaitSemaphores: []vk.Semaphore{app.ctx.RenderFinishedSemaphore},
+		PWaitSemaphores: []vk.Semaphore{renderFinished},
 		PSwapchains:     []vk.SwapchainKHR{app.ctx.Swapchain},
 		PImageIndices:   []uint32{app.currentImage},
 	}
 
-	if r := vk.QueuePresentKHR(app.ctx.PresentQueue, &presentInfo); err != nil && r != vk.SUBOPTIMAL_KHR && r != vk.ERROR_OUT_OF_DATE_KHR {
-		panic("Could not submit to presentation queue! " + err.Error())
+	if err := vk.QueuePresentKHR(app.ctx.PresentQueue, &presentInfo); err != nil {
+		if err == vk.SUBOPTIMAL_KHR || err == vk.ERROR_OUT_OF_DATE_KHR {
+			app.framebufferResized = true
+		} else {
+			return fmt.Errorf("could not submit to presentation queue: %w", err)
+		}
 	}
 
+	app.currentFrame = (app.currentFrame + 1) % app.ctx.MaxFramesInFlight
+	return nil
 }
 
 func (app *App) recordRenderingCommands(cb vk.CommandBuffer) {
@@ -144,15 +311,18 @@ func (app *App) recordRenderingCommands(cb vk.CommandBuffer) {
 
 	vk.BeginCommandBuffer(cb, &cbBeginInfo)
 
-	// Static camera for now, TODO read from nodes
-	camBytes := make([]byte, unsafe.Sizeof(app.cameras[0]))
-	vk.MemCopyObj(unsafe.Pointer(&camBytes[0]), &app.cameras[0])
-	vk.CmdPushConstants(cb, app.pipelineLayout, vk.SHADER_STAGE_VERTEX_BIT, 0, camBytes)
+	// The orbit camera is used for now, TODO allow selecting one of the cameras defined in the file
+	app.orbit.Aspect = float32(app.SwapchainExtent.Width) / float32(app.SwapchainExtent.Height)
+	(&vulkanRenderer{app: app, cb: cb}).SetCamera(app.orbit.ViewProj(), app.orbit.Eye())
+
+	app.writeJointMatrices(app.currentFrame)
+	app.writeMorphWeights(app.currentFrame)
 
 	vk.CmdBeginRenderPass(cb, &rpBeginInfo, vk.SUBPASS_CONTENTS_INLINE)
 	// Need to set up a uniform buffer for the camera+perspective matrix?
 
-	vk.CmdBindPipeline(cb, vk.PIPELINE_BIND_POINT_GRAPHICS, app.graphicsPipeline)
+	// Pipelines are bound per primitive, by vulkanRenderer.DrawPrimitive.
+	app.setViewportAndScissor(cb)
 	// bind vert, index bufs
 
 	app.RenderNode(app.scene, cb)
@@ -183,7 +353,13 @@ func (app *App) recordRenderingCommands(cb vk.CommandBuffer) {
 
 }
 
-var attrKeys = []gltf.AttributeKey{gltf.POSITION, gltf.NORMAL, gltf.TANGENT, gltf.TEXCOORD_0, gltf.TEXCOORD_1, gltf.COLOR_0}
+var attrKeys = []gltf.AttributeKey{gltf.POSITION, gltf.NORMAL, gltf.TANGENT, gltf.TEXCOORD_0, gltf.TEXCOORD_1, gltf.COLOR_0, gltf.JOINTS_0, gltf.WEIGHTS_0}
+
+// convertedAttrKeys are the attributes that are always bound from a buffer converted on load, never from the glTF
+// buffer, because they have more than one allowed format.
+var convertedAttrKeys = map[gltf.AttributeKey]bool{
+	gltf.TEXCOORD_0: true, gltf.TEXCOORD_1: true, gltf.COLOR_0: true, gltf.JOINTS_0: true, gltf.WEIGHTS_0: true,
+}
 
 // func (app *App) recordMeshCommands(cb vk.CommandBuffer, mesh *gltf.ResolvedMesh) {
 // 	for _, p := range mesh.Primitives {
@@ -252,6 +428,9 @@ type SceneNode struct {
 
 	BaseTransform    vkm.Mat
 	CurrentTransform vkm.Mat
+
+	// Weights are the current morph target weights of the node's mesh, or nil if it has no morph targets.
+	Weights []float32
 }
 
 func NewScene(s *gltf.ResolvedScene) *SceneNode {
@@ -264,11 +443,13 @@ func NewScene(s *gltf.ResolvedScene) *SceneNode {
 }
 
 func NewSceneNode(parent *SceneNode, model *gltf.ResolvedNode) *SceneNode {
+	base := nodeLocalTransform(model).toVkm()
 	rval := &SceneNode{
-		BaseTransform:    vkm.Identity(), // TODO
-		CurrentTransform: vkm.Identity(), // TODO
+		BaseTransform:    base,
+		CurrentTransform: base,
 		Parent:           parent,
 		ModelNode:        model,
+		Weights:          defaultMorphWeights(model),
 	}
 
 	if parent != nil {
@@ -289,59 +470,99 @@ func (n *SceneNode) ApplyTransform(txfm vkm.Mat) {
 
 }
 
-const _modelPCOffset = uint32(unsafe.Sizeof(vkm.Mat{}))
+// indexSceneNodes maps each glTF node under root to its SceneNode.
+func indexSceneNodes(root *SceneNode) map[*gltf.ResolvedNode]*SceneNode {
+	rval := make(map[*gltf.ResolvedNode]*SceneNode)
+	var walk func(n *SceneNode)
+	walk = func(n *SceneNode) {
+		if n.ModelNode != nil {
+			rval[n.ModelNode] = n
+		}
+		for _, child := range n.Children {
+			walk(child)
+		}
+	}
+	walk(root)
+	return rval
+}
 
-func (app *App) RenderNode(n *SceneNode, cb vk.CommandBuffer) {
-	if n.ModelNode != nil && n.ModelNode.Camera != nil {
-		projViewMat := n.ModelNode.Camera.ProjMatrix.MultM(n.CurrentTransform)
-		// This won't work as is...need to put projection in a bound uniform buffer before executing the command buffer
-		vk.CmdPushConstants(cb, app.pipelineLayout, vk.SHADER_STAGE_VERTEX_BIT, 0, projViewMat.AsBytes())
+// UpdateTransforms recomputes the CurrentTransform of every descendant of n from their BaseTransforms, e.g. after an
+// animation has changed them.
+func (n *SceneNode) UpdateTransforms() {
+	for _, child := range n.Children {
+		child.ApplyTransform(n.CurrentTransform)
+		child.UpdateTransforms()
 	}
+}
+
+const _modelPCOffset = uint32(unsafe.Sizeof(vkm.Mat{}))
+
+// vulkanRenderer records scene draws into a command buffer. The graphics pipeline must already be bound.
+type vulkanRenderer struct {
+	app *App
+	cb  vk.CommandBuffer
+	// bound is the pipeline last bound in cb, so consecutive primitives with the same vertex layout don't rebind it.
+	bound vk.Pipeline
+}
+
+func (vr *vulkanRenderer) SetCamera(projView vkm.Mat, eye [3]float32) {
+	app := vr.app
+	vk.CmdPushConstants(vr.cb, app.pipelineLayout, vk.SHADER_STAGE_VERTEX_BIT, 0, projView.AsBytes())
+
+	app.writeSceneData(app.currentFrame, eye)
+	sets := []vk.DescriptorSet{app.sceneSets[app.currentFrame]}
+	vk.CmdBindDescriptorSets(vr.cb, vk.PIPELINE_BIND_POINT_GRAPHICS, app.pipelineLayout, 2, sets, nil)
+}
+
+func (vr *vulkanRenderer) DrawPrimitive(p *gltf.ResolvedPrimitive, n *SceneNode) {
+	app, cb := vr.app, vr.cb
 
-	// self render...
 	vk.CmdPushConstants(cb, app.pipelineLayout, vk.SHADER_STAGE_VERTEX_BIT, _modelPCOffset, n.CurrentTransform.AsBytes())
 
-	if n.ModelNode != nil && n.ModelNode.Mesh != nil {
-		for _, p := range n.ModelNode.Mesh.Primitives {
-			bufs := make([]vk.Buffer, len(attrKeys))
-			offsets := make([]vk.DeviceSize, len(attrKeys))
-
-			for i, attrKey := range attrKeys {
-				if ra, ok := p.Attributes[attrKey]; !ok {
-					bufs[i] = vk.Buffer(vk.NULL_HANDLE)
-				} else {
-					bufs[i] = app.buffers[ra.BufferView.BufferView.Buffer]
-					offsets[i] = vk.DeviceSize(ra.ByteOffset + ra.BufferView.ByteOffset)
-				}
-
-			}
-
-			vk.CmdBindVertexBuffers(cb, 0, bufs, offsets)
-
-			if p.Indices != nil {
-				bufIdx := p.Indices.BufferView.BufferView.Buffer
-
-				var idxType vk.IndexType
-				switch p.Indices.ComponentType {
-				case gltf.UNSIGNED_BYTE:
-					idxType = vk.INDEX_TYPE_UINT8_EXT
-					panic("unsported index type UINT8")
-				case gltf.UNSIGNED_SHORT:
-					idxType = vk.INDEX_TYPE_UINT16
-				case gltf.UNSIGNED_INT:
-					idxType = vk.INDEX_TYPE_UINT32
-				}
-
-				vk.CmdBindIndexBuffer(cb, app.buffers[bufIdx], vk.DeviceSize(p.Indices.ByteOffset+p.Indices.BufferView.ByteOffset), idxType)
-				vk.CmdDrawIndexed(cb, uint32(p.Indices.Count), 1, 0, 0, 0)
-			} else {
-				vk.CmdDraw(cb, uint32(p.Attributes[gltf.POSITION].Count), 1, 0, 0)
-			}
-		}
+	res := app.primitives[p]
+	if res.pipeline != vr.bound {
+		vk.CmdBindPipeline(cb, vk.PIPELINE_BIND_POINT_GRAPHICS, res.pipeline)
+		vr.bound = res.pipeline
 	}
 
-	for _, child := range n.Children {
-		child.ApplyTransform(n.CurrentTransform)
-		app.RenderNode(child, cb)
+	sets := []vk.DescriptorSet{app.drawDataSet, app.materialSets[p.Material]}
+	vk.CmdBindDescriptorSets(cb, vk.PIPELINE_BIND_POINT_GRAPHICS, app.pipelineLayout, 0, sets, []uint32{res.drawDataOffset})
+	// Set 2 is bound once per frame by SetCamera.
+	vk.CmdBindDescriptorSets(cb, vk.PIPELINE_BIND_POINT_GRAPHICS, app.pipelineLayout, 3, []vk.DescriptorSet{app.deformSet}, app.deformOffsets(n))
+
+	bufs := make([]vk.Buffer, len(res.vertexSources))
+	offsets := make([]vk.DeviceSize, len(res.vertexSources))
+	for i, src := range res.vertexSources {
+		bufs[i], offsets[i] = src.buffer, src.offset
+	}
+
+	vk.CmdBindVertexBuffers(cb, 0, bufs, offsets)
+
+	// Indices were set up, and converted if needed, by primitiveIndices.
+	if res.indexCount > 0 {
+		vk.CmdBindIndexBuffer(cb, res.indexBuffer, res.indexOffset, res.indexType)
+		vk.CmdDrawIndexed(cb, res.indexCount, 1, 0, 0, 0)
+	} else {
+		vk.CmdDraw(cb, res.vertexCount, 1, 0, 0)
 	}
 }
+
+// BeginNode opens a command buffer label for n, named after the glTF node, or its mesh if the node has no name.
+func (vr *vulkanRenderer) BeginNode(n *SceneNode) {
+	name := n.ModelNode.Name
+	if name == "" && n.ModelNode.Mesh != nil {
+		name = n.ModelNode.Mesh.Name
+	}
+	if name == "" {
+		name = "node"
+	}
+	vr.app.BeginLabel(vr.cb, name)
+}
+
+func (vr *vulkanRenderer) EndNode() {
+	vr.app.EndLabel(vr.cb)
+}
+
+func (app *App) RenderNode(n *SceneNode, cb vk.CommandBuffer) {
+	RenderScene(&vulkanRenderer{app: app, cb: cb}, n)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"github.com/bbredesen/gltf"
+	"github.com/chewxy/math32"
+)
+
+// sceneBounds returns the world-space axis-aligned bounding box of every mesh under n, using each node's
+// CurrentTransform. ok is false if there is no geometry in the scene.
+func sceneBounds(doc *gltf.ResolvedGlTF, n *SceneNode) (min, max [3]float32, ok bool) {
+	min = [3]float32{math32.Inf(1), math32.Inf(1), math32.Inf(1)}
+	max = [3]float32{math32.Inf(-1), math32.Inf(-1), math32.Inf(-1)}
+
+	var walk func(n *SceneNode)
+	walk = func(n *SceneNode) {
+		if n.ModelNode != nil && n.ModelNode.Mesh != nil {
+			world := fromVkm(n.CurrentTransform)
+			for _, p := range n.ModelNode.Mesh.Primitives {
+				// A primitive whose positions can't be read isn't drawn, so it doesn't count here either.
+				positions, _ := readVec3(doc, p.Attributes[gltf.POSITION])
+				for _, pos := range positions {
+					wp := world.transformPoint(pos)
+					for i := range wp {
+						min[i] = math32.Min(min[i], wp[i])
+						max[i] = math32.Max(max[i], wp[i])
+					}
+					ok = true
+				}
+			}
+		}
+		for _, child := range n.Children {
+			walk(child)
+		}
+	}
+	walk(n)
+
+	return
+}
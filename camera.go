@@ -0,0 +1,132 @@
+package main
+
+import (
+	"github.com/bbredesen/vkm"
+	"github.com/chewxy/math32"
+)
+
+// OrbitCamera is a perspective camera that orbits a target point as a turntable, not an arcball: dragging changes the
+// yaw and pitch of the eye around the target, so the world's +Y axis always stays up on screen. Yaw and pitch are in
+// radians; yaw is measured about +Y from +Z, and pitch is the elevation above the XZ plane. All input is given in
+// window pixels (or wheel notches), so the type has no dependency on any particular window system.
+type OrbitCamera struct {
+	Target     [3]float32
+	Distance   float32
+	Yaw, Pitch float32
+
+	Yfov   float32
+	Aspect float32
+
+	// Radius of the framed model, used to pick the clip planes.
+	Radius float32
+
+	// RotateSpeed is in radians per pixel of drag, DollySpeed is the fraction of the distance moved per wheel notch.
+	RotateSpeed, DollySpeed float32
+}
+
+const maxOrbitPitch = math32.Pi/2 - 0.01
+
+func NewOrbitCamera() *OrbitCamera {
+	return &OrbitCamera{
+		Distance:    5,
+		Yaw:         math32.Pi / 4,
+		Pitch:       math32.Pi / 6,
+		Yfov:        2 * math32.Pi * (60.0 / 360.0),
+		Aspect:      1,
+		Radius:      1,
+		RotateSpeed: 0.01,
+		DollySpeed:  0.1,
+	}
+}
+
+// Rotate orbits the eye around the target by a mouse drag of (dx, dy) pixels.
+func (c *OrbitCamera) Rotate(dx, dy float32) {
+	c.Yaw -= dx * c.RotateSpeed
+	c.Pitch += dy * c.RotateSpeed
+
+	if c.Pitch > maxOrbitPitch {
+		c.Pitch = maxOrbitPitch
+	} else if c.Pitch < -maxOrbitPitch {
+		c.Pitch = -maxOrbitPitch
+	}
+}
+
+// Pan moves the target in the view plane so that the point under the cursor follows a drag of (dx, dy) pixels in a
+// viewport that is viewportHeight pixels tall.
+func (c *OrbitCamera) Pan(dx, dy, viewportHeight float32) {
+	if viewportHeight <= 0 {
+		return
+	}
+	worldPerPixel := 2 * c.Distance * math32.Tan(c.Yfov/2) / viewportHeight
+
+	right, up := c.basis()
+	for i := range c.Target {
+		c.Target[i] += (-dx*right[i] + dy*up[i]) * worldPerPixel
+	}
+}
+
+// Dolly moves the eye toward (positive notches) or away from the target.
+func (c *OrbitCamera) Dolly(notches float32) {
+	c.Distance *= math32.Pow(1-c.DollySpeed, notches)
+
+	minDist := c.Radius * 1e-3
+	if c.Distance < minDist {
+		c.Distance = minDist
+	}
+}
+
+// Frame points the camera at the center of the box and backs off far enough that the whole box is in view.
+func (c *OrbitCamera) Frame(min, max [3]float32) {
+	var diag float32
+	for i := range c.Target {
+		c.Target[i] = (min[i] + max[i]) / 2
+		diag += (max[i] - min[i]) * (max[i] - min[i])
+	}
+
+	c.Radius = math32.Sqrt(diag) / 2
+	if c.Radius == 0 {
+		c.Radius = 1
+	}
+
+	// Fit the bounding sphere into the narrower of the two fields of view.
+	fov := c.Yfov
+	if c.Aspect < 1 {
+		fov = 2 * math32.Atan(math32.Tan(c.Yfov/2)*c.Aspect)
+	}
+	c.Distance = c.Radius / math32.Sin(fov/2)
+}
+
+// Eye returns the position of the camera in world space.
+func (c *OrbitCamera) Eye() [3]float32 {
+	cp := math32.Cos(c.Pitch)
+	return [3]float32{
+		c.Target[0] + c.Distance*cp*math32.Sin(c.Yaw),
+		c.Target[1] + c.Distance*math32.Sin(c.Pitch),
+		c.Target[2] + c.Distance*cp*math32.Cos(c.Yaw),
+	}
+}
+
+// basis returns the camera's right and up vectors in world space.
+func (c *OrbitCamera) basis() (right, up [3]float32) {
+	sy, cy := math32.Sin(c.Yaw), math32.Cos(c.Yaw)
+	sp, cp := math32.Sin(c.Pitch), math32.Cos(c.Pitch)
+
+	right = [3]float32{cy, 0, -sy}
+	up = [3]float32{-sp * sy, cp, -sp * cy}
+	return
+}
+
+// ViewProj returns the combined projection and view matrix for the current camera state.
+func (c *OrbitCamera) ViewProj() vkm.Mat {
+	eye := c.Eye()
+
+	near := c.Distance - 2*c.Radius
+	if min := c.Distance * 1e-3; near < min {
+		near = min
+	}
+	far := c.Distance + 2*c.Radius
+
+	return vkm.GlTFPerspective(c.Yfov, c.Aspect, near, far).MultM(
+		vkm.LookAt(vkm.NewPt(eye[0], eye[1], eye[2]), vkm.NewPt(c.Target[0], c.Target[1], c.Target[2]), vkm.UnitVecY()),
+	)
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/chewxy/math32"
+)
+
+// testCamera returns a camera looking down -Z at the origin from 5 units away, with a 90 degree vertical field of view.
+func testCamera() *OrbitCamera {
+	c := NewOrbitCamera()
+	c.Yaw, c.Pitch = 0, 0
+	c.Yfov = math32.Pi / 2
+	return c
+}
+
+// screenPos projects p with the camera, and returns where it lands in a viewport of the given size, in pixels.
+func screenPos(c *OrbitCamera, p [3]float32, width, height float32) [2]float32 {
+	clip := fromVkm(c.ViewProj()).transformVec4([4]float32{p[0], p[1], p[2], 1})
+	return [2]float32{(clip[0]/clip[3] + 1) / 2 * width, (clip[1]/clip[3] + 1) / 2 * height}
+}
+
+func TestOrbitCameraRotate(t *testing.T) {
+	tests := []struct {
+		name               string
+		pitch              float32
+		dx, dy             float32
+		wantYaw, wantPitch float32
+	}{
+		// Dragging right swings the eye to the left, so the model appears to turn with the cursor.
+		{"drag right", 0, 100, 0, -1, 0},
+		{"drag down", 0, 0, 50, 0, 0.5},
+		{"diagonal", 0.2, -10, -10, 0.1, 0.1},
+		// Pitch stops just short of the poles, where the view direction would be parallel to the up vector.
+		{"clamp up", 1.5, 0, 100, 0, maxOrbitPitch},
+		{"clamp down", -1.5, 0, -100, 0, -maxOrbitPitch},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := testCamera()
+			c.Pitch = test.pitch
+			c.Rotate(test.dx, test.dy)
+			if !floatsNear([]float32{c.Yaw, c.Pitch}, []float32{test.wantYaw, test.wantPitch}) {
+				t.Errorf("yaw and pitch are %v, %v; want %v, %v", c.Yaw, c.Pitch, test.wantYaw, test.wantPitch)
+			}
+		})
+	}
+}
+
+func TestOrbitCameraEye(t *testing.T) {
+	tests := []struct {
+		name       string
+		yaw, pitch float32
+		want       [3]float32
+	}{
+		{"front", 0, 0, [3]float32{1, 2, 8}},
+		{"side", math32.Pi / 2, 0, [3]float32{6, 2, 3}},
+		{"above", 0, math32.Pi / 6, [3]float32{1, 4.5, 3 + 5*math32.Sqrt(3)/2}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := testCamera()
+			c.Target = [3]float32{1, 2, 3}
+			c.Yaw, c.Pitch = test.yaw, test.pitch
+			if got := c.Eye(); !floatsNear(got[:], test.want[:]) {
+				t.Errorf("eye at %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestOrbitCameraPan(t *testing.T) {
+	// The viewport is 200 pixels tall, and the 90 degree field of view spans 10 units at the target's distance of 5,
+	// so a pixel is 0.05 units there.
+	tests := []struct {
+		name       string
+		yaw, pitch float32
+		dx, dy     float32
+		wantTarget [3]float32
+	}{
+		{"right", 0, 0, 20, 0, [3]float32{-1, 0, 0}},
+		{"down", 0, 0, 0, 20, [3]float32{0, 1, 0}},
+		// Looking down -X, the camera's right is -Z.
+		{"turned", math32.Pi / 2, 0, 20, 0, [3]float32{0, 0, 1}},
+		{"pitched", 0, math32.Pi / 4, 20, 20, [3]float32{-1, math32.Sqrt(2) / 2, -math32.Sqrt(2) / 2}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := testCamera()
+			c.Yaw, c.Pitch = test.yaw, test.pitch
+			before := screenPos(c, [3]float32{}, 200, 200)
+
+			c.Pan(test.dx, test.dy, 200)
+			if !floatsNear(c.Target[:], test.wantTarget[:]) {
+				t.Errorf("target moved to %v, want %v", c.Target, test.wantTarget)
+			}
+
+			// The point that was under the cursor stays under it.
+			after := screenPos(c, [3]float32{}, 200, 200)
+			moved := []float32{after[0] - before[0], after[1] - before[1]}
+			if !floatsNear(moved, []float32{test.dx, test.dy}) {
+				t.Errorf("the origin moved %v pixels on screen, want %v", moved, []float32{test.dx, test.dy})
+			}
+		})
+	}
+
+	c := testCamera()
+	c.Pan(20, 20, 0)
+	if c.Target != ([3]float32{}) {
+		t.Errorf("pan in an empty viewport moved the target to %v", c.Target)
+	}
+}
+
+func TestOrbitCameraDolly(t *testing.T) {
+	tests := []struct {
+		name     string
+		notches  float32
+		distance float32
+	}{
+		{"in", 1, 4.5},
+		{"out", -1, 5 / 0.9},
+		{"in twice", 2, 5 * 0.81},
+		// The eye never reaches the target, however far it is dollied in.
+		{"limit", 1000, 1e-3},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := testCamera()
+			c.Dolly(test.notches)
+			if !floatsNear([]float32{c.Distance}, []float32{test.distance}) {
+				t.Errorf("distance is %v, want %v", c.Distance, test.distance)
+			}
+		})
+	}
+}
+
+func TestOrbitCameraFrame(t *testing.T) {
+	tests := []struct {
+		name         string
+		aspect       float32
+		min, max     [3]float32
+		wantTarget   [3]float32
+		wantRadius   float32
+		wantDistance float32
+	}{
+		// The bounding sphere of the box has a radius of 3, and must fit in the 90 degree field of view.
+		{"box", 1, [3]float32{-1, 0, 1}, [3]float32{1, 4, 5}, [3]float32{0, 2, 3}, 3, 3 * math32.Sqrt(2)},
+		// Wider than tall, the vertical field of view is still the narrower one.
+		{"wide", 2, [3]float32{-1, 0, 1}, [3]float32{1, 4, 5}, [3]float32{0, 2, 3}, 3, 3 * math32.Sqrt(2)},
+		// Half as wide as tall, the horizontal field of view is 2 * atan(0.5), and its half-angle has a sine of
+		// 1 / sqrt(5).
+		{"tall", 0.5, [3]float32{-1, 0, 1}, [3]float32{1, 4, 5}, [3]float32{0, 2, 3}, 3, 3 * math32.Sqrt(5)},
+		// A single point is framed as if it had a radius of 1.
+		{"point", 1, [3]float32{1, 1, 1}, [3]float32{1, 1, 1}, [3]float32{1, 1, 1}, 1, math32.Sqrt(2)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := testCamera()
+			c.Aspect = test.aspect
+			c.Frame(test.min, test.max)
+			if !floatsNear(c.Target[:], test.wantTarget[:]) {
+				t.Errorf("target is %v, want %v", c.Target, test.wantTarget)
+			}
+			if !floatsNear([]float32{c.Radius, c.Distance}, []float32{test.wantRadius, test.wantDistance}) {
+				t.Errorf("radius and distance are %v, %v; want %v, %v", c.Radius, c.Distance, test.wantRadius, test.wantDistance)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/bbredesen/gltf-viewer/vkctx"
+	"github.com/bbredesen/go-vk"
+)
+
+// runListDevices prints every Vulkan device, with the index that -device accepts, and marks the one that would be
+// used. It needs no window, so it works with software drivers like lavapipe on machines without a display.
+func runListDevices() int {
+	var ctx vkctx.Context
+	ctx.EnableDeviceExtensions = []string{vk.KHR_SWAPCHAIN_EXTENSION_NAME, vk.EXT_ROBUSTNESS_2_EXTENSION_NAME}
+	ctx.DeviceSelector = *deviceSelector
+
+	devices, err := ctx.ListDevices()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		return 1
+	}
+	if len(devices) == 0 {
+		fmt.Fprintf(os.Stderr, "no Vulkan devices found\n")
+		return 1
+	}
+
+	for _, d := range devices {
+		mark := " "
+		if d.Selected {
+			mark = "*"
+		}
+		fmt.Printf("%s %d: %s\n", mark, d.Index, d.Name)
+		fmt.Printf("    type:        %s\n", d.TypeName())
+		fmt.Printf("    vendor:      0x%04x, device 0x%04x\n", d.VendorID, d.DeviceID)
+		fmt.Printf("    api:         %s\n", vkctx.VersionString(d.APIVersion))
+		fmt.Printf("    driver:      %s\n", vkctx.DriverVersionString(d.VendorID, d.DriverVersion))
+		fmt.Printf("    memory:      %.1f GiB device local\n", float64(d.DeviceLocalMemory)/(1<<30))
+
+		exts := make([]string, len(ctx.EnableDeviceExtensions))
+		for i, name := range ctx.EnableDeviceExtensions {
+			exts[i] = name + " " + yesNo(d.Extensions[name])
+		}
+		fmt.Printf("    extensions:  %s\n", strings.Join(exts, ", "))
+		fmt.Printf("    anisotropy:  %s\n", yesNo(d.SamplerAnisotropy))
+
+		if d.Unsuitable != "" {
+			fmt.Printf("    unsuitable:  %s\n", d.Unsuitable)
+		} else {
+			fmt.Printf("    score:       %d\n", d.Score)
+		}
+	}
+	return 0
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
@@ -1,10 +1,11 @@
 package main
 
 import (
-	"errors"
-	"unsafe"
+	"fmt"
+	"os"
 
 	"github.com/bbredesen/gltf"
+	"github.com/bbredesen/gltf-viewer/vkctx"
 	"github.com/bbredesen/go-vk"
 	"github.com/bbredesen/vkm"
 	"github.com/chewxy/math32"
@@ -13,27 +14,14 @@ import (
 func (app *App) destroyBuffers() {
 	for i := range app.buffers {
 		vk.DestroyBuffer(app.Device, app.buffers[i], nil)
-		vk.FreeMemory(app.Device, app.bufferMemories[i], nil)
+		app.Free(app.bufferMemories[i])
 	}
 	app.buffers = nil
 	app.bufferMemories = nil
 }
 
-func (app *App) copyBuffer(srcBuffer, dstBuffer vk.Buffer, size vk.DeviceSize) {
-	cbuf := app.BeginOneTimeCommands()
-
-	region := vk.BufferCopy{
-		SrcOffset: 0,
-		DstOffset: 0,
-		Size:      size,
-	}
-
-	vk.CmdCopyBuffer(cbuf, srcBuffer, dstBuffer, []vk.BufferCopy{region})
-
-	app.EndOneTimeCommands(cbuf)
-}
-
-func (app *App) createBuffer(usage vk.BufferUsageFlags, size vk.DeviceSize, memProps vk.MemoryPropertyFlags) (buffer vk.Buffer, memory vk.DeviceMemory) {
+// createBuffer creates a buffer, and allocates and binds memory with memProps for it.
+func (app *App) createBuffer(usage vk.BufferUsageFlags, size vk.DeviceSize, memProps vk.MemoryPropertyFlags) (vk.Buffer, *vkctx.Allocation, error) {
 
 	bufferCI := vk.BufferCreateInfo{
 		Size:        size,
@@ -41,27 +29,18 @@ func (app *App) createBuffer(usage vk.BufferUsageFlags, size vk.DeviceSize, memP
 		SharingMode: vk.SHARING_MODE_EXCLUSIVE,
 	}
 
-	var err error
-
-	if buffer, err = vk.CreateBuffer(app.Device, &bufferCI, nil); err != nil {
-		panic("Could not create buffer: " + err.Error())
+	buffer, err := vk.CreateBuffer(app.Device, &bufferCI, nil)
+	if err != nil {
+		return buffer, nil, fmt.Errorf("could not create buffer: %w", err)
 	}
 
-	memReq := vk.GetBufferMemoryRequirements(app.Device, buffer)
-
-	memAllocInfo := vk.MemoryAllocateInfo{
-		AllocationSize:  memReq.Size,
-		MemoryTypeIndex: uint32(app.FindMemoryType(memReq.MemoryTypeBits, memProps)), //vk.MEMORY_PROPERTY_HOST_VISIBLE_BIT|vk.MEMORY_PROPERTY_HOST_COHERENT_BIT)),
-	}
-
-	if memory, err = vk.AllocateMemory(app.Device, &memAllocInfo, nil); err != nil {
-		panic("Could not allocate memory for buffer: " + err.Error())
-	}
-	if err = vk.BindBufferMemory(app.Device, buffer, memory, 0); err != nil {
-		panic("Could not bind memory for buffer: " + err.Error())
+	memory, err := app.AllocateBuffer(buffer, memProps)
+	if err != nil {
+		vk.DestroyBuffer(app.Device, buffer, nil)
+		return vk.Buffer(vk.NULL_HANDLE), nil, fmt.Errorf("could not allocate memory for buffer: %w", err)
 	}
 
-	return
+	return buffer, memory, nil
 }
 
 // glTF specifies that the default camera is at the origin, and defines the camera space as looking at -Z, but not much
@@ -80,36 +59,63 @@ func defaultCamera() vkm.Mat {
 	}
 }
 
-func (app *App) loadGlTF(doc *gltf.ResolvedGlTF) error {
-	app.modelDoc = doc
+// sceneCameras returns the projection matrices for the cameras defined in doc, or just the default camera if there are
+// none.
+func sceneCameras(doc *gltf.ResolvedGlTF) []vkm.Mat {
+	var cameras []vkm.Mat
 
 	for _, cam := range doc.Cameras {
 		if cam.Type == gltf.PERSPECTIVE {
-			app.cameras = append(app.cameras, vkm.GlTFPerspective(cam.Perspective.Yfov, cam.Perspective.AspectRatio, cam.Perspective.Znear, cam.Perspective.Zfar))
+			cameras = append(cameras, vkm.GlTFPerspective(cam.Perspective.Yfov, cam.Perspective.AspectRatio, cam.Perspective.Znear, cam.Perspective.Zfar))
 		} else if cam.Type == gltf.ORTHOGRAPHIC {
-			app.cameras = append(app.cameras, vkm.GlTFOrthoProjection(cam.Orthographic.Xmag, cam.Orthographic.Ymag, cam.Orthographic.Znear, cam.Orthographic.Zfar))
+			cameras = append(cameras, vkm.GlTFOrthoProjection(cam.Orthographic.Xmag, cam.Orthographic.Ymag, cam.Orthographic.Znear, cam.Orthographic.Zfar))
 		}
 	}
 
 	// Use the default camera if none have been defined.
-	if len(app.cameras) == 0 {
-		app.cameras = append(app.cameras, defaultCamera())
+	if len(cameras) == 0 {
+		cameras = append(cameras, defaultCamera())
+	}
+
+	return cameras
+}
+
+func (app *App) loadGlTF(doc *gltf.ResolvedGlTF) (err error) {
+	app.modelDoc = doc
+
+	// Buffers and textures are all uploaded in one batch, once everything is created.
+	if err = app.beginUploads(); err != nil {
+		return err
 	}
+	defer func() {
+		if flushErr := app.flushUploads(); err == nil {
+			err = flushErr
+		}
+	}()
+
+	app.cameras = sceneCameras(doc)
 
 	// TODO (Temporarily) override everything with the default camera
 	// app.cameras = []vkm.Mat{defaultCamera()}
 
-	for _, docBuf := range doc.Buffers {
-		vkBuf, bufMem := app.createBuffer(vk.BUFFER_USAGE_VERTEX_BUFFER_BIT|vk.BUFFER_USAGE_INDEX_BUFFER_BIT, vk.DeviceSize(docBuf.ByteLength), vk.MEMORY_PROPERTY_DEVICE_LOCAL_BIT|vk.MEMORY_PROPERTY_HOST_COHERENT_BIT)
-		ptr, err := vk.MapMemory(app.Device, bufMem, 0, vk.DeviceSize(docBuf.ByteLength), 0)
-		if err != nil {
-			vk.DestroyBuffer(app.Device, vkBuf, nil)
-			return errors.New("failed to map memory for buffer, result code was " + err.Error())
+	for i, docBuf := range doc.Buffers {
+		if docBuf.ByteLength < 0 || docBuf.ByteLength > len(docBuf.Data) {
+			return fmt.Errorf("buffer %d has a byteLength of %d, but only %d bytes of data", i, docBuf.ByteLength, len(docBuf.Data))
+		}
+		if docBuf.ByteLength == 0 {
+			// Nothing can read from an empty buffer, and Vulkan has no empty buffers, so it gets a null handle to keep
+			// app.buffers indexed like doc.Buffers.
+			app.buffers = append(app.buffers, vk.Buffer(vk.NULL_HANDLE))
+			app.bufferMemories = append(app.bufferMemories, nil)
+			continue
 		}
 
-		vk.MemCopySlice(unsafe.Pointer(ptr), docBuf.Data)
-		vk.UnmapMemory(app.Device, bufMem)
+		vkBuf, bufMem, err := app.createBufferWithData(vk.BUFFER_USAGE_VERTEX_BUFFER_BIT|vk.BUFFER_USAGE_INDEX_BUFFER_BIT, docBuf.Data[:docBuf.ByteLength])
+		if err != nil {
+			return fmt.Errorf("could not upload buffer %d: %w", i, err)
+		}
 
+		app.SetObjectName(vk.OBJECT_TYPE_BUFFER, uint64(vkBuf), debugName("buffer", len(app.buffers), docBuf.Name))
 		app.buffers = append(app.buffers, vkBuf)
 		app.bufferMemories = append(app.bufferMemories, bufMem)
 	}
@@ -129,76 +135,70 @@ func (app *App) loadGlTF(doc *gltf.ResolvedGlTF) error {
 	// TODO
 
 	app.scene = NewScene(doc.Scene)
-	return nil
+	app.animation = NewAnimationPlayer(doc, app.scene)
+	if err := app.animation.Configure(app.Animation); err != nil {
+		// Show the model anyway, with the default animation.
+		fmt.Fprintf(os.Stderr, "warning: %s\n", err.Error())
+	}
+	app.frameModel()
+
+	if err := app.createEnvironment(); err != nil {
+		return err
+	}
+	if err := app.createMaterials(); err != nil {
+		return err
+	}
+	if err := app.createJointBuffer(); err != nil {
+		return err
+	}
+	if err := app.createMorphWeightBuffer(); err != nil {
+		return err
+	}
+	if err := app.createDrawData(); err != nil {
+		return err
+	}
+	return app.createDeformSet()
 }
 
-func accessorToFormat(accType gltf.AccessorTypeEnum, compType gltf.ComponentTypeEnum) vk.Format {
-
-	switch accType {
-	case gltf.SCALAR:
-		switch compType {
-		case gltf.BYTE:
-			return vk.FORMAT_R8_SINT
-		case gltf.UNSIGNED_BYTE:
-			return vk.FORMAT_R8_UINT
-		case gltf.SHORT:
-			return vk.FORMAT_R16_SINT
-		case gltf.UNSIGNED_SHORT:
-			return vk.FORMAT_R16_UINT
-		case gltf.UNSIGNED_INT:
-			return vk.FORMAT_R32_UINT
-		case gltf.FLOAT:
-			return vk.FORMAT_R32_SFLOAT
-		}
+// debugName formats the name of a glTF object for SetObjectName, like `mesh 2 "Wheel"`, or `mesh 2` if it has no
+// name.
+func debugName(kind string, index int, name string) string {
+	if name == "" {
+		return fmt.Sprintf("%s %d", kind, index)
+	}
+	return fmt.Sprintf("%s %d %q", kind, index, name)
+}
 
-	case gltf.VEC2:
-		switch compType {
-		case gltf.BYTE:
-			return vk.FORMAT_R8G8_SINT
-		case gltf.UNSIGNED_BYTE:
-			return vk.FORMAT_R8G8_UINT
-		case gltf.SHORT:
-			return vk.FORMAT_R16G16_SINT
-		case gltf.UNSIGNED_SHORT:
-			return vk.FORMAT_R16G16_UINT
-		case gltf.UNSIGNED_INT:
-			return vk.FORMAT_R32G32_UINT
-		case gltf.FLOAT:
-			return vk.FORMAT_R32G32_SFLOAT
-		}
+type formatKey struct {
+	compType   gltf.ComponentTypeEnum
+	normalized bool
+}
 
-	case gltf.VEC3:
-		switch compType {
-		case gltf.BYTE:
-			return vk.FORMAT_R8G8B8_SINT
-		case gltf.UNSIGNED_BYTE:
-			return vk.FORMAT_R8G8B8_UINT
-		case gltf.SHORT:
-			return vk.FORMAT_R16G16B16_SINT
-		case gltf.UNSIGNED_SHORT:
-			return vk.FORMAT_R16G16B16_UINT
-		case gltf.UNSIGNED_INT:
-			return vk.FORMAT_R32G32B32_UINT
-		case gltf.FLOAT:
-			return vk.FORMAT_R32G32B32_SFLOAT
-		}
+// accessorFormats holds the formats for SCALAR, VEC2, VEC3 and VEC4 elements of each component type.
+var accessorFormats = map[formatKey][4]vk.Format{
+	{gltf.BYTE, false}:           {vk.FORMAT_R8_SINT, vk.FORMAT_R8G8_SINT, vk.FORMAT_R8G8B8_SINT, vk.FORMAT_R8G8B8A8_SINT},
+	{gltf.BYTE, true}:            {vk.FORMAT_R8_SNORM, vk.FORMAT_R8G8_SNORM, vk.FORMAT_R8G8B8_SNORM, vk.FORMAT_R8G8B8A8_SNORM},
+	{gltf.UNSIGNED_BYTE, false}:  {vk.FORMAT_R8_UINT, vk.FORMAT_R8G8_UINT, vk.FORMAT_R8G8B8_UINT, vk.FORMAT_R8G8B8A8_UINT},
+	{gltf.UNSIGNED_BYTE, true}:   {vk.FORMAT_R8_UNORM, vk.FORMAT_R8G8_UNORM, vk.FORMAT_R8G8B8_UNORM, vk.FORMAT_R8G8B8A8_UNORM},
+	{gltf.SHORT, false}:          {vk.FORMAT_R16_SINT, vk.FORMAT_R16G16_SINT, vk.FORMAT_R16G16B16_SINT, vk.FORMAT_R16G16B16A16_SINT},
+	{gltf.SHORT, true}:           {vk.FORMAT_R16_SNORM, vk.FORMAT_R16G16_SNORM, vk.FORMAT_R16G16B16_SNORM, vk.FORMAT_R16G16B16A16_SNORM},
+	{gltf.UNSIGNED_SHORT, false}: {vk.FORMAT_R16_UINT, vk.FORMAT_R16G16_UINT, vk.FORMAT_R16G16B16_UINT, vk.FORMAT_R16G16B16A16_UINT},
+	{gltf.UNSIGNED_SHORT, true}:  {vk.FORMAT_R16_UNORM, vk.FORMAT_R16G16_UNORM, vk.FORMAT_R16G16B16_UNORM, vk.FORMAT_R16G16B16A16_UNORM},
+	{gltf.UNSIGNED_INT, false}:   {vk.FORMAT_R32_UINT, vk.FORMAT_R32G32_UINT, vk.FORMAT_R32G32B32_UINT, vk.FORMAT_R32G32B32A32_UINT},
+	{gltf.FLOAT, false}:          {vk.FORMAT_R32_SFLOAT, vk.FORMAT_R32G32_SFLOAT, vk.FORMAT_R32G32B32_SFLOAT, vk.FORMAT_R32G32B32A32_SFLOAT},
+}
 
-	case gltf.VEC4:
-		switch compType {
-		case gltf.BYTE:
-			return vk.FORMAT_R8G8B8A8_SINT
-		case gltf.UNSIGNED_BYTE:
-			return vk.FORMAT_R8G8B8A8_UINT
-		case gltf.SHORT:
-			return vk.FORMAT_R16G16B16A16_SINT
-		case gltf.UNSIGNED_SHORT:
-			return vk.FORMAT_R16G16B16A16_UINT
-		case gltf.UNSIGNED_INT:
-			return vk.FORMAT_R32G32B32A32_UINT
-		case gltf.FLOAT:
-			return vk.FORMAT_R32G32B32A32_SFLOAT
-		}
+// accessorToFormat returns the Vulkan format matching an accessor's element layout. Normalized integer components map
+// to UNORM and SNORM formats, which the shader reads as floats in 0..1 or -1..1, and others to UINT and SINT formats.
+// MAT types, and combinations with no matching format, return FORMAT_UNDEFINED.
+func accessorToFormat(accType gltf.AccessorTypeEnum, compType gltf.ComponentTypeEnum, normalized bool) vk.Format {
+	n := componentCount(accType)
+	if compType == gltf.FLOAT {
+		// The flag is meaningless for floats.
+		normalized = false
+	}
+	if f, ok := accessorFormats[formatKey{compType, normalized}]; ok && accType != gltf.MAT2 && n >= 1 && n <= 4 {
+		return f[n-1]
 	}
-
 	return vk.FORMAT_UNDEFINED
 }
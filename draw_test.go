@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/bbredesen/gltf"
+	"github.com/bbredesen/go-vk"
+)
+
+func TestAccessorToFormat(t *testing.T) {
+	// The formats for SCALAR, VEC2, VEC3 and VEC4 of each component type. KHR_mesh_quantization allows positions,
+	// normals, tangents and texture coordinates to be BYTE or SHORT, normalized or not, so all of these can reach the
+	// vertex input state.
+	tests := []struct {
+		compType   gltf.ComponentTypeEnum
+		normalized bool
+		want       [4]vk.Format
+	}{
+		{gltf.BYTE, false, [4]vk.Format{vk.FORMAT_R8_SINT, vk.FORMAT_R8G8_SINT, vk.FORMAT_R8G8B8_SINT, vk.FORMAT_R8G8B8A8_SINT}},
+		{gltf.BYTE, true, [4]vk.Format{vk.FORMAT_R8_SNORM, vk.FORMAT_R8G8_SNORM, vk.FORMAT_R8G8B8_SNORM, vk.FORMAT_R8G8B8A8_SNORM}},
+		{gltf.UNSIGNED_BYTE, false, [4]vk.Format{vk.FORMAT_R8_UINT, vk.FORMAT_R8G8_UINT, vk.FORMAT_R8G8B8_UINT, vk.FORMAT_R8G8B8A8_UINT}},
+		{gltf.UNSIGNED_BYTE, true, [4]vk.Format{vk.FORMAT_R8_UNORM, vk.FORMAT_R8G8_UNORM, vk.FORMAT_R8G8B8_UNORM, vk.FORMAT_R8G8B8A8_UNORM}},
+		{gltf.SHORT, false, [4]vk.Format{vk.FORMAT_R16_SINT, vk.FORMAT_R16G16_SINT, vk.FORMAT_R16G16B16_SINT, vk.FORMAT_R16G16B16A16_SINT}},
+		{gltf.SHORT, true, [4]vk.Format{vk.FORMAT_R16_SNORM, vk.FORMAT_R16G16_SNORM, vk.FORMAT_R16G16B16_SNORM, vk.FORMAT_R16G16B16A16_SNORM}},
+		{gltf.UNSIGNED_SHORT, false, [4]vk.Format{vk.FORMAT_R16_UINT, vk.FORMAT_R16G16_UINT, vk.FORMAT_R16G16B16_UINT, vk.FORMAT_R16G16B16A16_UINT}},
+		{gltf.UNSIGNED_SHORT, true, [4]vk.Format{vk.FORMAT_R16_UNORM, vk.FORMAT_R16G16_UNORM, vk.FORMAT_R16G16B16_UNORM, vk.FORMAT_R16G16B16A16_UNORM}},
+		{gltf.UNSIGNED_INT, false, [4]vk.Format{vk.FORMAT_R32_UINT, vk.FORMAT_R32G32_UINT, vk.FORMAT_R32G32B32_UINT, vk.FORMAT_R32G32B32A32_UINT}},
+		// There are no 32 bit normalized formats.
+		{gltf.UNSIGNED_INT, true, [4]vk.Format{vk.FORMAT_UNDEFINED, vk.FORMAT_UNDEFINED, vk.FORMAT_UNDEFINED, vk.FORMAT_UNDEFINED}},
+		{gltf.FLOAT, false, [4]vk.Format{vk.FORMAT_R32_SFLOAT, vk.FORMAT_R32G32_SFLOAT, vk.FORMAT_R32G32B32_SFLOAT, vk.FORMAT_R32G32B32A32_SFLOAT}},
+		// normalized is ignored for floats.
+		{gltf.FLOAT, true, [4]vk.Format{vk.FORMAT_R32_SFLOAT, vk.FORMAT_R32G32_SFLOAT, vk.FORMAT_R32G32B32_SFLOAT, vk.FORMAT_R32G32B32A32_SFLOAT}},
+	}
+
+	vectors := []gltf.AccessorTypeEnum{gltf.SCALAR, gltf.VEC2, gltf.VEC3, gltf.VEC4}
+	matrices := []gltf.AccessorTypeEnum{gltf.MAT2, gltf.MAT3, gltf.MAT4}
+	for _, test := range tests {
+		for i, accType := range vectors {
+			if got := accessorToFormat(accType, test.compType, test.normalized); got != test.want[i] {
+				t.Errorf("accessorToFormat(%v, %s, %v) = %v, want %v",
+					accType, componentTypeName(test.compType), test.normalized, got, test.want[i])
+			}
+		}
+		// Matrices are never vertex attributes.
+		for _, accType := range matrices {
+			if got := accessorToFormat(accType, test.compType, test.normalized); got != vk.FORMAT_UNDEFINED {
+				t.Errorf("accessorToFormat(%v, %s, %v) = %v, want FORMAT_UNDEFINED",
+					accType, componentTypeName(test.compType), test.normalized, got)
+			}
+		}
+	}
+
+	if got := accessorToFormat(gltf.VEC3, gltf.ComponentTypeEnum(0), false); got != vk.FORMAT_UNDEFINED {
+		t.Errorf("accessorToFormat with an invalid component type = %v, want FORMAT_UNDEFINED", got)
+	}
+}
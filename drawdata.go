@@ -0,0 +1,334 @@
+package main
+
+import (
+	"fmt"
+	"unsafe"
+
+	"github.com/bbredesen/gltf"
+	"github.com/bbredesen/gltf-viewer/vkctx"
+	"github.com/bbredesen/go-vk"
+)
+
+// drawData is the per-draw uniform block (set 0, binding 0) in the shaders. The layout must match the shaders' std140
+// block.
+type drawData struct {
+	BaseColorFactor   [4]float32
+	EmissiveFactor    [4]float32
+	MetallicFactor    float32
+	RoughnessFactor   float32
+	NormalScale       float32
+	OcclusionStrength float32
+	Flags             uint32
+	// TexCoordSets has bit n set when the texture in textureSlot n samples TEXCOORD_1 instead of TEXCOORD_0.
+	TexCoordSets uint32
+	// MorphTargetCount is the number of morph targets of the primitive, whose deltas start at MorphDeltaBase in the
+	// morph delta buffer.
+	MorphTargetCount uint32
+	MorphDeltaBase   uint32
+}
+
+const (
+	// drawFlagHasColor0 is set when the primitive has a COLOR_0 attribute. Without it the shader ignores the (null)
+	// color binding and uses white.
+	drawFlagHasColor0 uint32 = 1 << iota
+	// drawFlagHasNormal is set when the primitive has a NORMAL attribute. Without it the shader uses the face normal.
+	drawFlagHasNormal
+	// drawFlagHasTangent is set when the primitive has a TANGENT attribute. Without it the shader derives the tangent
+	// from texture coordinate derivatives.
+	drawFlagHasTangent
+	// drawFlagHasNormalTexture is set when the material has a normal texture.
+	drawFlagHasNormalTexture
+	// drawFlagHasJoints is set when the primitive has JOINTS_0 and WEIGHTS_0 attributes. It is only skinned if the
+	// node drawing it also has a skin.
+	drawFlagHasJoints
+)
+
+// primitiveResources holds the per-primitive GPU data built at load time.
+type primitiveResources struct {
+	// drawDataOffset is the dynamic offset of this primitive's drawData in the per-draw uniform buffer.
+	drawDataOffset uint32
+
+	// Attributes that have more than one allowed format are converted to float on load, so that one pipeline handles
+	// every variant. These buffers are bound instead of the glTF buffer.
+	converted map[gltf.AttributeKey]convertedAttribute
+
+	// pipeline is shared by every primitive with the same vertex layout, and vertexSources are bound to its vertex
+	// input bindings in order, see primitiveVertexLayout.
+	pipeline      vk.Pipeline
+	vertexSources []vertexSource
+
+	// topology and the index buffer come from primitiveIndices. indexCount is 0 for non-indexed primitives, which draw
+	// vertexCount vertices instead. convertedIndices is set when the index buffer was created on load.
+	topology         vk.PrimitiveTopology
+	indexBuffer      vk.Buffer
+	indexOffset      vk.DeviceSize
+	indexType        vk.IndexType
+	indexCount       uint32
+	vertexCount      uint32
+	convertedIndices *convertedAttribute
+
+	// name identifies the primitive in object names and command buffer labels, like `mesh 0 "Cube" primitive 1`.
+	name string
+}
+
+type convertedAttribute struct {
+	buffer vk.Buffer
+	memory *vkctx.Allocation
+}
+
+// convertAttribute uploads host-side vertex data as a new vertex buffer, to be bound for key.
+func (app *App) convertAttribute(res *primitiveResources, key gltf.AttributeKey, data []byte) error {
+	buf, mem, err := app.createBufferWithData(vk.BUFFER_USAGE_VERTEX_BUFFER_BIT, data)
+	if err != nil {
+		return err
+	}
+	app.SetObjectName(vk.OBJECT_TYPE_BUFFER, uint64(buf), fmt.Sprintf("%s %v", res.name, key))
+	res.converted[key] = convertedAttribute{buffer: buf, memory: mem}
+	return nil
+}
+
+// primitiveNames names every primitive in doc by its mesh and its index in the mesh, for debugging tools.
+func primitiveNames(doc *gltf.ResolvedGlTF) map[*gltf.ResolvedPrimitive]string {
+	names := make(map[*gltf.ResolvedPrimitive]string)
+	for mi, m := range doc.Meshes {
+		for pi, p := range m.Primitives {
+			names[p] = fmt.Sprintf("%s primitive %d", debugName("mesh", mi, m.Name), pi)
+		}
+	}
+	return names
+}
+
+// collectPrimitives returns every primitive reachable from n, each exactly once, in traversal order.
+func collectPrimitives(n *SceneNode, seen map[*gltf.ResolvedPrimitive]bool, out []*gltf.ResolvedPrimitive) []*gltf.ResolvedPrimitive {
+	if n.ModelNode != nil && n.ModelNode.Mesh != nil {
+		for _, p := range n.ModelNode.Mesh.Primitives {
+			if !seen[p] {
+				seen[p] = true
+				out = append(out, p)
+			}
+		}
+	}
+	for _, child := range n.Children {
+		out = collectPrimitives(child, seen, out)
+	}
+	return out
+}
+
+// createDrawData builds the per-draw uniform buffer and converted vertex attributes for every primitive in the scene,
+// and writes the buffer into the draw data descriptor set.
+func (app *App) createDrawData() error {
+	prims := collectPrimitives(app.scene, make(map[*gltf.ResolvedPrimitive]bool), nil)
+	fans := app.supportsTriangleFans()
+
+	align := uint32(vk.GetPhysicalDeviceProperties(app.PhysicalDevice).Limits.MinUniformBufferOffsetAlignment)
+	stride := uint32(unsafe.Sizeof(drawData{}))
+	if align > 0 {
+		stride = (stride + align - 1) / align * align
+	}
+	app.drawDataStride = stride
+
+	data := make([]byte, int(stride)*(len(prims)+1))
+	var morphDeltas [][4]float32
+	app.primitives = make(map[*gltf.ResolvedPrimitive]*primitiveResources, len(prims))
+	names := primitiveNames(app.modelDoc)
+
+	for i, p := range prims {
+		res := &primitiveResources{
+			drawDataOffset: uint32(i) * stride,
+			converted:      make(map[gltf.AttributeKey]convertedAttribute),
+			name:           names[p],
+		}
+
+		factors := materialFactorsOf(p.Material)
+		dd := drawData{
+			BaseColorFactor:   factors.BaseColor,
+			EmissiveFactor:    [4]float32{factors.Emissive[0], factors.Emissive[1], factors.Emissive[2], 0},
+			MetallicFactor:    factors.Metallic,
+			RoughnessFactor:   factors.Roughness,
+			NormalScale:       factors.NormalScale,
+			OcclusionStrength: factors.OcclusionStrength,
+		}
+		if _, ok := p.Attributes[gltf.NORMAL]; ok {
+			dd.Flags |= drawFlagHasNormal
+		}
+		if _, ok := p.Attributes[gltf.TANGENT]; ok {
+			dd.Flags |= drawFlagHasTangent
+		}
+
+		colors, err := readColor(app.modelDoc, p.Attributes[gltf.COLOR_0])
+		if err != nil {
+			return fmt.Errorf("%s: %w", res.name, attributeError(gltf.COLOR_0, err))
+		}
+		if len(colors) > 0 {
+			dd.Flags |= drawFlagHasColor0
+
+			colorBytes := unsafe.Slice((*byte)(unsafe.Pointer(&colors[0])), len(colors)*int(unsafe.Sizeof(colors[0])))
+			if err := app.convertAttribute(res, gltf.COLOR_0, colorBytes); err != nil {
+				return err
+			}
+		}
+
+		for _, key := range []gltf.AttributeKey{gltf.TEXCOORD_0, gltf.TEXCOORD_1} {
+			uvs, err := readTexCoord(app.modelDoc, p.Attributes[key])
+			if err != nil {
+				return fmt.Errorf("%s: %w", res.name, attributeError(key, err))
+			}
+			if len(uvs) > 0 {
+				uvBytes := unsafe.Slice((*byte)(unsafe.Pointer(&uvs[0])), len(uvs)*int(unsafe.Sizeof(uvs[0])))
+				if err := app.convertAttribute(res, key, uvBytes); err != nil {
+					return err
+				}
+			}
+		}
+
+		// POSITION, NORMAL, and TANGENT are bound straight from the glTF buffers when their format allows, see
+		// directVertexFormat, and otherwise converted to float here.
+		for _, key := range []gltf.AttributeKey{gltf.POSITION, gltf.NORMAL, gltf.TANGENT} {
+			acc := p.Attributes[key]
+			if acc == nil {
+				continue
+			}
+			if _, ok := app.directVertexFormat(acc); ok {
+				// Bound as it is, so the device reads it; check it like the readers would.
+				if err := checkAccessor(app.modelDoc, acc); err != nil {
+					return fmt.Errorf("%s: %w", res.name, attributeError(key, err))
+				}
+				continue
+			}
+			values, err := readFloats(app.modelDoc, acc)
+			if err != nil {
+				return fmt.Errorf("%s: %w", res.name, attributeError(key, err))
+			}
+			if len(values) > 0 {
+				valueBytes := unsafe.Slice((*byte)(unsafe.Pointer(&values[0])), len(values)*int(unsafe.Sizeof(values[0])))
+				if err := app.convertAttribute(res, key, valueBytes); err != nil {
+					return err
+				}
+			}
+		}
+
+		joints, err := readJoints(app.modelDoc, p.Attributes[gltf.JOINTS_0])
+		if err != nil {
+			return fmt.Errorf("%s: %w", res.name, attributeError(gltf.JOINTS_0, err))
+		}
+		weights, err := readWeights(app.modelDoc, p.Attributes[gltf.WEIGHTS_0])
+		if err != nil {
+			return fmt.Errorf("%s: %w", res.name, attributeError(gltf.WEIGHTS_0, err))
+		}
+		if len(joints) > 0 && len(weights) > 0 {
+			dd.Flags |= drawFlagHasJoints
+
+			jointBytes := unsafe.Slice((*byte)(unsafe.Pointer(&joints[0])), len(joints)*int(unsafe.Sizeof(joints[0])))
+			if err := app.convertAttribute(res, gltf.JOINTS_0, jointBytes); err != nil {
+				return err
+			}
+			weightBytes := unsafe.Slice((*byte)(unsafe.Pointer(&weights[0])), len(weights)*int(unsafe.Sizeof(weights[0])))
+			if err := app.convertAttribute(res, gltf.WEIGHTS_0, weightBytes); err != nil {
+				return err
+			}
+		}
+
+		targets, err := readMorphTargets(app.modelDoc, p)
+		if err != nil {
+			return fmt.Errorf("%s: %w", res.name, err)
+		}
+		if len(targets) > 0 {
+			if pos := p.Attributes[gltf.POSITION]; pos != nil {
+				dd.MorphTargetCount = uint32(len(targets))
+				dd.MorphDeltaBase = uint32(len(morphDeltas))
+				morphDeltas = appendMorphDeltas(morphDeltas, targets, pos.Count)
+			}
+		}
+
+		for slot, mt := range materialTextures(p.Material) {
+			if mt == nil {
+				continue
+			}
+			if mt.TexCoord == 1 {
+				dd.TexCoordSets |= 1 << slot
+			}
+			if textureSlot(slot) == normalSlot {
+				dd.Flags |= drawFlagHasNormalTexture
+			}
+		}
+
+		if err := app.primitiveIndices(p, res, fans); err != nil {
+			return err
+		}
+
+		layout, sources := app.primitiveVertexLayout(p, res)
+		pipeline, err := app.pipelineFor(pipelineKey{layout: layout, topology: res.topology}, res.name)
+		if err != nil {
+			return err
+		}
+		res.pipeline, res.vertexSources = pipeline, sources
+
+		vk.MemCopyObj(unsafe.Pointer(&data[res.drawDataOffset]), &dd)
+		app.primitives[p] = res
+	}
+
+	var err error
+	if app.drawDataBuffer, app.drawDataMemory, err = app.createBufferWithData(vk.BUFFER_USAGE_UNIFORM_BUFFER_BIT, data); err != nil {
+		return err
+	}
+	if err := app.createMorphDeltaBuffer(morphDeltas); err != nil {
+		return err
+	}
+
+	return app.writeDrawDataDescriptor()
+}
+
+func (app *App) writeDrawDataDescriptor() error {
+	poolCI := vk.DescriptorPoolCreateInfo{
+		MaxSets: 1,
+		PPoolSizes: []vk.DescriptorPoolSize{
+			{Type: vk.DESCRIPTOR_TYPE_UNIFORM_BUFFER_DYNAMIC, DescriptorCount: 1},
+		},
+	}
+
+	var err error
+	if app.drawDataPool, err = vk.CreateDescriptorPool(app.Device, &poolCI, nil); err != nil {
+		return fmt.Errorf("could not create descriptor pool: %w", err)
+	}
+
+	allocInfo := vk.DescriptorSetAllocateInfo{
+		DescriptorPool: app.drawDataPool,
+		PSetLayouts:    []vk.DescriptorSetLayout{app.drawDataSetLayout},
+	}
+	sets, err := vk.AllocateDescriptorSets(app.Device, &allocInfo)
+	if err != nil {
+		return fmt.Errorf("could not allocate descriptor set: %w", err)
+	}
+	app.drawDataSet = sets[0]
+
+	write := vk.WriteDescriptorSet{
+		DstSet:          app.drawDataSet,
+		DstBinding:      0,
+		DstArrayElement: 0,
+		DescriptorType:  vk.DESCRIPTOR_TYPE_UNIFORM_BUFFER_DYNAMIC,
+		PBufferInfo: []vk.DescriptorBufferInfo{
+			{Buffer: app.drawDataBuffer, Offset: 0, Range: vk.DeviceSize(unsafe.Sizeof(drawData{}))},
+		},
+	}
+	vk.UpdateDescriptorSets(app.Device, []vk.WriteDescriptorSet{write}, nil)
+
+	return nil
+}
+
+func (app *App) destroyDrawData() {
+	for _, res := range app.primitives {
+		for _, conv := range res.converted {
+			vk.DestroyBuffer(app.Device, conv.buffer, nil)
+			app.Free(conv.memory)
+		}
+		if res.convertedIndices != nil {
+			vk.DestroyBuffer(app.Device, res.convertedIndices.buffer, nil)
+			app.Free(res.convertedIndices.memory)
+		}
+	}
+	app.primitives = nil
+
+	vk.DestroyDescriptorPool(app.Device, app.drawDataPool, nil)
+	vk.DestroyBuffer(app.Device, app.drawDataBuffer, nil)
+	app.Free(app.drawDataMemory)
+}
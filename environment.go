@@ -0,0 +1,235 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"unsafe"
+
+	"github.com/bbredesen/go-vk"
+)
+
+// sceneData is the per-frame uniform block (set 2, binding 0) in shader.frag. The layout must match the shader's
+// std140 block.
+type sceneData struct {
+	EyePosition       [4]float32
+	SpecularMipLevels float32
+	_                 [3]float32
+}
+
+// createEnvironment uploads the image-based lighting maps and writes one scene descriptor set (set 2) per frame in
+// flight. Each set has its own slice of the scene uniform buffer, which stays mapped so SetCamera can write it while
+// earlier frames are still being drawn.
+func (app *App) createEnvironment() error {
+	env := defaultEnvironment()
+
+	var err error
+	if app.envIrradiance, err = app.uploadTexture(cubeMapTextureData(env.irradiance), "environment irradiance"); err != nil {
+		return err
+	}
+	if app.envSpecular, err = app.uploadTexture(cubeMapTextureData(env.specular), "environment specular"); err != nil {
+		return err
+	}
+	if app.envBRDFLUT, err = app.uploadTexture(brdfLUTTextureData(env), "BRDF lookup table"); err != nil {
+		return err
+	}
+
+	samplerCI := vk.SamplerCreateInfo{
+		MagFilter:    vk.FILTER_LINEAR,
+		MinFilter:    vk.FILTER_LINEAR,
+		MipmapMode:   vk.SAMPLER_MIPMAP_MODE_LINEAR,
+		AddressModeU: vk.SAMPLER_ADDRESS_MODE_CLAMP_TO_EDGE,
+		AddressModeV: vk.SAMPLER_ADDRESS_MODE_CLAMP_TO_EDGE,
+		AddressModeW: vk.SAMPLER_ADDRESS_MODE_CLAMP_TO_EDGE,
+		CompareOp:    vk.COMPARE_OP_ALWAYS,
+		BorderColor:  vk.BORDER_COLOR_INT_OPAQUE_BLACK,
+		MinLod:       0,
+		MaxLod:       float32(iblSpecularLevels),
+	}
+	if app.envSampler, err = vk.CreateSampler(app.Device, &samplerCI, nil); err != nil {
+		return fmt.Errorf("could not create environment sampler: %w", err)
+	}
+
+	frames := app.ctx.MaxFramesInFlight
+
+	align := uint32(vk.GetPhysicalDeviceProperties(app.PhysicalDevice).Limits.MinUniformBufferOffsetAlignment)
+	stride := uint32(unsafe.Sizeof(sceneData{}))
+	if align > 0 {
+		stride = (stride + align - 1) / align * align
+	}
+	app.sceneDataStride = stride
+
+	size := vk.DeviceSize(stride) * vk.DeviceSize(frames)
+	if app.sceneDataBuffer, app.sceneDataMemory, err = app.createBuffer(vk.BUFFER_USAGE_UNIFORM_BUFFER_BIT, size, vk.MEMORY_PROPERTY_HOST_VISIBLE_BIT|vk.MEMORY_PROPERTY_HOST_COHERENT_BIT); err != nil {
+		return err
+	}
+
+	ptr, err := app.MapMemory(app.sceneDataMemory)
+	if err != nil {
+		return fmt.Errorf("failed to map scene uniform buffer, result code was %w", err)
+	}
+	app.sceneDataMapped = unsafe.Slice((*byte)(ptr), int(size))
+
+	poolCI := vk.DescriptorPoolCreateInfo{
+		MaxSets: uint32(frames),
+		PPoolSizes: []vk.DescriptorPoolSize{
+			{Type: vk.DESCRIPTOR_TYPE_UNIFORM_BUFFER, DescriptorCount: uint32(frames)},
+			{Type: vk.DESCRIPTOR_TYPE_COMBINED_IMAGE_SAMPLER, DescriptorCount: 3 * uint32(frames)},
+		},
+	}
+	if app.scenePool, err = vk.CreateDescriptorPool(app.Device, &poolCI, nil); err != nil {
+		return fmt.Errorf("could not create scene descriptor pool: %w", err)
+	}
+
+	layouts := make([]vk.DescriptorSetLayout, frames)
+	for i := range layouts {
+		layouts[i] = app.sceneSetLayout
+	}
+	if app.sceneSets, err = vk.AllocateDescriptorSets(app.Device, &vk.DescriptorSetAllocateInfo{
+		DescriptorPool: app.scenePool,
+		PSetLayouts:    layouts,
+	}); err != nil {
+		return fmt.Errorf("could not allocate scene descriptor sets: %w", err)
+	}
+
+	imageWrite := func(set vk.DescriptorSet, binding uint32, tex *textureImage) vk.WriteDescriptorSet {
+		return vk.WriteDescriptorSet{
+			DstSet:          set,
+			DstBinding:      binding,
+			DstArrayElement: 0,
+			DescriptorType:  vk.DESCRIPTOR_TYPE_COMBINED_IMAGE_SAMPLER,
+			PImageInfo: []vk.DescriptorImageInfo{
+				{Sampler: app.envSampler, ImageView: tex.view, ImageLayout: vk.IMAGE_LAYOUT_SHADER_READ_ONLY_OPTIMAL},
+			},
+		}
+	}
+
+	writes := make([]vk.WriteDescriptorSet, 0, 4*frames)
+	for i, set := range app.sceneSets {
+		writes = append(writes,
+			vk.WriteDescriptorSet{
+				DstSet:          set,
+				DstBinding:      0,
+				DstArrayElement: 0,
+				DescriptorType:  vk.DESCRIPTOR_TYPE_UNIFORM_BUFFER,
+				PBufferInfo: []vk.DescriptorBufferInfo{
+					{Buffer: app.sceneDataBuffer, Offset: vk.DeviceSize(i) * vk.DeviceSize(stride), Range: vk.DeviceSize(unsafe.Sizeof(sceneData{}))},
+				},
+			},
+			imageWrite(set, 1, app.envIrradiance),
+			imageWrite(set, 2, app.envSpecular),
+			imageWrite(set, 3, app.envBRDFLUT),
+		)
+	}
+	vk.UpdateDescriptorSets(app.Device, writes, nil)
+
+	return nil
+}
+
+// writeSceneData updates the scene uniform block used by frame. The frame's previous submission must have completed.
+func (app *App) writeSceneData(frame int, eye [3]float32) {
+	sd := sceneData{
+		EyePosition:       [4]float32{eye[0], eye[1], eye[2], 1},
+		SpecularMipLevels: float32(iblSpecularLevels),
+	}
+	vk.MemCopyObj(unsafe.Pointer(&app.sceneDataMapped[uint32(frame)*app.sceneDataStride]), &sd)
+}
+
+func (app *App) destroyEnvironment() {
+	vk.DestroyDescriptorPool(app.Device, app.scenePool, nil)
+	app.sceneSets = nil
+
+	app.sceneDataMapped = nil
+	vk.DestroyBuffer(app.Device, app.sceneDataBuffer, nil)
+	app.Free(app.sceneDataMemory)
+
+	vk.DestroySampler(app.Device, app.envSampler, nil)
+	for _, tex := range []*textureImage{app.envIrradiance, app.envSpecular, app.envBRDFLUT} {
+		if tex != nil {
+			app.destroyTexture(tex)
+		}
+	}
+	app.envIrradiance, app.envSpecular, app.envBRDFLUT = nil, nil, nil
+}
+
+// cubeMapTextureData converts a cube map to half float RGBA, with the alpha channel set to 1.
+func cubeMapTextureData(c *cubeMap) *textureData {
+	td := &textureData{
+		width:  uint32(c.size),
+		height: uint32(c.size),
+		format: vk.FORMAT_R16G16B16A16_SFLOAT,
+		cube:   true,
+		layers: make([][][]byte, 6),
+	}
+
+	for face := range td.layers {
+		td.layers[face] = make([][]byte, len(c.levels))
+		for level := range c.levels {
+			texels := c.levels[level][face]
+			data := make([]byte, 0, len(texels)*8)
+			for _, t := range texels {
+				data = appendHalf(data, t[0], t[1], t[2], 1)
+			}
+			td.layers[face][level] = data
+		}
+	}
+
+	return td
+}
+
+// brdfLUTTextureData converts the BRDF lookup table to a two channel half float image, with N.V along the x axis and
+// roughness along y.
+func brdfLUTTextureData(env *environment) *textureData {
+	data := make([]byte, 0, len(env.brdfLUT)*4)
+	for _, t := range env.brdfLUT {
+		data = appendHalf(data, t[0], t[1])
+	}
+
+	return &textureData{
+		width:  uint32(env.lutSize),
+		height: uint32(env.lutSize),
+		format: vk.FORMAT_R16G16_SFLOAT,
+		layers: [][][]byte{{data}},
+	}
+}
+
+// appendHalf appends each value to b as a little-endian IEEE 754 half float.
+func appendHalf(b []byte, values ...float32) []byte {
+	for _, v := range values {
+		h := float32ToHalf(v)
+		b = append(b, byte(h), byte(h>>8))
+	}
+	return b
+}
+
+// float32ToHalf converts f to the nearest half float. Values too large for a half become infinity, and values too
+// small become zero; the lighting maps have neither NaNs nor anything near those limits.
+func float32ToHalf(f float32) uint16 {
+	bits := math.Float32bits(f)
+	sign := uint16(bits>>16) & 0x8000
+	exp := int((bits>>23)&0xFF) - 127 + 15
+	mant := bits & 0x7FFFFF
+
+	switch {
+	case exp >= 0x1F:
+		return sign | 0x7C00
+	case exp <= 0:
+		if exp < -10 {
+			return sign
+		}
+		// Subnormal: shift the mantissa, with its implicit leading bit, into place.
+		mant |= 0x800000
+		shift := uint32(14 - exp)
+		h := mant >> shift
+		if mant>>(shift-1)&1 != 0 {
+			h++
+		}
+		return sign | uint16(h)
+	}
+
+	h := uint32(sign) | uint32(exp)<<10 | mant>>13
+	if mant&0x1000 != 0 {
+		// Round to nearest. A carry out of the mantissa correctly increments the exponent.
+		h++
+	}
+	return uint16(h)
+}
@@ -1,11 +1,6 @@
 module github.com/bbredesen/gltf-viewer
 
-go 1.20
-
-replace (
-	github.com/bbredesen/gltf v0.0.0-20230303214809-5e2ce6e666a0 => C:\Users\benbr\go\src\github.com\bbredesen\gltf\
-	github.com/bbredesen/go-vk v0.0.0-20230304035711-844b33e5033c => C:\Users\benbr\go\src\github.com\bbredesen\go-vk\
-)
+go 1.21
 
 require (
 	github.com/bbredesen/gltf v0.0.0-20230303214809-5e2ce6e666a0
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"strconv"
+	"strings"
+	"unsafe"
+
+	"github.com/bbredesen/go-vk"
+)
+
+// InitializeHeadless sets up Vulkan to render into an offscreen image instead of a window. No surface or swapchain
+// extensions are requested, so this can run against a software ICD on a machine without a display. As with
+// Initialize, Teardown must not be called if this fails.
+func (app *App) InitializeHeadless(extent vk.Extent2D) error {
+	app.EnableDeviceExtensions = append(app.EnableDeviceExtensions, vk.EXT_ROBUSTNESS_2_EXTENSION_NAME)
+
+	if err := app.Context.InitializeHeadless(extent); err != nil {
+		return fmt.Errorf("could not initialize Vulkan: %w", err)
+	}
+
+	if err := app.VulkanPipeline.Initialize(&app.Context); err != nil {
+		app.Context.Teardown()
+		return fmt.Errorf("could not create the render pipeline: %w", err)
+	}
+	return nil
+}
+
+// RenderToPNG draws a single frame of the loaded scene and writes the result to filename.
+func (app *App) RenderToPNG(filename string) error {
+	app.currentImage, app.currentFrame = 0, 0
+	cb := app.ctx.CommandBuffers[app.currentFrame]
+	fence := app.ctx.InFlightFences[app.currentFrame]
+
+	vk.ResetFences(app.ctx.Device, []vk.Fence{fence})
+	app.recordRenderingCommands(cb)
+
+	submitInfo := vk.SubmitInfo{
+		PCommandBuffers: []vk.CommandBuffer{cb},
+	}
+	if err := vk.QueueSubmit(app.ctx.GraphicsQueue, []vk.SubmitInfo{submitInfo}, fence); err != nil {
+		return fmt.Errorf("could not submit to graphics queue: %w", err)
+	}
+	if err := vk.WaitForFences(app.ctx.Device, []vk.Fence{fence}, true, ^uint64(0)); err != nil {
+		return fmt.Errorf("could not wait for rendering to finish: %w", err)
+	}
+
+	img, err := app.readbackImage()
+	if err != nil {
+		return err
+	}
+
+	return writePNG(filename, img)
+}
+
+func writePNG(filename string, img image.Image) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// readbackImage copies the offscreen color attachment into a host-visible buffer and returns it as an image.RGBA. The
+// render pass leaves the attachment in TRANSFER_SRC_OPTIMAL layout when running headless.
+func (app *App) readbackImage() (*image.RGBA, error) {
+	extent := app.ctx.SwapchainExtent
+	img := image.NewRGBA(image.Rect(0, 0, int(extent.Width), int(extent.Height)))
+	size := vk.DeviceSize(len(img.Pix))
+
+	buf, mem, err := app.createBuffer(vk.BUFFER_USAGE_TRANSFER_DST_BIT, size, vk.MEMORY_PROPERTY_HOST_VISIBLE_BIT|vk.MEMORY_PROPERTY_HOST_COHERENT_BIT)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		vk.DestroyBuffer(app.Device, buf, nil)
+		app.Free(mem)
+	}()
+
+	region := vk.BufferImageCopy{
+		BufferOffset:      0,
+		BufferRowLength:   0, // tightly packed
+		BufferImageHeight: 0,
+		ImageSubresource: vk.ImageSubresourceLayers{
+			AspectMask:     vk.IMAGE_ASPECT_COLOR_BIT,
+			MipLevel:       0,
+			BaseArrayLayer: 0,
+			LayerCount:     1,
+		},
+		ImageOffset: vk.Offset3D{X: 0, Y: 0, Z: 0},
+		ImageExtent: vk.Extent3D{Width: extent.Width, Height: extent.Height, Depth: 1},
+	}
+
+	cbuf, err := app.BeginOneTimeCommands()
+	if err != nil {
+		return nil, err
+	}
+	vk.CmdCopyImageToBuffer(cbuf, app.ctx.SwapchainImages[0], vk.IMAGE_LAYOUT_TRANSFER_SRC_OPTIMAL, buf, []vk.BufferImageCopy{region})
+	if err := app.EndOneTimeCommands(cbuf); err != nil {
+		return nil, err
+	}
+
+	ptr, err := app.MapMemory(mem)
+	if err != nil {
+		return nil, fmt.Errorf("failed to map readback buffer: %w", err)
+	}
+	copy(img.Pix, unsafe.Slice((*byte)(ptr), len(img.Pix)))
+
+	// The shader writes the base color alpha, but blending isn't implemented and the swapchain composites as opaque, so
+	// match that here.
+	for i := 3; i < len(img.Pix); i += 4 {
+		img.Pix[i] = 0xFF
+	}
+
+	return img, nil
+}
+
+// parseSize parses a "WIDTHxHEIGHT" string, as passed to -size.
+func parseSize(s string) (vk.Extent2D, error) {
+	w, h, ok := strings.Cut(strings.ToLower(s), "x")
+	if !ok {
+		return vk.Extent2D{}, fmt.Errorf("size %q is not in the form WIDTHxHEIGHT", s)
+	}
+
+	width, err := strconv.ParseUint(w, 10, 32)
+	if err != nil || width == 0 {
+		return vk.Extent2D{}, fmt.Errorf("invalid width in size %q", s)
+	}
+	height, err := strconv.ParseUint(h, 10, 32)
+	if err != nil || height == 0 {
+		return vk.Extent2D{}, fmt.Errorf("invalid height in size %q", s)
+	}
+
+	return vk.Extent2D{Width: uint32(width), Height: uint32(height)}, nil
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/chewxy/math32"
+)
+
+// The default image-based lighting environment is a procedural sky. At startup it is convolved on the CPU into the
+// maps used by the split-sum approximation: a diffuse irradiance cube map, a specular cube map prefiltered for a range
+// of roughness values (one per mip level), and the BRDF lookup table. The Vulkan renderer uploads these, and the
+// software renderer samples the same data directly.
+
+const (
+	iblSpecularSize   = 128
+	iblSpecularLevels = 6 // 128 down to 4 texels per side; the last level is for roughness 1
+	iblIrradianceSize = 32
+	iblBRDFLUTSize    = 128
+	iblSampleCount    = 256
+)
+
+// sunDirection points towards the sun in the default sky. It is the direction of the static light the viewer used
+// before image-based lighting.
+var sunDirection = normalize3([3]float32{500, 300, 500})
+
+// skyRadiance returns the radiance of the default environment in direction dir: a blue gradient above a neutral
+// ground, with a soft sun.
+func skyRadiance(dir [3]float32) [3]float32 {
+	zenith := [3]float32{0.25, 0.45, 0.85}
+	horizon := [3]float32{0.80, 0.85, 0.90}
+	ground := [3]float32{0.30, 0.27, 0.24}
+	sunColor := [3]float32{12, 11.4, 10.2}
+
+	var c [3]float32
+	if dir[1] >= 0 {
+		c = mix3(horizon, zenith, math32.Sqrt(dir[1]))
+	} else {
+		c = mix3(horizon, ground, math32.Pow(-dir[1], 0.35))
+	}
+
+	if s := dot3(dir, sunDirection); s > 0 {
+		c = add3(c, scale3(sunColor, math32.Pow(s, 32)))
+	}
+	return c
+}
+
+// cubeFaceDir returns the direction through face coordinates (u, v) in [-1, 1] of a cube map face. Faces are in
+// layer order +X, -X, +Y, -Y, +Z, -Z, with v increasing down the image, per the Vulkan cube map face selection table.
+func cubeFaceDir(face int, u, v float32) [3]float32 {
+	var d [3]float32
+	switch face {
+	case 0:
+		d = [3]float32{1, -v, -u}
+	case 1:
+		d = [3]float32{-1, -v, u}
+	case 2:
+		d = [3]float32{u, 1, v}
+	case 3:
+		d = [3]float32{u, -1, -v}
+	case 4:
+		d = [3]float32{u, -v, 1}
+	case 5:
+		d = [3]float32{-u, -v, -1}
+	}
+	return normalize3(d)
+}
+
+// cubeFaceUV is the inverse of cubeFaceDir.
+func cubeFaceUV(d [3]float32) (face int, u, v float32) {
+	ax, ay, az := math32.Abs(d[0]), math32.Abs(d[1]), math32.Abs(d[2])
+
+	var sc, tc, ma float32
+	switch {
+	case ax >= ay && ax >= az:
+		ma, tc = ax, -d[1]
+		if d[0] > 0 {
+			face, sc = 0, -d[2]
+		} else {
+			face, sc = 1, d[2]
+		}
+	case ay >= az:
+		ma, sc = ay, d[0]
+		if d[1] > 0 {
+			face, tc = 2, d[2]
+		} else {
+			face, tc = 3, -d[2]
+		}
+	default:
+		ma, tc = az, -d[1]
+		if d[2] > 0 {
+			face, sc = 4, d[0]
+		} else {
+			face, sc = 5, -d[0]
+		}
+	}
+	if ma == 0 {
+		return 0, 0, 0
+	}
+	return face, sc / ma, tc / ma
+}
+
+// cubeMap is an RGB float cube map with a mip chain. levels[l][face] holds the texels of one face, row by row.
+type cubeMap struct {
+	size   int
+	levels [][6][][3]float32
+}
+
+func (c *cubeMap) levelSize(level int) int {
+	if s := c.size >> level; s > 0 {
+		return s
+	}
+	return 1
+}
+
+// generateCubeMap fills a cube map by calling texel for the center direction of every texel. Faces are generated in
+// parallel.
+func generateCubeMap(size, levels int, texel func(level int, dir [3]float32) [3]float32) *cubeMap {
+	c := &cubeMap{size: size, levels: make([][6][][3]float32, levels)}
+
+	var wg sync.WaitGroup
+	for level := range c.levels {
+		n := c.levelSize(level)
+		for face := 0; face < 6; face++ {
+			data := make([][3]float32, n*n)
+			c.levels[level][face] = data
+
+			wg.Add(1)
+			go func(level, face, n int, data [][3]float32) {
+				defer wg.Done()
+				for y := 0; y < n; y++ {
+					for x := 0; x < n; x++ {
+						u := 2*(float32(x)+0.5)/float32(n) - 1
+						v := 2*(float32(y)+0.5)/float32(n) - 1
+						data[y*n+x] = texel(level, cubeFaceDir(face, u, v))
+					}
+				}
+			}(level, face, n, data)
+		}
+	}
+	wg.Wait()
+
+	return c
+}
+
+// sample returns the bilinearly filtered value in direction dir at a fractional mip level, blending linearly between
+// levels like a trilinear samplerCube lookup. Filtering does not cross face edges.
+func (c *cubeMap) sample(dir [3]float32, lod float32) [3]float32 {
+	maxLevel := float32(len(c.levels) - 1)
+	if lod < 0 {
+		lod = 0
+	} else if lod > maxLevel {
+		lod = maxLevel
+	}
+
+	l0 := int(lod)
+	a := c.sampleLevel(dir, l0)
+	if t := lod - float32(l0); t > 0 && l0+1 < len(c.levels) {
+		return mix3(a, c.sampleLevel(dir, l0+1), t)
+	}
+	return a
+}
+
+func (c *cubeMap) sampleLevel(dir [3]float32, level int) [3]float32 {
+	face, u, v := cubeFaceUV(dir)
+	n := c.levelSize(level)
+	data := c.levels[level][face]
+
+	x := (u+1)/2*float32(n) - 0.5
+	y := (v+1)/2*float32(n) - 0.5
+	x0, y0 := math32.Floor(x), math32.Floor(y)
+	fx, fy := x-x0, y-y0
+
+	at := func(ix, iy int) [3]float32 {
+		return data[clampIndex(iy, n)*n+clampIndex(ix, n)]
+	}
+	ix, iy := int(x0), int(y0)
+	top := mix3(at(ix, iy), at(ix+1, iy), fx)
+	bottom := mix3(at(ix, iy+1), at(ix+1, iy+1), fx)
+	return mix3(top, bottom, fy)
+}
+
+func clampIndex(i, n int) int {
+	if i < 0 {
+		return 0
+	}
+	if i >= n {
+		return n - 1
+	}
+	return i
+}
+
+// hammersley returns point i of an n-point Hammersley sequence in [0, 1)^2.
+func hammersley(i, n int) (float32, float32) {
+	bits := uint32(i)
+	bits = (bits << 16) | (bits >> 16)
+	bits = ((bits & 0x55555555) << 1) | ((bits & 0xAAAAAAAA) >> 1)
+	bits = ((bits & 0x33333333) << 2) | ((bits & 0xCCCCCCCC) >> 2)
+	bits = ((bits & 0x0F0F0F0F) << 4) | ((bits & 0xF0F0F0F0) >> 4)
+	bits = ((bits & 0x00FF00FF) << 8) | ((bits & 0xFF00FF00) >> 8)
+	return float32(i) / float32(n), float32(bits) * 2.3283064365386963e-10
+}
+
+// tangentFrame returns two unit vectors perpendicular to n and to each other.
+func tangentFrame(n [3]float32) (t, b [3]float32) {
+	up := [3]float32{0, 1, 0}
+	if math32.Abs(n[1]) > 0.999 {
+		up = [3]float32{0, 0, 1}
+	}
+	t = normalize3(cross3(up, n))
+	b = cross3(n, t)
+	return
+}
+
+// fromTangentSpace converts a tangent-space vector (z along n) to world space.
+func fromTangentSpace(v, n [3]float32) [3]float32 {
+	t, b := tangentFrame(n)
+	return add3(add3(scale3(t, v[0]), scale3(b, v[1])), scale3(n, v[2]))
+}
+
+// importanceSampleGGX returns a tangent-space half vector distributed according to the GGX normal distribution for
+// the given roughness (alpha = roughness^2).
+func importanceSampleGGX(xi0, xi1, roughness float32) [3]float32 {
+	a := roughness * roughness
+	phi := 2 * math32.Pi * xi0
+	cosTheta := math32.Sqrt((1 - xi1) / (1 + (a*a-1)*xi1))
+	sinTheta := math32.Sqrt(1 - cosTheta*cosTheta)
+	return [3]float32{math32.Cos(phi) * sinTheta, math32.Sin(phi) * sinTheta, cosTheta}
+}
+
+// visibilitySmithGGXCorrelated is the height-correlated Smith visibility term from the glTF spec's reference BRDF.
+func visibilitySmithGGXCorrelated(nDotL, nDotV, alpha float32) float32 {
+	a2 := alpha * alpha
+	ggxV := nDotL * math32.Sqrt(nDotV*nDotV*(1-a2)+a2)
+	ggxL := nDotV * math32.Sqrt(nDotL*nDotL*(1-a2)+a2)
+	if sum := ggxV + ggxL; sum > 0 {
+		return 0.5 / sum
+	}
+	return 0
+}
+
+// irradianceTexel returns the cosine-weighted average radiance around n, i.e. irradiance/pi, so that the diffuse term
+// is just this times the diffuse color.
+func irradianceTexel(n [3]float32) [3]float32 {
+	var sum [3]float32
+	for i := 0; i < iblSampleCount; i++ {
+		xi0, xi1 := hammersley(i, iblSampleCount)
+		// Cosine-weighted hemisphere sample
+		r := math32.Sqrt(xi1)
+		phi := 2 * math32.Pi * xi0
+		l := [3]float32{r * math32.Cos(phi), r * math32.Sin(phi), math32.Sqrt(1 - xi1)}
+		sum = add3(sum, skyRadiance(fromTangentSpace(l, n)))
+	}
+	return scale3(sum, 1/float32(iblSampleCount))
+}
+
+// specularTexel prefilters the environment with the GGX distribution, assuming n = v = r as in the split-sum
+// approximation.
+func specularTexel(n [3]float32, roughness float32) [3]float32 {
+	if roughness == 0 {
+		return skyRadiance(n)
+	}
+
+	var sum [3]float32
+	var weight float32
+	for i := 0; i < iblSampleCount; i++ {
+		xi0, xi1 := hammersley(i, iblSampleCount)
+		h := fromTangentSpace(importanceSampleGGX(xi0, xi1, roughness), n)
+		l := reflect3(scale3(n, -1), h)
+		if nDotL := dot3(n, l); nDotL > 0 {
+			sum = add3(sum, scale3(skyRadiance(l), nDotL))
+			weight += nDotL
+		}
+	}
+	if weight == 0 {
+		return skyRadiance(n)
+	}
+	return scale3(sum, 1/weight)
+}
+
+// brdfLUTTexel integrates the specular BRDF for the split-sum approximation, returning the scale and bias applied to
+// F0.
+func brdfLUTTexel(nDotV, roughness float32) [2]float32 {
+	v := [3]float32{math32.Sqrt(1 - nDotV*nDotV), 0, nDotV}
+	alpha := roughness * roughness
+
+	var a, b float32
+	for i := 0; i < iblSampleCount; i++ {
+		xi0, xi1 := hammersley(i, iblSampleCount)
+		h := importanceSampleGGX(xi0, xi1, roughness)
+		l := reflect3(scale3(v, -1), h)
+
+		nDotL, nDotH, vDotH := l[2], h[2], dot3(v, h)
+		if nDotL > 0 && nDotH > 0 {
+			vis := visibilitySmithGGXCorrelated(nDotL, nDotV, alpha) * vDotH * nDotL / nDotH
+			fc := math32.Pow(1-vDotH, 5)
+			a += (1 - fc) * vis
+			b += fc * vis
+		}
+	}
+	return [2]float32{4 * a / iblSampleCount, 4 * b / iblSampleCount}
+}
+
+// environment holds the precomputed image-based lighting maps.
+type environment struct {
+	irradiance *cubeMap
+	specular   *cubeMap
+
+	lutSize int
+	brdfLUT [][2]float32 // indexed by roughness row, then N.V column
+}
+
+var (
+	defaultEnvOnce sync.Once
+	defaultEnv     *environment
+)
+
+// defaultEnvironment returns the environment for the procedural sky, generating it on first use.
+func defaultEnvironment() *environment {
+	defaultEnvOnce.Do(func() {
+		env := &environment{lutSize: iblBRDFLUTSize}
+
+		env.irradiance = generateCubeMap(iblIrradianceSize, 1, func(_ int, dir [3]float32) [3]float32 {
+			return irradianceTexel(dir)
+		})
+		env.specular = generateCubeMap(iblSpecularSize, iblSpecularLevels, func(level int, dir [3]float32) [3]float32 {
+			return specularTexel(dir, float32(level)/float32(iblSpecularLevels-1))
+		})
+
+		env.brdfLUT = make([][2]float32, env.lutSize*env.lutSize)
+		var wg sync.WaitGroup
+		for y := 0; y < env.lutSize; y++ {
+			wg.Add(1)
+			go func(y int) {
+				defer wg.Done()
+				roughness := (float32(y) + 0.5) / float32(env.lutSize)
+				for x := 0; x < env.lutSize; x++ {
+					env.brdfLUT[y*env.lutSize+x] = brdfLUTTexel((float32(x)+0.5)/float32(env.lutSize), roughness)
+				}
+			}(y)
+		}
+		wg.Wait()
+
+		defaultEnv = env
+	})
+	return defaultEnv
+}
+
+// sampleBRDFLUT returns the bilinearly filtered LUT value, clamping to the edges, like the shader's lookup.
+func (env *environment) sampleBRDFLUT(nDotV, roughness float32) [2]float32 {
+	n := env.lutSize
+	x := nDotV*float32(n) - 0.5
+	y := roughness*float32(n) - 0.5
+	x0, y0 := math32.Floor(x), math32.Floor(y)
+	fx, fy := x-x0, y-y0
+	ix, iy := int(x0), int(y0)
+
+	at := func(ix, iy int) [2]float32 {
+		return env.brdfLUT[clampIndex(iy, n)*n+clampIndex(ix, n)]
+	}
+	var rval [2]float32
+	for c := 0; c < 2; c++ {
+		top := at(ix, iy)[c] + (at(ix+1, iy)[c]-at(ix, iy)[c])*fx
+		bottom := at(ix, iy+1)[c] + (at(ix+1, iy+1)[c]-at(ix, iy+1)[c])*fx
+		rval[c] = top + (bottom-top)*fy
+	}
+	return rval
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/jpeg" // registers the JPEG decoder for image.Decode
+	_ "image/png"  // registers the PNG decoder for image.Decode
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bbredesen/gltf"
+)
+
+// imageBytes returns the encoded contents of a glTF image, which may be stored in a buffer view, embedded as a data
+// URI, or in an external file. Relative file URIs are resolved against baseDir, the directory of the glTF file.
+func imageBytes(doc *gltf.ResolvedGlTF, img *gltf.ResolvedImage, baseDir string) ([]byte, error) {
+	if img.BufferView != nil {
+		bv := img.BufferView
+		if err := checkViewRange(doc, bv, 0, bv.ByteLength); err != nil {
+			return nil, fmt.Errorf("image: %w", err)
+		}
+		data := doc.Buffers[bv.BufferView.Buffer].Data
+		return data[bv.ByteOffset : bv.ByteOffset+bv.ByteLength], nil
+	}
+
+	if img.Uri == "" {
+		return nil, errors.New("image has neither a uri nor a bufferView")
+	}
+
+	if strings.HasPrefix(img.Uri, "data:") {
+		return decodeDataURI(img.Uri)
+	}
+
+	path, err := url.PathUnescape(img.Uri)
+	if err != nil {
+		return nil, fmt.Errorf("invalid image uri %q: %w", img.Uri, err)
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(baseDir, filepath.FromSlash(path))
+	}
+	return os.ReadFile(path)
+}
+
+// decodeDataURI decodes a base64 data URI, e.g. "data:image/png;base64,iVBORw0...". glTF only allows base64 payloads.
+func decodeDataURI(uri string) ([]byte, error) {
+	header, payload, ok := strings.Cut(uri, ",")
+	if !ok || !strings.HasSuffix(header, ";base64") {
+		return nil, errors.New("data uri is not base64 encoded")
+	}
+	return base64.StdEncoding.DecodeString(payload)
+}
+
+// decodeImage loads a glTF image and converts it to 8-bit non-premultiplied RGBA, which is the layout uploaded to the
+// GPU. PNG and JPEG are supported.
+func decodeImage(doc *gltf.ResolvedGlTF, img *gltf.ResolvedImage, baseDir string) (*image.NRGBA, error) {
+	data, err := imageBytes(doc, img, baseDir)
+	if err != nil {
+		return nil, err
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("could not decode image: %w", err)
+	}
+
+	if nrgba, ok := src.(*image.NRGBA); ok && nrgba.Rect.Min == (image.Point{}) && nrgba.Stride == 4*nrgba.Rect.Dx() {
+		return nrgba, nil
+	}
+
+	b := src.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Rect, src, b.Min, draw.Src)
+	return dst, nil
+}
+
+// imageCache decodes each glTF image at most once.
+type imageCache struct {
+	doc     *gltf.ResolvedGlTF
+	baseDir string
+	images  map[*gltf.ResolvedImage]*image.NRGBA
+}
+
+func newImageCache(doc *gltf.ResolvedGlTF, baseDir string) *imageCache {
+	return &imageCache{
+		doc:     doc,
+		baseDir: baseDir,
+		images:  make(map[*gltf.ResolvedImage]*image.NRGBA),
+	}
+}
+
+func (c *imageCache) get(img *gltf.ResolvedImage) (*image.NRGBA, error) {
+	if rval, ok := c.images[img]; ok {
+		return rval, nil
+	}
+	rval, err := decodeImage(c.doc, img, c.baseDir)
+	if err != nil {
+		return nil, err
+	}
+	c.images[img] = rval
+	return rval, nil
+}
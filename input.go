@@ -0,0 +1,114 @@
+package main
+
+import (
+	"time"
+
+	"github.com/bbredesen/gltf-viewer/shared"
+)
+
+const (
+	keyFrameModel      = 'F'
+	keyPlayPause       = ' '
+	keyLoop            = 'L'
+	keyNextAnimation   = 'N'
+	keyFasterAnimation = 0x26 // VK_UP
+	keySlowerAnimation = 0x28 // VK_DOWN
+	keyNextMorphTarget = 'T'
+	keyDecreaseWeight  = 0x25 // VK_LEFT
+	keyIncreaseWeight  = 0x27 // VK_RIGHT
+
+	// morphWeightStep is the change in the selected morph target's weight for each press of the left or right arrow.
+	morphWeightStep = 0.1
+)
+
+// processInput drives the orbit camera and animation playback: left-drag rotates, right-drag pans, the wheel dollies,
+// and F frames the whole model. Space plays or pauses the animation, L toggles looping, N selects the next animation,
+// and the up and down arrows double or halve the playback speed. T selects the next morph target, and the left and
+// right arrows decrease or increase its weight, overriding the file and any animation.
+func (app *App) processInput(keys map[byte]bool, mouse shared.MouseState, deltaT time.Duration) {
+	dx, dy := float32(mouse.X-app.lastMouse.X), float32(mouse.Y-app.lastMouse.Y)
+
+	// Only treat movement as a drag if the button was already down last frame, so the first press doesn't jump.
+	held := mouse.Buttons & app.lastMouse.Buttons
+	switch {
+	case held&shared.MouseButtonLeft != 0:
+		app.orbit.Rotate(dx, dy)
+	case held&shared.MouseButtonRight != 0:
+		app.orbit.Pan(dx, dy, float32(app.SwapchainExtent.Height))
+	}
+
+	if mouse.Wheel != 0 {
+		app.orbit.Dolly(mouse.Wheel)
+	}
+
+	// Keys act once per press, rather than repeating while held.
+	pressed := func(key byte) bool { return keys[key] && !app.keysDown[key] }
+
+	if pressed(keyFrameModel) {
+		app.frameModel()
+	}
+
+	if anim := app.animation; anim != nil && anim.Animation() != nil {
+		switch {
+		case pressed(keyPlayPause):
+			if !anim.Playing && !anim.Loop && anim.Time >= anim.Animation().Duration {
+				// Stopped at the end; start over.
+				anim.Time = 0
+			}
+			anim.Playing = !anim.Playing
+		case pressed(keyLoop):
+			anim.Loop = !anim.Loop
+		case pressed(keyNextAnimation):
+			anim.SelectIndex((anim.Current + 1) % len(anim.Animations))
+			app.Log().Info("selected animation", "index", anim.Current, "name", anim.Animation().Name)
+		case pressed(keyFasterAnimation):
+			anim.Speed *= 2
+		case pressed(keySlowerAnimation):
+			anim.Speed /= 2
+		}
+	}
+
+	if anim := app.animation; anim != nil {
+		if count := anim.MorphTargetCount(); count > 0 {
+			app.morphTarget %= count
+			switch {
+			case pressed(keyNextMorphTarget):
+				app.morphTarget = (app.morphTarget + 1) % count
+				app.Log().Info("selected morph target", "index", app.morphTarget, "weight", anim.Weight(app.morphTarget))
+			case pressed(keyDecreaseWeight):
+				anim.WeightOverrides[app.morphTarget] = anim.Weight(app.morphTarget) - morphWeightStep
+				app.Log().Info("morph target weight", "index", app.morphTarget, "weight", anim.WeightOverrides[app.morphTarget])
+			case pressed(keyIncreaseWeight):
+				anim.WeightOverrides[app.morphTarget] = anim.Weight(app.morphTarget) + morphWeightStep
+				app.Log().Info("morph target weight", "index", app.morphTarget, "weight", anim.WeightOverrides[app.morphTarget])
+			}
+		}
+	}
+
+	app.keysDown = make(map[byte]bool, len(keys))
+	for key, down := range keys {
+		app.keysDown[key] = down
+	}
+
+	app.lastMouse = mouse
+}
+
+// frameModel points the orbit camera at the center of the scene's bounding box, at a distance that fits it in view.
+func (app *App) frameModel() {
+	if app.scene == nil {
+		return
+	}
+	app.orbit.Aspect = float32(app.SwapchainExtent.Width) / float32(app.SwapchainExtent.Height)
+	if min, max, ok := sceneBounds(app.modelDoc, app.scene); ok {
+		app.orbit.Frame(min, max)
+	}
+}
+
+// tick advances the animation by deltaT and poses the scene for the next frame.
+func (app *App) tick(deltaT time.Duration) {
+	if app.animation == nil {
+		return
+	}
+	app.animation.Advance(deltaT)
+	app.animation.Apply()
+}
@@ -0,0 +1,475 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/bbredesen/gltf"
+)
+
+// modelReport describes the contents of a glTF document, for the inspect subcommand. Objects refer to each other by
+// their index in the document, as in glTF.
+type modelReport struct {
+	Scenes     []sceneReport     `json:"scenes"`
+	Meshes     []meshReport      `json:"meshes"`
+	Materials  []materialReport  `json:"materials"`
+	Textures   []textureReport   `json:"textures"`
+	Animations []animationReport `json:"animations"`
+	Skins      []skinReport      `json:"skins"`
+	Cameras    []cameraReport    `json:"cameras"`
+
+	ExtensionsUsed     []string `json:"extensionsUsed"`
+	ExtensionsRequired []string `json:"extensionsRequired"`
+
+	Totals totalsReport `json:"totals"`
+}
+
+type sceneReport struct {
+	Name    string       `json:"name,omitempty"`
+	Default bool         `json:"default"`
+	Nodes   []nodeReport `json:"nodes"`
+}
+
+type nodeReport struct {
+	Name string `json:"name,omitempty"`
+	Mesh *int   `json:"mesh,omitempty"`
+	Skin *int   `json:"skin,omitempty"`
+	// World is the node's world transform in the default pose, column-major.
+	World    mat4         `json:"world"`
+	Children []nodeReport `json:"children,omitempty"`
+}
+
+type meshReport struct {
+	Name       string            `json:"name,omitempty"`
+	Primitives []primitiveReport `json:"primitives"`
+}
+
+type primitiveReport struct {
+	Mode       string            `json:"mode"`
+	Attributes []attributeReport `json:"attributes"`
+	Indices    *attributeReport  `json:"indices,omitempty"`
+	Material   *int              `json:"material,omitempty"`
+	Targets    int               `json:"morphTargets,omitempty"`
+}
+
+type attributeReport struct {
+	Name          string `json:"name,omitempty"`
+	Type          string `json:"type"`
+	ComponentType string `json:"componentType"`
+	Normalized    bool   `json:"normalized,omitempty"`
+	Sparse        bool   `json:"sparse,omitempty"`
+	Count         int    `json:"count"`
+	// Format is the Vulkan format accessorToFormat maps the accessor to, or VK_FORMAT_UNDEFINED.
+	Format string `json:"format"`
+}
+
+type materialReport struct {
+	Name     string                           `json:"name,omitempty"`
+	Factors  materialFactors                  `json:"factors"`
+	Textures map[string]materialTextureReport `json:"textures,omitempty"`
+}
+
+type materialTextureReport struct {
+	Texture  int `json:"texture"`
+	TexCoord int `json:"texCoord"`
+}
+
+type textureReport struct {
+	Name string `json:"name,omitempty"`
+	// Image is the URI of the source image, or a note that it is embedded.
+	Image   string `json:"image"`
+	Sampler string `json:"sampler,omitempty"`
+}
+
+type animationReport struct {
+	Name     string  `json:"name"`
+	Duration float32 `json:"duration"`
+	Channels int     `json:"channels"`
+}
+
+type skinReport struct {
+	Name   string `json:"name,omitempty"`
+	Joints int    `json:"joints"`
+}
+
+type cameraReport struct {
+	Type string `json:"type"`
+	// Yfov and AspectRatio are for perspective cameras, Xmag and Ymag for orthographic ones.
+	Yfov        float32 `json:"yfov,omitempty"`
+	AspectRatio float32 `json:"aspectRatio,omitempty"`
+	Xmag        float32 `json:"xmag,omitempty"`
+	Ymag        float32 `json:"ymag,omitempty"`
+	Znear       float32 `json:"znear"`
+	Zfar        float32 `json:"zfar,omitempty"`
+}
+
+// totalsReport counts the geometry of each mesh once, however many nodes instance it. Accessors shared between
+// primitives are counted once.
+type totalsReport struct {
+	Vertices    int `json:"vertices"`
+	Indices     int `json:"indices"`
+	Triangles   int `json:"triangles"`
+	VertexBytes int `json:"vertexBytes"`
+	IndexBytes  int `json:"indexBytes"`
+	BufferBytes int `json:"bufferBytes"`
+}
+
+// runInspect implements "gltf-viewer inspect [-json] file...", printing a report of the files' contents without
+// creating a window or a Vulkan device. It returns the exit code.
+func runInspect(args []string) int {
+	fs := flag.NewFlagSet("inspect", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s inspect [-json] model.gltf|model.glb ...\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+
+	files, ok := parseFileArgs(fs, args)
+	if !ok {
+		return 2
+	}
+
+	doc, err := loadModels(files)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		return 1
+	}
+
+	report := inspectModel(doc)
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+			return 1
+		}
+		return 0
+	}
+	report.write(os.Stdout)
+	return 0
+}
+
+// inspectModel builds the report for doc.
+func inspectModel(doc *gltf.ResolvedGlTF) *modelReport {
+	r := &modelReport{
+		ExtensionsUsed:     doc.ExtensionsUsed,
+		ExtensionsRequired: doc.ExtensionsRequired,
+	}
+
+	meshIndex := indexOf(doc.Meshes)
+	skinIndex := indexOf(doc.Skins)
+	materialIndex := indexOf(doc.Materials)
+	textureIndex := indexOf(doc.Textures)
+
+	var nodeReports func(n *SceneNode) []nodeReport
+	nodeReports = func(n *SceneNode) []nodeReport {
+		var rval []nodeReport
+		for _, child := range n.Children {
+			nr := nodeReport{
+				Name:     child.ModelNode.Name,
+				World:    fromVkm(child.CurrentTransform),
+				Children: nodeReports(child),
+			}
+			if m := child.ModelNode.Mesh; m != nil {
+				nr.Mesh = ref(meshIndex[m])
+			}
+			if s := child.ModelNode.Skin; s != nil {
+				nr.Skin = ref(skinIndex[s])
+			}
+			rval = append(rval, nr)
+		}
+		return rval
+	}
+	for _, s := range doc.Scenes {
+		r.Scenes = append(r.Scenes, sceneReport{
+			Name:    s.Name,
+			Default: s == doc.Scene,
+			Nodes:   nodeReports(NewScene(s)),
+		})
+	}
+
+	counted := make(map[*gltf.ResolvedAccessor]bool)
+	for _, m := range doc.Meshes {
+		mr := meshReport{Name: m.Name}
+		for _, p := range m.Primitives {
+			pr := primitiveReport{Mode: primitiveModeName(p), Targets: len(p.Targets)}
+			for key, acc := range p.Attributes {
+				ar := accessorReport(acc)
+				ar.Name = fmt.Sprint(key)
+				pr.Attributes = append(pr.Attributes, ar)
+
+				if !counted[acc] {
+					counted[acc] = true
+					r.Totals.VertexBytes += acc.Count * elementSize(acc)
+					if key == gltf.POSITION {
+						r.Totals.Vertices += acc.Count
+					}
+				}
+			}
+			sort.Slice(pr.Attributes, func(i, j int) bool { return pr.Attributes[i].Name < pr.Attributes[j].Name })
+
+			if acc := p.Indices; acc != nil {
+				ar := accessorReport(acc)
+				pr.Indices = &ar
+				if !counted[acc] {
+					counted[acc] = true
+					r.Totals.Indices += acc.Count
+					r.Totals.IndexBytes += acc.Count * elementSize(acc)
+				}
+			}
+			r.Totals.Triangles += triangleCount(p)
+
+			if p.Material != nil {
+				pr.Material = ref(materialIndex[p.Material])
+			}
+			mr.Primitives = append(mr.Primitives, pr)
+		}
+		r.Meshes = append(r.Meshes, mr)
+	}
+
+	for _, m := range doc.Materials {
+		mr := materialReport{Name: m.Name, Factors: materialFactorsOf(m)}
+		for slot, mt := range materialTextures(m) {
+			if mt == nil {
+				continue
+			}
+			if mr.Textures == nil {
+				mr.Textures = make(map[string]materialTextureReport)
+			}
+			mr.Textures[textureSlot(slot).String()] = materialTextureReport{Texture: textureIndex[mt.Texture], TexCoord: mt.TexCoord}
+		}
+		r.Materials = append(r.Materials, mr)
+	}
+
+	for _, t := range doc.Textures {
+		tr := textureReport{Name: t.Name, Image: "none"}
+		if img := t.Source; img != nil {
+			switch {
+			case img.BufferView != nil:
+				tr.Image = "embedded in buffer view"
+			case strings.HasPrefix(img.Uri, "data:"):
+				tr.Image = "embedded data uri"
+			default:
+				tr.Image = img.Uri
+			}
+		}
+		if s := t.Sampler; s != nil {
+			tr.Sampler = fmt.Sprintf("mag %d, min %d, wrap %d/%d", s.MagFilter, s.MinFilter, s.WrapS, s.WrapT)
+		}
+		r.Textures = append(r.Textures, tr)
+	}
+
+	for _, a := range loadAnimations(doc) {
+		r.Animations = append(r.Animations, animationReport{Name: a.Name, Duration: a.Duration, Channels: len(a.channels)})
+	}
+
+	for _, s := range doc.Skins {
+		r.Skins = append(r.Skins, skinReport{Name: s.Name, Joints: len(s.Joints)})
+	}
+
+	for _, cam := range doc.Cameras {
+		var cr cameraReport
+		if cam.Type == gltf.PERSPECTIVE {
+			cr.Type = "perspective"
+			cr.Yfov, cr.AspectRatio, cr.Znear, cr.Zfar = cam.Perspective.Yfov, cam.Perspective.AspectRatio, cam.Perspective.Znear, cam.Perspective.Zfar
+		} else if cam.Type == gltf.ORTHOGRAPHIC {
+			cr.Type = "orthographic"
+			cr.Xmag, cr.Ymag, cr.Znear, cr.Zfar = cam.Orthographic.Xmag, cam.Orthographic.Ymag, cam.Orthographic.Znear, cam.Orthographic.Zfar
+		}
+		r.Cameras = append(r.Cameras, cr)
+	}
+
+	for _, b := range doc.Buffers {
+		r.Totals.BufferBytes += int(b.ByteLength)
+	}
+
+	return r
+}
+
+// indexOf maps each element of a document array to its index.
+func indexOf[T comparable](elems []T) map[T]int {
+	rval := make(map[T]int, len(elems))
+	for i, e := range elems {
+		rval[e] = i
+	}
+	return rval
+}
+
+func ref(i int) *int {
+	return &i
+}
+
+func accessorReport(acc *gltf.ResolvedAccessor) attributeReport {
+	return attributeReport{
+		Type:          fmt.Sprint(acc.Type),
+		ComponentType: componentTypeName(acc.ComponentType),
+		Normalized:    acc.Normalized,
+		Sparse:        acc.Sparse != nil,
+		Count:         acc.Count,
+		Format:        fmt.Sprint(accessorToFormat(acc.Type, acc.ComponentType, acc.Normalized)),
+	}
+}
+
+func componentTypeName(t gltf.ComponentTypeEnum) string {
+	switch t {
+	case gltf.BYTE:
+		return "BYTE"
+	case gltf.UNSIGNED_BYTE:
+		return "UNSIGNED_BYTE"
+	case gltf.SHORT:
+		return "SHORT"
+	case gltf.UNSIGNED_SHORT:
+		return "UNSIGNED_SHORT"
+	case gltf.UNSIGNED_INT:
+		return "UNSIGNED_INT"
+	case gltf.FLOAT:
+		return "FLOAT"
+	}
+	return fmt.Sprint(int(t))
+}
+
+func primitiveModeName(p *gltf.ResolvedPrimitive) string {
+	switch p.Mode {
+	case gltf.POINTS:
+		return "POINTS"
+	case gltf.LINES:
+		return "LINES"
+	case gltf.LINE_LOOP:
+		return "LINE_LOOP"
+	case gltf.LINE_STRIP:
+		return "LINE_STRIP"
+	case gltf.TRIANGLE_STRIP:
+		return "TRIANGLE_STRIP"
+	case gltf.TRIANGLE_FAN:
+		return "TRIANGLE_FAN"
+	}
+	return "TRIANGLES"
+}
+
+// triangleCount returns the number of triangles a primitive draws, or 0 for points and lines.
+func triangleCount(p *gltf.ResolvedPrimitive) int {
+	n := vertexCount(p)
+	if p.Indices != nil {
+		n = p.Indices.Count
+	}
+	switch p.Mode {
+	case gltf.TRIANGLES:
+		return n / 3
+	case gltf.TRIANGLE_STRIP, gltf.TRIANGLE_FAN:
+		if n >= 3 {
+			return n - 2
+		}
+	}
+	return 0
+}
+
+// write prints the report as indented text.
+func (r *modelReport) write(w io.Writer) {
+	for i, s := range r.Scenes {
+		def := ""
+		if s.Default {
+			def = " (default)"
+		}
+		fmt.Fprintf(w, "scene %d %q%s\n", i, s.Name, def)
+		writeNodes(w, s.Nodes, "  ")
+	}
+
+	for i, m := range r.Meshes {
+		fmt.Fprintf(w, "mesh %d %q\n", i, m.Name)
+		for j, p := range m.Primitives {
+			fmt.Fprintf(w, "  primitive %d: %s", j, p.Mode)
+			if p.Material != nil {
+				fmt.Fprintf(w, ", material %d", *p.Material)
+			}
+			if p.Targets > 0 {
+				fmt.Fprintf(w, ", %d morph targets", p.Targets)
+			}
+			fmt.Fprintln(w)
+			for _, a := range p.Attributes {
+				fmt.Fprintf(w, "    %-12s %s\n", a.Name, a.describe())
+			}
+			if p.Indices != nil {
+				fmt.Fprintf(w, "    %-12s %s\n", "indices", p.Indices.describe())
+			}
+		}
+	}
+
+	for i, m := range r.Materials {
+		f := m.Factors
+		fmt.Fprintf(w, "material %d %q: base color %v, metallic %g, roughness %g\n", i, m.Name, f.BaseColor, f.Metallic, f.Roughness)
+		slots := make([]string, 0, len(m.Textures))
+		for slot := range m.Textures {
+			slots = append(slots, slot)
+		}
+		sort.Strings(slots)
+		for _, slot := range slots {
+			fmt.Fprintf(w, "  %s: texture %d, TEXCOORD_%d\n", slot, m.Textures[slot].Texture, m.Textures[slot].TexCoord)
+		}
+	}
+
+	for i, t := range r.Textures {
+		fmt.Fprintf(w, "texture %d %q: %s", i, t.Name, t.Image)
+		if t.Sampler != "" {
+			fmt.Fprintf(w, ", sampler %s", t.Sampler)
+		}
+		fmt.Fprintln(w)
+	}
+
+	for i, a := range r.Animations {
+		fmt.Fprintf(w, "animation %d %q: %gs, %d channels\n", i, a.Name, a.Duration, a.Channels)
+	}
+	for i, s := range r.Skins {
+		fmt.Fprintf(w, "skin %d %q: %d joints\n", i, s.Name, s.Joints)
+	}
+	for i, c := range r.Cameras {
+		if c.Xmag != 0 || c.Ymag != 0 {
+			fmt.Fprintf(w, "camera %d: %s, xmag %g, ymag %g, znear %g, zfar %g\n", i, c.Type, c.Xmag, c.Ymag, c.Znear, c.Zfar)
+		} else {
+			fmt.Fprintf(w, "camera %d: %s, yfov %g, aspect %g, znear %g, zfar %g\n", i, c.Type, c.Yfov, c.AspectRatio, c.Znear, c.Zfar)
+		}
+	}
+
+	fmt.Fprintf(w, "extensions used: %s\n", strings.Join(r.ExtensionsUsed, ", "))
+	fmt.Fprintf(w, "extensions required: %s\n", strings.Join(r.ExtensionsRequired, ", "))
+
+	t := r.Totals
+	fmt.Fprintf(w, "totals: %d vertices, %d indices, %d triangles\n", t.Vertices, t.Indices, t.Triangles)
+	fmt.Fprintf(w, "        %d bytes of vertex data, %d bytes of index data, %d bytes in buffers\n", t.VertexBytes, t.IndexBytes, t.BufferBytes)
+}
+
+func writeNodes(w io.Writer, nodes []nodeReport, indent string) {
+	for _, n := range nodes {
+		fmt.Fprintf(w, "%snode %q", indent, n.Name)
+		if n.Mesh != nil {
+			fmt.Fprintf(w, ", mesh %d", *n.Mesh)
+		}
+		if n.Skin != nil {
+			fmt.Fprintf(w, ", skin %d", *n.Skin)
+		}
+		// Translation, then the upper 3x3 by rows, which holds rotation and scale.
+		m := n.World
+		fmt.Fprintf(w, ", at (%g, %g, %g), basis [%g %g %g; %g %g %g; %g %g %g]\n", m[12], m[13], m[14],
+			m[0], m[4], m[8], m[1], m[5], m[9], m[2], m[6], m[10])
+		writeNodes(w, n.Children, indent+"  ")
+	}
+}
+
+func (a *attributeReport) describe() string {
+	var flags []string
+	if a.Normalized {
+		flags = append(flags, "normalized")
+	}
+	if a.Sparse {
+		flags = append(flags, "sparse")
+	}
+	suffix := ""
+	if len(flags) > 0 {
+		suffix = " (" + strings.Join(flags, ", ") + ")"
+	}
+	return fmt.Sprintf("%s of %s x %d%s -> %s", a.Type, a.ComponentType, a.Count, suffix, a.Format)
+}
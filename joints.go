@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"unsafe"
+
+	"github.com/bbredesen/gltf-viewer/vkctx"
+	"github.com/bbredesen/go-vk"
+)
+
+// jointBlockHeader is the size of the fields before the joint matrices in the JointMatrices block (set 3, binding 0)
+// in shader.vert: a uint joint count, padded to the 16 byte alignment of the std430 mat4 array.
+const jointBlockHeader = 16
+
+// frameBlockLayout is the layout of a storage buffer that is bound with a dynamic offset per node. Each frame in
+// flight has its own region of frameStride bytes, which holds one block per node, at offsets within the region. The
+// first block of each region belongs to no node, and is what nodes without a block of their own are bound to. Blocks
+// start at multiples of the minimum storage buffer offset alignment, and blockRange, the size of the largest block,
+// is the range of the descriptor.
+type frameBlockLayout struct {
+	offsets     map[*SceneNode]uint32
+	frameStride uint32
+	blockRange  uint32
+}
+
+// newFrameBlockLayout lays out a first block of firstSize bytes, then a block of blockSize(n) bytes for each of nodes.
+func newFrameBlockLayout(align, firstSize uint32, nodes []*SceneNode, blockSize func(n *SceneNode) uint32) frameBlockLayout {
+	alignUp := func(size uint32) uint32 {
+		if align > 0 {
+			return (size + align - 1) / align * align
+		}
+		return size
+	}
+
+	l := frameBlockLayout{offsets: make(map[*SceneNode]uint32), blockRange: firstSize}
+	offset := alignUp(firstSize)
+	for _, n := range nodes {
+		size := blockSize(n)
+		l.offsets[n] = offset
+		offset += alignUp(size)
+		if size > l.blockRange {
+			l.blockRange = size
+		}
+	}
+	l.frameStride = offset
+	return l
+}
+
+// offset returns the dynamic offset of n's block in frame's region, or of the first block if n has none.
+func (l frameBlockLayout) offset(frame int, n *SceneNode) uint32 {
+	return uint32(frame)*l.frameStride + l.offsets[n]
+}
+
+// createFrameBlockBuffer creates a zeroed, host-visible storage buffer with a region for each frame in flight, laid out
+// by l. It stays mapped until it is destroyed.
+func (app *App) createFrameBlockBuffer(l frameBlockLayout) (vk.Buffer, *vkctx.Allocation, []byte, error) {
+	// The descriptor range covers the largest block, so the buffer is padded for the range starting at the last one.
+	size := vk.DeviceSize(l.frameStride)*vk.DeviceSize(app.ctx.MaxFramesInFlight) + vk.DeviceSize(l.blockRange)
+	buffer, memory, err := app.createBuffer(vk.BUFFER_USAGE_STORAGE_BUFFER_BIT, size, vk.MEMORY_PROPERTY_HOST_VISIBLE_BIT|vk.MEMORY_PROPERTY_HOST_COHERENT_BIT)
+	if err != nil {
+		return buffer, nil, nil, err
+	}
+
+	ptr, err := app.MapMemory(memory)
+	if err != nil {
+		vk.DestroyBuffer(app.Device, buffer, nil)
+		app.Free(memory)
+		return vk.Buffer(vk.NULL_HANDLE), nil, nil, fmt.Errorf("failed to map memory for buffer, result code was %w", err)
+	}
+	mapped := unsafe.Slice((*byte)(ptr), int(size))
+	for i := range mapped {
+		mapped[i] = 0
+	}
+	return buffer, memory, mapped, nil
+}
+
+// createJointBuffer allocates the storage buffer for joint matrices (set 3, binding 0), with a block per skinned node
+// in each frame's region. The first block has a joint count of zero, and is bound for nodes without a skin, which the
+// shader then doesn't skin.
+func (app *App) createJointBuffer() error {
+	app.skins = newSceneSkins(app.modelDoc, app.scene)
+
+	align := uint32(vk.GetPhysicalDeviceProperties(app.PhysicalDevice).Limits.MinStorageBufferOffsetAlignment)
+	app.jointLayout = newFrameBlockLayout(align, jointBlockHeader, skinnedNodes(app.scene), func(n *SceneNode) uint32 {
+		return jointBlockHeader + uint32(len(n.ModelNode.Skin.Joints))*uint32(unsafe.Sizeof(mat4{}))
+	})
+
+	var err error
+	if app.jointBuffer, app.jointMemory, app.jointMapped, err = app.createFrameBlockBuffer(app.jointLayout); err != nil {
+		return fmt.Errorf("could not create the joint matrix buffer: %w", err)
+	}
+	return nil
+}
+
+// createDeformSet writes the descriptor set for skinning and morphing (set 3): joint matrices and morph weights,
+// each bound with a dynamic offset for the node being drawn, and the morph target deltas of every primitive.
+func (app *App) createDeformSet() error {
+	poolCI := vk.DescriptorPoolCreateInfo{
+		MaxSets: 1,
+		PPoolSizes: []vk.DescriptorPoolSize{
+			{Type: vk.DESCRIPTOR_TYPE_STORAGE_BUFFER_DYNAMIC, DescriptorCount: 2},
+			{Type: vk.DESCRIPTOR_TYPE_STORAGE_BUFFER, DescriptorCount: 1},
+		},
+	}
+
+	var err error
+	if app.deformPool, err = vk.CreateDescriptorPool(app.Device, &poolCI, nil); err != nil {
+		return fmt.Errorf("could not create deform descriptor pool: %w", err)
+	}
+
+	sets, err := vk.AllocateDescriptorSets(app.Device, &vk.DescriptorSetAllocateInfo{
+		DescriptorPool: app.deformPool,
+		PSetLayouts:    []vk.DescriptorSetLayout{app.deformSetLayout},
+	})
+	if err != nil {
+		return fmt.Errorf("could not allocate deform descriptor set: %w", err)
+	}
+	app.deformSet = sets[0]
+
+	bufferWrite := func(binding uint32, descriptorType vk.DescriptorType, buffer vk.Buffer, size uint32) vk.WriteDescriptorSet {
+		return vk.WriteDescriptorSet{
+			DstSet:          app.deformSet,
+			DstBinding:      binding,
+			DstArrayElement: 0,
+			DescriptorType:  descriptorType,
+			PBufferInfo: []vk.DescriptorBufferInfo{
+				{Buffer: buffer, Offset: 0, Range: vk.DeviceSize(size)},
+			},
+		}
+	}
+
+	vk.UpdateDescriptorSets(app.Device, []vk.WriteDescriptorSet{
+		bufferWrite(0, vk.DESCRIPTOR_TYPE_STORAGE_BUFFER_DYNAMIC, app.jointBuffer, app.jointLayout.blockRange),
+		bufferWrite(1, vk.DESCRIPTOR_TYPE_STORAGE_BUFFER_DYNAMIC, app.morphWeightBuffer, app.morphWeightLayout.blockRange),
+		bufferWrite(2, vk.DESCRIPTOR_TYPE_STORAGE_BUFFER, app.morphDeltaBuffer, app.morphDeltaSize),
+	}, nil)
+
+	return nil
+}
+
+// writeJointMatrices computes the joint matrices of every skinned node from the current pose and writes them to the
+// region of the joint buffer used by frame. The frame's previous submission must have completed.
+func (app *App) writeJointMatrices(frame int) {
+	base := uint32(frame) * app.jointLayout.frameStride
+	for n, offset := range app.jointLayout.offsets {
+		matrices := app.skins.jointMatrices(n)
+
+		block := app.jointMapped[base+offset:]
+		count := uint32(len(matrices))
+		vk.MemCopyObj(unsafe.Pointer(&block[0]), &count)
+		if count > 0 {
+			copy(block[jointBlockHeader:], unsafe.Slice((*byte)(unsafe.Pointer(&matrices[0])), len(matrices)*int(unsafe.Sizeof(mat4{}))))
+		}
+	}
+}
+
+// deformOffsets returns the dynamic offsets of the deform set for drawing n in the current frame.
+func (app *App) deformOffsets(n *SceneNode) []uint32 {
+	return []uint32{app.jointOffset(n), app.morphWeightOffset(n)}
+}
+
+// jointOffset returns the dynamic offset of n's joint matrices for the current frame.
+func (app *App) jointOffset(n *SceneNode) uint32 {
+	// Nodes without a skin have no block of their own, and get the empty first block.
+	return app.jointLayout.offset(app.currentFrame, n)
+}
+
+func (app *App) destroyJointBuffer() {
+	app.jointMapped = nil
+	vk.DestroyBuffer(app.Device, app.jointBuffer, nil)
+	app.Free(app.jointMemory)
+	app.jointLayout = frameBlockLayout{}
+}
@@ -0,0 +1,52 @@
+package main
+
+import "testing"
+
+func TestFrameBlockLayout(t *testing.T) {
+	a, b, c := &SceneNode{}, &SceneNode{}, &SceneNode{}
+	sizes := map[*SceneNode]uint32{a: 80, b: 16, c: 200}
+	blockSize := func(n *SceneNode) uint32 { return sizes[n] }
+
+	tests := []struct {
+		name        string
+		align       uint32
+		nodes       []*SceneNode
+		wantOffsets []uint32
+		wantStride  uint32
+		wantRange   uint32
+	}{
+		// Every block starts on a multiple of the alignment, including the first node's, after the unused block.
+		{"aligned", 64, []*SceneNode{a, b, c}, []uint32{64, 192, 256}, 512, 200},
+		{"unaligned", 0, []*SceneNode{a, b, c}, []uint32{16, 96, 112}, 312, 200},
+		{"alignment of 1", 1, []*SceneNode{a, b}, []uint32{16, 96}, 112, 80},
+		// With no nodes, there is only the first block, which is still a valid range.
+		{"no nodes", 256, nil, nil, 256, 16},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			l := newFrameBlockLayout(test.align, 16, test.nodes, blockSize)
+			if len(l.offsets) != len(test.nodes) {
+				t.Errorf("%d offsets, want %d", len(l.offsets), len(test.nodes))
+			}
+			for i, n := range test.nodes {
+				if l.offsets[n] != test.wantOffsets[i] {
+					t.Errorf("block %d at %d, want %d", i, l.offsets[n], test.wantOffsets[i])
+				}
+			}
+			if l.frameStride != test.wantStride || l.blockRange != test.wantRange {
+				t.Errorf("frame stride %d and range %d, want %d and %d", l.frameStride, l.blockRange, test.wantStride, test.wantRange)
+			}
+
+			// Frames follow each other, and nodes without a block get the first one.
+			if got, want := l.offset(2, &SceneNode{}), 2*test.wantStride; got != want {
+				t.Errorf("node without a block in frame 2 is at %d, want %d", got, want)
+			}
+			if len(test.nodes) > 0 {
+				if got, want := l.offset(1, test.nodes[0]), test.wantStride+test.wantOffsets[0]; got != want {
+					t.Errorf("first node in frame 1 is at %d, want %d", got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,459 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bbredesen/gltf"
+)
+
+// Binary glTF container constants, see section 4.4 of the glTF 2.0 spec.
+const (
+	glbMagic     = 0x46546C67 // "glTF"
+	glbChunkJSON = 0x4E4F534A // "JSON"
+	glbChunkBIN  = 0x004E4942 // "BIN\0"
+	glbHeaderLen = 12
+)
+
+// jsonObject is a glTF document, or part of one, decoded generically so that it can be rewritten before it is
+// resolved.
+type jsonObject = map[string]any
+
+// loadModels reads and resolves one or more .gltf or .glb files. A single file is returned as is. Multiple files are
+// merged into one document whose default scene holds a node for each file, laid out side by side along the X axis.
+//
+// A single .gltf file is loaded by the glTF package, and its relative URIs stay relative to the file. Otherwise,
+// relative buffer and image URIs are made absolute, against the directory of the file that references them, so that
+// files from different directories can be merged. The BIN chunks of .glb files are written to temporary files, to be
+// referenced the same way, for as long as it takes to resolve the document.
+func loadModels(filenames []string) (*gltf.ResolvedGlTF, error) {
+	if len(filenames) == 1 {
+		glb, err := isGLB(filenames[0])
+		if err != nil {
+			return nil, fmt.Errorf("error reading file %s: %w", filenames[0], err)
+		}
+		if !glb {
+			return loadModel(filenames[0])
+		}
+	}
+
+	binDir, err := os.MkdirTemp("", "gltf-viewer-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(binDir)
+
+	docs := make([]jsonObject, len(filenames))
+	for i, filename := range filenames {
+		doc, err := readModel(filename, filepath.Join(binDir, fmt.Sprintf("%d.bin", i)))
+		if err != nil {
+			return nil, fmt.Errorf("error reading file %s: %w", filename, err)
+		}
+		docs[i] = doc
+	}
+
+	if len(docs) == 1 {
+		resolved, err := resolveModel(docs[0])
+		if err != nil {
+			return nil, fmt.Errorf("error processing file %s: %w", filenames[0], err)
+		}
+		return resolved, nil
+	}
+
+	merged := mergeModels(docs, filenames)
+	resolved, err := resolveModel(merged)
+	if err != nil {
+		return nil, fmt.Errorf("error processing files: %w", err)
+	}
+
+	// Bounds are only known once the document is resolved, so the layout is applied by resolving a second time.
+	if !arrangeSideBySide(merged, resolved) {
+		return resolved, nil
+	}
+	return resolveModel(merged)
+}
+
+// loadModel loads a single .gltf file with the glTF package, which reads its buffers relative to the file.
+func loadModel(filename string) (*gltf.ResolvedGlTF, error) {
+	input, err := gltf.FromFilename(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error reading file %s: %w", filename, err)
+	}
+	resolved, err := input.Resolve(nil)
+	if err != nil {
+		return nil, fmt.Errorf("error processing file %s: %w", filename, err)
+	}
+	return resolved, nil
+}
+
+// isGLB reports whether a file is a binary glTF container, detected by its magic header rather than the extension.
+func isGLB(filename string) (bool, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	var magic uint32
+	if err := binary.Read(f, binary.LittleEndian, &magic); err != nil {
+		// Too short to be either, which reading it as JSON will report.
+		return false, nil
+	}
+	return magic == glbMagic, nil
+}
+
+// readModel reads a .gltf or .glb file, detected by its magic header rather than the extension, and rewrites its URIs
+// as described in loadModels. The BIN chunk of a .glb file is written to binFile.
+func readModel(filename, binFile string) (jsonObject, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var bin []byte
+	if len(data) >= 4 && binary.LittleEndian.Uint32(data) == glbMagic {
+		if data, bin, err = parseGLB(data); err != nil {
+			return nil, err
+		}
+	}
+
+	var doc jsonObject
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid glTF JSON: %w", err)
+	}
+
+	dir := filepath.Dir(filename)
+	for i, b := range objects(doc["buffers"]) {
+		uri, _ := b["uri"].(string)
+		switch {
+		case uri == "" && i == 0 && bin != nil:
+			if err := os.WriteFile(binFile, bin, 0o644); err != nil {
+				return nil, err
+			}
+			path, err := filepath.Abs(binFile)
+			if err != nil {
+				return nil, err
+			}
+			b["uri"] = fileURI(path)
+		case uri == "":
+			return nil, fmt.Errorf("buffer %d has no uri", i)
+		case !strings.HasPrefix(uri, "data:"):
+			path, err := resolveURI(dir, uri)
+			if err != nil {
+				return nil, err
+			}
+			b["uri"] = fileURI(path)
+		}
+	}
+	for _, img := range objects(doc["images"]) {
+		if uri, _ := img["uri"].(string); uri != "" && !strings.HasPrefix(uri, "data:") {
+			path, err := resolveURI(dir, uri)
+			if err != nil {
+				return nil, err
+			}
+			img["uri"] = fileURI(path)
+		}
+	}
+
+	return doc, nil
+}
+
+// parseGLB returns the JSON and BIN chunks of a binary glTF container. bin is nil if there is no BIN chunk.
+func parseGLB(data []byte) (jsonChunk, bin []byte, err error) {
+	if len(data) < glbHeaderLen {
+		return nil, nil, errors.New("truncated .glb header")
+	}
+	if version := binary.LittleEndian.Uint32(data[4:]); version != 2 {
+		return nil, nil, fmt.Errorf("unsupported .glb version %d", version)
+	}
+	length := binary.LittleEndian.Uint32(data[8:])
+	if int(length) > len(data) {
+		return nil, nil, errors.New("truncated .glb file")
+	}
+
+	chunks := data[glbHeaderLen:length]
+	for len(chunks) >= 8 {
+		chunkLen, chunkType := binary.LittleEndian.Uint32(chunks), binary.LittleEndian.Uint32(chunks[4:])
+		if int(chunkLen) > len(chunks)-8 {
+			return nil, nil, errors.New("truncated .glb chunk")
+		}
+		chunk := chunks[8 : 8+chunkLen]
+		switch {
+		case chunkType == glbChunkJSON && jsonChunk == nil:
+			jsonChunk = chunk
+		case chunkType == glbChunkBIN && bin == nil:
+			bin = chunk
+		}
+		// Unknown chunks are skipped, as the spec requires.
+		chunks = chunks[8+chunkLen:]
+	}
+
+	if jsonChunk == nil {
+		return nil, nil, errors.New(".glb file has no JSON chunk")
+	}
+	return jsonChunk, bin, nil
+}
+
+// resolveURI returns the file path of a relative or absolute file URI, relative ones being resolved against dir.
+func resolveURI(dir, uri string) (string, error) {
+	path, err := url.PathUnescape(uri)
+	if err != nil {
+		return "", fmt.Errorf("invalid uri %q: %w", uri, err)
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, filepath.FromSlash(path))
+	}
+	return filepath.Abs(path)
+}
+
+// fileURI returns the URI of an absolute file path.
+func fileURI(path string) string {
+	return (&url.URL{Path: filepath.ToSlash(path)}).EscapedPath()
+}
+
+// resolveModel decodes a rewritten document into the glTF package's types and resolves it.
+func resolveModel(doc jsonObject) (*gltf.ResolvedGlTF, error) {
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	var input gltf.GlTF
+	if err := json.Unmarshal(data, &input); err != nil {
+		return nil, err
+	}
+	return input.Resolve(nil)
+}
+
+// objects returns the elements of a JSON array of objects, skipping anything else.
+func objects(v any) []jsonObject {
+	arr, _ := v.([]any)
+	rval := make([]jsonObject, 0, len(arr))
+	for _, e := range arr {
+		if obj, ok := e.(jsonObject); ok {
+			rval = append(rval, obj)
+		}
+	}
+	return rval
+}
+
+// mergedArrays are the top-level arrays of a glTF document that are concatenated when merging. scenes are not, since
+// the merged document has a single scene of its own.
+var mergedArrays = []string{
+	"accessors", "animations", "buffers", "bufferViews", "cameras", "images", "materials", "meshes", "nodes",
+	"samplers", "skins", "textures",
+}
+
+// mergeModels concatenates the documents, offsetting every index into the arrays of each one past those of the
+// documents before it. The merged default scene has a node named after each file, parenting the nodes of that file's
+// default scene. Extensions that reference other objects by index are not rewritten.
+func mergeModels(docs []jsonObject, filenames []string) jsonObject {
+	merged := jsonObject{"asset": jsonObject{"version": "2.0"}}
+	arrays := make(map[string][]any)
+	var roots []any
+
+	for i, doc := range docs {
+		base := make(map[string]int)
+		for _, name := range mergedArrays {
+			base[name] = len(arrays[name])
+		}
+		offsetIndices(doc, base)
+
+		for _, name := range mergedArrays {
+			arr, _ := doc[name].([]any)
+			arrays[name] = append(arrays[name], arr...)
+		}
+		for _, name := range []string{"extensionsUsed", "extensionsRequired"} {
+			exts, _ := doc[name].([]any)
+			for _, ext := range exts {
+				merged[name] = appendUnique(merged[name], ext)
+			}
+		}
+
+		// The file's default scene, or its first if none is set.
+		var children []any
+		scenes := objects(doc["scenes"])
+		scene := 0
+		if s, ok := doc["scene"].(float64); ok {
+			scene = int(s)
+		}
+		if scene < len(scenes) {
+			children, _ = scenes[scene]["nodes"].([]any)
+		}
+
+		root := jsonObject{"name": filepath.Base(filenames[i])}
+		if len(children) > 0 {
+			root["children"] = children
+		}
+		roots = append(roots, float64(len(arrays["nodes"])))
+		arrays["nodes"] = append(arrays["nodes"], root)
+	}
+
+	for name, arr := range arrays {
+		if len(arr) > 0 {
+			merged[name] = arr
+		}
+	}
+	merged["scenes"] = []any{jsonObject{"nodes": roots}}
+	merged["scene"] = float64(0)
+	return merged
+}
+
+func appendUnique(list any, v any) []any {
+	arr, _ := list.([]any)
+	for _, e := range arr {
+		if e == v {
+			return arr
+		}
+	}
+	return append(arr, v)
+}
+
+// offsetIndices adds base[array] to every index in doc that refers to an element of that array. Scene node lists are
+// offset too, since the merged scene takes its nodes from them.
+func offsetIndices(doc jsonObject, base map[string]int) {
+	offset := func(obj jsonObject, key, array string) {
+		if v, ok := obj[key].(float64); ok {
+			obj[key] = v + float64(base[array])
+		}
+	}
+	offsetAll := func(obj jsonObject, key, array string) {
+		arr, _ := obj[key].([]any)
+		for i, v := range arr {
+			if f, ok := v.(float64); ok {
+				arr[i] = f + float64(base[array])
+			}
+		}
+	}
+	offsetValues := func(v any, array string) {
+		obj, _ := v.(jsonObject)
+		for key := range obj {
+			offset(obj, key, array)
+		}
+	}
+
+	for _, s := range objects(doc["scenes"]) {
+		offsetAll(s, "nodes", "nodes")
+	}
+	for _, n := range objects(doc["nodes"]) {
+		offsetAll(n, "children", "nodes")
+		offset(n, "mesh", "meshes")
+		offset(n, "skin", "skins")
+		offset(n, "camera", "cameras")
+	}
+	for _, m := range objects(doc["meshes"]) {
+		for _, p := range objects(m["primitives"]) {
+			offsetValues(p["attributes"], "accessors")
+			offset(p, "indices", "accessors")
+			offset(p, "material", "materials")
+			targets, _ := p["targets"].([]any)
+			for _, t := range targets {
+				offsetValues(t, "accessors")
+			}
+		}
+	}
+	for _, a := range objects(doc["accessors"]) {
+		offset(a, "bufferView", "bufferViews")
+		if sparse, ok := a["sparse"].(jsonObject); ok {
+			for _, key := range []string{"indices", "values"} {
+				if obj, ok := sparse[key].(jsonObject); ok {
+					offset(obj, "bufferView", "bufferViews")
+				}
+			}
+		}
+	}
+	for _, bv := range objects(doc["bufferViews"]) {
+		offset(bv, "buffer", "buffers")
+	}
+	for _, m := range objects(doc["materials"]) {
+		offsetTextureInfos(m, float64(base["textures"]))
+	}
+	for _, t := range objects(doc["textures"]) {
+		offset(t, "sampler", "samplers")
+		offset(t, "source", "images")
+	}
+	for _, img := range objects(doc["images"]) {
+		offset(img, "bufferView", "bufferViews")
+	}
+	for _, s := range objects(doc["skins"]) {
+		offset(s, "inverseBindMatrices", "accessors")
+		offset(s, "skeleton", "nodes")
+		offsetAll(s, "joints", "nodes")
+	}
+	for _, a := range objects(doc["animations"]) {
+		// Channels refer to samplers of their own animation, which need no offset.
+		for _, ch := range objects(a["channels"]) {
+			if target, ok := ch["target"].(jsonObject); ok {
+				offset(target, "node", "nodes")
+			}
+		}
+		for _, s := range objects(a["samplers"]) {
+			offset(s, "input", "accessors")
+			offset(s, "output", "accessors")
+		}
+	}
+}
+
+// offsetTextureInfos offsets the texture index of every textureInfo in a material, including those of material
+// extensions, which are all properties named like "baseColorTexture".
+func offsetTextureInfos(obj jsonObject, base float64) {
+	for key, v := range obj {
+		child, ok := v.(jsonObject)
+		if !ok {
+			continue
+		}
+		if index, ok := child["index"].(float64); ok && strings.HasSuffix(key, "Texture") {
+			child["index"] = index + base
+		}
+		offsetTextureInfos(child, base)
+	}
+}
+
+// arrangeSideBySide sets the translation of each file's node in a merged document, so that their bounding boxes
+// are lined up along the X axis with a gap between them. resolved is merged, resolved as it is. It returns false if
+// nothing needs to move.
+func arrangeSideBySide(merged jsonObject, resolved *gltf.ResolvedGlTF) bool {
+	scene := NewScene(resolved.Scene)
+	nodes := objects(merged["nodes"])
+	roots, _ := objects(merged["scenes"])[0]["nodes"].([]any)
+
+	type box struct {
+		min, max [3]float32
+		ok       bool
+	}
+	boxes := make([]box, len(scene.Children))
+	var gap float32
+	for i, child := range scene.Children {
+		b := &boxes[i]
+		b.min, b.max, b.ok = sceneBounds(resolved, child)
+		if width := b.max[0] - b.min[0]; b.ok && 0.1*width > gap {
+			gap = 0.1 * width
+		}
+	}
+
+	moved := false
+	var cursor float32
+	placed := false
+	for i, b := range boxes {
+		if !b.ok {
+			continue
+		}
+		x := float32(0)
+		if placed {
+			x = cursor + gap - b.min[0]
+		}
+		cursor = x + b.max[0]
+		placed = true
+
+		if x != 0 {
+			nodes[int(roots[i].(float64))]["translation"] = []any{float64(x), 0.0, 0.0}
+			moved = true
+		}
+	}
+	return moved
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeTestGLB writes a binary glTF container with buffer as its BIN chunk, and returns the file name.
+func writeTestGLB(t *testing.T, buffer []byte) string {
+	t.Helper()
+
+	jsonChunk := []byte(fmt.Sprintf(`{"asset": {"version": "2.0"}, "buffers": [{"byteLength": %d}]}`, len(buffer)))
+	for len(jsonChunk)%4 != 0 {
+		jsonChunk = append(jsonChunk, ' ')
+	}
+	bin := append([]byte{}, buffer...)
+	for len(bin)%4 != 0 {
+		bin = append(bin, 0)
+	}
+
+	var glb bytes.Buffer
+	le := binary.LittleEndian
+	length := uint32(glbHeaderLen + 8 + len(jsonChunk) + 8 + len(bin))
+	binary.Write(&glb, le, []uint32{glbMagic, 2, length, uint32(len(jsonChunk)), glbChunkJSON})
+	glb.Write(jsonChunk)
+	binary.Write(&glb, le, []uint32{uint32(len(bin)), glbChunkBIN})
+	glb.Write(bin)
+
+	filename := filepath.Join(t.TempDir(), "test.glb")
+	if err := os.WriteFile(filename, glb.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestLoadMergedModels(t *testing.T) {
+	files := []string{
+		writeTestModel(t, `{}`, []byte{1, 2, 3, 4}),
+		writeTestGLB(t, []byte{5, 6, 7}),
+		writeTestModel(t, `{}`, []byte{8}),
+	}
+	doc, err := loadModels(files)
+	if err != nil {
+		t.Fatalf("loadModels: %s", err)
+	}
+
+	want := [][]byte{{1, 2, 3, 4}, {5, 6, 7}, {8}}
+	if len(doc.Buffers) != len(want) {
+		t.Fatalf("merged document has %d buffers, want %d", len(doc.Buffers), len(want))
+	}
+	for i, w := range want {
+		if got := doc.Buffers[i].Data; !bytes.HasPrefix(got, w) {
+			t.Errorf("buffer %d is %v, want %v", i, got, w)
+		}
+	}
+	if doc.Scene == nil || len(doc.Scene.Nodes) != len(files) {
+		t.Errorf("merged default scene should have a node per file")
+	}
+}
+
+func TestLoadGLB(t *testing.T) {
+	doc, err := loadModels([]string{writeTestGLB(t, []byte{5, 6, 7})})
+	if err != nil {
+		t.Fatalf("loadModels: %s", err)
+	}
+	if len(doc.Buffers) != 1 || !bytes.HasPrefix(doc.Buffers[0].Data, []byte{5, 6, 7}) {
+		t.Errorf("BIN chunk was not loaded as the first buffer")
+	}
+}
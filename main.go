@@ -1,37 +1,133 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/bbredesen/gltf"
-	"github.com/bbredesen/gltf-viewer/shared"
+	"github.com/bbredesen/gltf-viewer/vkctx"
 )
 
+var (
+	renderFilename = flag.String("render", "", "render a single frame offscreen and write it to this PNG file, without opening a window")
+	renderSize     = flag.String("size", "1024x768", "image size for -render, as WIDTHxHEIGHT")
+	renderSoftware = flag.Bool("software", false, "use the CPU rasterizer for -render instead of Vulkan")
+	framesInFlight = flag.Int("frames-in-flight", vkctx.DefaultMaxFramesInFlight, "number of frames the CPU may record ahead of the GPU")
+	deviceSelector = flag.String("device", "", "Vulkan device to use, as an index from -list-devices or part of its name (default the best scoring device)")
+	listDevices    = flag.Bool("list-devices", false, "print the Vulkan devices and exit")
+	validate       = flag.Bool("validate", false, "enable the Vulkan validation layer and log its messages")
+
+	animationName   = flag.String("animation", "", "name or index of the animation to play (default the first)")
+	animationSpeed  = flag.Float64("speed", 1, "animation playback speed")
+	animationTime   = flag.Float64("time", 0, "animation start time in seconds; with -render, the time of the rendered pose")
+	animationNoLoop = flag.Bool("no-loop", false, "stop at the end of the animation instead of looping")
+	morphWeights    = weightsFlag{}
+)
+
+// weightsFlag parses morph target weight overrides as a comma separated list of TARGET=WEIGHT pairs.
+type weightsFlag map[int]float32
+
+func (w weightsFlag) String() string {
+	pairs := make([]string, 0, len(w))
+	for target, weight := range w {
+		pairs = append(pairs, fmt.Sprintf("%d=%g", target, weight))
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (w weightsFlag) Set(s string) error {
+	for _, pair := range strings.Split(s, ",") {
+		target, weight, ok := strings.Cut(pair, "=")
+		if !ok {
+			return fmt.Errorf("%q is not TARGET=WEIGHT", pair)
+		}
+		t, err := strconv.Atoi(strings.TrimSpace(target))
+		if err != nil || t < 0 {
+			return fmt.Errorf("invalid morph target index %q", target)
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(weight), 32)
+		if err != nil {
+			return fmt.Errorf("invalid morph weight %q", weight)
+		}
+		w[t] = float32(v)
+	}
+	return nil
+}
+
+func animationOptions() AnimationOptions {
+	return AnimationOptions{
+		Name:    *animationName,
+		Time:    float32(*animationTime),
+		Speed:   float32(*animationSpeed),
+		NoLoop:  *animationNoLoop,
+		Weights: morphWeights,
+	}
+}
+
 func init() {
+	flag.Var(morphWeights, "weights", "morph target weight overrides, as TARGET=WEIGHT[,...], e.g. 0=1,2=0.5")
 
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [-animation name] [-device index|name] [-validate] [-render out.png [-size WxH] [-software]] model.gltf|model.glb ...\n"+
+			"       %s -list-devices [-device index|name]\n"+
+			"       %s inspect [-json] model.gltf|model.glb ...\n"+
+			"       %s validate [-strict] model.gltf|model.glb ...\n\n"+
+			"Multiple models are shown side by side.\n\n", os.Args[0], os.Args[0], os.Args[0], os.Args[0])
+		flag.PrintDefaults()
+	}
 }
 
 func main() {
-	if len(os.Args) != 2 {
-		fmt.Fprintf(os.Stderr, "usage: %s filename.gltf\n", os.Args[0])
-		os.Exit(1)
+	if len(os.Args) > 1 && os.Args[1] == "inspect" {
+		os.Exit(runInspect(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "validate" {
+		os.Exit(runValidate(os.Args[2:]))
 	}
 
-	gltfInput, err := gltf.FromFilename(os.Args[1])
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "error reading file %s: %s\n", os.Args[1], err.Error())
+	flag.Parse()
+
+	if *listDevices {
+		os.Exit(runListDevices())
+	}
+
+	if flag.NArg() < 1 {
+		flag.Usage()
 		os.Exit(1)
 	}
 
-	gltfDoc, err := gltfInput.Resolve(nil)
+	gltfDoc, err := loadModels(flag.Args())
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "error processing file %s: %s\n", os.Args[1], err.Error())
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
 		os.Exit(1)
 	}
 
+	// Relative image URIs of a single .gltf file are resolved against its directory. loadModels made those of .glb and
+	// merged files absolute.
+	modelDir := filepath.Dir(flag.Arg(0))
+
+	if *renderFilename != "" {
+		if err := renderHeadless(gltfDoc, modelDir); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+
 	app := NewApp()
-	app.Initialize() // Move pipeline creation to after loadGlTF, or as part of it?
+	app.ModelDir = modelDir
+	app.Animation = animationOptions()
+	app.MaxFramesInFlight = *framesInFlight
+	app.DeviceSelector = *deviceSelector
+	app.Validation = *validate
+	if err := app.Initialize(); err != nil { // Move pipeline creation to after loadGlTF, or as part of it?
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		os.Exit(1)
+	}
 	// Opt b is to have a standard buffer format for position, color, etc. and translate from the format in the file?
 	// Translation is not always required. See spec section 3.7.2, attribute types have semantics for acessor and component types, eg. position is
 	// always a VEC3 of FLOAT. Some have multiple options. e.g. COLOR_n can be vec3 or vec4, color components can be float, byte
@@ -42,7 +138,46 @@ func main() {
 		fmt.Fprintf(os.Stderr, "error loading glTF to graphics engine: %s\n", err.Error())
 	}
 
-	app.winapp.DefaultMainLoop(shared.DefaultIgnoreInput, shared.DefaultIgnoreTick, app.drawFrame)
+	app.winapp.DefaultMainLoop(app.processInput, app.tick, app.drawFrame)
 
 	app.Teardown()
+	if app.renderErr != nil {
+		os.Exit(1)
+	}
+}
+
+// renderHeadless renders one frame of doc to *renderFilename, either with Vulkan or, with -software, on the CPU. It
+// returns rather than exiting on error, so that the deferred Teardown runs.
+func renderHeadless(doc *gltf.ResolvedGlTF, modelDir string) error {
+	extent, err := parseSize(*renderSize)
+	if err != nil {
+		return err
+	}
+
+	if *renderSoftware {
+		img := RenderSoftware(doc, modelDir, animationOptions(), int(extent.Width), int(extent.Height))
+		if err := writePNG(*renderFilename, img); err != nil {
+			return fmt.Errorf("error writing %s: %w", *renderFilename, err)
+		}
+		return nil
+	}
+
+	app := NewApp()
+	app.ModelDir = modelDir
+	app.Animation = animationOptions()
+	app.DeviceSelector = *deviceSelector
+	app.Validation = *validate
+	if err := app.InitializeHeadless(extent); err != nil {
+		return err
+	}
+	defer app.Teardown()
+
+	if err := app.loadGlTF(doc); err != nil {
+		return fmt.Errorf("error loading glTF to graphics engine: %w", err)
+	}
+
+	if err := app.RenderToPNG(*renderFilename); err != nil {
+		return fmt.Errorf("error rendering to %s: %w", *renderFilename, err)
+	}
+	return nil
 }
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"github.com/bbredesen/gltf"
+)
+
+// defaultBaseColor is the glTF default baseColorFactor, used when a primitive has no material or the material does
+// not set one.
+var defaultBaseColor = [4]float32{1, 1, 1, 1}
+
+// materialBaseColor returns the base color factor of m. An all-zero factor is treated as unset, since that is what an
+// absent property decodes to.
+func materialBaseColor(m *gltf.ResolvedMaterial) [4]float32 {
+	if m == nil || m.PbrMetallicRoughness == nil {
+		return defaultBaseColor
+	}
+
+	var rval [4]float32
+	var isSet bool
+	for i := range m.PbrMetallicRoughness.BaseColorFactor {
+		rval[i] = m.PbrMetallicRoughness.BaseColorFactor[i]
+		isSet = isSet || rval[i] != 0
+	}
+	if !isSet {
+		return defaultBaseColor
+	}
+	return rval
+}
+
+// materialFactors are the constant material properties, with the glTF defaults applied.
+type materialFactors struct {
+	BaseColor         [4]float32 `json:"baseColor"`
+	Emissive          [3]float32 `json:"emissive"`
+	Metallic          float32    `json:"metallic"`
+	Roughness         float32    `json:"roughness"`
+	NormalScale       float32    `json:"normalScale"`
+	OcclusionStrength float32    `json:"occlusionStrength"`
+}
+
+func materialFactorsOf(m *gltf.ResolvedMaterial) materialFactors {
+	rval := materialFactors{
+		BaseColor:         materialBaseColor(m),
+		Metallic:          1,
+		Roughness:         1,
+		NormalScale:       1,
+		OcclusionStrength: 1,
+	}
+	if m == nil {
+		return rval
+	}
+
+	if pbr := m.PbrMetallicRoughness; pbr != nil {
+		rval.Metallic, rval.Roughness = pbr.MetallicFactor, pbr.RoughnessFactor
+	}
+	for i := range m.EmissiveFactor {
+		rval.Emissive[i] = m.EmissiveFactor[i]
+	}
+
+	// As with the base color, zero is what an absent scale or strength decodes to. Neither is useful as an actual
+	// value, since it would make the texture pointless.
+	if m.NormalTexture != nil && m.NormalTexture.Scale != 0 {
+		rval.NormalScale = m.NormalTexture.Scale
+	}
+	if m.OcclusionTexture != nil && m.OcclusionTexture.Strength != 0 {
+		rval.OcclusionStrength = m.OcclusionTexture.Strength
+	}
+
+	return rval
+}
+
+// textureSlot identifies one of the material textures. The values are the binding numbers in descriptor set 1.
+type textureSlot int
+
+const (
+	baseColorSlot textureSlot = iota
+	metallicRoughnessSlot
+	normalSlot
+	occlusionSlot
+	emissiveSlot
+	numTextureSlots
+)
+
+// String returns the name of the slot's texture in the glTF material, without the "Texture" suffix.
+func (s textureSlot) String() string {
+	return [numTextureSlots]string{"baseColor", "metallicRoughness", "normal", "occlusion", "emissive"}[s]
+}
+
+// isColor reports whether the texture in this slot holds sRGB-encoded color, rather than linear data.
+func (s textureSlot) isColor() bool {
+	return s == baseColorSlot || s == emissiveSlot
+}
+
+// materialTexture is a texture reference from a material, and the TEXCOORD_n set it is sampled with.
+type materialTexture struct {
+	Texture  *gltf.ResolvedTexture
+	TexCoord int
+}
+
+// materialTextures returns the textures used by m, indexed by slot. Slots without a usable texture are nil.
+func materialTextures(m *gltf.ResolvedMaterial) [numTextureSlots]*materialTexture {
+	var rval [numTextureSlots]*materialTexture
+	if m == nil {
+		return rval
+	}
+
+	set := func(slot textureSlot, tex *gltf.ResolvedTexture, texCoord int) {
+		if tex != nil && tex.Source != nil {
+			rval[slot] = &materialTexture{Texture: tex, TexCoord: texCoord}
+		}
+	}
+
+	if pbr := m.PbrMetallicRoughness; pbr != nil {
+		if ti := pbr.BaseColorTexture; ti != nil {
+			set(baseColorSlot, ti.Texture, ti.TexCoord)
+		}
+		if ti := pbr.MetallicRoughnessTexture; ti != nil {
+			set(metallicRoughnessSlot, ti.Texture, ti.TexCoord)
+		}
+	}
+	if ti := m.NormalTexture; ti != nil {
+		set(normalSlot, ti.Texture, ti.TexCoord)
+	}
+	if ti := m.OcclusionTexture; ti != nil {
+		set(occlusionSlot, ti.Texture, ti.TexCoord)
+	}
+	if ti := m.EmissiveTexture; ti != nil {
+		set(emissiveSlot, ti.Texture, ti.TexCoord)
+	}
+
+	return rval
+}
+
+// glTF sampler filter and wrap values. These are the OpenGL enum values used in the JSON.
+const (
+	gltfNearest              = 9728
+	gltfLinear               = 9729
+	gltfNearestMipmapNearest = 9984
+	gltfLinearMipmapNearest  = 9985
+	gltfNearestMipmapLinear  = 9986
+	gltfLinearMipmapLinear   = 9987
+
+	gltfClampToEdge    = 33071
+	gltfMirroredRepeat = 33648
+	gltfRepeat         = 10497
+)
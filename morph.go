@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"unsafe"
+
+	"github.com/bbredesen/gltf"
+	"github.com/bbredesen/go-vk"
+)
+
+// morphTarget holds the per-vertex deltas of one morph target. Attributes the target doesn't displace are nil.
+type morphTarget struct {
+	positions, normals, tangents [][3]float32
+}
+
+// readMorphTargets reads the POSITION, NORMAL, and TANGENT deltas of each of a primitive's targets. Deltas may be
+// stored as normalized integers, so they are read with readFloats rather than readVec3.
+func readMorphTargets(doc *gltf.ResolvedGlTF, p *gltf.ResolvedPrimitive) ([]morphTarget, error) {
+	read := func(acc *gltf.ResolvedAccessor) ([][3]float32, error) {
+		if acc == nil || acc.Type != gltf.VEC3 {
+			return nil, nil
+		}
+		values, err := readFloats(doc, acc)
+		if err != nil {
+			return nil, err
+		}
+		rval := make([][3]float32, len(values)/3)
+		for i := range rval {
+			copy(rval[i][:], values[i*3:])
+		}
+		return rval, nil
+	}
+
+	rval := make([]morphTarget, len(p.Targets))
+	for i, t := range p.Targets {
+		var err error
+		if rval[i].positions, err = read(t[gltf.POSITION]); err != nil {
+			return nil, fmt.Errorf("morph target %d POSITION: %w", i, err)
+		}
+		if rval[i].normals, err = read(t[gltf.NORMAL]); err != nil {
+			return nil, fmt.Errorf("morph target %d NORMAL: %w", i, err)
+		}
+		if rval[i].tangents, err = read(t[gltf.TANGENT]); err != nil {
+			return nil, fmt.Errorf("morph target %d TANGENT: %w", i, err)
+		}
+	}
+	return rval, nil
+}
+
+// morphTargetCount returns the number of morph targets of a mesh. The spec requires every primitive to have the same
+// number, but the largest is used in case they don't.
+func morphTargetCount(mesh *gltf.ResolvedMesh) int {
+	var count int
+	for _, p := range mesh.Primitives {
+		if len(p.Targets) > count {
+			count = len(p.Targets)
+		}
+	}
+	return count
+}
+
+// defaultMorphWeights returns the initial morph weights of a node: the node's weights if it has them, otherwise the
+// mesh's, otherwise all zero. It returns nil if the node has no mesh with morph targets.
+func defaultMorphWeights(n *gltf.ResolvedNode) []float32 {
+	if n == nil || n.Mesh == nil {
+		return nil
+	}
+	count := morphTargetCount(n.Mesh)
+	if count == 0 {
+		return nil
+	}
+
+	rval := make([]float32, count)
+	if len(n.Weights) > 0 {
+		copy(rval, n.Weights)
+	} else {
+		copy(rval, n.Mesh.Weights)
+	}
+	return rval
+}
+
+// morphVertices returns the positions, normals, and tangents with the weighted target deltas added. Tangent w is
+// passed through. This is the CPU equivalent of the morphing in shader.vert. normals and tangents may be nil, in which
+// case nil is returned for them too.
+func morphVertices(positions, normals [][3]float32, tangents [][4]float32, targets []morphTarget, weights []float32) ([][3]float32, [][3]float32, [][4]float32) {
+	outPositions := append([][3]float32(nil), positions...)
+	outNormals := append([][3]float32(nil), normals...)
+	outTangents := append([][4]float32(nil), tangents...)
+
+	for t, target := range targets {
+		if t >= len(weights) || weights[t] == 0 {
+			continue
+		}
+		w := weights[t]
+
+		for i := range outPositions {
+			if i < len(target.positions) {
+				outPositions[i] = add3(outPositions[i], scale3(target.positions[i], w))
+			}
+		}
+		for i := range outNormals {
+			if i < len(target.normals) {
+				outNormals[i] = add3(outNormals[i], scale3(target.normals[i], w))
+			}
+		}
+		for i := range outTangents {
+			if i < len(target.tangents) {
+				d := scale3(target.tangents[i], w)
+				outTangents[i] = [4]float32{outTangents[i][0] + d[0], outTangents[i][1] + d[1], outTangents[i][2] + d[2], outTangents[i][3]}
+			}
+		}
+	}
+
+	return outPositions, outNormals, outTangents
+}
+
+// morphDeltaAttributes is the number of vec4 deltas stored per vertex and target in the MorphDeltas buffer (set 3,
+// binding 2) in shader.vert: position, normal, and tangent, in that order.
+const morphDeltaAttributes = 3
+
+// appendMorphDeltas appends the deltas of targets for vertexCount vertices to deltas, in the order shader.vert reads
+// them: for each vertex, for each target, the position, normal, and tangent delta. Missing deltas are zero.
+func appendMorphDeltas(deltas [][4]float32, targets []morphTarget, vertexCount int) [][4]float32 {
+	at := func(values [][3]float32, i int) [4]float32 {
+		if i < len(values) {
+			return [4]float32{values[i][0], values[i][1], values[i][2], 0}
+		}
+		return [4]float32{}
+	}
+
+	for v := 0; v < vertexCount; v++ {
+		for _, t := range targets {
+			deltas = append(deltas, at(t.positions, v), at(t.normals, v), at(t.tangents, v))
+		}
+	}
+	return deltas
+}
+
+// createMorphDeltaBuffer uploads the morph target deltas collected by createDrawData as a storage buffer (set 3,
+// binding 2). A zeroed placeholder is uploaded when the model has no morph targets, since the binding can't be empty.
+func (app *App) createMorphDeltaBuffer(deltas [][4]float32) error {
+	if len(deltas) == 0 {
+		deltas = make([][4]float32, 1)
+	}
+	data := unsafe.Slice((*byte)(unsafe.Pointer(&deltas[0])), len(deltas)*int(unsafe.Sizeof(deltas[0])))
+
+	var err error
+	if app.morphDeltaBuffer, app.morphDeltaMemory, err = app.createBufferWithData(vk.BUFFER_USAGE_STORAGE_BUFFER_BIT, data); err != nil {
+		return err
+	}
+	app.morphDeltaSize = uint32(len(data))
+	return nil
+}
+
+// createMorphWeightBuffer allocates the storage buffer for morph target weights (set 3, binding 1). Like the joint
+// buffer, each frame in flight has its own region, holding one block per node with morph targets; nodes without any
+// are bound to the first block, which is never read because their primitives have no targets.
+func (app *App) createMorphWeightBuffer() error {
+	align := uint32(vk.GetPhysicalDeviceProperties(app.PhysicalDevice).Limits.MinStorageBufferOffsetAlignment)
+	// The first block is never read, but still has to be a valid range for the descriptor.
+	const firstBlock = 16
+	app.morphWeightLayout = newFrameBlockLayout(align, firstBlock, findMorphNodes(app.scene), func(n *SceneNode) uint32 {
+		return uint32(len(n.Weights)) * uint32(unsafe.Sizeof(float32(0)))
+	})
+
+	var err error
+	if app.morphWeightBuffer, app.morphWeightMemory, app.morphWeightMapped, err = app.createFrameBlockBuffer(app.morphWeightLayout); err != nil {
+		return fmt.Errorf("could not create the morph weight buffer: %w", err)
+	}
+	return nil
+}
+
+// writeMorphWeights copies the current weights of every node with morph targets to the region of the weight buffer
+// used by frame. The frame's previous submission must have completed.
+func (app *App) writeMorphWeights(frame int) {
+	base := uint32(frame) * app.morphWeightLayout.frameStride
+	for n, offset := range app.morphWeightLayout.offsets {
+		copy(app.morphWeightMapped[base+offset:], unsafe.Slice((*byte)(unsafe.Pointer(&n.Weights[0])), len(n.Weights)*int(unsafe.Sizeof(n.Weights[0]))))
+	}
+}
+
+// morphWeightOffset returns the dynamic offset of n's morph weights for the current frame.
+func (app *App) morphWeightOffset(n *SceneNode) uint32 {
+	return app.morphWeightLayout.offset(app.currentFrame, n)
+}
+
+func (app *App) destroyMorphBuffers() {
+	app.morphWeightMapped = nil
+	vk.DestroyBuffer(app.Device, app.morphWeightBuffer, nil)
+	app.Free(app.morphWeightMemory)
+	app.morphWeightLayout = frameBlockLayout{}
+
+	vk.DestroyBuffer(app.Device, app.morphDeltaBuffer, nil)
+	app.Free(app.morphDeltaMemory)
+}
@@ -0,0 +1,96 @@
+package main
+
+import "testing"
+
+func TestMorphVertices(t *testing.T) {
+	positions := [][3]float32{{0, 0, 0}, {1, 0, 0}, {0, 1, 0}}
+	normals := [][3]float32{{0, 0, 1}, {0, 0, 1}, {0, 0, 1}}
+	tangents := [][4]float32{{1, 0, 0, 1}, {1, 0, 0, -1}, {1, 0, 0, 1}}
+
+	// The first target raises every vertex and tilts the normals. The second moves only the positions, and only of
+	// the first two vertices: its deltas stop short, as if the accessor were shorter than POSITION.
+	targets := []morphTarget{
+		{
+			positions: [][3]float32{{0, 0, 1}, {0, 0, 1}, {0, 0, 1}},
+			normals:   [][3]float32{{1, 0, 0}, {1, 0, 0}, {1, 0, 0}},
+			tangents:  [][3]float32{{0, 0, -1}, {0, 0, -1}, {0, 0, -1}},
+		},
+		{
+			positions: [][3]float32{{2, 0, 0}, {0, 2, 0}},
+		},
+	}
+
+	tests := []struct {
+		name          string
+		weights       []float32
+		wantPositions [][3]float32
+		wantNormals   [][3]float32
+		wantTangents  [][4]float32
+	}{
+		{
+			name:          "zero weights",
+			weights:       []float32{0, 0},
+			wantPositions: positions,
+			wantNormals:   normals,
+			wantTangents:  tangents,
+		},
+		{
+			// Tangent w, the bitangent sign, is not a delta and passes through.
+			name:          "first target",
+			weights:       []float32{0.5, 0},
+			wantPositions: [][3]float32{{0, 0, 0.5}, {1, 0, 0.5}, {0, 1, 0.5}},
+			wantNormals:   [][3]float32{{0.5, 0, 1}, {0.5, 0, 1}, {0.5, 0, 1}},
+			wantTangents:  [][4]float32{{1, 0, -0.5, 1}, {1, 0, -0.5, -1}, {1, 0, -0.5, 1}},
+		},
+		{
+			name:          "both targets",
+			weights:       []float32{1, 0.5},
+			wantPositions: [][3]float32{{1, 0, 1}, {1, 1, 1}, {0, 1, 1}},
+			wantNormals:   [][3]float32{{1, 0, 1}, {1, 0, 1}, {1, 0, 1}},
+			wantTangents:  [][4]float32{{1, 0, -1, 1}, {1, 0, -1, -1}, {1, 0, -1, 1}},
+		},
+		{
+			// Weights can be negative, and more than 1.
+			name:          "extrapolated",
+			weights:       []float32{-1, 2},
+			wantPositions: [][3]float32{{4, 0, -1}, {1, 4, -1}, {0, 1, -1}},
+			wantNormals:   [][3]float32{{-1, 0, 1}, {-1, 0, 1}, {-1, 0, 1}},
+			wantTangents:  [][4]float32{{1, 0, 1, 1}, {1, 0, 1, -1}, {1, 0, 1, 1}},
+		},
+		{
+			// Targets without a weight are left out.
+			name:          "missing weight",
+			weights:       []float32{1},
+			wantPositions: [][3]float32{{0, 0, 1}, {1, 0, 1}, {0, 1, 1}},
+			wantNormals:   [][3]float32{{1, 0, 1}, {1, 0, 1}, {1, 0, 1}},
+			wantTangents:  [][4]float32{{1, 0, -1, 1}, {1, 0, -1, -1}, {1, 0, -1, 1}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gotPositions, gotNormals, gotTangents := morphVertices(positions, normals, tangents, targets, test.weights)
+			for i := range positions {
+				if got, want := gotPositions[i], test.wantPositions[i]; !floatsNear(got[:], want[:]) {
+					t.Errorf("vertex %d at %v, want %v", i, got, want)
+				}
+				if got, want := gotNormals[i], test.wantNormals[i]; !floatsNear(got[:], want[:]) {
+					t.Errorf("normal %d is %v, want %v", i, got, want)
+				}
+				if got, want := gotTangents[i], test.wantTangents[i]; !floatsNear(got[:], want[:]) {
+					t.Errorf("tangent %d is %v, want %v", i, got, want)
+				}
+			}
+		})
+	}
+
+	// The inputs are not modified.
+	if positions[0] != ([3]float32{}) || normals[0] != ([3]float32{0, 0, 1}) || tangents[0] != ([4]float32{1, 0, 0, 1}) {
+		t.Errorf("morphVertices modified its inputs")
+	}
+
+	_, gotNormals, gotTangents := morphVertices(positions, nil, nil, targets, []float32{1, 1})
+	if gotNormals != nil || gotTangents != nil {
+		t.Errorf("morphing without normals or tangents returned %v and %v, want nil", gotNormals, gotTangents)
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"github.com/bbredesen/gltf-viewer/raster"
+	"github.com/chewxy/math32"
+)
+
+// This is a CPU copy of the shading in shaders/shader.frag, used by the software renderer. The two must be kept in
+// step; the structure and names follow the shader so they can be compared side by side.
+
+// pbrFragment holds the interpolated vertex outputs for one fragment, in world space.
+type pbrFragment struct {
+	position [3]float32
+	normal   [3]float32
+	// tangent is the TANGENT attribute, with the bitangent sign in w. It is all zero if the primitive has no tangents.
+	tangent  [4]float32
+	texCoord [2][2]float32
+	color    [4]float32
+}
+
+// pbrMaterial is a material prepared for CPU shading. Textures may be nil, which samples as white.
+type pbrMaterial struct {
+	factors  materialFactors
+	textures [numTextureSlots]*raster.Texture
+	texCoord [numTextureSlots]int
+
+	// flatNormals is set when the primitive has no NORMAL attribute, and the normal is the face normal instead.
+	flatNormals bool
+}
+
+func (m *pbrMaterial) sample(slot textureSlot, f *pbrFragment) [4]float32 {
+	tex := m.textures[slot]
+	if tex == nil {
+		return [4]float32{1, 1, 1, 1}
+	}
+	uv := f.texCoord[m.texCoord[slot]]
+	return tex.Sample(uv[0], uv[1])
+}
+
+// shadePBR evaluates the glTF metallic-roughness BRDF under image-based lighting, and returns the tone mapped linear
+// color and alpha.
+func shadePBR(env *environment, eye [3]float32, m *pbrMaterial, f *pbrFragment) [4]float32 {
+	baseColor := m.factors.BaseColor
+	texel := m.sample(baseColorSlot, f)
+	for i := range baseColor {
+		baseColor[i] *= f.color[i] * texel[i]
+	}
+
+	mr := m.sample(metallicRoughnessSlot, f)
+	metallic := clamp01(m.factors.Metallic * mr[2])
+	roughness := clamp01(m.factors.Roughness * mr[1])
+
+	v := normalize3(sub3(eye, f.position))
+
+	n := normalize3(f.normal)
+	if m.flatNormals && dot3(n, v) < 0 {
+		n = scale3(n, -1)
+	}
+
+	if m.textures[normalSlot] != nil {
+		t := [3]float32{f.tangent[0], f.tangent[1], f.tangent[2]}
+		if dot3(t, t) > 0 {
+			t = normalize3(sub3(t, scale3(n, dot3(n, t))))
+			b := scale3(cross3(n, t), f.tangent[3])
+
+			tn := m.sample(normalSlot, f)
+			x := (tn[0]*2 - 1) * m.factors.NormalScale
+			y := (tn[1]*2 - 1) * m.factors.NormalScale
+			z := tn[2]*2 - 1
+			n = normalize3(add3(add3(scale3(t, x), scale3(b, y)), scale3(n, z)))
+		}
+	}
+
+	rgb := [3]float32{baseColor[0], baseColor[1], baseColor[2]}
+	cDiff := mix3(rgb, [3]float32{}, metallic)
+	f0 := mix3([3]float32{0.04, 0.04, 0.04}, rgb, metallic)
+
+	nDotV := clamp01(dot3(n, v))
+	lod := roughness * float32(iblSpecularLevels-1)
+	specularLight := env.specular.sample(reflect3(scale3(v, -1), n), lod)
+	irradiance := env.irradiance.sample(n, 0)
+	brdf := env.sampleBRDFLUT(nDotV, roughness)
+
+	var color [3]float32
+	fresnelWeight := math32.Pow(1-nDotV, 5)
+	for i := 0; i < 3; i++ {
+		fr := math32.Max(1-roughness, f0[i]) - f0[i]
+		kS := f0[i] + fr*fresnelWeight
+		fssEss := kS*brdf[0] + brdf[1]
+
+		specular := specularLight[i] * fssEss
+		diffuse := irradiance[i] * cDiff[i] * (1 - fssEss)
+		color[i] = diffuse + specular
+	}
+
+	ao := m.sample(occlusionSlot, f)[0]
+	color = mix3(color, scale3(color, ao), m.factors.OcclusionStrength)
+
+	emissive := m.sample(emissiveSlot, f)
+	for i := 0; i < 3; i++ {
+		color[i] += m.factors.Emissive[i] * emissive[i]
+	}
+
+	color = toneMapPBRNeutral(color)
+	return [4]float32{color[0], color[1], color[2], baseColor[3]}
+}
+
+// toneMapPBRNeutral is the Khronos PBR Neutral tone mapper, which keeps base colors close to their input values
+// under neutral lighting.
+func toneMapPBRNeutral(color [3]float32) [3]float32 {
+	const startCompression = 0.8 - 0.04
+	const desaturation = 0.15
+
+	x := math32.Min(color[0], math32.Min(color[1], color[2]))
+	offset := float32(0.04)
+	if x < 0.08 {
+		offset = x - 6.25*x*x
+	}
+	color = sub3(color, [3]float32{offset, offset, offset})
+
+	peak := math32.Max(color[0], math32.Max(color[1], color[2]))
+	if peak < startCompression {
+		return color
+	}
+
+	const d = 1 - startCompression
+	newPeak := 1 - d*d/(peak+d-startCompression)
+	color = scale3(color, newPeak/peak)
+
+	g := 1 - 1/(desaturation*(peak-newPeak)+1)
+	return mix3(color, [3]float32{newPeak, newPeak, newPeak}, g)
+}
+
+// linearToSRGB encodes a linear color channel, as the GPU does when writing to an _SRGB attachment.
+func linearToSRGB(c float32) float32 {
+	if c <= 0.0031308 {
+		return c * 12.92
+	}
+	return 1.055*math32.Pow(c, 1/2.4) - 0.055
+}
+
+func clamp01(x float32) float32 {
+	if x < 0 {
+		return 0
+	}
+	if x > 1 {
+		return 1
+	}
+	return x
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"unsafe"
+
+	"github.com/bbredesen/go-vk"
+)
+
+// Pipeline cache files start with a header of their own, followed by the data from vkGetPipelineCacheData. Drivers
+// check the Vulkan header at the start of that data, but need not cope with truncated or corrupt data after it, so the
+// length and checksum here are checked before anything is handed to the driver.
+const (
+	pipelineCacheMagic      = "GVPC"
+	pipelineCacheVersion    = 1
+	pipelineCacheHeaderSize = 20 // magic, version, driver version, data length, CRC-32 of the data
+
+	vkCacheHeaderSize       = 32 // VkPipelineCacheHeaderVersionOne
+	vkCacheHeaderVersionOne = 1  // VK_PIPELINE_CACHE_HEADER_VERSION_ONE
+)
+
+// pipelineCachePath returns the file holding the pipeline cache for a device, or "" if the user has no cache
+// directory. Caches are only compatible between devices with the same pipeline cache UUID, and drivers often keep the
+// UUID across versions while changing the data, so the driver version is part of the name too.
+func pipelineCachePath(props *vk.PhysicalDeviceProperties) string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	name := fmt.Sprintf("pipelines-%x-%08x.bin", props.PipelineCacheUUID[:], props.DriverVersion)
+	return filepath.Join(dir, "gltf-viewer", name)
+}
+
+// createPipelineCache creates the pipeline cache, seeded from the file saved by an earlier run on the same device and
+// driver. A missing file just means an empty cache; a corrupt or mismatched one is reported and deleted.
+func (vp *VulkanPipeline) createPipelineCache() error {
+	props := vk.GetPhysicalDeviceProperties(vp.ctx.PhysicalDevice)
+	vp.pipelineCacheFile = pipelineCachePath(&props)
+
+	var initial []byte
+	if vp.pipelineCacheFile != "" {
+		var err error
+		if initial, err = readPipelineCache(vp.pipelineCacheFile, &props); err != nil {
+			vp.ctx.Log().Warn("discarding pipeline cache", "err", err)
+			os.Remove(vp.pipelineCacheFile)
+		}
+	}
+
+	var err error
+	if vp.pipelineCache, err = createVkPipelineCache(vp.ctx.Device, initial); err != nil && len(initial) > 0 {
+		// The driver rejected data that passed the checks above; start over with an empty cache.
+		vp.ctx.Log().Warn("discarding pipeline cache", "file", vp.pipelineCacheFile, "err", err)
+		os.Remove(vp.pipelineCacheFile)
+		vp.pipelineCache, err = createVkPipelineCache(vp.ctx.Device, nil)
+	}
+	if err != nil {
+		return fmt.Errorf("could not create pipeline cache: %w", err)
+	}
+	return nil
+}
+
+func createVkPipelineCache(device vk.Device, initial []byte) (vk.PipelineCache, error) {
+	cacheCI := vk.PipelineCacheCreateInfo{}
+	if len(initial) > 0 {
+		cacheCI.InitialDataSize = uintptr(len(initial))
+		cacheCI.PInitialData = unsafe.Pointer(&initial[0])
+	}
+	return vk.CreatePipelineCache(device, &cacheCI, nil)
+}
+
+// savePipelineCache writes the pipeline cache to disk for the next run. The cache only saves time at startup, so
+// failures are reported but otherwise ignored.
+func (vp *VulkanPipeline) savePipelineCache() {
+	if vp.pipelineCacheFile == "" {
+		return
+	}
+
+	data, err := vk.GetPipelineCacheData(vp.ctx.Device, vp.pipelineCache)
+	if err == nil && len(data) > 0 {
+		props := vk.GetPhysicalDeviceProperties(vp.ctx.PhysicalDevice)
+		err = writePipelineCache(vp.pipelineCacheFile, data, props.DriverVersion)
+	}
+	if err != nil {
+		vp.ctx.Log().Warn("could not save pipeline cache", "file", vp.pipelineCacheFile, "err", err)
+	}
+}
+
+// readPipelineCache returns the Vulkan cache data saved in filename, after checking that it is intact and was made by
+// the device described by props. A missing file returns no data and no error.
+func readPipelineCache(filename string, props *vk.PhysicalDeviceProperties) ([]byte, error) {
+	file, err := os.ReadFile(filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := checkPipelineCache(file, props)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return data, nil
+}
+
+// checkPipelineCache validates the headers of a pipeline cache file, and returns the Vulkan cache data in it.
+func checkPipelineCache(file []byte, props *vk.PhysicalDeviceProperties) ([]byte, error) {
+	le := binary.LittleEndian
+
+	if len(file) < pipelineCacheHeaderSize || string(file[:4]) != pipelineCacheMagic {
+		return nil, errors.New("not a pipeline cache file")
+	}
+	if version := le.Uint32(file[4:]); version != pipelineCacheVersion {
+		return nil, fmt.Errorf("unsupported file version %d", version)
+	}
+	if driver := le.Uint32(file[8:]); driver != props.DriverVersion {
+		return nil, fmt.Errorf("made by driver version %08x, not %08x", driver, props.DriverVersion)
+	}
+	data := file[pipelineCacheHeaderSize:]
+	if n := le.Uint32(file[12:]); int(n) != len(data) {
+		return nil, fmt.Errorf("truncated, header has %d bytes of data but file has %d", n, len(data))
+	}
+	if crc32.ChecksumIEEE(data) != le.Uint32(file[16:]) {
+		return nil, errors.New("checksum mismatch")
+	}
+
+	// The Vulkan header, which is written in the byte order of the host.
+	if len(data) < vkCacheHeaderSize {
+		return nil, errors.New("cache data is too short")
+	}
+	headerSize, headerVersion := le.Uint32(data[0:]), le.Uint32(data[4:])
+	if headerSize < vkCacheHeaderSize || int(headerSize) > len(data) || headerVersion != vkCacheHeaderVersionOne {
+		return nil, fmt.Errorf("invalid cache header (size %d, version %d)", headerSize, headerVersion)
+	}
+	if vendor, device := le.Uint32(data[8:]), le.Uint32(data[12:]); vendor != props.VendorID || device != props.DeviceID {
+		return nil, fmt.Errorf("made for device %04x:%04x, not %04x:%04x", vendor, device, props.VendorID, props.DeviceID)
+	}
+	if !bytes.Equal(data[16:32], props.PipelineCacheUUID[:]) {
+		return nil, errors.New("pipeline cache UUID does not match the device")
+	}
+	return data, nil
+}
+
+// writePipelineCache saves data, from vkGetPipelineCacheData, to filename with the header checkPipelineCache expects.
+// The file is written under a temporary name and renamed into place, so that a crash, or another viewer saving at
+// the same time, can't leave a partly written cache behind.
+func writePipelineCache(filename string, data []byte, driverVersion uint32) error {
+	le := binary.LittleEndian
+
+	file := make([]byte, pipelineCacheHeaderSize, pipelineCacheHeaderSize+len(data))
+	copy(file, pipelineCacheMagic)
+	le.PutUint32(file[4:], pipelineCacheVersion)
+	le.PutUint32(file[8:], driverVersion)
+	le.PutUint32(file[12:], uint32(len(data)))
+	le.PutUint32(file[16:], crc32.ChecksumIEEE(data))
+	file = append(file, data...)
+
+	dir := filepath.Dir(filename)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, filepath.Base(filename)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(file)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filename)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
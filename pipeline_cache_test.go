@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bbredesen/go-vk"
+)
+
+func testDeviceProperties() vk.PhysicalDeviceProperties {
+	props := vk.PhysicalDeviceProperties{
+		VendorID:      0x1002,
+		DeviceID:      0x73bf,
+		DriverVersion: 0x00802003,
+	}
+	for i := range props.PipelineCacheUUID {
+		props.PipelineCacheUUID[i] = byte(i + 1)
+	}
+	return props
+}
+
+// testCacheData returns cache data as vkGetPipelineCacheData would for props, with a few bytes of payload after the
+// Vulkan header.
+func testCacheData(props *vk.PhysicalDeviceProperties) []byte {
+	le := binary.LittleEndian
+
+	data := make([]byte, vkCacheHeaderSize, vkCacheHeaderSize+8)
+	le.PutUint32(data[0:], vkCacheHeaderSize)
+	le.PutUint32(data[4:], vkCacheHeaderVersionOne)
+	le.PutUint32(data[8:], props.VendorID)
+	le.PutUint32(data[12:], props.DeviceID)
+	copy(data[16:], props.PipelineCacheUUID[:])
+	return append(data, 1, 2, 3, 4, 5, 6, 7, 8)
+}
+
+func TestPipelineCacheRoundTrip(t *testing.T) {
+	props := testDeviceProperties()
+	data := testCacheData(&props)
+	filename := filepath.Join(t.TempDir(), "cache", "pipelines.bin")
+
+	if err := writePipelineCache(filename, data, props.DriverVersion); err != nil {
+		t.Fatalf("writePipelineCache: %s", err)
+	}
+	got, err := readPipelineCache(filename, &props)
+	if err != nil {
+		t.Fatalf("readPipelineCache: %s", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("read %v, want %v", got, data)
+	}
+
+	// Saving again replaces the file, and leaves no temporary files behind.
+	data[len(data)-1] = 9
+	if err := writePipelineCache(filename, data, props.DriverVersion); err != nil {
+		t.Fatalf("writePipelineCache: %s", err)
+	}
+	if got, err = readPipelineCache(filename, &props); err != nil || !bytes.Equal(got, data) {
+		t.Errorf("after rewrite, read %v, %v; want %v", got, err, data)
+	}
+	entries, err := os.ReadDir(filepath.Dir(filename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("cache directory has %d entries, want 1", len(entries))
+	}
+}
+
+func TestReadPipelineCacheMissing(t *testing.T) {
+	props := testDeviceProperties()
+	data, err := readPipelineCache(filepath.Join(t.TempDir(), "missing.bin"), &props)
+	if data != nil || err != nil {
+		t.Errorf("readPipelineCache of a missing file = %v, %v; want nil, nil", data, err)
+	}
+}
+
+func TestCheckPipelineCache(t *testing.T) {
+	props := testDeviceProperties()
+
+	// file returns a valid cache file for props, after applying modify to the data, and then to the whole file. The
+	// header is written after modifying the data, so its length and checksum match.
+	file := func(modifyData func([]byte) []byte, modifyFile func([]byte) []byte) []byte {
+		data := testCacheData(&props)
+		if modifyData != nil {
+			data = modifyData(data)
+		}
+		filename := filepath.Join(t.TempDir(), "pipelines.bin")
+		if err := writePipelineCache(filename, data, props.DriverVersion); err != nil {
+			t.Fatal(err)
+		}
+		f, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if modifyFile != nil {
+			f = modifyFile(f)
+		}
+		return f
+	}
+
+	tests := []struct {
+		name  string
+		file  []byte
+		props func(*vk.PhysicalDeviceProperties)
+		ok    bool
+	}{
+		{name: "valid", file: file(nil, nil), ok: true},
+		{name: "empty", file: nil},
+		{name: "short header", file: file(nil, func(f []byte) []byte { return f[:pipelineCacheHeaderSize-1] })},
+		{name: "bad magic", file: file(nil, func(f []byte) []byte { f[0] = 'X'; return f })},
+		{name: "bad version", file: file(nil, func(f []byte) []byte { f[4]++; return f })},
+		{name: "truncated", file: file(nil, func(f []byte) []byte { return f[:len(f)-3] })},
+		{name: "trailing data", file: file(nil, func(f []byte) []byte { return append(f, 0) })},
+		{name: "bad checksum", file: file(nil, func(f []byte) []byte { f[len(f)-1] ^= 0xff; return f })},
+		{
+			name:  "wrong driver",
+			file:  file(nil, nil),
+			props: func(p *vk.PhysicalDeviceProperties) { p.DriverVersion++ },
+		},
+		{
+			name:  "wrong device",
+			file:  file(nil, nil),
+			props: func(p *vk.PhysicalDeviceProperties) { p.DeviceID++ },
+		},
+		{
+			name:  "wrong UUID",
+			file:  file(nil, nil),
+			props: func(p *vk.PhysicalDeviceProperties) { p.PipelineCacheUUID[15]++ },
+		},
+		{
+			name: "short cache data",
+			file: file(func(d []byte) []byte { return d[:vkCacheHeaderSize-1] }, nil),
+		},
+		{
+			name: "bad Vulkan header size",
+			file: file(func(d []byte) []byte { binary.LittleEndian.PutUint32(d, 1000); return d }, nil),
+		},
+		{
+			name: "bad Vulkan header version",
+			file: file(func(d []byte) []byte { binary.LittleEndian.PutUint32(d[4:], 2); return d }, nil),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := props
+			if test.props != nil {
+				test.props(&p)
+			}
+			data, err := checkPipelineCache(test.file, &p)
+			switch {
+			case test.ok && err != nil:
+				t.Errorf("checkPipelineCache: %s", err)
+			case test.ok && !bytes.Equal(data, testCacheData(&props)):
+				t.Errorf("checkPipelineCache returned %v, want the cache data", data)
+			case !test.ok && err == nil:
+				t.Errorf("checkPipelineCache accepted the file")
+			}
+		})
+	}
+}
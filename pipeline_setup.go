@@ -1,9 +1,11 @@
 package main
 
-//go:generate glslc.exe shaders/shader.vert -o shaders/vert.spv
-//go:generate glslc.exe shaders/shader.frag -o shaders/frag.spv
+//go:generate glslc shaders/shader.vert -o shaders/vert.spv
+//go:generate glslc shaders/shader.frag -o shaders/frag.spv
 
 import (
+	"errors"
+	"fmt"
 	"os"
 	"unsafe"
 
@@ -14,40 +16,74 @@ import (
 )
 
 type VulkanPipeline struct {
-	ctx              *vkctx.Context
-	pipelineLayout   vk.PipelineLayout
-	graphicsPipeline vk.Pipeline
+	ctx            *vkctx.Context
+	pipelineLayout vk.PipelineLayout
+	// graphicsPipelines holds a pipeline for each distinct vertex layout and topology, created on first use by
+	// pipelineFor.
+	graphicsPipelines map[pipelineKey]vk.Pipeline
+	// pipelineCache is loaded from, and saved back to, pipelineCacheFile, see createPipelineCache. The file name is
+	// empty if there is nowhere to save it.
+	pipelineCache     vk.PipelineCache
+	pipelineCacheFile string
 
 	// Renderpass
 	renderPass vk.RenderPass
 
 	stencilSubpass, colorSubpass     vk.SubpassDescription
 	stencilImage, colorImage         vk.Image
-	stencilMemory, colorMemory       vk.DeviceMemory
+	stencilMemory, colorMemory       *vkctx.Allocation
 	stencilImageView, colorImageView vk.ImageView
 
 	vertShaderModule, fragShaderModule vk.ShaderModule
 
+	// Set 0 holds the per-draw uniform block, bound with a dynamic offset for each primitive.
+	drawDataSetLayout vk.DescriptorSetLayout
+	// Set 1 holds the material textures, one set per material.
+	materialSetLayout vk.DescriptorSetLayout
+	// Set 2 holds the per-frame scene data and the image-based lighting maps, one set per frame in flight.
+	sceneSetLayout vk.DescriptorSetLayout
+	// Set 3 holds the joint matrices and morph weights, bound with dynamic offsets for each node, and the morph target
+	// deltas of every primitive.
+	deformSetLayout vk.DescriptorSetLayout
+
+	// accessorBindings and accessorAttrs describe each attribute as if it were alone in its buffer: the stride is the
+	// tightly packed element size, and the offset is 0. primitiveVertexLayout adjusts them for interleaved data.
 	accessorBindings map[gltf.AttributeKey]vk.VertexInputBindingDescription
 	accessorAttrs    map[gltf.AttributeKey]vk.VertexInputAttributeDescription
 }
 
-func (vp *VulkanPipeline) Initialize(ctx *vkctx.Context) {
+// Initialize creates the render pass, framebuffers, and pipeline layout for ctx. If any step fails, everything created
+// so far is destroyed again.
+func (vp *VulkanPipeline) Initialize(ctx *vkctx.Context) (err error) {
 	vp.ctx = ctx
+	defer func() {
+		if err != nil {
+			vp.Teardown()
+		}
+	}()
 	// vp.stencilImage, vp.stencilMemory = ctx.CreateImage(ctx.SwapchainExtent, vk.FORMAT_S8_UINT, vk.IMAGE_TILING_OPTIMAL, vk.IMAGE_USAGE_DEPTH_STENCIL_ATTACHMENT_BIT, vk.MEMORY_PROPERTY_DEVICE_LOCAL_BIT)
 	// vp.stencilImageView = ctx.CreateImageView(vp.stencilImage, vk.FORMAT_S8_UINT, vk.IMAGE_ASPECT_STENCIL_BIT)
 
-	vp.colorImage, vp.colorMemory = ctx.CreateImage(ctx.SwapchainExtent, vk.FORMAT_R32G32B32A32_SFLOAT, vk.IMAGE_TILING_OPTIMAL, vk.IMAGE_USAGE_COLOR_ATTACHMENT_BIT, vk.MEMORY_PROPERTY_DEVICE_LOCAL_BIT)
-	vp.colorImageView = ctx.CreateImageView(vp.colorImage, vk.FORMAT_R32G32B32A32_SFLOAT, vk.IMAGE_ASPECT_COLOR_BIT)
+	if vp.colorImage, vp.colorMemory, err = ctx.CreateImage(ctx.SwapchainExtent, vk.FORMAT_R32G32B32A32_SFLOAT, vk.IMAGE_TILING_OPTIMAL, vk.IMAGE_USAGE_COLOR_ATTACHMENT_BIT, vk.MEMORY_PROPERTY_DEVICE_LOCAL_BIT); err != nil {
+		return err
+	}
+	if vp.colorImageView, err = ctx.CreateImageView(vp.colorImage, vk.FORMAT_R32G32B32A32_SFLOAT, vk.IMAGE_ASPECT_COLOR_BIT); err != nil {
+		return err
+	}
 
-	vp.CreateRenderPass()
+	if err = vp.CreateRenderPass(); err != nil {
+		return err
+	}
 
-	vp.CreateFramebuffers()
+	if err = vp.CreateFramebuffers(); err != nil {
+		return err
+	}
 
-	vp.CreateGraphicsPipelines()
+	return vp.CreateGraphicsPipelines()
 }
 
-func (vp *VulkanPipeline) standardViewport() *vk.PipelineViewportStateCreateInfo {
+// setViewportAndScissor records the dynamic viewport and scissor state covering the whole swapchain extent.
+func (vp *VulkanPipeline) setViewportAndScissor(cb vk.CommandBuffer) {
 
 	viewport := vk.Viewport{
 		X:        0.0,
@@ -63,11 +99,8 @@ func (vp *VulkanPipeline) standardViewport() *vk.PipelineViewportStateCreateInfo
 		Extent: vp.ctx.SwapchainExtent,
 	}
 
-	return &vk.PipelineViewportStateCreateInfo{
-		PViewports: []vk.Viewport{viewport},
-		PScissors:  []vk.Rect2D{scissor},
-	}
-
+	vk.CmdSetViewport(cb, 0, []vk.Viewport{viewport})
+	vk.CmdSetScissor(cb, 0, []vk.Rect2D{scissor})
 }
 
 func (vp *VulkanPipeline) prebuildVertexInputDescriptions() {
@@ -76,35 +109,257 @@ func (vp *VulkanPipeline) prebuildVertexInputDescriptions() {
 
 	vp.accessorBindings[gltf.POSITION] = vk.VertexInputBindingDescription{
 		Binding:   0,
-		Stride:    3 * 4, // POSITION is always a VEC3 of FLOAT, TODO handle interleaved data
+		Stride:    3 * 4, // POSITION is always a VEC3 of FLOAT
 		InputRate: vk.VERTEX_INPUT_RATE_VERTEX,
 	}
 	vp.accessorAttrs[gltf.POSITION] = vk.VertexInputAttributeDescription{
 		Location: 0,
 		Binding:  0,
 		Format:   vk.FORMAT_R32G32B32_SFLOAT,
-		Offset:   0, // TODO handle offset from base of vertex?
+		Offset:   0,
 	}
 
 	vp.accessorBindings[gltf.NORMAL] = vk.VertexInputBindingDescription{
 		Binding:   1,
-		Stride:    3 * 4, // NORMAL is always a VEC3 of FLOAT, TODO handle interleaved data
+		Stride:    3 * 4, // NORMAL is always a VEC3 of FLOAT
 		InputRate: vk.VERTEX_INPUT_RATE_VERTEX,
 	}
 	vp.accessorAttrs[gltf.NORMAL] = vk.VertexInputAttributeDescription{
 		Location: 1,
 		Binding:  1,
 		Format:   vk.FORMAT_R32G32B32_SFLOAT,
-		Offset:   0, // TODO handle offset from base of vertex?
+		Offset:   0,
+	}
+
+	vp.accessorBindings[gltf.TANGENT] = vk.VertexInputBindingDescription{
+		Binding:   2,
+		Stride:    4 * 4, // TANGENT is always a VEC4 of FLOAT
+		InputRate: vk.VERTEX_INPUT_RATE_VERTEX,
+	}
+	vp.accessorAttrs[gltf.TANGENT] = vk.VertexInputAttributeDescription{
+		Location: 5,
+		Binding:  2,
+		Format:   vk.FORMAT_R32G32B32A32_SFLOAT,
+		Offset:   0,
+	}
+
+	vp.accessorBindings[gltf.TEXCOORD_0] = vk.VertexInputBindingDescription{
+		Binding:   3,
+		Stride:    2 * 4, // TEXCOORD_n is converted to a VEC2 of FLOAT on load
+		InputRate: vk.VERTEX_INPUT_RATE_VERTEX,
+	}
+	vp.accessorAttrs[gltf.TEXCOORD_0] = vk.VertexInputAttributeDescription{
+		Location: 3,
+		Binding:  3,
+		Format:   vk.FORMAT_R32G32_SFLOAT,
+		Offset:   0,
+	}
+
+	vp.accessorBindings[gltf.TEXCOORD_1] = vk.VertexInputBindingDescription{
+		Binding:   4,
+		Stride:    2 * 4,
+		InputRate: vk.VERTEX_INPUT_RATE_VERTEX,
+	}
+	vp.accessorAttrs[gltf.TEXCOORD_1] = vk.VertexInputAttributeDescription{
+		Location: 4,
+		Binding:  4,
+		Format:   vk.FORMAT_R32G32_SFLOAT,
+		Offset:   0,
+	}
+
+	vp.accessorBindings[gltf.COLOR_0] = vk.VertexInputBindingDescription{
+		Binding:   5,
+		Stride:    4 * 4, // COLOR_0 is converted to a VEC4 of FLOAT on load
+		InputRate: vk.VERTEX_INPUT_RATE_VERTEX,
+	}
+	vp.accessorAttrs[gltf.COLOR_0] = vk.VertexInputAttributeDescription{
+		Location: 2,
+		Binding:  5,
+		Format:   vk.FORMAT_R32G32B32A32_SFLOAT,
+		Offset:   0,
+	}
+
+	vp.accessorBindings[gltf.JOINTS_0] = vk.VertexInputBindingDescription{
+		Binding:   6,
+		Stride:    4 * 4, // JOINTS_0 is converted to a VEC4 of UNSIGNED_INT on load
+		InputRate: vk.VERTEX_INPUT_RATE_VERTEX,
+	}
+	vp.accessorAttrs[gltf.JOINTS_0] = vk.VertexInputAttributeDescription{
+		Location: 6,
+		Binding:  6,
+		Format:   vk.FORMAT_R32G32B32A32_UINT,
+		Offset:   0,
+	}
+
+	vp.accessorBindings[gltf.WEIGHTS_0] = vk.VertexInputBindingDescription{
+		Binding:   7,
+		Stride:    4 * 4, // WEIGHTS_0 is converted to a VEC4 of FLOAT on load
+		InputRate: vk.VERTEX_INPUT_RATE_VERTEX,
+	}
+	vp.accessorAttrs[gltf.WEIGHTS_0] = vk.VertexInputAttributeDescription{
+		Location: 7,
+		Binding:  7,
+		Format:   vk.FORMAT_R32G32B32A32_SFLOAT,
+		Offset:   0,
+	}
+}
+
+func (vp *VulkanPipeline) createDescriptorSetLayouts() error {
+	drawDataLayoutCI := vk.DescriptorSetLayoutCreateInfo{
+		PBindings: []vk.DescriptorSetLayoutBinding{
+			{
+				Binding:         0,
+				DescriptorType:  vk.DESCRIPTOR_TYPE_UNIFORM_BUFFER_DYNAMIC,
+				DescriptorCount: 1,
+				StageFlags:      vk.SHADER_STAGE_VERTEX_BIT | vk.SHADER_STAGE_FRAGMENT_BIT,
+			},
+		},
+	}
+
+	// One binding per textureSlot
+	materialLayoutCI := vk.DescriptorSetLayoutCreateInfo{}
+	for slot := textureSlot(0); slot < numTextureSlots; slot++ {
+		materialLayoutCI.PBindings = append(materialLayoutCI.PBindings, vk.DescriptorSetLayoutBinding{
+			Binding:         uint32(slot),
+			DescriptorType:  vk.DESCRIPTOR_TYPE_COMBINED_IMAGE_SAMPLER,
+			DescriptorCount: 1,
+			StageFlags:      vk.SHADER_STAGE_FRAGMENT_BIT,
+		})
+	}
+
+	sceneLayoutCI := vk.DescriptorSetLayoutCreateInfo{
+		PBindings: []vk.DescriptorSetLayoutBinding{
+			{
+				Binding:         0, // SceneData
+				DescriptorType:  vk.DESCRIPTOR_TYPE_UNIFORM_BUFFER,
+				DescriptorCount: 1,
+				StageFlags:      vk.SHADER_STAGE_FRAGMENT_BIT,
+			},
+			{
+				Binding:         1, // irradianceMap
+				DescriptorType:  vk.DESCRIPTOR_TYPE_COMBINED_IMAGE_SAMPLER,
+				DescriptorCount: 1,
+				StageFlags:      vk.SHADER_STAGE_FRAGMENT_BIT,
+			},
+			{
+				Binding:         2, // specularMap
+				DescriptorType:  vk.DESCRIPTOR_TYPE_COMBINED_IMAGE_SAMPLER,
+				DescriptorCount: 1,
+				StageFlags:      vk.SHADER_STAGE_FRAGMENT_BIT,
+			},
+			{
+				Binding:         3, // brdfLUT
+				DescriptorType:  vk.DESCRIPTOR_TYPE_COMBINED_IMAGE_SAMPLER,
+				DescriptorCount: 1,
+				StageFlags:      vk.SHADER_STAGE_FRAGMENT_BIT,
+			},
+		},
 	}
+
+	deformLayoutCI := vk.DescriptorSetLayoutCreateInfo{
+		PBindings: []vk.DescriptorSetLayoutBinding{
+			{
+				Binding:         0, // JointMatrices
+				DescriptorType:  vk.DESCRIPTOR_TYPE_STORAGE_BUFFER_DYNAMIC,
+				DescriptorCount: 1,
+				StageFlags:      vk.SHADER_STAGE_VERTEX_BIT,
+			},
+			{
+				Binding:         1, // MorphWeights
+				DescriptorType:  vk.DESCRIPTOR_TYPE_STORAGE_BUFFER_DYNAMIC,
+				DescriptorCount: 1,
+				StageFlags:      vk.SHADER_STAGE_VERTEX_BIT,
+			},
+			{
+				Binding:         2, // MorphDeltas
+				DescriptorType:  vk.DESCRIPTOR_TYPE_STORAGE_BUFFER,
+				DescriptorCount: 1,
+				StageFlags:      vk.SHADER_STAGE_VERTEX_BIT,
+			},
+		},
+	}
+
+	var err error
+	if vp.drawDataSetLayout, err = vk.CreateDescriptorSetLayout(vp.ctx.Device, &drawDataLayoutCI, nil); err != nil {
+		return fmt.Errorf("could not create draw data descriptor set layout: %w", err)
+	}
+	if vp.materialSetLayout, err = vk.CreateDescriptorSetLayout(vp.ctx.Device, &materialLayoutCI, nil); err != nil {
+		return fmt.Errorf("could not create material descriptor set layout: %w", err)
+	}
+	if vp.sceneSetLayout, err = vk.CreateDescriptorSetLayout(vp.ctx.Device, &sceneLayoutCI, nil); err != nil {
+		return fmt.Errorf("could not create scene descriptor set layout: %w", err)
+	}
+	if vp.deformSetLayout, err = vk.CreateDescriptorSetLayout(vp.ctx.Device, &deformLayoutCI, nil); err != nil {
+		return fmt.Errorf("could not create deform descriptor set layout: %w", err)
+	}
+	return nil
 }
 
-func (vp *VulkanPipeline) CreateGraphicsPipelines() {
+// CreateGraphicsPipelines loads the shaders and the pipeline cache, and creates the descriptor set and pipeline
+// layouts. The pipelines themselves are created by pipelineFor. Objects created before a failure are destroyed by
+// Teardown.
+func (vp *VulkanPipeline) CreateGraphicsPipelines() error {
 	vp.prebuildVertexInputDescriptions()
 
-	vp.vertShaderModule = vp.createShaderModule("shaders/vert.spv")
-	vp.fragShaderModule = vp.createShaderModule("shaders/frag.spv")
+	if err := vp.createPipelineCache(); err != nil {
+		return err
+	}
+
+	var err error
+	if vp.vertShaderModule, err = vp.createShaderModule("shaders/vert.spv"); err != nil {
+		return err
+	}
+	if vp.fragShaderModule, err = vp.createShaderModule("shaders/frag.spv"); err != nil {
+		return err
+	}
+
+	if err = vp.createDescriptorSetLayouts(); err != nil {
+		return err
+	}
+
+	pipelineLayoutCreateInfo := vk.PipelineLayoutCreateInfo{
+		PSetLayouts: []vk.DescriptorSetLayout{vp.drawDataSetLayout, vp.materialSetLayout, vp.sceneSetLayout, vp.deformSetLayout},
+		PPushConstantRanges: []vk.PushConstantRange{
+			{
+				StageFlags: vk.SHADER_STAGE_VERTEX_BIT,
+				Offset:     0,
+				Size:       2 * uint32(unsafe.Sizeof(vkm.Mat{})),
+			},
+		},
+	}
+
+	if vp.pipelineLayout, err = vk.CreatePipelineLayout(vp.ctx.Device, &pipelineLayoutCreateInfo, nil); err != nil {
+		return fmt.Errorf("could not create pipeline layout: %w", err)
+	}
 
+	// Pipelines depend on the vertex layout and topology of the primitives they draw, so they are created as the model
+	// is loaded.
+	vp.graphicsPipelines = make(map[pipelineKey]vk.Pipeline)
+	return nil
+}
+
+// pipelineKey is the state that differs between graphics pipelines.
+type pipelineKey struct {
+	layout   vertexLayout
+	topology vk.PrimitiveTopology
+}
+
+// pipelineFor returns the graphics pipeline for a vertex layout and topology, creating it if this is the first
+// primitive with them. A new pipeline is named after that primitive, given by name, for debugging tools.
+func (vp *VulkanPipeline) pipelineFor(key pipelineKey, name string) (vk.Pipeline, error) {
+	if gp, ok := vp.graphicsPipelines[key]; ok {
+		return gp, nil
+	}
+	gp, err := vp.createGraphicsPipeline(key)
+	if err != nil {
+		return gp, err
+	}
+	vp.ctx.SetObjectName(vk.OBJECT_TYPE_PIPELINE, uint64(gp), "pipeline for "+name)
+	vp.graphicsPipelines[key] = gp
+	return gp, nil
+}
+
+func (vp *VulkanPipeline) createGraphicsPipeline(key pipelineKey) (vk.Pipeline, error) {
 	vertShaderStageCreateInfo := vk.PipelineShaderStageCreateInfo{
 		Stage:               vk.SHADER_STAGE_VERTEX_BIT,
 		Module:              vp.vertShaderModule,
@@ -123,40 +378,23 @@ func (vp *VulkanPipeline) CreateGraphicsPipelines() {
 		vertShaderStageCreateInfo, fragShaderStageCreateInfo,
 	}
 
-	vertexBindings, vertexAttrs := []vk.VertexInputBindingDescription{}, []vk.VertexInputAttributeDescription{}
-
-	vertexBindings = append(vertexBindings, vp.accessorBindings[gltf.POSITION], vp.accessorBindings[gltf.NORMAL])
-	vertexAttrs = append(vertexAttrs, vp.accessorAttrs[gltf.POSITION], vp.accessorAttrs[gltf.NORMAL])
-
 	vertexInputCreateInfo := vk.PipelineVertexInputStateCreateInfo{
 		PNext:                        nil,
 		Flags:                        0,
-		PVertexBindingDescriptions:   vertexBindings,
-		PVertexAttributeDescriptions: vertexAttrs,
+		PVertexBindingDescriptions:   key.layout.bindings[:key.layout.bindingCount],
+		PVertexAttributeDescriptions: key.layout.attrs[:],
 	}
 
 	inputAssemblyCreateInfo := vk.PipelineInputAssemblyStateCreateInfo{
-		Topology:               vk.PRIMITIVE_TOPOLOGY_TRIANGLE_LIST, // TODO
+		Topology:               key.topology,
 		PrimitiveRestartEnable: false,
 	}
 
-	viewport := vk.Viewport{
-		X:        0.0,
-		Y:        0.0,
-		Width:    float32(vp.ctx.SwapchainExtent.Width),
-		Height:   float32(vp.ctx.SwapchainExtent.Height),
-		MinDepth: 0.0,
-		MaxDepth: 1.0,
-	}
-
-	scissor := vk.Rect2D{
-		Offset: vk.Offset2D{X: 0, Y: 0},
-		Extent: vp.ctx.SwapchainExtent,
-	}
-
+	// Viewport and scissor are dynamic state, set in recordRenderingCommands, so the pipeline does not need to be
+	// rebuilt when the window is resized. The counts still come from these slices; the values are ignored.
 	viewportStateCreateInfo := vk.PipelineViewportStateCreateInfo{
-		PViewports: []vk.Viewport{viewport},
-		PScissors:  []vk.Rect2D{scissor},
+		PViewports: []vk.Viewport{{}},
+		PScissors:  []vk.Rect2D{{}},
 	}
 
 	rasterizerCreateInfo := vk.PipelineRasterizationStateCreateInfo{
@@ -209,26 +447,9 @@ func (vp *VulkanPipeline) CreateGraphicsPipelines() {
 		MaxDepthBounds:        1.0,
 	}
 
-	// dynamicStateCreateInfo := vk.PipelineDynamicStateCreateInfo{
-	// 	PDynamicStates: []vk.DynamicState{vk.DYNAMIC_STATE_VIEWPORT, vk.DYNAMIC_STATE_SCISSOR},
-	// }
-
-	pipelineLayoutCreateInfo := vk.PipelineLayoutCreateInfo{
-		// PSetLayouts: []vk.DescriptorSetLayout{app.descriptorSetLayout}, // TODO
-		PPushConstantRanges: []vk.PushConstantRange{
-			{
-				StageFlags: vk.SHADER_STAGE_VERTEX_BIT,
-				Offset:     0,
-				Size:       2 * uint32(unsafe.Sizeof(vkm.Mat{})),
-			},
-		},
-	}
-
-	p, err := vk.CreatePipelineLayout(vp.ctx.Device, &pipelineLayoutCreateInfo, nil)
-	if err != nil {
-		panic(err)
+	dynamicStateCreateInfo := vk.PipelineDynamicStateCreateInfo{
+		PDynamicStates: []vk.DynamicState{vk.DYNAMIC_STATE_VIEWPORT, vk.DYNAMIC_STATE_SCISSOR},
 	}
-	vp.pipelineLayout = p
 
 	pipelineCreateInfo := vk.GraphicsPipelineCreateInfo{
 		PStages: shaderStages,
@@ -242,26 +463,31 @@ func (vp *VulkanPipeline) CreateGraphicsPipelines() {
 		PDepthStencilState:  &depthStencilStateCreateInfo,
 
 		PTessellationState: &vk.PipelineTessellationStateCreateInfo{},
-		PDynamicState:      &vk.PipelineDynamicStateCreateInfo{}, // dynamicStateCreateInfo,
+		PDynamicState:      &dynamicStateCreateInfo,
 
 		Layout:     vp.pipelineLayout,
 		RenderPass: vp.renderPass,
 		Subpass:    0,
 	}
 
-	if gp, err := vk.CreateGraphicsPipelines(
+	gp, err := vk.CreateGraphicsPipelines(
 		vp.ctx.Device,
-		0, // vk.NULL_HANDLE missing
+		vp.pipelineCache,
 		[]vk.GraphicsPipelineCreateInfo{pipelineCreateInfo},
 		nil,
-	); err != nil {
-		panic(err)
-	} else {
-		vp.graphicsPipeline = gp[0]
+	)
+	if err != nil {
+		return vk.Pipeline(vk.NULL_HANDLE), fmt.Errorf("could not create graphics pipeline: %w", err)
 	}
+	return gp[0], nil
 }
 
-func (vp *VulkanPipeline) CreateRenderPass() {
+func (vp *VulkanPipeline) CreateRenderPass() error {
+	// Offscreen images are copied back to the host after the pass instead of being presented.
+	colorFinalLayout := vk.IMAGE_LAYOUT_PRESENT_SRC_KHR
+	if vp.ctx.Headless {
+		colorFinalLayout = vk.IMAGE_LAYOUT_TRANSFER_SRC_OPTIMAL
+	}
 
 	colorAttachmentDescription := vk.AttachmentDescription{
 		Format:  vp.ctx.SwapchainImageFormat,
@@ -273,7 +499,7 @@ func (vp *VulkanPipeline) CreateRenderPass() {
 		StencilStoreOp: vk.ATTACHMENT_STORE_OP_DONT_CARE,
 
 		InitialLayout: vk.IMAGE_LAYOUT_UNDEFINED,
-		FinalLayout:   vk.IMAGE_LAYOUT_PRESENT_SRC_KHR,
+		FinalLayout:   colorFinalLayout,
 	}
 
 	colorAttachmentRef := vk.AttachmentReference{
@@ -336,19 +562,36 @@ func (vp *VulkanPipeline) CreateRenderPass() {
 		DstAccessMask: vk.ACCESS_COLOR_ATTACHMENT_WRITE_BIT | vk.ACCESS_DEPTH_STENCIL_ATTACHMENT_READ_BIT,
 	}
 
+	dependencies := []vk.SubpassDependency{dependencyToColor}
+	if vp.ctx.Headless {
+		// readbackImage copies the color attachment out right after the pass, so the attachment writes have to be
+		// finished and visible to the transfer first. Presentation is synchronized by the semaphores instead.
+		dependencies = append(dependencies, vk.SubpassDependency{
+			SrcSubpass:    0,
+			DstSubpass:    vk.SUBPASS_EXTERNAL,
+			SrcStageMask:  vk.PIPELINE_STAGE_COLOR_ATTACHMENT_OUTPUT_BIT,
+			SrcAccessMask: vk.ACCESS_COLOR_ATTACHMENT_WRITE_BIT,
+			DstStageMask:  vk.PIPELINE_STAGE_TRANSFER_BIT,
+			DstAccessMask: vk.ACCESS_TRANSFER_READ_BIT,
+		})
+	}
+
 	renderPassCreateInfo := vk.RenderPassCreateInfo{
 		PAttachments:  []vk.AttachmentDescription{colorAttachmentDescription, depthAttachmentDescription},
 		PSubpasses:    []vk.SubpassDescription{colorSubpassDescription},
-		PDependencies: []vk.SubpassDependency{dependencyToColor},
+		PDependencies: dependencies,
 	}
 
 	var err error
 	if vp.renderPass, err = vk.CreateRenderPass(vp.ctx.Device, &renderPassCreateInfo, nil); err != nil {
-		panic(err)
+		return fmt.Errorf("could not create render pass: %w", err)
 	}
+	return nil
 }
 
-func (vp *VulkanPipeline) CreateFramebuffers() {
+// CreateFramebuffers creates a framebuffer for each swapchain image view. If one fails, those already created are left
+// for destroyFramebuffers.
+func (vp *VulkanPipeline) CreateFramebuffers() error {
 
 	vp.ctx.SwapChainFramebuffers = make([]vk.Framebuffer, len(vp.ctx.SwapchainImageViews))
 
@@ -363,10 +606,11 @@ func (vp *VulkanPipeline) CreateFramebuffers() {
 
 		fb, err := vk.CreateFramebuffer(vp.ctx.Device, &framebufferCreateInfo, nil)
 		if err != nil {
-			panic(err)
+			return fmt.Errorf("could not create framebuffer: %w", err)
 		}
 		vp.ctx.SwapChainFramebuffers[i] = fb
 	}
+	return nil
 }
 
 func (vp *VulkanPipeline) destroyFramebuffers() {
@@ -387,19 +631,38 @@ func (vp *VulkanPipeline) Teardown() {
 	vk.DestroyImage(vp.ctx.Device, vp.colorImage, nil)
 	// vk.DestroyImage(vp.ctx.Device, vp.stencilImage, nil)
 
-	vk.FreeMemory(vp.ctx.Device, vp.colorMemory, nil)
-	vk.FreeMemory(vp.ctx.Device, vp.stencilMemory, nil)
+	vp.ctx.Free(vp.colorMemory)
+	vp.ctx.Free(vp.stencilMemory)
+
+	// Teardown also cleans up after a failed Initialize, which may be followed by another Teardown.
+	vp.vertShaderModule, vp.fragShaderModule = vk.ShaderModule(vk.NULL_HANDLE), vk.ShaderModule(vk.NULL_HANDLE)
+	vp.colorImage, vp.colorImageView, vp.colorMemory, vp.stencilMemory = vk.Image(vk.NULL_HANDLE), vk.ImageView(vk.NULL_HANDLE), nil, nil
 
 	vp.destroyFramebuffers()
 
-	// for _, gp := range vp.graphicsPipelines {
-	vk.DestroyPipeline(vp.ctx.Device, vp.graphicsPipeline, nil)
-	// }
-	vp.graphicsPipeline = vk.Pipeline(vk.NULL_HANDLE)
+	for _, gp := range vp.graphicsPipelines {
+		vk.DestroyPipeline(vp.ctx.Device, gp, nil)
+	}
+	vp.graphicsPipelines = nil
+
+	if vp.pipelineCache != vk.PipelineCache(vk.NULL_HANDLE) {
+		vp.savePipelineCache()
+		vk.DestroyPipelineCache(vp.ctx.Device, vp.pipelineCache, nil)
+		vp.pipelineCache = vk.PipelineCache(vk.NULL_HANDLE)
+	}
 
 	vk.DestroyPipelineLayout(vp.ctx.Device, vp.pipelineLayout, nil)
 	vp.pipelineLayout = vk.PipelineLayout(vk.NULL_HANDLE)
 
+	vk.DestroyDescriptorSetLayout(vp.ctx.Device, vp.drawDataSetLayout, nil)
+	vp.drawDataSetLayout = vk.DescriptorSetLayout(vk.NULL_HANDLE)
+	vk.DestroyDescriptorSetLayout(vp.ctx.Device, vp.materialSetLayout, nil)
+	vp.materialSetLayout = vk.DescriptorSetLayout(vk.NULL_HANDLE)
+	vk.DestroyDescriptorSetLayout(vp.ctx.Device, vp.sceneSetLayout, nil)
+	vp.sceneSetLayout = vk.DescriptorSetLayout(vk.NULL_HANDLE)
+	vk.DestroyDescriptorSetLayout(vp.ctx.Device, vp.deformSetLayout, nil)
+	vp.deformSetLayout = vk.DescriptorSetLayout(vk.NULL_HANDLE)
+
 	// vk.DestroyShaderModule(app.ctx.Device, app.fragShaderModule, nil)
 	// app.fragShaderModule = vk.ShaderModule(vk.NULL_HANDLE)
 	// vk.DestroyShaderModule(app.device, app.vertShaderModule, nil)
@@ -409,22 +672,25 @@ func (vp *VulkanPipeline) Teardown() {
 	vp.renderPass = vk.RenderPass(vk.NULL_HANDLE)
 }
 
-func (vp *VulkanPipeline) createShaderModule(filename string) vk.ShaderModule {
+func (vp *VulkanPipeline) createShaderModule(filename string) (vk.ShaderModule, error) {
 	smCI := vk.ShaderModuleCreateInfo{
 		CodeSize: 0,
 		PCode:    new(uint32),
 	}
 
-	if dat, err := os.ReadFile(filename); err != nil {
-		panic("Failed to read shader file " + filename + ": " + err.Error())
-	} else {
-		smCI.CodeSize = uintptr(len(dat))
-		smCI.PCode = (*uint32)(unsafe.Pointer(&dat[0]))
+	dat, err := os.ReadFile(filename)
+	if err != nil {
+		return vk.ShaderModule(vk.NULL_HANDLE), fmt.Errorf("could not read shader file: %w", err)
 	}
+	if len(dat) == 0 {
+		return vk.ShaderModule(vk.NULL_HANDLE), errors.New("shader file " + filename + " is empty")
+	}
+	smCI.CodeSize = uintptr(len(dat))
+	smCI.PCode = (*uint32)(unsafe.Pointer(&dat[0]))
 
-	if mod, err := vk.CreateShaderModule(vp.ctx.Device, &smCI, nil); err != nil {
-		panic(err)
-	} else {
-		return mod
+	mod, err := vk.CreateShaderModule(vp.ctx.Device, &smCI, nil)
+	if err != nil {
+		return mod, fmt.Errorf("could not create shader module from %s: %w", filename, err)
 	}
+	return mod, nil
 }
@@ -0,0 +1,270 @@
+// Package raster is a small software rasterizer that mirrors the fixed-function parts of the Vulkan pipeline used by
+// the viewer: the same clip space conventions, a LESS depth test against a depth buffer cleared to 1.0, no face
+// culling, and perspective-correct interpolation of vertex outputs. Vertex and fragment shading are supplied by the
+// caller through the Shader interface. It has no dependency on a Vulkan driver, so scene rendering can be run and
+// compared deterministically on any machine.
+package raster
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// Shader supplies the programmable stages of a draw.
+type Shader interface {
+	// Varyings returns the number of float32 outputs that Vertex writes and Fragment receives.
+	Varyings() int
+	// Vertex returns the clip-space position of vertex i, and writes its outputs to out.
+	Vertex(i int, out []float32) [4]float32
+	// Fragment returns the color of a fragment from its interpolated outputs. Components are clamped to [0, 1] and
+	// written to the color buffer as-is.
+	Fragment(in []float32) [4]float32
+}
+
+// Rasterizer renders triangles into an RGBA color buffer with a float32 depth buffer.
+type Rasterizer struct {
+	Width, Height int
+
+	color *image.RGBA
+	depth []float32
+}
+
+// New returns a rasterizer with a cleared color and depth buffer of the given size.
+func New(width, height int) *Rasterizer {
+	r := &Rasterizer{
+		Width:  width,
+		Height: height,
+		color:  image.NewRGBA(image.Rect(0, 0, width, height)),
+		depth:  make([]float32, width*height),
+	}
+	r.Clear(color.RGBA{A: 0xFF})
+	return r
+}
+
+// Clear fills the color buffer with c and resets depth to the far plane.
+func (r *Rasterizer) Clear(c color.RGBA) {
+	for i := 0; i < len(r.color.Pix); i += 4 {
+		r.color.Pix[i+0] = c.R
+		r.color.Pix[i+1] = c.G
+		r.color.Pix[i+2] = c.B
+		r.color.Pix[i+3] = c.A
+	}
+	for i := range r.depth {
+		r.depth[i] = 1
+	}
+}
+
+// Image returns the color buffer. It is not copied, so later draws will modify it.
+func (r *Rasterizer) Image() *image.RGBA {
+	return r.color
+}
+
+// clipVertex is a vertex after the vertex shader: a clip-space position plus its outputs.
+type clipVertex struct {
+	pos      [4]float32
+	varyings []float32
+}
+
+// DrawTriangles draws a triangle list of vertexCount vertices. If indices is not nil, it selects the vertices of each
+// triangle instead, and out-of-range indices cause the triangle to be skipped.
+func (r *Rasterizer) DrawTriangles(vertexCount int, indices []uint32, s Shader) {
+	n := s.Varyings()
+
+	shaded := make([]clipVertex, vertexCount)
+	for i := range shaded {
+		shaded[i].varyings = make([]float32, n)
+		shaded[i].pos = s.Vertex(i, shaded[i].varyings)
+	}
+
+	count := vertexCount
+	if indices != nil {
+		count = len(indices)
+	}
+
+	for t := 0; t+2 < count; t += 3 {
+		var tri [3]clipVertex
+		valid := true
+		for k := 0; k < 3; k++ {
+			idx := uint32(t + k)
+			if indices != nil {
+				idx = indices[t+k]
+			}
+			if int(idx) >= len(shaded) {
+				valid = false
+				break
+			}
+			tri[k] = shaded[idx]
+		}
+		if valid {
+			r.drawClipTriangle(tri, s)
+		}
+	}
+}
+
+// drawClipTriangle clips against the near plane (z >= 0 in Vulkan clip space) and rasterizes the resulting polygon as
+// a fan. The remaining planes are handled by the viewport bounds and depth range checks during rasterization.
+func (r *Rasterizer) drawClipTriangle(tri [3]clipVertex, s Shader) {
+	poly := clipNear(tri[:])
+	for i := 1; i+1 < len(poly); i++ {
+		r.rasterize(poly[0], poly[i], poly[i+1], s)
+	}
+}
+
+func clipNear(in []clipVertex) []clipVertex {
+	out := make([]clipVertex, 0, len(in)+1)
+	for i := range in {
+		a, b := in[i], in[(i+1)%len(in)]
+		aIn, bIn := a.pos[2] >= 0, b.pos[2] >= 0
+
+		if aIn {
+			out = append(out, a)
+		}
+		if aIn != bIn {
+			t := a.pos[2] / (a.pos[2] - b.pos[2])
+			out = append(out, lerpVertex(a, b, t))
+		}
+	}
+	return out
+}
+
+func lerpVertex(a, b clipVertex, t float32) clipVertex {
+	v := clipVertex{varyings: make([]float32, len(a.varyings))}
+	for i := 0; i < 4; i++ {
+		v.pos[i] = a.pos[i] + (b.pos[i]-a.pos[i])*t
+	}
+	for i := range v.varyings {
+		v.varyings[i] = a.varyings[i] + (b.varyings[i]-a.varyings[i])*t
+	}
+	return v
+}
+
+// screenVertex is a vertex after the perspective divide and viewport transform.
+type screenVertex struct {
+	x, y, z   float32
+	invW      float32
+	varyingsW []float32 // varyings divided by w, for perspective-correct interpolation
+}
+
+func (r *Rasterizer) toScreen(v clipVertex) screenVertex {
+	invW := 1 / v.pos[3]
+	s := screenVertex{
+		x:         (v.pos[0]*invW + 1) * 0.5 * float32(r.Width),
+		y:         (v.pos[1]*invW + 1) * 0.5 * float32(r.Height),
+		z:         v.pos[2] * invW,
+		invW:      invW,
+		varyingsW: make([]float32, len(v.varyings)),
+	}
+	for i := range v.varyings {
+		s.varyingsW[i] = v.varyings[i] * invW
+	}
+	return s
+}
+
+func (r *Rasterizer) rasterize(c0, c1, c2 clipVertex, s Shader) {
+	if c0.pos[3] <= 0 || c1.pos[3] <= 0 || c2.pos[3] <= 0 {
+		return
+	}
+	v0, v1, v2 := r.toScreen(c0), r.toScreen(c1), r.toScreen(c2)
+
+	area := edge(v0.x, v0.y, v1.x, v1.y, v2.x, v2.y)
+	if area == 0 {
+		return
+	}
+
+	minX := clampInt(int(floor3(v0.x, v1.x, v2.x)), 0, r.Width-1)
+	maxX := clampInt(int(ceil3(v0.x, v1.x, v2.x)), 0, r.Width-1)
+	minY := clampInt(int(floor3(v0.y, v1.y, v2.y)), 0, r.Height-1)
+	maxY := clampInt(int(ceil3(v0.y, v1.y, v2.y)), 0, r.Height-1)
+
+	varyings := make([]float32, len(c0.varyings))
+
+	for y := minY; y <= maxY; y++ {
+		py := float32(y) + 0.5
+		for x := minX; x <= maxX; x++ {
+			px := float32(x) + 0.5
+
+			w0 := edge(v1.x, v1.y, v2.x, v2.y, px, py) / area
+			w1 := edge(v2.x, v2.y, v0.x, v0.y, px, py) / area
+			w2 := edge(v0.x, v0.y, v1.x, v1.y, px, py) / area
+			if w0 < 0 || w1 < 0 || w2 < 0 {
+				continue
+			}
+
+			z := w0*v0.z + w1*v1.z + w2*v2.z
+			if z < 0 || z > 1 {
+				continue
+			}
+			di := y*r.Width + x
+			if !(z < r.depth[di]) {
+				continue
+			}
+			r.depth[di] = z
+
+			invW := w0*v0.invW + w1*v1.invW + w2*v2.invW
+			for i := range varyings {
+				varyings[i] = (w0*v0.varyingsW[i] + w1*v1.varyingsW[i] + w2*v2.varyingsW[i]) / invW
+			}
+
+			c := s.Fragment(varyings)
+			pi := r.color.PixOffset(x, y)
+			for i := 0; i < 4; i++ {
+				r.color.Pix[pi+i] = toUnorm8(c[i])
+			}
+		}
+	}
+}
+
+func edge(ax, ay, bx, by, px, py float32) float32 {
+	return (bx-ax)*(py-ay) - (by-ay)*(px-ax)
+}
+
+func toUnorm8(c float32) uint8 {
+	if c <= 0 {
+		return 0
+	}
+	if c >= 1 {
+		return 0xFF
+	}
+	return uint8(c*255 + 0.5)
+}
+
+func floor3(a, b, c float32) float32 {
+	return float32(math.Floor(float64(min3(a, b, c))))
+}
+
+func ceil3(a, b, c float32) float32 {
+	return float32(math.Ceil(float64(max3(a, b, c))))
+}
+
+func min3(a, b, c float32) float32 {
+	m := a
+	if b < m {
+		m = b
+	}
+	if c < m {
+		m = c
+	}
+	return m
+}
+
+func max3(a, b, c float32) float32 {
+	m := a
+	if b > m {
+		m = b
+	}
+	if c > m {
+		m = c
+	}
+	return m
+}
+
+func clampInt(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
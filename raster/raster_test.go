@@ -0,0 +1,226 @@
+package raster
+
+import (
+	"image/color"
+	"testing"
+)
+
+// testShader draws vertices given directly in clip space. Each vertex has a color, which is interpolated and written
+// out as-is.
+type testShader struct {
+	positions [][4]float32
+	colors    [][4]float32
+
+	// fragments counts the calls to Fragment, which happen only for fragments that pass the depth test.
+	fragments int
+}
+
+func (s *testShader) Varyings() int { return 4 }
+
+func (s *testShader) Vertex(i int, out []float32) [4]float32 {
+	copy(out, s.colors[i][:])
+	return s.positions[i]
+}
+
+func (s *testShader) Fragment(in []float32) [4]float32 {
+	s.fragments++
+	return [4]float32{in[0], in[1], in[2], in[3]}
+}
+
+// flatTriangles returns a shader for triangles of a single color. Vertices are given in pixels in a viewport of the
+// given size, at depth z.
+func flatTriangles(width, height int, z float32, c [4]float32, pixels ...[2]float32) *testShader {
+	s := &testShader{}
+	for _, p := range pixels {
+		s.positions = append(s.positions, [4]float32{2*p[0]/float32(width) - 1, 2*p[1]/float32(height) - 1, z, 1})
+		s.colors = append(s.colors, c)
+	}
+	return s
+}
+
+var (
+	red   = [4]float32{1, 0, 0, 1}
+	green = [4]float32{0, 1, 0, 1}
+)
+
+// coverage returns the image as rows of '#' for pixels that are not the clear color, and '.' for the rest.
+func coverage(r *Rasterizer) []string {
+	img := r.Image()
+	var rows []string
+	for y := 0; y < r.Height; y++ {
+		row := make([]byte, r.Width)
+		for x := range row {
+			row[x] = '.'
+			if img.RGBAAt(x, y) != (color.RGBA{A: 0xFF}) {
+				row[x] = '#'
+			}
+		}
+		rows = append(rows, string(row))
+	}
+	return rows
+}
+
+func equalRows(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// fullScreen is a triangle in pixels that covers the whole of a 4 x 4 viewport.
+var fullScreen = [][2]float32{{0, 0}, {8, 0}, {0, 8}}
+
+func TestDepthOrdering(t *testing.T) {
+	near := flatTriangles(4, 4, 0.25, green, fullScreen...)
+	far := flatTriangles(4, 4, 0.5, red, fullScreen...)
+
+	tests := []struct {
+		name  string
+		draws []*testShader
+	}{
+		{"near first", []*testShader{near, far}},
+		{"far first", []*testShader{far, near}},
+		// LESS, not LESS_OR_EQUAL: a second draw at the same depth does not replace the first.
+		{"equal depth", []*testShader{near, flatTriangles(4, 4, 0.25, red, fullScreen...)}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := New(4, 4)
+			for _, s := range test.draws {
+				r.DrawTriangles(len(s.positions), nil, s)
+			}
+			for y := 0; y < 4; y++ {
+				for x := 0; x < 4; x++ {
+					if c := r.Image().RGBAAt(x, y); c != (color.RGBA{G: 0xFF, A: 0xFF}) {
+						t.Fatalf("pixel (%d, %d) is %v, want the nearer green triangle", x, y, c)
+					}
+				}
+			}
+		})
+	}
+
+	// Depths outside [0, 1] are not drawn. Behind the near plane, the triangle is clipped away entirely.
+	for _, z := range []float32{-0.5, 1.5} {
+		r := New(4, 4)
+		s := flatTriangles(4, 4, z, red, fullScreen...)
+		r.DrawTriangles(len(s.positions), nil, s)
+		if s.fragments != 0 {
+			t.Errorf("triangle at depth %v drew %d fragments, want none", z, s.fragments)
+		}
+	}
+}
+
+func TestNearPlaneClipping(t *testing.T) {
+	// Depth is x in NDC, so the left half of the viewport is behind the near plane, and the triangle is clipped at its
+	// vertical center line. The rest of the triangle covers the right half.
+	r := New(4, 4)
+	s := &testShader{
+		positions: [][4]float32{{-1, -1, -1, 1}, {3, -1, 3, 1}, {-1, 3, -1, 1}},
+		colors:    [][4]float32{red, red, red},
+	}
+	r.DrawTriangles(3, nil, s)
+
+	want := []string{"..##", "..##", "..##", "..##"}
+	if got := coverage(r); !equalRows(got, want) {
+		t.Errorf("coverage is\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestPerspectiveCorrectInterpolation(t *testing.T) {
+	// A triangle along the top of a 4 x 4 viewport. The top right corner has w = 2, and carries a value of 1 that is
+	// 0 at the other corners. At the center of pixel (x, 0), the screen-space barycentric of that corner is
+	// b = (x + 0.5) / 4, and the perspective-correct value is (b / 2) / (1 - b / 2), where plain linear interpolation
+	// would give b.
+	r := New(4, 4)
+	s := &testShader{
+		positions: [][4]float32{{-1, -1, 0, 1}, {2, -2, 0, 2}, {-1, 3, 0, 1}},
+		colors:    [][4]float32{{0, 0, 0, 1}, {1, 0, 0, 1}, {0, 0, 0, 1}},
+	}
+	r.DrawTriangles(3, nil, s)
+
+	for x, want := range []float32{0.0667, 0.2308, 0.4545, 0.7778} {
+		got := r.Image().RGBAAt(x, 0).R
+		if d := int(got) - int(toUnorm8(want)); d < -1 || d > 1 {
+			t.Errorf("pixel (%d, 0) has red %d, want %d (%v)", x, got, toUnorm8(want), want)
+		}
+	}
+}
+
+func TestFillRules(t *testing.T) {
+	tests := []struct {
+		name string
+		// Triangle vertices in pixels, in a 4 x 4 viewport.
+		pixels [][2]float32
+		want   []string
+	}{
+		{
+			// Pixels are sampled at their centers. Centers on an edge are inside: (1.5, 0.5) lies on the diagonal.
+			name:   "pixel centers",
+			pixels: [][2]float32{{0, 0}, {2, 0}, {0, 2}},
+			want:   []string{"##..", "#...", "....", "...."},
+		},
+		{
+			// No face culling, so the other winding covers the same pixels.
+			name:   "clockwise",
+			pixels: [][2]float32{{0, 0}, {0, 2}, {2, 0}},
+			want:   []string{"##..", "#...", "....", "...."},
+		},
+		{
+			// A triangle that covers no pixel center draws nothing, however close it comes.
+			name:   "between centers",
+			pixels: [][2]float32{{0.6, 0.6}, {1.4, 0.6}, {0.6, 1.4}},
+			want:   []string{"....", "....", "....", "...."},
+		},
+		{
+			name:   "degenerate",
+			pixels: [][2]float32{{0, 0}, {2, 2}, {4, 4}},
+			want:   []string{"....", "....", "....", "...."},
+		},
+		{
+			// Triangles are cut off at the viewport edges.
+			name:   "offscreen",
+			pixels: [][2]float32{{-10, 2}, {20, 2}, {2, 100}},
+			want:   []string{"....", "....", "####", "####"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := New(4, 4)
+			s := flatTriangles(4, 4, 0.5, red, test.pixels...)
+			r.DrawTriangles(3, nil, s)
+			if got := coverage(r); !equalRows(got, test.want) {
+				t.Errorf("coverage is\n%q\nwant\n%q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSharedEdge(t *testing.T) {
+	// A quad split along its diagonal. The pixel centers on the diagonal are inside both triangles, but only the first
+	// one shades them: the second fails the depth test.
+	r := New(4, 4)
+	s := flatTriangles(4, 4, 0.5, red, [2]float32{0, 0}, [2]float32{4, 0}, [2]float32{4, 4}, [2]float32{0, 4})
+	r.DrawTriangles(4, []uint32{0, 1, 2, 0, 2, 3}, s)
+
+	want := []string{"####", "####", "####", "####"}
+	if got := coverage(r); !equalRows(got, want) {
+		t.Errorf("coverage is\n%q\nwant\n%q", got, want)
+	}
+	if s.fragments != 16 {
+		t.Errorf("shaded %d fragments, want one for each of the 16 pixels", s.fragments)
+	}
+
+	// Triangles with an index past the last vertex are skipped, and the rest are still drawn.
+	r = New(4, 4)
+	s.fragments = 0
+	r.DrawTriangles(4, []uint32{0, 1, 4, 0, 2, 3}, s)
+	want = []string{"#...", "##..", "###.", "####"}
+	if got := coverage(r); !equalRows(got, want) {
+		t.Errorf("with an out of range index, coverage is\n%q\nwant\n%q", got, want)
+	}
+}
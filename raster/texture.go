@@ -0,0 +1,116 @@
+package raster
+
+import (
+	"image"
+	"math"
+)
+
+// WrapMode is how texture coordinates outside [0, 1] are mapped back into the texture.
+type WrapMode int
+
+const (
+	WrapRepeat WrapMode = iota
+	WrapClampToEdge
+	WrapMirroredRepeat
+)
+
+// Texture is an RGBA texture sampled the way the GPU samples a single mip level: bilinear or nearest filtering, and
+// per-axis wrapping. Texels are stored as linear floats, so sRGB textures must be decoded when they are built.
+type Texture struct {
+	Width, Height int
+	Texels        [][4]float32
+
+	WrapS, WrapT WrapMode
+	Nearest      bool
+}
+
+// NewTexture converts img to a texture. If srgb is set, the color channels are decoded from sRGB to linear, as the GPU
+// does when sampling an _SRGB format image.
+func NewTexture(img *image.NRGBA, srgb bool) *Texture {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	t := &Texture{
+		Width:  w,
+		Height: h,
+		Texels: make([][4]float32, w*h),
+	}
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			pi := img.PixOffset(img.Rect.Min.X+x, img.Rect.Min.Y+y)
+			texel := &t.Texels[y*w+x]
+			for c := 0; c < 4; c++ {
+				v := float32(img.Pix[pi+c]) / 255
+				if srgb && c < 3 {
+					v = srgbToLinear(v)
+				}
+				texel[c] = v
+			}
+		}
+	}
+	return t
+}
+
+func srgbToLinear(c float32) float32 {
+	if c <= 0.04045 {
+		return c / 12.92
+	}
+	return float32(math.Pow((float64(c)+0.055)/1.055, 2.4))
+}
+
+// Sample returns the filtered texel at texture coordinate (u, v), with (0, 0) at the top left of the image.
+func (t *Texture) Sample(u, v float32) [4]float32 {
+	if t.Width == 0 || t.Height == 0 {
+		return [4]float32{1, 1, 1, 1}
+	}
+
+	// Texel centers are at half-integer coordinates.
+	x := u*float32(t.Width) - 0.5
+	y := v*float32(t.Height) - 0.5
+
+	if t.Nearest {
+		return t.texel(int(math.Floor(float64(x+0.5))), int(math.Floor(float64(y+0.5))))
+	}
+
+	x0, y0 := math.Floor(float64(x)), math.Floor(float64(y))
+	fx, fy := x-float32(x0), y-float32(y0)
+	ix, iy := int(x0), int(y0)
+
+	t00, t10 := t.texel(ix, iy), t.texel(ix+1, iy)
+	t01, t11 := t.texel(ix, iy+1), t.texel(ix+1, iy+1)
+
+	var rval [4]float32
+	for c := range rval {
+		top := t00[c] + (t10[c]-t00[c])*fx
+		bottom := t01[c] + (t11[c]-t01[c])*fx
+		rval[c] = top + (bottom-top)*fy
+	}
+	return rval
+}
+
+func (t *Texture) texel(x, y int) [4]float32 {
+	x = wrap(x, t.Width, t.WrapS)
+	y = wrap(y, t.Height, t.WrapT)
+	return t.Texels[y*t.Width+x]
+}
+
+func wrap(i, n int, mode WrapMode) int {
+	switch mode {
+	case WrapClampToEdge:
+		return clampInt(i, 0, n-1)
+	case WrapMirroredRepeat:
+		period := 2 * n
+		i %= period
+		if i < 0 {
+			i += period
+		}
+		if i >= n {
+			i = period - 1 - i
+		}
+		return i
+	}
+	i %= n
+	if i < 0 {
+		i += n
+	}
+	return i
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"github.com/bbredesen/gltf"
+	"github.com/bbredesen/vkm"
+)
+
+// Renderer is the backend-specific half of drawing a scene. RenderScene walks the scene tree and composes transforms;
+// implementations only need to record or execute draws. See vulkanRenderer and softwareRenderer.
+type Renderer interface {
+	// SetCamera sets the combined projection and view matrix used by subsequent draws, and the world-space eye
+	// position used for specular lighting.
+	SetCamera(projView vkm.Mat, eye [3]float32)
+	// DrawPrimitive draws one mesh primitive of node n, using n's CurrentTransform as the model-to-world transform and
+	// n's skin, if it has one.
+	DrawPrimitive(p *gltf.ResolvedPrimitive, n *SceneNode)
+}
+
+// nodeLabeler is implemented by renderers that can group the draws of each node, for debugging tools. RenderScene
+// calls BeginNode before drawing a node and its descendants, and EndNode after.
+type nodeLabeler interface {
+	BeginNode(n *SceneNode)
+	EndNode()
+}
+
+// RenderScene draws n and all of its descendants with r, updating each node's CurrentTransform along the way. The
+// camera must be set before calling this; camera nodes in the scene do not change it.
+func RenderScene(r Renderer, n *SceneNode) {
+	if l, ok := r.(nodeLabeler); ok && n.ModelNode != nil {
+		l.BeginNode(n)
+		defer l.EndNode()
+	}
+
+	if n.ModelNode != nil && n.ModelNode.Mesh != nil {
+		for _, p := range n.ModelNode.Mesh.Primitives {
+			r.DrawPrimitive(p, n)
+		}
+	}
+
+	for _, child := range n.Children {
+		child.ApplyTransform(n.CurrentTransform)
+		RenderScene(r, child)
+	}
+}
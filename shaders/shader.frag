@@ -1,16 +1,139 @@
 #version 450
 
+// The glTF metallic-roughness BRDF under image-based lighting. pbr.go is a CPU copy of this shader for the software
+// renderer; keep the two in step.
+
 layout(location=0) in vec4 fragColor;
-// layout(location=1) in vec2 fragTexCoord;
+layout(location=1) in vec2 fragTexCoord0;
+layout(location=2) in vec2 fragTexCoord1;
+layout(location=3) in vec3 fragPosition;
+layout(location=4) in vec3 fragNormal;
+layout(location=5) in vec4 fragTangent;
+
+// Must match drawData in drawdata.go.
+const uint DRAW_FLAG_HAS_NORMAL = 2;
+const uint DRAW_FLAG_HAS_TANGENT = 4;
+const uint DRAW_FLAG_HAS_NORMAL_TEXTURE = 8;
+
+layout(set=0, binding=0) uniform DrawData {
+    vec4 baseColorFactor;
+    vec4 emissiveFactor;
+    float metallicFactor;
+    float roughnessFactor;
+    float normalScale;
+    float occlusionStrength;
+    uint flags;
+    uint texCoordSets;
+} draw;
+
+// Material textures, one binding per textureSlot in material.go. Empty slots bind a 1x1 white texture.
+const uint BASE_COLOR_SLOT = 0;
+const uint METALLIC_ROUGHNESS_SLOT = 1;
+const uint NORMAL_SLOT = 2;
+const uint OCCLUSION_SLOT = 3;
+const uint EMISSIVE_SLOT = 4;
+
+layout(set=1, binding=0) uniform sampler2D baseColorTexture;
+layout(set=1, binding=1) uniform sampler2D metallicRoughnessTexture;
+layout(set=1, binding=2) uniform sampler2D normalTexture;
+layout(set=1, binding=3) uniform sampler2D occlusionTexture;
+layout(set=1, binding=4) uniform sampler2D emissiveTexture;
+
+// Per-frame data and the image-based lighting maps, see environment.go. Must match sceneData.
+layout(set=2, binding=0) uniform SceneData {
+    vec4 eyePosition;
+    float specularMipLevels;
+} scene;
 
-// layout(binding=1) uniform sampler2D texSampler;
+layout(set=2, binding=1) uniform samplerCube irradianceMap;
+layout(set=2, binding=2) uniform samplerCube specularMap;
+layout(set=2, binding=3) uniform sampler2D brdfLUT;
 
 layout(location=0) out vec4 outColor;
 
+vec2 texCoord(uint slot) {
+    return (draw.texCoordSets & (1u << slot)) != 0 ? fragTexCoord1 : fragTexCoord0;
+}
+
+// Khronos PBR Neutral tone mapping
+vec3 toneMapPBRNeutral(vec3 color) {
+    const float startCompression = 0.8 - 0.04;
+    const float desaturation = 0.15;
+
+    float x = min(color.r, min(color.g, color.b));
+    float offset = x < 0.08 ? x - 6.25 * x * x : 0.04;
+    color -= offset;
+
+    float peak = max(color.r, max(color.g, color.b));
+    if (peak < startCompression) return color;
+
+    const float d = 1.0 - startCompression;
+    float newPeak = 1.0 - d * d / (peak + d - startCompression);
+    color *= newPeak / peak;
+
+    float g = 1.0 - 1.0 / (desaturation * (peak - newPeak) + 1.0);
+    return mix(color, vec3(newPeak), g);
+}
 
 void main() {
-    // outColor = vec4(gl_FragCoord.z/gl_FragCoord.w, gl_FragCoord.z/gl_FragCoord.w, gl_FragCoord.z/gl_FragCoord.w, 1.0);
-    // outColor = vec4(fragTexCoord, 0.0, 1.0);
-    // outColor = texture(texSampler, fragTexCoord);
-    outColor = fragColor;
-}
\ No newline at end of file
+    vec4 baseColor = draw.baseColorFactor * fragColor * texture(baseColorTexture, texCoord(BASE_COLOR_SLOT));
+
+    vec4 mr = texture(metallicRoughnessTexture, texCoord(METALLIC_ROUGHNESS_SLOT));
+    float metallic = clamp(draw.metallicFactor * mr.b, 0.0, 1.0);
+    float roughness = clamp(draw.roughnessFactor * mr.g, 0.0, 1.0);
+
+    vec3 v = normalize(scene.eyePosition.xyz - fragPosition);
+
+    // Without normals the face normal is used, facing the viewer since there is no culling.
+    vec3 n;
+    if ((draw.flags & DRAW_FLAG_HAS_NORMAL) != 0) {
+        n = normalize(fragNormal);
+    } else {
+        n = normalize(cross(dFdx(fragPosition), dFdy(fragPosition)));
+        if (dot(n, v) < 0.0) n = -n;
+    }
+
+    if ((draw.flags & DRAW_FLAG_HAS_NORMAL_TEXTURE) != 0) {
+        vec2 uv = texCoord(NORMAL_SLOT);
+        vec4 tangent = fragTangent;
+        if ((draw.flags & DRAW_FLAG_HAS_TANGENT) == 0) {
+            // Derive the tangent from how the texture coordinates change across the triangle.
+            vec3 dp1 = dFdx(fragPosition), dp2 = dFdy(fragPosition);
+            vec2 duv1 = dFdx(uv), duv2 = dFdy(uv);
+            float det = duv1.x * duv2.y - duv2.x * duv1.y;
+            tangent = det != 0.0 ? vec4((dp1 * duv2.y - dp2 * duv1.y) / det, 1.0) : vec4(0.0);
+        }
+
+        if (dot(tangent.xyz, tangent.xyz) > 0.0) {
+            vec3 t = normalize(tangent.xyz - n * dot(n, tangent.xyz));
+            vec3 b = cross(n, t) * tangent.w;
+
+            vec3 tn = texture(normalTexture, uv).rgb * 2.0 - 1.0;
+            tn.xy *= draw.normalScale;
+            n = normalize(t * tn.x + b * tn.y + n * tn.z);
+        }
+    }
+
+    vec3 cDiff = mix(baseColor.rgb, vec3(0.0), metallic);
+    vec3 f0 = mix(vec3(0.04), baseColor.rgb, metallic);
+
+    float nDotV = clamp(dot(n, v), 0.0, 1.0);
+    float lod = roughness * (scene.specularMipLevels - 1.0);
+    vec3 specularLight = textureLod(specularMap, reflect(-v, n), lod).rgb;
+    vec3 irradiance = texture(irradianceMap, n).rgb;
+    vec2 brdf = texture(brdfLUT, vec2(nDotV, roughness)).rg;
+
+    vec3 fr = max(vec3(1.0 - roughness), f0) - f0;
+    vec3 kS = f0 + fr * pow(1.0 - nDotV, 5.0);
+    vec3 fssEss = kS * brdf.x + brdf.y;
+
+    vec3 color = irradiance * cDiff * (1.0 - fssEss) + specularLight * fssEss;
+
+    float ao = texture(occlusionTexture, texCoord(OCCLUSION_SLOT)).r;
+    color = mix(color, color * ao, draw.occlusionStrength);
+
+    color += draw.emissiveFactor.rgb * texture(emissiveTexture, texCoord(EMISSIVE_SLOT)).rgb;
+
+    // The color attachment is sRGB, so the output is linear.
+    outColor = vec4(toneMapPBRNeutral(color), baseColor.a);
+}
@@ -1,16 +1,13 @@
 #version 450
 
-// layout(binding=0) uniform UniformBufferObject {
-//     mat4 model;
-//     mat4 view;
-//     mat4 proj;
-// } ubo;
-
 layout(location=0) in vec3 inPosition;
 layout(location=1) in vec3 inNormal;
-
-// layout(location=1) in vec3 inColor;
-// layout(location=2) in vec2 inTexCoord;
+layout(location=2) in vec4 inColor;
+layout(location=3) in vec2 inTexCoord0;
+layout(location=4) in vec2 inTexCoord1;
+layout(location=5) in vec4 inTangent;
+layout(location=6) in uvec4 inJoints;
+layout(location=7) in vec4 inWeights;
 
 
 layout (push_constant) uniform constants {
@@ -18,22 +15,91 @@ layout (push_constant) uniform constants {
     mat4 model;
 } pc;
 
+// Per-draw data, bound with a dynamic offset for each primitive. Must match drawData in drawdata.go.
+const uint DRAW_FLAG_HAS_COLOR_0 = 1;
+const uint DRAW_FLAG_HAS_NORMAL = 2;
+const uint DRAW_FLAG_HAS_TANGENT = 4;
+const uint DRAW_FLAG_HAS_JOINTS = 16;
+
+layout(set=0, binding=0) uniform DrawData {
+    vec4 baseColorFactor;
+    vec4 emissiveFactor;
+    float metallicFactor;
+    float roughnessFactor;
+    float normalScale;
+    float occlusionStrength;
+    uint flags;
+    uint texCoordSets;
+    uint morphTargetCount;
+    uint morphDeltaBase;
+} draw;
+
+// Joint matrices of the node being drawn, relative to the node, see joints.go. jointCount is zero for nodes without a
+// skin.
+layout(std430, set=3, binding=0) readonly buffer JointMatrices {
+    uint jointCount;
+    mat4 joints[];
+} skin;
+
+// Morph target weights of the node being drawn, see morph.go.
+layout(std430, set=3, binding=1) readonly buffer MorphWeights {
+    float weights[];
+} morph;
+
+// Morph target deltas of every primitive. Those of target t for vertex v start at
+// draw.morphDeltaBase + (v * draw.morphTargetCount + t) * 3, as position, normal, and tangent.
+layout(std430, set=3, binding=2) readonly buffer MorphDeltas {
+    vec4 deltas[];
+} targets;
+
 layout(location=0) out vec4 fragColor;
-// layout(location=1) out vec3 normal;
-// layout(location=1) out vec2 fragTexCoord;
+layout(location=1) out vec2 fragTexCoord0;
+layout(location=2) out vec2 fragTexCoord1;
+layout(location=3) out vec3 fragPosition;
+layout(location=4) out vec3 fragNormal;
+layout(location=5) out vec4 fragTangent;
+
+// Outputs are in world space; lighting is done in shader.frag.
+mat4 jointMatrix(uint joint) {
+    return joint < skin.jointCount ? skin.joints[joint] : mat4(0.0);
+}
 
-// Static light at (3,1,5)
-// Phong Diffuse Lighting: diffuse component x dot(normal, light vec)
 void main() {
-    
-    gl_Position = pc.proj*pc.model*vec4(inPosition, 1.0);
-    
-    // Static light
-    vec3 lightVec = normalize(vec3(500,300,500) - inPosition);
-    float ndotl = dot(inNormal, lightVec);
-
-    vec4 baseColor = vec4(0.5,0.5,0.5,1); //vec4(abs(inNormal),1.0);
-    fragColor = baseColor * ndotl;
-
-    // fragTexCoord = inTexCoord;
-}
\ No newline at end of file
+    // Morph targets apply before skinning.
+    vec3 position = inPosition;
+    vec3 normal = inNormal;
+    vec3 tangent = inTangent.xyz;
+    for (uint t = 0; t < draw.morphTargetCount; t++) {
+        float w = morph.weights[t];
+        if (w == 0.0) {
+            continue;
+        }
+        uint base = draw.morphDeltaBase + (uint(gl_VertexIndex) * draw.morphTargetCount + t) * 3;
+        position += w * targets.deltas[base].xyz;
+        normal += w * targets.deltas[base + 1].xyz;
+        tangent += w * targets.deltas[base + 2].xyz;
+    }
+
+    mat4 skinMatrix = mat4(1.0);
+    if ((draw.flags & DRAW_FLAG_HAS_JOINTS) != 0 && skin.jointCount > 0) {
+        skinMatrix = inWeights.x * jointMatrix(inJoints.x) +
+                     inWeights.y * jointMatrix(inJoints.y) +
+                     inWeights.z * jointMatrix(inJoints.z) +
+                     inWeights.w * jointMatrix(inJoints.w);
+    }
+    mat4 model = pc.model * skinMatrix;
+
+    vec4 worldPos = model * vec4(position, 1.0);
+    gl_Position = pc.proj * worldPos;
+    // Only used by POINTS primitives, which glTF draws one pixel in size.
+    gl_PointSize = 1.0;
+    fragPosition = worldPos.xyz;
+
+    // Missing attributes are bound to null buffers and read as zero; the fragment shader checks the same flags.
+    fragNormal = (draw.flags & DRAW_FLAG_HAS_NORMAL) != 0 ? transpose(inverse(mat3(model))) * normal : vec3(0.0);
+    fragTangent = (draw.flags & DRAW_FLAG_HAS_TANGENT) != 0 ? vec4(mat3(model) * tangent, inTangent.w) : vec4(0.0);
+
+    fragColor = (draw.flags & DRAW_FLAG_HAS_COLOR_0) != 0 ? inColor : vec4(1.0);
+    fragTexCoord0 = inTexCoord0;
+    fragTexCoord1 = inTexCoord1;
+}
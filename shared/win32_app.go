@@ -1,8 +1,10 @@
+//go:build windows
+
 package shared
 
 import (
+	"fmt"
 	"runtime"
-	"time"
 	"unsafe"
 
 	"github.com/bbredesen/go-vk"
@@ -21,11 +23,13 @@ func NewWin32App(c chan WindowMessage) *Win32App {
 	}
 }
 
+// NewWindow returns the Window implementation for this platform.
+func NewWindow(c chan WindowMessage) Window {
+	return NewWin32App(c)
+}
+
 type Win32App struct {
-	// ZeroTime is the time that the main loop begins. Note that this is only set if [DefaultMainLoop] is used
-	ZeroTime time.Time
-	// CurrentTime is the time of the current iteration of the main loop
-	CurrentTime time.Time
+	mainLoop
 
 	isInitialized bool
 
@@ -36,16 +40,35 @@ type Win32App struct {
 	HInstance win32.HInstance
 	HWnd      win32.HWnd
 
-	lastFrameTime time.Time
-
 	ClassName     string
 	Width, Height uint32
+
+	// createErr carries the error from the window thread if the window could not be created, in place of the CREATE
+	// message.
+	createErr chan error
 }
 
 func (app *Win32App) GetRequiredInstanceExtensions() []string {
 	return []string{vk.KHR_SURFACE_EXTENSION_NAME, vk.KHR_WIN32_SURFACE_EXTENSION_NAME}
 }
 
+func (app *Win32App) CreateSurface(instance vk.Instance) (vk.SurfaceKHR, error) {
+	ci := vk.Win32SurfaceCreateInfoKHR{
+		Hinstance: windows.Handle(app.HInstance),
+		Hwnd:      windows.HWND(app.HWnd),
+	}
+
+	return vk.CreateWin32SurfaceKHR(instance, &ci, nil)
+}
+
+func (app *Win32App) SetSize(width, height uint32) {
+	app.Width, app.Height = width, height
+}
+
+func (app *Win32App) Extent() vk.Extent2D {
+	return GetWindowExtent(app.HWnd)
+}
+
 func (app *Win32App) createAndLoop(quitChan chan int) {
 	// The OS thread that creates the window also has to run the message loop. You
 	// can't createWindow and then go messageLoop, or the window simply freezes once the Go runtime attempts to run this
@@ -53,26 +76,34 @@ func (app *Win32App) createAndLoop(quitChan chan int) {
 	// This call ensures that the spawned goroutine is 1-to-1 with the current thread.
 	runtime.LockOSThread()
 
-	app.createWindow()
+	if err := app.createWindow(); err != nil {
+		app.createErr <- err
+		return
+	}
 	quitChan <- messageLoop(app.HWnd)
 }
 
-func (app *Win32App) Initialize(windowTitle string) {
+func (app *Win32App) Initialize(windowTitle string) error {
 	quitChan := make(chan int)
 	app.windowTitle = windowTitle
+	app.createErr = make(chan error, 1)
 
 	go app.createAndLoop(quitChan)
 
 	// Create and loop will fire the CREATE message once the window is created. Vulkan initialization will crash if HWnd
 	// is not set yet, so we wait here until the window is created before moving forward.
-	createMessage := <-app.winMsgs
-	if createMessage.Text != "CREATE" {
-		panic("Did not get CREATE as first message: " + createMessage.Text)
+	select {
+	case err := <-app.createErr:
+		return err
+	case createMessage := <-app.winMsgs:
+		if createMessage.Text != "CREATE" {
+			return fmt.Errorf("did not get CREATE as the first window message: %s", createMessage.Text)
+		}
+		app.HWnd = createMessage.HWnd
 	}
-	app.HWnd = createMessage.HWnd
 
 	app.isInitialized = true
-
+	return nil
 }
 
 func messageLoop(hWnd win32.HWnd) int {
@@ -96,7 +127,7 @@ func (app *Win32App) Shutdown() {
 
 func (app *Win32App) IsInitialized() bool { return app.isInitialized }
 
-func (app *Win32App) createWindow() {
+func (app *Win32App) createWindow() error {
 	if app.Width == 0 {
 		app.Width = 1280
 	}
@@ -105,11 +136,14 @@ func (app *Win32App) createWindow() {
 	}
 
 	className := app.ClassName //"ttf-renderer"
+	if className == "" {
+		className = app.windowTitle
+	}
 
 	var err win32.Win32Error
 	app.HInstance, err = win32.GetModuleHandleExW(0, "")
 	if err != 0 {
-		panic(err)
+		return fmt.Errorf("could not get the module handle: %v", err)
 	}
 
 	cursor, err := win32.LoadCursor(0, win32.IDC_ARROW)
@@ -128,7 +162,7 @@ func (app *Win32App) createWindow() {
 	wndClass.Size = uint32(unsafe.Sizeof(wndClass))
 
 	if _, err := win32.RegisterClassExW(&wndClass); err != 0 {
-		panic(err)
+		return fmt.Errorf("could not register the window class: %v", err)
 	}
 
 	app.HWnd, err = win32.CreateWindowExW(
@@ -147,9 +181,10 @@ func (app *Win32App) createWindow() {
 	)
 
 	if err != 0 {
-		panic(err)
+		return fmt.Errorf("could not create the window: %v", err)
 	}
 
+	return nil
 }
 
 func wndProc(hwnd win32.HWnd, msg win32.Msg, wParam, lParam uintptr) uintptr {
@@ -216,11 +251,37 @@ func wndProc(hwnd win32.HWnd, msg win32.Msg, wParam, lParam uintptr) uintptr {
 			KeyCode: byte(wParam),
 		}
 
+	case win32.WM_MOUSEMOVE:
+		globalChannel <- mouseMessage("MOUSEMOVE", hwnd, 0, lParam)
+	case win32.WM_LBUTTONDOWN:
+		globalChannel <- mouseMessage("MOUSEDOWN", hwnd, MouseButtonLeft, lParam)
+	case win32.WM_LBUTTONUP:
+		globalChannel <- mouseMessage("MOUSEUP", hwnd, MouseButtonLeft, lParam)
+	case win32.WM_RBUTTONDOWN:
+		globalChannel <- mouseMessage("MOUSEDOWN", hwnd, MouseButtonRight, lParam)
+	case win32.WM_RBUTTONUP:
+		globalChannel <- mouseMessage("MOUSEUP", hwnd, MouseButtonRight, lParam)
+	case win32.WM_MBUTTONDOWN:
+		globalChannel <- mouseMessage("MOUSEDOWN", hwnd, MouseButtonMiddle, lParam)
+	case win32.WM_MBUTTONUP:
+		globalChannel <- mouseMessage("MOUSEUP", hwnd, MouseButtonMiddle, lParam)
+	case win32.WM_MOUSEWHEEL:
+		// The wheel delta is the signed high word of wParam, in multiples of WHEEL_DELTA (120). Unlike the other mouse
+		// messages, the position in lParam is in screen coordinates, so it is not forwarded.
+		globalChannel <- WindowMessage{
+			Text:       "MOUSEWHEEL",
+			HWnd:       hwnd,
+			WheelDelta: float32(int16(wParam>>16)) / 120,
+		}
+
 	case win32.WM_SIZE:
 		// fmt.Printf("WM_SIZE: %d x %d\n", lParam&0xFFFF, lParam>>16)
+		// The new client size is in lParam; a minimized window reports 0 x 0.
 		globalChannel <- WindowMessage{
-			Text: "SIZE",
-			HWnd: hwnd,
+			Text:   "SIZE",
+			HWnd:   hwnd,
+			Wparam: uint(wParam),
+			Lparam: uint(lParam),
 		}
 		// win32.ValidateRect(hwnd, nil)
 	case win32.WM_ENTERSIZEMOVE:
@@ -267,70 +328,19 @@ func wndProc(hwnd win32.HWnd, msg win32.Msg, wParam, lParam uintptr) uintptr {
 	return 0
 }
 
-type ProcessInputFunc func(keys map[byte]bool, deltaT time.Duration)
-type TickFunc func(deltaT time.Duration)
-type DrawFunc func()
-
-func (app *Win32App) DefaultMainLoop(fnInput ProcessInputFunc, fnTick TickFunc, fnDraw DrawFunc) {
-	app.ZeroTime = time.Now()
-	app.lastFrameTime = app.ZeroTime
-
-	// Read any system messages...input, resize, window close, etc.
-	for {
-	innerLoop:
-		for {
-			select {
-			case msg := <-app.winMsgs:
-				// fmt.Println(msg.Text)
-				switch msg.Text {
-				case "KEYDOWN":
-					setAutoRepeat(msg.KeyCode)
-					// Some kind of non-repeat option for a keycode would be useful...handle a single keypress, possibly
-					// let the OS handle the input delay and watch msg.IsRepeat for certain keys
-				case "KEYUP":
-					clearAutoRepeat(msg.KeyCode)
-				case "DESTROY":
-					// Break out of the loop
-					return
-
-				}
-			default:
-				// Pull everything off the queue, then continue the outer loop
-				break innerLoop // "break" will break out of the select statement, not the loop, so we have to use a break label
-			}
-
-		}
-
-		deltaT := time.Since(app.CurrentTime) // Set on the last time through the loop, so CurrentTime is actually the last frame time here.
-		app.CurrentTime = time.Now()
-
-		if app.lastFrameTime == app.ZeroTime {
-			deltaT = 0
-		}
-
-		fnInput(keyAutoRepeat(), deltaT)
-		fnTick(deltaT)
-		fnDraw()
+// mouseMessage builds a mouse WindowMessage from a client-area mouse message. The cursor position is packed into
+// lParam as two signed 16-bit values.
+func mouseMessage(text string, hwnd win32.HWnd, button MouseButton, lParam uintptr) WindowMessage {
+	return WindowMessage{
+		Text:   text,
+		HWnd:   hwnd,
+		Lparam: uint(lParam),
+		MouseX: int32(int16(lParam & 0xFFFF)),
+		MouseY: int32(int16((lParam >> 16) & 0xFFFF)),
+		Button: button,
 	}
 }
 
-func DefaultIgnoreInput(map[byte]bool, time.Duration) {}
-func DefaultIgnoreTick(time.Duration)                 {}
-func DefaultIgnoreDraw()                              {}
-
-var autoRepeater map[byte]bool
-
-func setAutoRepeat(keyCode byte) {
-	autoRepeater[keyCode] = true
-}
-func clearAutoRepeat(keyCode byte) {
-	delete(autoRepeater, keyCode)
-}
-
-func keyAutoRepeat() map[byte]bool {
-	if autoRepeater == nil {
-		autoRepeater = make(map[byte]bool)
-	}
-
-	return autoRepeater
+func (app *Win32App) DefaultMainLoop(fnInput ProcessInputFunc, fnTick TickFunc, fnDraw DrawFunc) {
+	app.run(app.winMsgs, fnInput, fnTick, fnDraw)
 }
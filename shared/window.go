@@ -1,50 +1,197 @@
 package shared
 
 import (
-	"fmt"
+	"time"
 
 	"github.com/bbredesen/go-vk"
-
-	"github.com/bbredesen/win32-toolkit"
 )
 
-var (
-	globalChannel chan<- WindowMessage
-	hInstance     win32.HInstance
-	hWnd          win32.HWnd
-)
+// Window is a platform window that can host a Vulkan surface. Each platform provides one implementation, selected by
+// build tags: Win32App on Windows and X11App on Linux. Use NewWindow to get the one for the current platform.
+//
+// Window events are delivered on the channel passed to NewWindow as WindowMessages. The first message after
+// Initialize is always "CREATE", and "DESTROY" is the last one before the main loop returns.
+type Window interface {
+	// Initialize creates and shows the window, and blocks until it is ready for surface creation. If the window can't
+	// be created, the error is returned and the Window is left uninitialized.
+	Initialize(windowTitle string) error
+	// Shutdown is the reverse of Initialize
+	Shutdown()
+	IsInitialized() bool
+
+	// SetSize sets the requested client area size. It must be called before Initialize to have any effect.
+	SetSize(width, height uint32)
+	// Extent returns the current size of the client area.
+	Extent() vk.Extent2D
+
+	// GetRequiredInstanceExtensions returns the instance extensions needed to create a surface for this window.
+	GetRequiredInstanceExtensions() []string
+	// CreateSurface creates a presentation surface for this window.
+	CreateSurface(instance vk.Instance) (vk.SurfaceKHR, error)
+
+	// OnResize sets a function to be called from the main loop when the client area has changed size. While the
+	// user is dragging the window border, the call is deferred until the drag ends. A minimized window is reported as
+	// 0 x 0.
+	OnResize(fn ResizeFunc)
+
+	DefaultMainLoop(fnInput ProcessInputFunc, fnTick TickFunc, fnDraw DrawFunc)
+}
 
 type WindowMessage struct {
 	Text string
-	HWnd win32.HWnd
+	HWnd WindowHandle
 
 	Wparam, Lparam uint // Specifically defined as 64 bits by Win32 on 64-bit systems.
 
 	Character rune
 	KeyCode   byte
 	IsRepeat  bool
+
+	// Mouse messages ("MOUSEMOVE", "MOUSEDOWN", "MOUSEUP", "MOUSEWHEEL") report the cursor position in client area
+	// pixels. Button is the button that changed for MOUSEDOWN and MOUSEUP. WheelDelta is in notches, positive when
+	// rolled away from the user.
+	MouseX, MouseY int32
+	Button         MouseButton
+	WheelDelta     float32
 	// todo
 }
 
-func GetWindowExtent(hWnd win32.HWnd) vk.Extent2D {
-	var rval vk.Extent2D
-	var rect win32.Rect
+type MouseButton uint8
 
-	result := win32.GetClientRect(hWnd, &rect)
+const (
+	MouseButtonLeft MouseButton = 1 << iota
+	MouseButtonRight
+	MouseButtonMiddle
+)
+
+// MouseState is the mouse as of the current frame, accumulated from the mouse messages received since the last one.
+type MouseState struct {
+	X, Y int32
+	// Buttons is the set of buttons currently held down.
+	Buttons MouseButton
+	// Wheel is the total wheel movement, in notches, since the last frame.
+	Wheel float32
+}
+
+type ProcessInputFunc func(keys map[byte]bool, mouse MouseState, deltaT time.Duration)
+type TickFunc func(deltaT time.Duration)
+type DrawFunc func()
+type ResizeFunc func(width, height uint32)
+
+// mainLoop is the platform-independent part of DefaultMainLoop, embedded by each Window implementation.
+type mainLoop struct {
+	// ZeroTime is the time that the main loop begins. Note that this is only set if [DefaultMainLoop] is used
+	ZeroTime time.Time
+	// CurrentTime is the time of the current iteration of the main loop
+	CurrentTime time.Time
+
+	lastFrameTime time.Time
+
+	mouse MouseState
+
+	fnResize      ResizeFunc
+	inSizeMove    bool
+	pendingResize bool
+	width, height uint32
+}
 
-	if result != 0 {
-		panic(result)
+func (app *mainLoop) OnResize(fn ResizeFunc) {
+	app.fnResize = fn
+}
+
+func (app *mainLoop) resized() {
+	app.pendingResize = false
+	if app.fnResize != nil {
+		app.fnResize(app.width, app.height)
+	}
+}
+
+func (app *mainLoop) run(winMsgs <-chan WindowMessage, fnInput ProcessInputFunc, fnTick TickFunc, fnDraw DrawFunc) {
+	app.ZeroTime = time.Now()
+	app.lastFrameTime = app.ZeroTime
+
+	// Read any system messages...input, resize, window close, etc.
+	for {
+	innerLoop:
+		for {
+			select {
+			case msg := <-winMsgs:
+				// fmt.Println(msg.Text)
+				switch msg.Text {
+				case "KEYDOWN":
+					setAutoRepeat(msg.KeyCode)
+					// Some kind of non-repeat option for a keycode would be useful...handle a single keypress, possibly
+					// let the OS handle the input delay and watch msg.IsRepeat for certain keys
+				case "KEYUP":
+					clearAutoRepeat(msg.KeyCode)
+				case "MOUSEMOVE":
+					app.mouse.X, app.mouse.Y = msg.MouseX, msg.MouseY
+				case "MOUSEDOWN":
+					app.mouse.X, app.mouse.Y = msg.MouseX, msg.MouseY
+					app.mouse.Buttons |= msg.Button
+				case "MOUSEUP":
+					app.mouse.X, app.mouse.Y = msg.MouseX, msg.MouseY
+					app.mouse.Buttons &^= msg.Button
+				case "MOUSEWHEEL":
+					app.mouse.Wheel += msg.WheelDelta
+				case "SIZE":
+					app.width, app.height = uint32(msg.Lparam&0xFFFF), uint32((msg.Lparam>>16)&0xFFFF)
+					if app.inSizeMove {
+						app.pendingResize = true
+					} else {
+						app.resized()
+					}
+				case "ENTERSIZEMOVE":
+					app.inSizeMove = true
+				case "EXITSIZEMOVE":
+					app.inSizeMove = false
+					if app.pendingResize {
+						app.resized()
+					}
+				case "DESTROY":
+					// Break out of the loop
+					return
+
+				}
+			default:
+				// Pull everything off the queue, then continue the outer loop
+				break innerLoop // "break" will break out of the select statement, not the loop, so we have to use a break label
+			}
+
+		}
+
+		app.CurrentTime = time.Now()
+		deltaT := app.CurrentTime.Sub(app.lastFrameTime)
+		if app.lastFrameTime == app.ZeroTime {
+			// The first frame; time spent before it, waiting for the window, isn't animation time.
+			deltaT = 0
+		}
+		app.lastFrameTime = app.CurrentTime
+
+		fnInput(keyAutoRepeat(), app.mouse, deltaT)
+		app.mouse.Wheel = 0
+		fnTick(deltaT)
+		fnDraw()
 	}
-	rval.Width = uint32(rect.Right - rect.Left)
-	rval.Height = uint32(rect.Bottom - rect.Top)
-	// fmt.Printf("Client size: %d t x %d w\n", rval.Height, rval.Width)
-	return rval
+}
+
+func DefaultIgnoreInput(map[byte]bool, MouseState, time.Duration) {}
+func DefaultIgnoreTick(time.Duration)                             {}
+func DefaultIgnoreDraw()                                          {}
 
+var autoRepeater map[byte]bool
+
+func setAutoRepeat(keyCode byte) {
+	keyAutoRepeat()[keyCode] = true
+}
+func clearAutoRepeat(keyCode byte) {
+	delete(autoRepeater, keyCode)
 }
 
-func PrettyWin32Msg(msg win32.MSG) string {
-	return fmt.Sprintf("{ message: %s, wParam: %.8x, lParam: %.8x, pt: %v }",
-		win32.Msg(msg.Message).String(),
-		msg.WParam, msg.LParam, msg.Pt,
-	)
+func keyAutoRepeat() map[byte]bool {
+	if autoRepeater == nil {
+		autoRepeater = make(map[byte]bool)
+	}
+
+	return autoRepeater
 }
@@ -0,0 +1,406 @@
+//go:build linux
+
+package shared
+
+/*
+#cgo LDFLAGS: -lX11
+#include <stdlib.h>
+#include <X11/Xlib.h>
+#include <X11/Xutil.h>
+
+// Go cannot access the fields of the XEvent union directly, so these accessors unpack the events we care about.
+static int eventType(XEvent *e) { return e->type; }
+static unsigned int keyCode(XEvent *e) { return e->xkey.keycode; }
+static unsigned long keySym(XEvent *e) { return XLookupKeysym(&e->xkey, 0); }
+static int configureWidth(XEvent *e) { return e->xconfigure.width; }
+static int configureHeight(XEvent *e) { return e->xconfigure.height; }
+static Atom clientMessageAtom(XEvent *e) { return (Atom)e->xclient.data.l[0]; }
+static int buttonX(XEvent *e) { return e->xbutton.x; }
+static int buttonY(XEvent *e) { return e->xbutton.y; }
+static unsigned int buttonNumber(XEvent *e) { return e->xbutton.button; }
+static int motionX(XEvent *e) { return e->xmotion.x; }
+static int motionY(XEvent *e) { return e->xmotion.y; }
+
+// sendClientMessage queues a ClientMessage event carrying atom a for window w, as the window manager does for
+// WM_DELETE_WINDOW.
+static void sendClientMessage(Display *d, Window w, Atom a) {
+	XEvent e = {0};
+	e.xclient.type = ClientMessage;
+	e.xclient.window = w;
+	e.xclient.format = 32;
+	e.xclient.data.l[0] = a;
+	XSendEvent(d, w, False, NoEventMask, &e);
+	XFlush(d);
+}
+
+// Key releases from auto-repeat are immediately followed by a press with the same time and keycode.
+static int isRepeatRelease(Display *d, XEvent *e) {
+	if (!XEventsQueued(d, QueuedAfterReading)) return 0;
+	XEvent next;
+	XPeekEvent(d, &next);
+	return next.type == KeyPress && next.xkey.time == e->xkey.time && next.xkey.keycode == e->xkey.keycode;
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"unsafe"
+
+	"github.com/bbredesen/go-vk"
+)
+
+// WindowHandle identifies the window that a WindowMessage came from. On X11 this is the window XID.
+type WindowHandle = uintptr
+
+// NewWindow returns the Window implementation for this platform.
+func NewWindow(c chan WindowMessage) Window {
+	return NewX11App(c)
+}
+
+func NewX11App(c chan WindowMessage) *X11App {
+	return &X11App{
+		winMsgs: c,
+		sendMsg: c,
+	}
+}
+
+// X11App is the Linux Window implementation, using Xlib and VK_KHR_xlib_surface. Events are translated to the same
+// WindowMessage texts that Win32App sends, so the main loop and callers do not need to know which backend is in use.
+type X11App struct {
+	mainLoop
+
+	isInitialized bool
+
+	windowTitle string
+
+	winMsgs <-chan WindowMessage
+	sendMsg chan<- WindowMessage
+
+	// createErr carries the error from the event thread if the window could not be created, in place of the CREATE
+	// message. quit is closed by Shutdown, and done by the event thread once it has destroyed the window and closed
+	// the display.
+	createErr  chan error
+	quit, done chan struct{}
+
+	Display *C.Display
+	Window  C.Window
+
+	// wmDeleteWindow is sent by the window manager when the user closes the window, and wakeShutdown by Shutdown to
+	// stop the event thread.
+	wmDeleteWindow, wakeShutdown C.Atom
+
+	Width, Height uint32
+}
+
+func (app *X11App) GetRequiredInstanceExtensions() []string {
+	return []string{vk.KHR_SURFACE_EXTENSION_NAME, vk.KHR_XLIB_SURFACE_EXTENSION_NAME}
+}
+
+func (app *X11App) CreateSurface(instance vk.Instance) (vk.SurfaceKHR, error) {
+	ci := vk.XlibSurfaceCreateInfoKHR{
+		Dpy:    (*vk.Display)(unsafe.Pointer(app.Display)),
+		Window: vk.Window(app.Window),
+	}
+
+	return vk.CreateXlibSurfaceKHR(instance, &ci, nil)
+}
+
+func (app *X11App) SetSize(width, height uint32) {
+	app.Width, app.Height = width, height
+}
+
+func (app *X11App) Extent() vk.Extent2D {
+	var attrs C.XWindowAttributes
+	C.XGetWindowAttributes(app.Display, app.Window, &attrs)
+	return vk.Extent2D{Width: uint32(attrs.width), Height: uint32(attrs.height)}
+}
+
+func (app *X11App) Initialize(windowTitle string) error {
+	app.windowTitle = windowTitle
+	app.createErr = make(chan error, 1)
+	app.quit, app.done = make(chan struct{}), make(chan struct{})
+
+	// Vulkan drivers may call into Xlib from their own threads once the surface exists.
+	C.XInitThreads()
+
+	go app.createAndLoop()
+
+	// Same as Win32App: wait for the window to exist before allowing surface creation.
+	select {
+	case err := <-app.createErr:
+		return err
+	case createMessage := <-app.winMsgs:
+		app.isInitialized = true
+		if createMessage.Text != "CREATE" {
+			app.Shutdown()
+			return fmt.Errorf("did not get CREATE as the first window message: %s", createMessage.Text)
+		}
+	}
+
+	return nil
+}
+
+// Shutdown is the reverse of Initialize: it stops the event thread, which then destroys the window and closes the
+// display. Any Vulkan surface created for the window must already be destroyed.
+func (app *X11App) Shutdown() {
+	if !app.isInitialized {
+		return
+	}
+	app.isInitialized = false
+
+	// The event thread may be blocked in XNextEvent, or on a full message channel that nobody is reading any more.
+	// The display stays open until quit is closed, so the wake-up can be sent first even if the thread has already
+	// left its loop after a DESTROY.
+	C.sendClientMessage(app.Display, app.Window, app.wakeShutdown)
+	close(app.quit)
+	<-app.done
+}
+
+// requestClose asks the window to close, as if the user had clicked its close button.
+func (app *X11App) requestClose() {
+	C.sendClientMessage(app.Display, app.Window, app.wmDeleteWindow)
+}
+
+func (app *X11App) IsInitialized() bool { return app.isInitialized }
+
+func (app *X11App) createAndLoop() {
+	// Xlib event handling is not tied to a thread the way Win32 is, but keeping window creation and the event loop on
+	// one OS thread matches the Win32 backend and avoids surprises.
+	runtime.LockOSThread()
+
+	if err := app.createWindow(); err != nil {
+		app.createErr <- err
+		return
+	}
+	app.sendMsg <- WindowMessage{Text: "CREATE", HWnd: WindowHandle(app.Window)}
+
+	app.messageLoop()
+
+	<-app.quit
+	C.XDestroyWindow(app.Display, app.Window)
+	C.XCloseDisplay(app.Display)
+	close(app.done)
+}
+
+// send passes a message to the main loop. It returns false, without sending, once Shutdown has been called.
+func (app *X11App) send(msg WindowMessage) bool {
+	select {
+	case app.sendMsg <- msg:
+		return true
+	case <-app.quit:
+		return false
+	}
+}
+
+func (app *X11App) createWindow() error {
+	if app.Width == 0 {
+		app.Width = 1280
+	}
+	if app.Height == 0 {
+		app.Height = 1024
+	}
+
+	app.Display = C.XOpenDisplay(nil)
+	if app.Display == nil {
+		return errors.New("could not open X display; is DISPLAY set?")
+	}
+
+	screen := C.XDefaultScreen(app.Display)
+	root := C.XRootWindow(app.Display, screen)
+
+	app.Window = C.XCreateSimpleWindow(app.Display, root, 0, 0, C.uint(app.Width), C.uint(app.Height), 0,
+		C.XBlackPixel(app.Display, screen), C.XBlackPixel(app.Display, screen))
+
+	title := C.CString(app.windowTitle)
+	defer C.free(unsafe.Pointer(title))
+	C.XStoreName(app.Display, app.Window, title)
+
+	C.XSelectInput(app.Display, app.Window,
+		C.ExposureMask|C.KeyPressMask|C.KeyReleaseMask|C.StructureNotifyMask|
+			C.ButtonPressMask|C.ButtonReleaseMask|C.PointerMotionMask)
+
+	// Ask the window manager to send a message, instead of killing the connection, when the window is closed.
+	atomName := C.CString("WM_DELETE_WINDOW")
+	defer C.free(unsafe.Pointer(atomName))
+	app.wmDeleteWindow = C.XInternAtom(app.Display, atomName, C.False)
+	C.XSetWMProtocols(app.Display, app.Window, &app.wmDeleteWindow, 1)
+
+	wakeName := C.CString("GLTF_VIEWER_SHUTDOWN")
+	defer C.free(unsafe.Pointer(wakeName))
+	app.wakeShutdown = C.XInternAtom(app.Display, wakeName, C.False)
+
+	C.XMapWindow(app.Display, app.Window)
+	C.XFlush(app.Display)
+
+	return nil
+}
+
+func (app *X11App) messageLoop() {
+	hwnd := WindowHandle(app.Window)
+	var width, height C.int = C.int(app.Width), C.int(app.Height)
+	var event C.XEvent
+
+	for {
+		C.XNextEvent(app.Display, &event)
+
+		switch C.eventType(&event) {
+		case C.Expose:
+			if !app.send(WindowMessage{Text: "PAINT", HWnd: hwnd}) {
+				return
+			}
+
+		case C.KeyPress:
+			keyCode, char := translateKeySym(C.keySym(&event))
+			if !app.send(WindowMessage{Text: "KEYDOWN", HWnd: hwnd, KeyCode: keyCode}) {
+				return
+			}
+			if char != 0 && !app.send(WindowMessage{Text: "CHAR", HWnd: hwnd, Character: char}) {
+				return
+			}
+
+		case C.KeyRelease:
+			keyCode, _ := translateKeySym(C.keySym(&event))
+			if C.isRepeatRelease(app.Display, &event) != 0 {
+				// Win32 reports auto-repeat as a KEYDOWN with IsRepeat set and no KEYUP; do the same here.
+				C.XNextEvent(app.Display, &event)
+				if !app.send(WindowMessage{Text: "KEYDOWN", HWnd: hwnd, KeyCode: keyCode, IsRepeat: true}) {
+					return
+				}
+				continue
+			}
+			if !app.send(WindowMessage{Text: "KEYUP", HWnd: hwnd, KeyCode: keyCode}) {
+				return
+			}
+
+		case C.MotionNotify:
+			msg := WindowMessage{
+				Text:   "MOUSEMOVE",
+				HWnd:   hwnd,
+				MouseX: int32(C.motionX(&event)),
+				MouseY: int32(C.motionY(&event)),
+			}
+			if !app.send(msg) {
+				return
+			}
+
+		case C.ButtonPress, C.ButtonRelease:
+			msg := WindowMessage{
+				Text:   "MOUSEDOWN",
+				HWnd:   hwnd,
+				MouseX: int32(C.buttonX(&event)),
+				MouseY: int32(C.buttonY(&event)),
+			}
+			if C.eventType(&event) == C.ButtonRelease {
+				msg.Text = "MOUSEUP"
+			}
+
+			// X11 reports the wheel as presses of buttons 4 (up) and 5 (down), with a matching release for each.
+			switch C.buttonNumber(&event) {
+			case 1:
+				msg.Button = MouseButtonLeft
+			case 2:
+				msg.Button = MouseButtonMiddle
+			case 3:
+				msg.Button = MouseButtonRight
+			case 4, 5:
+				if msg.Text == "MOUSEUP" {
+					continue
+				}
+				msg.Text, msg.WheelDelta = "MOUSEWHEEL", 1
+				if C.buttonNumber(&event) == 5 {
+					msg.WheelDelta = -1
+				}
+			default:
+				continue
+			}
+			if !app.send(msg) {
+				return
+			}
+
+		case C.ConfigureNotify:
+			w, h := C.configureWidth(&event), C.configureHeight(&event)
+			if w != width || h != height {
+				width, height = w, h
+				msg := WindowMessage{
+					Text:   "SIZE",
+					HWnd:   hwnd,
+					Lparam: uint(h)<<16 | uint(w)&0xFFFF,
+				}
+				if !app.send(msg) {
+					return
+				}
+			}
+
+		case C.UnmapNotify:
+			// Iconified (minimized); report a zero size the same way WM_SIZE does.
+			if !app.send(WindowMessage{Text: "SIZE", HWnd: hwnd}) {
+				return
+			}
+
+		case C.MapNotify:
+			msg := WindowMessage{
+				Text:   "SIZE",
+				HWnd:   hwnd,
+				Lparam: uint(height)<<16 | uint(width)&0xFFFF,
+			}
+			if !app.send(msg) {
+				return
+			}
+
+		case C.ClientMessage:
+			switch C.clientMessageAtom(&event) {
+			case app.wmDeleteWindow:
+				// The window itself stays open until Shutdown, since the surface may still be in use.
+				if app.send(WindowMessage{Text: "CLOSE", HWnd: hwnd}) {
+					app.send(WindowMessage{Text: "DESTROY", HWnd: hwnd})
+				}
+				return
+			case app.wakeShutdown:
+				return
+			}
+		}
+	}
+}
+
+// translateKeySym maps an X keysym onto the Win32 virtual key code that Win32App would report, so key handling is
+// the same on both platforms. Letters map to their upper case ASCII value, as VK codes do.
+func translateKeySym(sym C.ulong) (keyCode byte, char rune) {
+	switch {
+	case sym >= 'a' && sym <= 'z':
+		return byte(sym - 'a' + 'A'), rune(sym)
+	case sym >= 0x20 && sym <= 0x7E:
+		return byte(sym), rune(sym)
+	}
+
+	switch sym {
+	case 0xFF1B: // XK_Escape
+		return 0x1B, 0
+	case 0xFF0D: // XK_Return
+		return 0x0D, '\r'
+	case 0xFF08: // XK_BackSpace
+		return 0x08, 0
+	case 0xFF09: // XK_Tab
+		return 0x09, '\t'
+	case 0xFF51: // XK_Left
+		return 0x25, 0
+	case 0xFF52: // XK_Up
+		return 0x26, 0
+	case 0xFF53: // XK_Right
+		return 0x27, 0
+	case 0xFF54: // XK_Down
+		return 0x28, 0
+	case 0xFFE1, 0xFFE2: // XK_Shift_L, XK_Shift_R
+		return 0x10, 0
+	case 0xFFE3, 0xFFE4: // XK_Control_L, XK_Control_R
+		return 0x11, 0
+	}
+
+	return 0, 0
+}
+
+func (app *X11App) DefaultMainLoop(fnInput ProcessInputFunc, fnTick TickFunc, fnDraw DrawFunc) {
+	app.run(app.winMsgs, fnInput, fnTick, fnDraw)
+}
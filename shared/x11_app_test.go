@@ -0,0 +1,69 @@
+//go:build linux && xvfb
+
+// This test needs an X server, and a Vulkan driver that supports VK_KHR_xlib_surface, such as lavapipe. Run it with
+//
+//	xvfb-run go test -tags xvfb ./shared
+
+package shared
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bbredesen/go-vk"
+)
+
+func TestX11WindowSmoke(t *testing.T) {
+	app := NewX11App(make(chan WindowMessage, 32))
+	app.SetSize(320, 240)
+	if err := app.Initialize("x11 smoke test"); err != nil {
+		t.Fatalf("could not open a window: %s", err)
+	}
+	defer app.Shutdown()
+
+	icInfo := vk.InstanceCreateInfo{
+		PApplicationInfo:        &vk.ApplicationInfo{ApiVersion: vk.MAKE_VERSION(1, 0, 0)},
+		PpEnabledExtensionNames: app.GetRequiredInstanceExtensions(),
+	}
+	instance, err := vk.CreateInstance(&icInfo, nil)
+	if err != nil {
+		t.Fatalf("could not create an instance: %s", err)
+	}
+	defer vk.DestroyInstance(instance, nil)
+
+	surface, err := app.CreateSurface(instance)
+	if err != nil {
+		t.Fatalf("could not create a surface: %s", err)
+	}
+
+	// Pump a few frames, then close the window the way the window manager would; the main loop must return on the
+	// DESTROY that follows.
+	const closeAfter = 3
+	frames := 0
+	timeout := time.AfterFunc(10*time.Second, func() { panic("main loop did not return after the window was closed") })
+	app.DefaultMainLoop(
+		func(map[byte]bool, MouseState, time.Duration) {},
+		func(time.Duration) {},
+		func() {
+			frames++
+			if frames == closeAfter {
+				app.requestClose()
+			}
+			time.Sleep(time.Millisecond)
+		},
+	)
+	timeout.Stop()
+
+	if frames < closeAfter {
+		t.Errorf("drew %d frames, want at least %d", frames, closeAfter)
+	}
+	if e := app.Extent(); e.Width != 320 || e.Height != 240 {
+		t.Errorf("window extent is %d x %d, want 320 x 240", e.Width, e.Height)
+	}
+
+	vk.DestroySurfaceKHR(instance, surface, nil)
+	app.Shutdown()
+	if app.IsInitialized() {
+		t.Error("window is still initialized after Shutdown")
+	}
+}
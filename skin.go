@@ -0,0 +1,135 @@
+package main
+
+import (
+	"github.com/bbredesen/gltf"
+)
+
+// sceneSkins computes the joint matrices of the skinned nodes in a scene from the joints' current transforms.
+type sceneSkins struct {
+	nodes       map[*gltf.ResolvedNode]*SceneNode
+	inverseBind map[*gltf.ResolvedSkin][]mat4
+}
+
+func newSceneSkins(doc *gltf.ResolvedGlTF, root *SceneNode) *sceneSkins {
+	s := &sceneSkins{
+		nodes:       indexSceneNodes(root),
+		inverseBind: make(map[*gltf.ResolvedSkin][]mat4),
+	}
+
+	for _, n := range s.nodes {
+		skin := n.ModelNode.Skin
+		if skin == nil {
+			continue
+		}
+		if _, ok := s.inverseBind[skin]; ok {
+			continue
+		}
+
+		// Without inverseBindMatrices, each one is the identity, i.e. the joints are already in their bind pose. The
+		// same goes for matrices missing from a short or unreadable accessor.
+		ibms := make([]mat4, len(skin.Joints))
+		values, _ := readFloats(doc, skin.InverseBindMatrices)
+		for i := range ibms {
+			if len(values) >= (i+1)*16 {
+				copy(ibms[i][:], values[i*16:])
+			} else {
+				ibms[i] = identity4()
+			}
+		}
+		s.inverseBind[skin] = ibms
+	}
+
+	return s
+}
+
+// skinnedNodes returns the nodes that have a skin and a mesh, in traversal order.
+func skinnedNodes(root *SceneNode) []*SceneNode {
+	var rval []*SceneNode
+	var walk func(n *SceneNode)
+	walk = func(n *SceneNode) {
+		if n.ModelNode != nil && n.ModelNode.Skin != nil && n.ModelNode.Mesh != nil {
+			rval = append(rval, n)
+		}
+		for _, child := range n.Children {
+			walk(child)
+		}
+	}
+	walk(root)
+	return rval
+}
+
+// jointMatrices returns the joint matrices for a skinned node, or nil if it has no skin. They are relative to the
+// node, so that the node's own transform can still be used as the model matrix; the net effect is that the node's
+// transform is ignored for skinned vertices, as the spec requires.
+func (s *sceneSkins) jointMatrices(n *SceneNode) []mat4 {
+	if n.ModelNode == nil || n.ModelNode.Skin == nil {
+		return nil
+	}
+	skin := n.ModelNode.Skin
+	ibms := s.inverseBind[skin]
+
+	toNode := fromVkm(n.CurrentTransform).inverse()
+	rval := make([]mat4, len(skin.Joints))
+	for i, joint := range skin.Joints {
+		world := identity4()
+		if jn := s.nodes[joint]; jn != nil {
+			world = fromVkm(jn.CurrentTransform)
+		}
+		rval[i] = toNode.mul(world).mul(ibms[i])
+	}
+	return rval
+}
+
+// skinMatrix blends the joint matrices selected by joints with weights. Joints outside of matrices are skipped. This
+// is the CPU equivalent of the skinning in shader.vert.
+func skinMatrix(joints [4]uint32, weights [4]float32, matrices []mat4) mat4 {
+	var rval mat4
+	for i, j := range joints {
+		if weights[i] == 0 || int(j) >= len(matrices) {
+			continue
+		}
+		for k := range rval {
+			rval[k] += weights[i] * matrices[j][k]
+		}
+	}
+	return rval
+}
+
+// skinVertices returns the positions, normals, and tangents transformed by their blended joint matrices. Tangent w is
+// passed through. normals and tangents may be nil, in which case nil is returned for them too.
+func skinVertices(positions, normals [][3]float32, tangents [][4]float32, joints [][4]uint32, weights [][4]float32, matrices []mat4) ([][3]float32, [][3]float32, [][4]float32) {
+	outPositions := make([][3]float32, len(positions))
+	var outNormals [][3]float32
+	if normals != nil {
+		outNormals = make([][3]float32, len(normals))
+	}
+	var outTangents [][4]float32
+	if tangents != nil {
+		outTangents = make([][4]float32, len(tangents))
+	}
+
+	for i := range positions {
+		if i >= len(joints) || i >= len(weights) {
+			outPositions[i] = positions[i]
+			if i < len(normals) {
+				outNormals[i] = normals[i]
+			}
+			if i < len(tangents) {
+				outTangents[i] = tangents[i]
+			}
+			continue
+		}
+
+		m := skinMatrix(joints[i], weights[i], matrices)
+		outPositions[i] = m.transformPoint(positions[i])
+		if i < len(normals) {
+			outNormals[i] = normalize3(m.normalMatrix().transformDir(normals[i]))
+		}
+		if i < len(tangents) {
+			t := m.transformDir([3]float32{tangents[i][0], tangents[i][1], tangents[i][2]})
+			outTangents[i] = [4]float32{t[0], t[1], t[2], tangents[i][3]}
+		}
+	}
+
+	return outPositions, outNormals, outTangents
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/bbredesen/gltf"
+)
+
+// skinTestDocument holds the inverse bind matrices of skinTestBuffer.
+const skinTestDocument = `{
+	"bufferViews": [{"buffer": 0, "byteLength": 128}],
+	"accessors": [{"bufferView": 0, "componentType": 5126, "type": "MAT4", "count": 2}]
+}`
+
+// skinTestBuffer holds the inverse bind matrices for two joints: the root joint at the origin, and its child one unit
+// up the Y axis.
+var skinTestBuffer = littleEndian(
+	identity4(),
+	mat4{1, 0, 0, 0, 0, 1, 0, 0, 0, 0, 1, 0, 0, -1, 0, 1},
+)
+
+func TestSkinVertices(t *testing.T) {
+	doc := loadTestModel(t, skinTestDocument, skinTestBuffer)
+
+	// An arm of two joints, with the child bent a quarter turn about Z from its bind pose. The mesh node is moved
+	// away from the joints, which must make no difference, as a skinned mesh is placed by its joints alone.
+	child := testNode([3]float32{0, 1, 0}, rotZ90, [3]float32{})
+	root := testNode([3]float32{}, [4]float32{}, [3]float32{}, child)
+	skin := &gltf.ResolvedSkin{}
+	skin.Joints = []*gltf.ResolvedNode{root, child}
+	skin.InverseBindMatrices = doc.Accessors[0]
+	mesh := testNode([3]float32{5, 0, 0}, [4]float32{}, [3]float32{})
+	mesh.Skin = skin
+
+	scene := &gltf.ResolvedScene{}
+	scene.Nodes = []*gltf.ResolvedNode{root, mesh}
+	sceneRoot := NewScene(scene)
+	nodes := indexSceneNodes(sceneRoot)
+	skins := newSceneSkins(doc, sceneRoot)
+
+	positions := [][3]float32{{0, 0.5, 0}, {0, 2, 0}, {1, 1, 0}, {0, 2, 0}, {3, 3, 3}}
+	normals := [][3]float32{{1, 0, 0}, {1, 0, 0}, {1, 0, 0}, {1, 0, 0}, {1, 0, 0}}
+	tangents := [][4]float32{{0, 1, 0, 1}, {0, 1, 0, 1}, {0, 1, 0, -1}, {0, 1, 0, 1}, {0, 1, 0, 1}}
+	// The last vertex has no joints or weights, and is passed through.
+	joints := [][4]uint32{{0, 1, 0, 0}, {1, 0, 0, 0}, {1, 0, 0, 0}, {0, 1, 0, 0}}
+	weights := [][4]float32{{1, 0, 0, 0}, {1, 0, 0, 0}, {1, 0, 0, 0}, {0.5, 0.5, 0, 0}}
+
+	tests := []struct {
+		name                       string
+		bent                       bool
+		wantPositions, wantNormals [][3]float32
+		wantTangents               [][4]float32
+	}{
+		{
+			// In the bind pose, the joint matrices are the identity in world space.
+			name:          "bind pose",
+			wantPositions: [][3]float32{{0, 0.5, 0}, {0, 2, 0}, {1, 1, 0}, {0, 2, 0}, {3, 3, 3}},
+			wantNormals:   normals,
+			wantTangents:  tangents,
+		},
+		{
+			// The child joint takes (0, 2, 0) to (0, 1, 0) relative to itself, rotates it to (-1, 0, 0), and moves
+			// it back up to (-1, 1, 0). (1, 1, 0) goes to (1, 0, 0), then (0, 1, 0), then (0, 2, 0). The vertex with
+			// equal weights is halfway between where each joint alone would put it: (0, 2, 0) and (-1, 1, 0).
+			name:          "bent",
+			bent:          true,
+			wantPositions: [][3]float32{{0, 0.5, 0}, {-1, 1, 0}, {0, 2, 0}, {-0.5, 1.5, 0}, {3, 3, 3}},
+			wantNormals:   [][3]float32{{1, 0, 0}, {0, 1, 0}, {0, 1, 0}, {0.70710677, 0.70710677, 0}, {1, 0, 0}},
+			wantTangents:  [][4]float32{{0, 1, 0, 1}, {-1, 0, 0, 1}, {-1, 0, 0, -1}, {-0.5, 0.5, 0, 1}, {0, 1, 0, 1}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rotation := [4]float32{0, 0, 0, 1}
+			if test.bent {
+				rotation = rotZ90
+			}
+			nodes[child].BaseTransform = composeTRS([3]float32{0, 1, 0}, rotation, [3]float32{1, 1, 1}).toVkm()
+			sceneRoot.UpdateTransforms()
+
+			matrices := skins.jointMatrices(nodes[mesh])
+			gotPositions, gotNormals, gotTangents := skinVertices(positions, normals, tangents, joints, weights, matrices)
+
+			// Positions are skinned relative to the mesh node, which still places them in the world.
+			toWorld := fromVkm(nodes[mesh].CurrentTransform)
+			for i, want := range test.wantPositions {
+				if i >= len(joints) {
+					// Unskinned vertices stay in the mesh node's space.
+					want = toWorld.transformPoint(want)
+				}
+				got := toWorld.transformPoint(gotPositions[i])
+				if !floatsNear(got[:], want[:]) {
+					t.Errorf("vertex %d at %v, want %v", i, got, want)
+				}
+			}
+			for i, want := range test.wantNormals {
+				if got := gotNormals[i]; !floatsNear(got[:], want[:]) {
+					t.Errorf("normal %d is %v, want %v", i, got, want)
+				}
+			}
+			for i, want := range test.wantTangents {
+				if got := gotTangents[i]; !floatsNear(got[:], want[:]) {
+					t.Errorf("tangent %d is %v, want %v", i, got, want)
+				}
+			}
+		})
+	}
+
+	_, gotNormals, gotTangents := skinVertices(positions, nil, nil, joints, weights, nil)
+	if gotNormals != nil || gotTangents != nil {
+		t.Errorf("skinning without normals or tangents returned %v and %v, want nil", gotNormals, gotTangents)
+	}
+}
+
+func TestSkinMatrix(t *testing.T) {
+	translate := identity4()
+	translate[12] = 2
+	matrices := []mat4{identity4(), translate}
+
+	tests := []struct {
+		name    string
+		joints  [4]uint32
+		weights [4]float32
+		point   [3]float32
+		want    [3]float32
+	}{
+		{"single joint", [4]uint32{1, 0, 0, 0}, [4]float32{1, 0, 0, 0}, [3]float32{1, 1, 1}, [3]float32{3, 1, 1}},
+		{"blend", [4]uint32{0, 1, 0, 0}, [4]float32{0.75, 0.25, 0, 0}, [3]float32{1, 1, 1}, [3]float32{1.5, 1, 1}},
+		// Unused slots often repeat joint 0 with a zero weight, which must add nothing.
+		{"zero weights", [4]uint32{1, 0, 0, 0}, [4]float32{1, 0, 0, 0}, [3]float32{0, 0, 0}, [3]float32{2, 0, 0}},
+		// A joint index past the end of the skin is skipped, leaving only the valid joint's share.
+		{"missing joint", [4]uint32{1, 7, 0, 0}, [4]float32{0.5, 0.5, 0, 0}, [3]float32{0, 0, 0}, [3]float32{1, 0, 0}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := skinMatrix(test.joints, test.weights, matrices).transformPoint(test.point)
+			if !floatsNear(got[:], test.want[:]) {
+				t.Errorf("transformed %v to %v, want %v", test.point, got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"os"
+
+	"github.com/bbredesen/gltf"
+	"github.com/bbredesen/gltf-viewer/raster"
+	"github.com/bbredesen/vkm"
+)
+
+// softwareRenderer draws with the CPU rasterizer instead of Vulkan. Output should match the GPU path closely enough
+// for golden-image comparisons, but it is far too slow for interactive use.
+type softwareRenderer struct {
+	doc *gltf.ResolvedGlTF
+	r   *raster.Rasterizer
+	env *environment
+
+	skins *sceneSkins
+
+	viewProj mat4
+	eye      [3]float32
+
+	images   *imageCache
+	textures map[softwareTextureKey]*raster.Texture
+}
+
+// softwareTextureKey identifies a decoded texture. Color textures are decoded from sRGB, so the same texture can
+// appear twice.
+type softwareTextureKey struct {
+	tex  *gltf.ResolvedTexture
+	srgb bool
+}
+
+func (sr *softwareRenderer) SetCamera(projView vkm.Mat, eye [3]float32) {
+	sr.viewProj = fromVkm(projView)
+	sr.eye = eye
+}
+
+// softwareVertices is the vertex data for one draw, already decoded from its accessors.
+type softwareVertices struct {
+	positions [][3]float32
+	normals   [][3]float32
+	tangents  [][4]float32
+	texCoords [2][][2]float32
+	colors    [][4]float32
+	indices   []uint32
+}
+
+func (sr *softwareRenderer) DrawPrimitive(p *gltf.ResolvedPrimitive, n *SceneNode) {
+	verts, err := sr.readVertices(p, n)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: skipping primitive: %s\n", err.Error())
+		return
+	}
+	if verts == nil {
+		return
+	}
+
+	mat := &pbrMaterial{factors: materialFactorsOf(p.Material)}
+	for slot, mt := range materialTextures(p.Material) {
+		if mt != nil {
+			mat.textures[slot] = sr.texture(mt.Texture, textureSlot(slot).isColor())
+			mat.texCoord[slot] = mt.TexCoord
+		}
+	}
+
+	// The GPU derives missing normals and tangents per fragment from screen-space derivatives, which are constant
+	// across a triangle. The equivalent here is to give each triangle its own vertices with face values.
+	mat.flatNormals = verts.normals == nil
+	if mat.flatNormals || (mat.textures[normalSlot] != nil && verts.tangents == nil) {
+		verts = verts.withFaceFrames(mat.texCoord[normalSlot])
+	}
+
+	m := fromVkm(n.CurrentTransform)
+	shader := &pbrShader{
+		sr:           sr,
+		verts:        verts,
+		mat:          mat,
+		model:        m,
+		normalMatrix: m.normalMatrix(),
+		mvp:          sr.viewProj.mul(m),
+	}
+	sr.r.DrawTriangles(len(verts.positions), verts.indices, shader)
+}
+
+// readVertices decodes the vertices of a primitive, morphed and skinned as posed on n. It returns nil if the rasterizer
+// can't draw the primitive.
+func (sr *softwareRenderer) readVertices(p *gltf.ResolvedPrimitive, n *SceneNode) (*softwareVertices, error) {
+	indices, err := readIndices(sr.doc, p.Indices)
+	if err != nil {
+		return nil, fmt.Errorf("indices: %w", err)
+	}
+	// The rasterizer only draws triangle lists. Strips and fans are converted; points and lines are skipped.
+	indices, ok := triangleListIndices(p, indices)
+	if !ok {
+		return nil, nil
+	}
+
+	verts := &softwareVertices{indices: indices}
+	if verts.positions, err = readVec3(sr.doc, p.Attributes[gltf.POSITION]); err != nil || verts.positions == nil {
+		return nil, attributeError(gltf.POSITION, err)
+	}
+	if verts.normals, err = readVec3(sr.doc, p.Attributes[gltf.NORMAL]); err != nil {
+		return nil, attributeError(gltf.NORMAL, err)
+	}
+	if verts.tangents, err = readTangent(sr.doc, p.Attributes[gltf.TANGENT]); err != nil {
+		return nil, attributeError(gltf.TANGENT, err)
+	}
+	for set, key := range []gltf.AttributeKey{gltf.TEXCOORD_0, gltf.TEXCOORD_1} {
+		if verts.texCoords[set], err = readTexCoord(sr.doc, p.Attributes[key]); err != nil {
+			return nil, attributeError(key, err)
+		}
+	}
+	if verts.colors, err = readColor(sr.doc, p.Attributes[gltf.COLOR_0]); err != nil {
+		return nil, attributeError(gltf.COLOR_0, err)
+	}
+
+	// Morph targets apply before skinning, per the spec.
+	targets, err := readMorphTargets(sr.doc, p)
+	if err != nil {
+		return nil, err
+	}
+	if len(targets) > 0 {
+		verts.positions, verts.normals, verts.tangents = morphVertices(verts.positions, verts.normals, verts.tangents, targets, n.Weights)
+	}
+
+	if matrices := sr.skins.jointMatrices(n); matrices != nil {
+		joints, err := readJoints(sr.doc, p.Attributes[gltf.JOINTS_0])
+		if err != nil {
+			return nil, attributeError(gltf.JOINTS_0, err)
+		}
+		weights, err := readWeights(sr.doc, p.Attributes[gltf.WEIGHTS_0])
+		if err != nil {
+			return nil, attributeError(gltf.WEIGHTS_0, err)
+		}
+		if joints != nil && weights != nil {
+			verts.positions, verts.normals, verts.tangents = skinVertices(verts.positions, verts.normals, verts.tangents, joints, weights, matrices)
+		}
+	}
+	return verts, nil
+}
+
+// withFaceFrames returns a non-indexed copy of v where every triangle has its own three vertices. Missing normals are
+// replaced with the face normal, and missing tangents with the face tangent computed from texture coordinate set
+// texCoord.
+func (v *softwareVertices) withFaceFrames(texCoord int) *softwareVertices {
+	count := len(v.positions)
+	if v.indices != nil {
+		count = len(v.indices)
+	}
+	count -= count % 3
+
+	out := &softwareVertices{
+		positions: make([][3]float32, 0, count),
+		normals:   make([][3]float32, 0, count),
+		tangents:  make([][4]float32, 0, count),
+	}
+
+	index := func(i int) int {
+		if v.indices != nil {
+			return int(v.indices[i])
+		}
+		return i
+	}
+
+	for t := 0; t < count; t += 3 {
+		idx := [3]int{index(t), index(t + 1), index(t + 2)}
+		if idx[0] >= len(v.positions) || idx[1] >= len(v.positions) || idx[2] >= len(v.positions) {
+			continue
+		}
+
+		p0, p1, p2 := v.positions[idx[0]], v.positions[idx[1]], v.positions[idx[2]]
+		e1, e2 := sub3(p1, p0), sub3(p2, p0)
+		faceNormal := normalize3(cross3(e1, e2))
+
+		faceTangent := [4]float32{1, 0, 0, 1}
+		if uvs := v.texCoords[texCoord]; len(uvs) > idx[0] && len(uvs) > idx[1] && len(uvs) > idx[2] {
+			du1, dv1 := uvs[idx[1]][0]-uvs[idx[0]][0], uvs[idx[1]][1]-uvs[idx[0]][1]
+			du2, dv2 := uvs[idx[2]][0]-uvs[idx[0]][0], uvs[idx[2]][1]-uvs[idx[0]][1]
+			if det := du1*dv2 - du2*dv1; det != 0 {
+				t := scale3(sub3(scale3(e1, dv2), scale3(e2, dv1)), 1/det)
+				faceTangent = [4]float32{t[0], t[1], t[2], 1}
+			}
+		}
+
+		for _, i := range idx {
+			out.positions = append(out.positions, v.positions[i])
+			if i < len(v.normals) {
+				out.normals = append(out.normals, v.normals[i])
+			} else {
+				out.normals = append(out.normals, faceNormal)
+			}
+			if i < len(v.tangents) {
+				out.tangents = append(out.tangents, v.tangents[i])
+			} else {
+				out.tangents = append(out.tangents, faceTangent)
+			}
+			for set := range v.texCoords {
+				if i < len(v.texCoords[set]) {
+					out.texCoords[set] = append(out.texCoords[set], v.texCoords[set][i])
+				}
+			}
+			if i < len(v.colors) {
+				out.colors = append(out.colors, v.colors[i])
+			}
+		}
+	}
+
+	return out
+}
+
+// Offsets of the vertex outputs passed from pbrShader.Vertex to pbrShader.Fragment.
+const (
+	varyingPosition = 0
+	varyingNormal   = 3
+	varyingTangent  = 6
+	varyingTexCoord = 10 // two sets
+	varyingColor    = 14
+	numVaryings     = 18
+)
+
+// pbrShader is the software equivalent of shader.vert and shader.frag.
+type pbrShader struct {
+	sr    *softwareRenderer
+	verts *softwareVertices
+	mat   *pbrMaterial
+
+	model, normalMatrix, mvp mat4
+}
+
+func (s *pbrShader) Varyings() int {
+	return numVaryings
+}
+
+func (s *pbrShader) Vertex(i int, out []float32) [4]float32 {
+	v := s.verts
+	pos := v.positions[i]
+
+	worldPos := s.model.transformPoint(pos)
+	copy(out[varyingPosition:], worldPos[:])
+
+	if i < len(v.normals) {
+		n := s.normalMatrix.transformDir(v.normals[i])
+		copy(out[varyingNormal:], n[:])
+	}
+	if i < len(v.tangents) {
+		t := s.model.transformDir([3]float32{v.tangents[i][0], v.tangents[i][1], v.tangents[i][2]})
+		copy(out[varyingTangent:], []float32{t[0], t[1], t[2], v.tangents[i][3]})
+	}
+	for set := range v.texCoords {
+		if i < len(v.texCoords[set]) {
+			copy(out[varyingTexCoord+2*set:], v.texCoords[set][i][:])
+		}
+	}
+	color := [4]float32{1, 1, 1, 1}
+	if i < len(v.colors) {
+		color = v.colors[i]
+	}
+	copy(out[varyingColor:], color[:])
+
+	return s.mvp.transformVec4([4]float32{pos[0], pos[1], pos[2], 1})
+}
+
+func (s *pbrShader) Fragment(in []float32) [4]float32 {
+	var f pbrFragment
+	copy(f.position[:], in[varyingPosition:])
+	copy(f.normal[:], in[varyingNormal:])
+	copy(f.tangent[:], in[varyingTangent:])
+	copy(f.texCoord[0][:], in[varyingTexCoord:])
+	copy(f.texCoord[1][:], in[varyingTexCoord+2:])
+	copy(f.color[:], in[varyingColor:])
+
+	c := shadePBR(s.sr.env, s.sr.eye, s.mat, &f)
+
+	// The Vulkan color attachment is sRGB, so the encode happens in hardware there.
+	for i := 0; i < 3; i++ {
+		c[i] = linearToSRGB(clamp01(c[i]))
+	}
+	return c
+}
+
+// texture returns the decoded texture for tex, decoding it on first use. Textures that fail to load are left
+// untextured, as in the Vulkan renderer.
+func (sr *softwareRenderer) texture(tex *gltf.ResolvedTexture, srgb bool) *raster.Texture {
+	key := softwareTextureKey{tex, srgb}
+	if rt, ok := sr.textures[key]; ok {
+		return rt
+	}
+
+	var rt *raster.Texture
+	if img, err := sr.images.get(tex.Source); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: could not load texture: %s\n", err.Error())
+	} else {
+		rt = raster.NewTexture(img, srgb)
+		if s := tex.Sampler; s != nil {
+			rt.Nearest = int(s.MagFilter) == gltfNearest
+			rt.WrapS, rt.WrapT = rasterWrapMode(int(s.WrapS)), rasterWrapMode(int(s.WrapT))
+		}
+	}
+
+	sr.textures[key] = rt
+	return rt
+}
+
+func rasterWrapMode(wrap int) raster.WrapMode {
+	switch wrap {
+	case gltfClampToEdge:
+		return raster.WrapClampToEdge
+	case gltfMirroredRepeat:
+		return raster.WrapMirroredRepeat
+	}
+	return raster.WrapRepeat
+}
+
+// RenderSoftware renders the document's default scene without using the GPU, posed by the animation in anim. The camera
+// is framed on the scene the same way the Vulkan renderer frames it on load, so the two outputs can be compared.
+// Relative image URIs are resolved against modelDir.
+func RenderSoftware(doc *gltf.ResolvedGlTF, modelDir string, anim AnimationOptions, width, height int) *image.RGBA {
+	sr := &softwareRenderer{
+		doc:      doc,
+		r:        raster.New(width, height),
+		env:      defaultEnvironment(),
+		images:   newImageCache(doc, modelDir),
+		textures: make(map[softwareTextureKey]*raster.Texture),
+	}
+	sr.r.Clear(color.RGBA{A: 0xFF})
+
+	scene := NewScene(doc.Scene)
+	if err := NewAnimationPlayer(doc, scene).Configure(anim); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", err.Error())
+	}
+
+	cam := NewOrbitCamera()
+	cam.Aspect = float32(width) / float32(height)
+	if min, max, ok := sceneBounds(doc, scene); ok {
+		cam.Frame(min, max)
+	}
+	sr.SetCamera(cam.ViewProj(), cam.Eye())
+	sr.skins = newSceneSkins(doc, scene)
+
+	RenderScene(sr, scene)
+
+	return sr.r.Image()
+}
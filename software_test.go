@@ -0,0 +1,106 @@
+package main
+
+import (
+	"flag"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden images in testdata instead of comparing against them")
+
+// goldenTolerance is how far each channel may be from the golden image, to allow for floating point differences
+// between platforms.
+const goldenTolerance = 3
+
+// cubeDocument is a unit cube with a red dielectric material. It has no normals, so every face is flat shaded and the
+// three visible faces come out in distinct colors.
+const cubeDocument = `{
+	"bufferViews": [
+		{"buffer": 0, "byteOffset": 0, "byteLength": 96},
+		{"buffer": 0, "byteOffset": 96, "byteLength": 72}
+	],
+	"accessors": [
+		{"bufferView": 0, "componentType": 5126, "type": "VEC3", "count": 8,
+			"min": [-0.5, -0.5, -0.5], "max": [0.5, 0.5, 0.5]},
+		{"bufferView": 1, "componentType": 5123, "type": "SCALAR", "count": 36}
+	],
+	"materials": [{"pbrMetallicRoughness": {"baseColorFactor": [0.8, 0.1, 0.1, 1], "metallicFactor": 0, "roughnessFactor": 0.5}}],
+	"meshes": [{"primitives": [{"attributes": {"POSITION": 0}, "indices": 1, "material": 0}]}],
+	"nodes": [{"mesh": 0}],
+	"scenes": [{"nodes": [0]}],
+	"scene": 0
+}`
+
+var cubeBuffer = littleEndian(
+	[]float32{
+		-0.5, -0.5, -0.5, 0.5, -0.5, -0.5, 0.5, 0.5, -0.5, -0.5, 0.5, -0.5,
+		-0.5, -0.5, 0.5, 0.5, -0.5, 0.5, 0.5, 0.5, 0.5, -0.5, 0.5, 0.5,
+	},
+	[]uint16{
+		0, 2, 1, 0, 3, 2, // -Z
+		4, 5, 6, 4, 6, 7, // +Z
+		0, 4, 7, 0, 7, 3, // -X
+		1, 2, 6, 1, 6, 5, // +X
+		0, 1, 5, 0, 5, 4, // -Y
+		3, 7, 6, 3, 6, 2, // +Y
+	},
+)
+
+func TestRenderSoftwareGolden(t *testing.T) {
+	doc := loadTestModel(t, cubeDocument, cubeBuffer)
+	got := RenderSoftware(doc, "", AnimationOptions{}, 32, 32)
+
+	golden := filepath.Join("testdata", "cube.png")
+	if *updateGolden {
+		if err := os.MkdirAll("testdata", 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := writePNG(golden, got); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	f, err := os.Open(golden)
+	if err != nil {
+		t.Fatalf("%s; run the test with -update to create it", err)
+	}
+	defer f.Close()
+	want, err := png.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Bounds() != want.Bounds() {
+		t.Fatalf("rendered a %v image, want %v", got.Bounds(), want.Bounds())
+	}
+	mismatches := 0
+	for y := 0; y < got.Bounds().Dy(); y++ {
+		for x := 0; x < got.Bounds().Dx(); x++ {
+			if !colorNear(got, want, x, y) {
+				if mismatches < 10 {
+					t.Errorf("pixel (%d, %d) is %v, want %v", x, y, got.At(x, y), want.At(x, y))
+				}
+				mismatches++
+			}
+		}
+	}
+	if mismatches > 0 {
+		t.Errorf("%d pixels differ from %s", mismatches, golden)
+	}
+}
+
+// colorNear reports whether each channel of pixel (x, y) is within goldenTolerance in the two images.
+func colorNear(a, b image.Image, x, y int) bool {
+	ar, ag, ab, aa := a.At(x, y).RGBA()
+	br, bg, bb, ba := b.At(x, y).RGBA()
+	for _, d := range []int{int(ar>>8) - int(br>>8), int(ag>>8) - int(bg>>8), int(ab>>8) - int(bb>>8), int(aa>>8) - int(ba>>8)} {
+		if d < -goldenTolerance || d > goldenTolerance {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"os"
+	"strings"
+
+	"github.com/bbredesen/gltf"
+	"github.com/bbredesen/gltf-viewer/vkctx"
+	"github.com/bbredesen/go-vk"
+)
+
+// Color textures are sRGB, as required by the spec, so the sampler returns linear values. All other material textures
+// hold linear data.
+const (
+	colorTextureFormat = vk.FORMAT_R8G8B8A8_SRGB
+	dataTextureFormat  = vk.FORMAT_R8G8B8A8_UNORM
+)
+
+// textureImage is a sampled image uploaded to device-local memory.
+type textureImage struct {
+	image  vk.Image
+	memory *vkctx.Allocation
+	view   vk.ImageView
+}
+
+// textureKey identifies an uploaded image. The same glTF image may be used for both color and non-color data, which
+// need different formats, so the format is part of the key.
+type textureKey struct {
+	img    *gltf.ResolvedImage
+	format vk.Format
+}
+
+// textureData is host-side texel data for an image with any number of mip levels and array layers.
+// layers[layer][level] holds the tightly packed texels of one mip level of one layer.
+type textureData struct {
+	width, height uint32
+	format        vk.Format
+	cube          bool
+	layers        [][][]byte
+}
+
+func nrgbaTextureData(img *image.NRGBA, format vk.Format) *textureData {
+	return &textureData{
+		width:  uint32(img.Rect.Dx()),
+		height: uint32(img.Rect.Dy()),
+		format: format,
+		layers: [][][]byte{{img.Pix}},
+	}
+}
+
+// createMaterials uploads the textures used by the scene and writes a descriptor set (set 1) for each material, with
+// one combined image sampler per textureSlot. Empty slots, including every slot of the default material used by
+// primitives without one, sample a 1x1 white texture instead.
+func (app *App) createMaterials() error {
+	app.textures = make(map[textureKey]*textureImage)
+	app.samplers = make(map[*gltf.ResolvedSampler]vk.Sampler)
+	images := newImageCache(app.modelDoc, app.ModelDir)
+	imageIndex := indexOf(app.modelDoc.Images)
+
+	white := image.NewNRGBA(image.Rect(0, 0, 1, 1))
+	copy(white.Pix, []byte{0xFF, 0xFF, 0xFF, 0xFF})
+
+	var err error
+	if app.whiteTexture, err = app.uploadTexture(nrgbaTextureData(white, dataTextureFormat), "white texture"); err != nil {
+		return err
+	}
+	if app.defaultSampler, err = app.createSampler(nil); err != nil {
+		return err
+	}
+
+	// The nil material is the default material, for primitives that don't reference one.
+	materials := []*gltf.ResolvedMaterial{nil}
+	seen := map[*gltf.ResolvedMaterial]bool{nil: true}
+	for _, p := range collectPrimitives(app.scene, make(map[*gltf.ResolvedPrimitive]bool), nil) {
+		if !seen[p.Material] {
+			seen[p.Material] = true
+			materials = append(materials, p.Material)
+		}
+	}
+
+	poolCI := vk.DescriptorPoolCreateInfo{
+		MaxSets: uint32(len(materials)),
+		PPoolSizes: []vk.DescriptorPoolSize{
+			{Type: vk.DESCRIPTOR_TYPE_COMBINED_IMAGE_SAMPLER, DescriptorCount: uint32(len(materials)) * uint32(numTextureSlots)},
+		},
+	}
+	if app.materialPool, err = vk.CreateDescriptorPool(app.Device, &poolCI, nil); err != nil {
+		return fmt.Errorf("could not create material descriptor pool: %w", err)
+	}
+
+	layouts := make([]vk.DescriptorSetLayout, len(materials))
+	for i := range layouts {
+		layouts[i] = app.materialSetLayout
+	}
+	sets, err := vk.AllocateDescriptorSets(app.Device, &vk.DescriptorSetAllocateInfo{
+		DescriptorPool: app.materialPool,
+		PSetLayouts:    layouts,
+	})
+	if err != nil {
+		return fmt.Errorf("could not allocate material descriptor sets: %w", err)
+	}
+
+	app.materialSets = make(map[*gltf.ResolvedMaterial]vk.DescriptorSet, len(materials))
+	writes := make([]vk.WriteDescriptorSet, 0, len(materials)*int(numTextureSlots))
+
+	for i, m := range materials {
+		for slot, mt := range materialTextures(m) {
+			view, sampler := app.whiteTexture.view, app.defaultSampler
+
+			if mt != nil {
+				format := dataTextureFormat
+				if textureSlot(slot).isColor() {
+					format = colorTextureFormat
+				}
+
+				src := mt.Texture.Source
+				tex, err := app.textureFor(images, src, format, imageDebugName(src, imageIndex[src]))
+				if err != nil {
+					// A missing or corrupt image shouldn't prevent the rest of the model from being shown.
+					fmt.Fprintf(os.Stderr, "warning: could not load texture: %s\n", err.Error())
+				} else {
+					view = tex.view
+					if sampler, err = app.samplerFor(mt.Texture.Sampler); err != nil {
+						return err
+					}
+				}
+			}
+
+			writes = append(writes, vk.WriteDescriptorSet{
+				DstSet:          sets[i],
+				DstBinding:      uint32(slot),
+				DstArrayElement: 0,
+				DescriptorType:  vk.DESCRIPTOR_TYPE_COMBINED_IMAGE_SAMPLER,
+				PImageInfo: []vk.DescriptorImageInfo{
+					{Sampler: sampler, ImageView: view, ImageLayout: vk.IMAGE_LAYOUT_SHADER_READ_ONLY_OPTIMAL},
+				},
+			})
+		}
+		app.materialSets[m] = sets[i]
+	}
+
+	vk.UpdateDescriptorSets(app.Device, writes, nil)
+
+	return nil
+}
+
+// textureFor returns the uploaded copy of img in the given format, decoding and uploading it on first use. name labels
+// the image for debugging tools, see imageDebugName.
+func (app *App) textureFor(images *imageCache, img *gltf.ResolvedImage, format vk.Format, name string) (*textureImage, error) {
+	key := textureKey{img, format}
+	if tex, ok := app.textures[key]; ok {
+		return tex, nil
+	}
+
+	pix, err := images.get(img)
+	if err != nil {
+		return nil, err
+	}
+
+	tex, err := app.uploadTexture(nrgbaTextureData(pix, format), name)
+	if err != nil {
+		return nil, err
+	}
+	app.textures[key] = tex
+	return tex, nil
+}
+
+// uploadTexture creates a device-local image for td and queues the copy of its data into it, through a host-visible
+// staging buffer, like createBufferWithData. The image is in SHADER_READ_ONLY_OPTIMAL layout once the copy completes.
+// name labels the image and its view for debugging tools.
+func (app *App) uploadTexture(td *textureData, name string) (tex *textureImage, err error) {
+	if app.uploads == nil {
+		if err = app.beginUploads(); err != nil {
+			return nil, err
+		}
+		defer func() {
+			if flushErr := app.flushUploads(); err == nil {
+				err = flushErr
+			}
+		}()
+	}
+
+	extent := vk.Extent2D{Width: td.width, Height: td.height}
+	layerCount, levelCount := uint32(len(td.layers)), uint32(len(td.layers[0]))
+
+	var data []byte
+	for _, layer := range td.layers {
+		for _, level := range layer {
+			data = append(data, level...)
+		}
+	}
+	staging, err := app.stage(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var flags vk.ImageCreateFlags
+	viewType := vk.IMAGE_VIEW_TYPE_2D
+	if td.cube {
+		flags, viewType = vk.IMAGE_CREATE_CUBE_COMPATIBLE_BIT, vk.IMAGE_VIEW_TYPE_CUBE
+	}
+
+	// The view is created before any commands refer to the image, so that on failure the image can be destroyed
+	// without invalidating the batch.
+	tex = &textureImage{}
+	if tex.image, tex.memory, err = app.CreateSampledImage(extent, td.format, levelCount, layerCount, flags); err != nil {
+		return nil, err
+	}
+	if tex.view, err = app.CreateSampledImageView(tex.image, td.format, viewType, levelCount, layerCount); err != nil {
+		vk.DestroyImage(app.Device, tex.image, nil)
+		app.Free(tex.memory)
+		return nil, err
+	}
+	app.SetObjectName(vk.OBJECT_TYPE_IMAGE, uint64(tex.image), name)
+	app.SetObjectName(vk.OBJECT_TYPE_IMAGE_VIEW, uint64(tex.view), name)
+
+	// The copy goes on the transfer queue, and the transition for sampling on the graphics queue, see uploadBatch.
+	cb := app.uploads.transfer
+	app.TransitionImageLayout(cb, tex.image, levelCount, layerCount, vk.IMAGE_LAYOUT_UNDEFINED, vk.IMAGE_LAYOUT_TRANSFER_DST_OPTIMAL)
+
+	var offset int
+	for layer := range td.layers {
+		for level, data := range td.layers[layer] {
+			levelExtent := vk.Extent2D{Width: mipSize(td.width, level), Height: mipSize(td.height, level)}
+			app.CopyBufferToImage(cb, staging, vk.DeviceSize(offset), tex.image, uint32(level), uint32(layer), levelExtent)
+			offset += len(data)
+		}
+	}
+
+	app.TransitionImageLayout(app.uploads.graphics, tex.image, levelCount, layerCount, vk.IMAGE_LAYOUT_TRANSFER_DST_OPTIMAL, vk.IMAGE_LAYOUT_SHADER_READ_ONLY_OPTIMAL)
+
+	return tex, nil
+}
+
+// imageDebugName names a glTF image for SetObjectName, by its index in the document and its name, or by URI if it has
+// no name.
+func imageDebugName(img *gltf.ResolvedImage, index int) string {
+	name := img.Name
+	if name == "" && !strings.HasPrefix(img.Uri, "data:") {
+		name = img.Uri
+	}
+	return debugName("image", index, name)
+}
+
+// mipSize returns the size of mip level n of an image dimension.
+func mipSize(size uint32, n int) uint32 {
+	if s := size >> n; s > 0 {
+		return s
+	}
+	return 1
+}
+
+// samplerFor returns the vk.Sampler for a glTF sampler, creating it on first use. A nil sampler means the glTF
+// defaults, i.e. repeat wrapping with implementation-chosen filtering.
+func (app *App) samplerFor(s *gltf.ResolvedSampler) (vk.Sampler, error) {
+	if s == nil {
+		return app.defaultSampler, nil
+	}
+	if sampler, ok := app.samplers[s]; ok {
+		return sampler, nil
+	}
+
+	sampler, err := app.createSampler(s)
+	if err != nil {
+		return sampler, err
+	}
+	app.samplers[s] = sampler
+	return sampler, nil
+}
+
+func (app *App) createSampler(s *gltf.ResolvedSampler) (vk.Sampler, error) {
+	samplerCI := vk.SamplerCreateInfo{
+		MagFilter:    vk.FILTER_LINEAR,
+		MinFilter:    vk.FILTER_LINEAR,
+		MipmapMode:   vk.SAMPLER_MIPMAP_MODE_LINEAR,
+		AddressModeU: vk.SAMPLER_ADDRESS_MODE_REPEAT,
+		AddressModeV: vk.SAMPLER_ADDRESS_MODE_REPEAT,
+		AddressModeW: vk.SAMPLER_ADDRESS_MODE_REPEAT,
+		CompareOp:    vk.COMPARE_OP_ALWAYS,
+		BorderColor:  vk.BORDER_COLOR_INT_OPAQUE_BLACK,
+		MinLod:       0,
+		MaxLod:       0, // Textures are uploaded without mip levels
+	}
+
+	if s != nil {
+		if int(s.MagFilter) == gltfNearest {
+			samplerCI.MagFilter = vk.FILTER_NEAREST
+		}
+
+		switch int(s.MinFilter) {
+		case gltfNearest, gltfNearestMipmapNearest:
+			samplerCI.MinFilter, samplerCI.MipmapMode = vk.FILTER_NEAREST, vk.SAMPLER_MIPMAP_MODE_NEAREST
+		case gltfNearestMipmapLinear:
+			samplerCI.MinFilter, samplerCI.MipmapMode = vk.FILTER_NEAREST, vk.SAMPLER_MIPMAP_MODE_LINEAR
+		case gltfLinearMipmapNearest:
+			samplerCI.MinFilter, samplerCI.MipmapMode = vk.FILTER_LINEAR, vk.SAMPLER_MIPMAP_MODE_NEAREST
+		}
+
+		samplerCI.AddressModeU = samplerAddressMode(int(s.WrapS))
+		samplerCI.AddressModeV = samplerAddressMode(int(s.WrapT))
+	}
+
+	// Anisotropy is only worth having for smooth filtering; nearest filtering is usually chosen for pixel art. Some
+	// software drivers don't support it at all.
+	if app.SamplerAnisotropy && samplerCI.MagFilter == vk.FILTER_LINEAR && samplerCI.MinFilter == vk.FILTER_LINEAR {
+		samplerCI.AnisotropyEnable = true
+		samplerCI.MaxAnisotropy = vk.GetPhysicalDeviceProperties(app.PhysicalDevice).Limits.MaxSamplerAnisotropy
+	}
+
+	sampler, err := vk.CreateSampler(app.Device, &samplerCI, nil)
+	if err != nil {
+		return sampler, fmt.Errorf("could not create sampler: %w", err)
+	}
+	return sampler, nil
+}
+
+func samplerAddressMode(wrap int) vk.SamplerAddressMode {
+	switch wrap {
+	case gltfClampToEdge:
+		return vk.SAMPLER_ADDRESS_MODE_CLAMP_TO_EDGE
+	case gltfMirroredRepeat:
+		return vk.SAMPLER_ADDRESS_MODE_MIRRORED_REPEAT
+	}
+	return vk.SAMPLER_ADDRESS_MODE_REPEAT
+}
+
+func (app *App) destroyTexture(tex *textureImage) {
+	vk.DestroyImageView(app.Device, tex.view, nil)
+	vk.DestroyImage(app.Device, tex.image, nil)
+	app.Free(tex.memory)
+}
+
+func (app *App) destroyMaterials() {
+	vk.DestroyDescriptorPool(app.Device, app.materialPool, nil)
+	app.materialSets = nil
+
+	for _, sampler := range app.samplers {
+		vk.DestroySampler(app.Device, sampler, nil)
+	}
+	app.samplers = nil
+	vk.DestroySampler(app.Device, app.defaultSampler, nil)
+
+	for _, tex := range app.textures {
+		app.destroyTexture(tex)
+	}
+	app.textures = nil
+	if app.whiteTexture != nil {
+		app.destroyTexture(app.whiteTexture)
+		app.whiteTexture = nil
+	}
+}
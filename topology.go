@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/bbredesen/gltf"
+	"github.com/bbredesen/go-vk"
+)
+
+// portabilitySubsetExtension is exposed by implementations layered on other APIs, e.g. MoltenVK, which may not support
+// triangle fans.
+const portabilitySubsetExtension = "VK_KHR_portability_subset"
+
+// primitiveTopology returns the topology to draw a primitive mode with. LINE_LOOP has no Vulkan equivalent, and is
+// drawn as a LINE_STRIP with the first vertex repeated at the end, see primitiveIndices.
+func primitiveTopology(p *gltf.ResolvedPrimitive) vk.PrimitiveTopology {
+	switch p.Mode {
+	case gltf.POINTS:
+		return vk.PRIMITIVE_TOPOLOGY_POINT_LIST
+	case gltf.LINES:
+		return vk.PRIMITIVE_TOPOLOGY_LINE_LIST
+	case gltf.LINE_LOOP, gltf.LINE_STRIP:
+		return vk.PRIMITIVE_TOPOLOGY_LINE_STRIP
+	case gltf.TRIANGLE_STRIP:
+		return vk.PRIMITIVE_TOPOLOGY_TRIANGLE_STRIP
+	case gltf.TRIANGLE_FAN:
+		return vk.PRIMITIVE_TOPOLOGY_TRIANGLE_FAN
+	}
+	return vk.PRIMITIVE_TOPOLOGY_TRIANGLE_LIST
+}
+
+// vertexCount returns the number of vertices of a primitive, which is the count of its POSITION accessor.
+func vertexCount(p *gltf.ResolvedPrimitive) int {
+	if pos := p.Attributes[gltf.POSITION]; pos != nil {
+		return pos.Count
+	}
+	return 0
+}
+
+// sequentialIndices returns the indices 0 to count-1, for converting non-indexed primitives.
+func sequentialIndices(count int) []uint32 {
+	rval := make([]uint32, count)
+	for i := range rval {
+		rval[i] = uint32(i)
+	}
+	return rval
+}
+
+// closeLineLoop returns the indices of a line loop as a line strip, by repeating the first index at the end.
+func closeLineLoop(indices []uint32) []uint32 {
+	if len(indices) < 2 {
+		return indices
+	}
+	return append(append([]uint32(nil), indices...), indices[0])
+}
+
+// triangleStripToList returns the indices of a triangle strip as a triangle list. Every other triangle has its first
+// two vertices swapped, so that all of them keep the winding order of the first.
+func triangleStripToList(indices []uint32) []uint32 {
+	if len(indices) < 3 {
+		return nil
+	}
+	rval := make([]uint32, 0, (len(indices)-2)*3)
+	for i := 2; i < len(indices); i++ {
+		if i%2 == 0 {
+			rval = append(rval, indices[i-2], indices[i-1], indices[i])
+		} else {
+			rval = append(rval, indices[i-1], indices[i-2], indices[i])
+		}
+	}
+	return rval
+}
+
+// triangleFanToList returns the indices of a triangle fan as a triangle list.
+func triangleFanToList(indices []uint32) []uint32 {
+	if len(indices) < 3 {
+		return nil
+	}
+	rval := make([]uint32, 0, (len(indices)-2)*3)
+	for i := 2; i < len(indices); i++ {
+		rval = append(rval, indices[i-1], indices[i], indices[0])
+	}
+	return rval
+}
+
+// triangleListIndices returns the indices of a triangle primitive as a triangle list, or nil for points and lines.
+// Non-indexed primitives are indexed first, except for triangle lists, which need no conversion; ok is false only for
+// points and lines.
+func triangleListIndices(p *gltf.ResolvedPrimitive, indices []uint32) (rval []uint32, ok bool) {
+	if p.Mode != gltf.TRIANGLES && p.Mode != gltf.TRIANGLE_STRIP && p.Mode != gltf.TRIANGLE_FAN {
+		return nil, false
+	}
+	if p.Mode == gltf.TRIANGLES {
+		return indices, true
+	}
+	if indices == nil {
+		indices = sequentialIndices(vertexCount(p))
+	}
+	if p.Mode == gltf.TRIANGLE_STRIP {
+		return triangleStripToList(indices), true
+	}
+	return triangleFanToList(indices), true
+}
+
+// supportsTriangleFans reports whether the device can draw TRIANGLE_FAN. Fans are core Vulkan, but optional on
+// portability subset implementations; rather than query the feature, fans are converted to lists on any of them.
+func (app *App) supportsTriangleFans() bool {
+	extensions, err := vk.EnumerateDeviceExtensionProperties(app.PhysicalDevice, "")
+	if err != nil {
+		return false
+	}
+	for _, ext := range extensions {
+		if ext.ExtensionName == portabilitySubsetExtension {
+			return false
+		}
+	}
+	return true
+}
+
+// primitiveIndices sets up the topology and index buffer of a primitive. Indices are bound straight from the glTF
+// buffer when Vulkan can use them as they are. Otherwise they are converted on load: UNSIGNED_BYTE indices, which
+// would need VK_EXT_index_type_uint8, sparse indices, line loops, and triangle fans when fans is false.
+func (app *App) primitiveIndices(p *gltf.ResolvedPrimitive, res *primitiveResources, fans bool) error {
+	res.topology = primitiveTopology(p)
+	convertFan := p.Mode == gltf.TRIANGLE_FAN && !fans
+
+	if p.Mode != gltf.LINE_LOOP && !convertFan {
+		if p.Indices == nil {
+			res.vertexCount = uint32(vertexCount(p))
+			return nil
+		}
+
+		acc := p.Indices
+		if acc.Sparse == nil && acc.BufferView != nil && acc.ComponentType != gltf.UNSIGNED_BYTE {
+			if err := checkAccessor(app.modelDoc, acc); err != nil {
+				return fmt.Errorf("%s indices: %w", res.name, err)
+			}
+			res.indexBuffer = app.buffers[acc.BufferView.BufferView.Buffer]
+			res.indexOffset = vk.DeviceSize(acc.ByteOffset + acc.BufferView.ByteOffset)
+			res.indexType = vk.INDEX_TYPE_UINT32
+			if acc.ComponentType == gltf.UNSIGNED_SHORT {
+				res.indexType = vk.INDEX_TYPE_UINT16
+			}
+			res.indexCount = uint32(acc.Count)
+			return nil
+		}
+	}
+
+	indices, err := readIndices(app.modelDoc, p.Indices)
+	if err != nil {
+		return fmt.Errorf("%s indices: %w", res.name, err)
+	}
+	if indices == nil {
+		indices = sequentialIndices(vertexCount(p))
+	}
+	if p.Mode == gltf.LINE_LOOP {
+		indices = closeLineLoop(indices)
+	}
+	if convertFan {
+		indices = triangleFanToList(indices)
+		res.topology = vk.PRIMITIVE_TOPOLOGY_TRIANGLE_LIST
+	}
+	if len(indices) == 0 {
+		return nil
+	}
+
+	// 16 bit indices are enough for most primitives, including all converted from UNSIGNED_BYTE.
+	var data []byte
+	res.indexType = vk.INDEX_TYPE_UINT16
+	for _, i := range indices {
+		if i > 0xFFFF {
+			res.indexType = vk.INDEX_TYPE_UINT32
+			break
+		}
+	}
+	for _, i := range indices {
+		if res.indexType == vk.INDEX_TYPE_UINT16 {
+			data = binary.LittleEndian.AppendUint16(data, uint16(i))
+		} else {
+			data = binary.LittleEndian.AppendUint32(data, i)
+		}
+	}
+
+	buf, mem, err := app.createBufferWithData(vk.BUFFER_USAGE_INDEX_BUFFER_BIT, data)
+	if err != nil {
+		return err
+	}
+	app.SetObjectName(vk.OBJECT_TYPE_BUFFER, uint64(buf), res.name+" indices")
+	res.convertedIndices = &convertedAttribute{buffer: buf, memory: mem}
+	res.indexBuffer, res.indexOffset = buf, 0
+	res.indexCount = uint32(len(indices))
+	return nil
+}
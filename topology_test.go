@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/bbredesen/gltf"
+)
+
+// signedArea returns twice the signed area of each triangle in indices, with vertices taken from points. All of them
+// have the same sign if the triangles have the same winding order.
+func signedArea(points [][2]float32, indices []uint32) []float32 {
+	var rval []float32
+	for i := 0; i+2 < len(indices); i += 3 {
+		a, b, c := points[indices[i]], points[indices[i+1]], points[indices[i+2]]
+		rval = append(rval, (b[0]-a[0])*(c[1]-a[1])-(c[0]-a[0])*(b[1]-a[1]))
+	}
+	return rval
+}
+
+func TestIndexConversion(t *testing.T) {
+	tests := []struct {
+		name    string
+		convert func([]uint32) []uint32
+		indices []uint32
+		want    []uint32
+	}{
+		{"loop", closeLineLoop, []uint32{4, 5, 6}, []uint32{4, 5, 6, 4}},
+		// A single point or nothing has no line to close.
+		{"loop of one", closeLineLoop, []uint32{4}, []uint32{4}},
+		{"empty loop", closeLineLoop, nil, nil},
+		// Odd triangles of a strip swap their first two vertices.
+		{"strip", triangleStripToList, []uint32{0, 1, 2, 3, 4}, []uint32{0, 1, 2, 2, 1, 3, 2, 3, 4}},
+		{"strip of one triangle", triangleStripToList, []uint32{7, 8, 9}, []uint32{7, 8, 9}},
+		{"short strip", triangleStripToList, []uint32{0, 1}, nil},
+		{"fan", triangleFanToList, []uint32{0, 1, 2, 3, 4}, []uint32{1, 2, 0, 2, 3, 0, 3, 4, 0}},
+		{"fan of one triangle", triangleFanToList, []uint32{7, 8, 9}, []uint32{8, 9, 7}},
+		{"short fan", triangleFanToList, []uint32{0, 1}, nil},
+		{"empty fan", triangleFanToList, nil, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.convert(test.indices); fmt.Sprint(got) != fmt.Sprint(test.want) {
+				t.Errorf("converted %v to %v, want %v", test.indices, got, test.want)
+			}
+		})
+	}
+
+	// closeLineLoop copies, rather than appending into the backing array of the indices it was given.
+	backing := []uint32{1, 2, 3}
+	closeLineLoop(backing[:2])
+	if backing[2] != 3 {
+		t.Errorf("closeLineLoop overwrote the element past its indices with %d", backing[2])
+	}
+}
+
+func TestConversionWinding(t *testing.T) {
+	// A counterclockwise zigzag for the strip, and a counterclockwise polygon for the fan, so that the first
+	// triangle of each is counterclockwise, with a positive area.
+	strip := [][2]float32{{0, 0}, {1, 0}, {0, 1}, {1, 1}, {0, 2}, {1, 2}}
+	fan := [][2]float32{{0, 0}, {2, 0}, {2, 1}, {1, 2}, {0, 2}}
+
+	for _, test := range []struct {
+		name   string
+		points [][2]float32
+		list   []uint32
+	}{
+		{"strip", strip, triangleStripToList(sequentialIndices(len(strip)))},
+		{"fan", fan, triangleFanToList(sequentialIndices(len(fan)))},
+	} {
+		areas := signedArea(test.points, test.list)
+		if len(areas) != len(test.points)-2 {
+			t.Errorf("%s: %d triangles, want %d", test.name, len(areas), len(test.points)-2)
+		}
+		for i, area := range areas {
+			if area <= 0 {
+				t.Errorf("%s: triangle %d is clockwise, want all counterclockwise like the first", test.name, i)
+			}
+		}
+	}
+}
+
+func TestTriangleListIndices(t *testing.T) {
+	primitive := func(mode gltf.ModeEnum, vertices int) *gltf.ResolvedPrimitive {
+		position := &gltf.ResolvedAccessor{}
+		position.Count = vertices
+		p := &gltf.ResolvedPrimitive{}
+		p.Mode = mode
+		p.Attributes = map[gltf.AttributeKey]*gltf.ResolvedAccessor{gltf.POSITION: position}
+		return p
+	}
+
+	tests := []struct {
+		name    string
+		p       *gltf.ResolvedPrimitive
+		indices []uint32
+		want    []uint32
+		wantOK  bool
+	}{
+		// Triangle lists are used as they are, and non-indexed ones stay non-indexed.
+		{"list", primitive(gltf.TRIANGLES, 3), []uint32{2, 1, 0}, []uint32{2, 1, 0}, true},
+		{"non-indexed list", primitive(gltf.TRIANGLES, 3), nil, nil, true},
+		{"strip", primitive(gltf.TRIANGLE_STRIP, 4), []uint32{3, 2, 1, 0}, []uint32{3, 2, 1, 1, 2, 0}, true},
+		{"non-indexed strip", primitive(gltf.TRIANGLE_STRIP, 4), nil, []uint32{0, 1, 2, 2, 1, 3}, true},
+		{"fan", primitive(gltf.TRIANGLE_FAN, 4), []uint32{3, 2, 1, 0}, []uint32{2, 1, 3, 1, 0, 3}, true},
+		{"non-indexed fan", primitive(gltf.TRIANGLE_FAN, 4), nil, []uint32{1, 2, 0, 2, 3, 0}, true},
+		// Too few vertices for a triangle is not an error, just nothing to draw.
+		{"short strip", primitive(gltf.TRIANGLE_STRIP, 2), nil, nil, true},
+		{"short fan", primitive(gltf.TRIANGLE_FAN, 4), []uint32{0, 1}, nil, true},
+		{"points", primitive(gltf.POINTS, 3), nil, nil, false},
+		{"lines", primitive(gltf.LINES, 4), []uint32{0, 1, 2, 3}, nil, false},
+		{"line loop", primitive(gltf.LINE_LOOP, 3), nil, nil, false},
+		{"line strip", primitive(gltf.LINE_STRIP, 3), nil, nil, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, ok := triangleListIndices(test.p, test.indices)
+			if ok != test.wantOK || fmt.Sprint(got) != fmt.Sprint(test.want) {
+				t.Errorf("triangleListIndices = %v, %v; want %v, %v", got, ok, test.want, test.wantOK)
+			}
+		})
+	}
+}
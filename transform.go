@@ -0,0 +1,195 @@
+package main
+
+import (
+	"unsafe"
+
+	"github.com/bbredesen/gltf"
+	"github.com/bbredesen/vkm"
+)
+
+// mat4 is a column-major 4x4 matrix with the same memory layout as vkm.Mat and a GLSL mat4. glTF also stores node
+// matrices in column-major order, so element (row r, column c) is at index c*4+r.
+type mat4 [16]float32
+
+func identity4() mat4 {
+	return mat4{
+		1, 0, 0, 0,
+		0, 1, 0, 0,
+		0, 0, 1, 0,
+		0, 0, 0, 1,
+	}
+}
+
+func fromVkm(m vkm.Mat) mat4 {
+	return *(*mat4)(unsafe.Pointer(&m))
+}
+
+func (m mat4) toVkm() vkm.Mat {
+	return *(*vkm.Mat)(unsafe.Pointer(&m))
+}
+
+// mul returns m*o, i.e. o is applied first when transforming a column vector.
+func (m mat4) mul(o mat4) mat4 {
+	var r mat4
+	for c := 0; c < 4; c++ {
+		for row := 0; row < 4; row++ {
+			var sum float32
+			for k := 0; k < 4; k++ {
+				sum += m[k*4+row] * o[c*4+k]
+			}
+			r[c*4+row] = sum
+		}
+	}
+	return r
+}
+
+// transformPoint applies m to the point p (w = 1) and returns the result without a perspective divide.
+func (m mat4) transformPoint(p [3]float32) [3]float32 {
+	return [3]float32{
+		m[0]*p[0] + m[4]*p[1] + m[8]*p[2] + m[12],
+		m[1]*p[0] + m[5]*p[1] + m[9]*p[2] + m[13],
+		m[2]*p[0] + m[6]*p[1] + m[10]*p[2] + m[14],
+	}
+}
+
+// transformVec4 applies m to v, e.g. to get a clip-space position.
+func (m mat4) transformVec4(v [4]float32) [4]float32 {
+	var r [4]float32
+	for row := 0; row < 4; row++ {
+		r[row] = m[row]*v[0] + m[4+row]*v[1] + m[8+row]*v[2] + m[12+row]*v[3]
+	}
+	return r
+}
+
+// transformDir applies the upper 3x3 of m to the direction d, ignoring translation.
+func (m mat4) transformDir(d [3]float32) [3]float32 {
+	return [3]float32{
+		m[0]*d[0] + m[4]*d[1] + m[8]*d[2],
+		m[1]*d[0] + m[5]*d[1] + m[9]*d[2],
+		m[2]*d[0] + m[6]*d[1] + m[10]*d[2],
+	}
+}
+
+// normalMatrix returns the inverse transpose of the upper 3x3 of m, which transforms normals correctly under
+// non-uniform scale. It is returned as a mat4 with no translation, for use with transformDir. The result is not
+// normalized; callers normalize the transformed normal.
+func (m mat4) normalMatrix() mat4 {
+	a, b, c := m[0], m[4], m[8]
+	d, e, f := m[1], m[5], m[9]
+	g, h, i := m[2], m[6], m[10]
+
+	// The cofactor matrix is the inverse transpose scaled by the determinant. Only the direction of the transformed
+	// normal matters, apart from its sign, so the determinant is only used for that.
+	cof := mat4{
+		e*i - f*h, -(b*i - c*h), b*f - c*e, 0,
+		-(d*i - f*g), a*i - c*g, -(a*f - c*d), 0,
+		d*h - e*g, -(a*h - b*g), a*e - b*d, 0,
+		0, 0, 0, 1,
+	}
+	if det := a*cof[0] + b*cof[4] + c*cof[8]; det < 0 {
+		for k := 0; k < 11; k++ {
+			cof[k] = -cof[k]
+		}
+	}
+	return cof
+}
+
+// inverse returns the inverse of m, or the identity if m is singular.
+func (m mat4) inverse() mat4 {
+	var inv mat4
+	inv[0] = m[5]*m[10]*m[15] - m[5]*m[11]*m[14] - m[9]*m[6]*m[15] + m[9]*m[7]*m[14] + m[13]*m[6]*m[11] - m[13]*m[7]*m[10]
+	inv[4] = -m[4]*m[10]*m[15] + m[4]*m[11]*m[14] + m[8]*m[6]*m[15] - m[8]*m[7]*m[14] - m[12]*m[6]*m[11] + m[12]*m[7]*m[10]
+	inv[8] = m[4]*m[9]*m[15] - m[4]*m[11]*m[13] - m[8]*m[5]*m[15] + m[8]*m[7]*m[13] + m[12]*m[5]*m[11] - m[12]*m[7]*m[9]
+	inv[12] = -m[4]*m[9]*m[14] + m[4]*m[10]*m[13] + m[8]*m[5]*m[14] - m[8]*m[6]*m[13] - m[12]*m[5]*m[10] + m[12]*m[6]*m[9]
+	inv[1] = -m[1]*m[10]*m[15] + m[1]*m[11]*m[14] + m[9]*m[2]*m[15] - m[9]*m[3]*m[14] - m[13]*m[2]*m[11] + m[13]*m[3]*m[10]
+	inv[5] = m[0]*m[10]*m[15] - m[0]*m[11]*m[14] - m[8]*m[2]*m[15] + m[8]*m[3]*m[14] + m[12]*m[2]*m[11] - m[12]*m[3]*m[10]
+	inv[9] = -m[0]*m[9]*m[15] + m[0]*m[11]*m[13] + m[8]*m[1]*m[15] - m[8]*m[3]*m[13] - m[12]*m[1]*m[11] + m[12]*m[3]*m[9]
+	inv[13] = m[0]*m[9]*m[14] - m[0]*m[10]*m[13] - m[8]*m[1]*m[14] + m[8]*m[2]*m[13] + m[12]*m[1]*m[10] - m[12]*m[2]*m[9]
+	inv[2] = m[1]*m[6]*m[15] - m[1]*m[7]*m[14] - m[5]*m[2]*m[15] + m[5]*m[3]*m[14] + m[13]*m[2]*m[7] - m[13]*m[3]*m[6]
+	inv[6] = -m[0]*m[6]*m[15] + m[0]*m[7]*m[14] + m[4]*m[2]*m[15] - m[4]*m[3]*m[14] - m[12]*m[2]*m[7] + m[12]*m[3]*m[6]
+	inv[10] = m[0]*m[5]*m[15] - m[0]*m[7]*m[13] - m[4]*m[1]*m[15] + m[4]*m[3]*m[13] + m[12]*m[1]*m[7] - m[12]*m[3]*m[5]
+	inv[14] = -m[0]*m[5]*m[14] + m[0]*m[6]*m[13] + m[4]*m[1]*m[14] - m[4]*m[2]*m[13] - m[12]*m[1]*m[6] + m[12]*m[2]*m[5]
+	inv[3] = -m[1]*m[6]*m[11] + m[1]*m[7]*m[10] + m[5]*m[2]*m[11] - m[5]*m[3]*m[10] - m[9]*m[2]*m[7] + m[9]*m[3]*m[6]
+	inv[7] = m[0]*m[6]*m[11] - m[0]*m[7]*m[10] - m[4]*m[2]*m[11] + m[4]*m[3]*m[10] + m[8]*m[2]*m[7] - m[8]*m[3]*m[6]
+	inv[11] = -m[0]*m[5]*m[11] + m[0]*m[7]*m[9] + m[4]*m[1]*m[11] - m[4]*m[3]*m[9] - m[8]*m[1]*m[7] + m[8]*m[3]*m[5]
+	inv[15] = m[0]*m[5]*m[10] - m[0]*m[6]*m[9] - m[4]*m[1]*m[10] + m[4]*m[2]*m[9] + m[8]*m[1]*m[6] - m[8]*m[2]*m[5]
+
+	det := m[0]*inv[0] + m[1]*inv[4] + m[2]*inv[8] + m[3]*inv[12]
+	if det == 0 {
+		return identity4()
+	}
+	for i := range inv {
+		inv[i] /= det
+	}
+	return inv
+}
+
+// composeTRS builds T * R * S, where rotation is a unit quaternion stored as (x, y, z, w) per the glTF spec.
+func composeTRS(t [3]float32, r [4]float32, s [3]float32) mat4 {
+	x, y, z, w := r[0], r[1], r[2], r[3]
+
+	xx, yy, zz := x*x, y*y, z*z
+	xy, xz, yz := x*y, x*z, y*z
+	wx, wy, wz := w*x, w*y, w*z
+
+	return mat4{
+		(1 - 2*(yy+zz)) * s[0], 2 * (xy + wz) * s[0], 2 * (xz - wy) * s[0], 0,
+		2 * (xy - wz) * s[1], (1 - 2*(xx+zz)) * s[1], 2 * (yz + wx) * s[1], 0,
+		2 * (xz + wy) * s[2], 2 * (yz - wx) * s[2], (1 - 2*(xx+yy)) * s[2], 0,
+		t[0], t[1], t[2], 1,
+	}
+}
+
+// nodeTRS returns the translation, rotation, and scale of a node, substituting the glTF defaults for any property
+// that is absent from the file.
+func nodeTRS(n *gltf.ResolvedNode) (t [3]float32, r [4]float32, s [3]float32) {
+	r = [4]float32{0, 0, 0, 1}
+	s = [3]float32{1, 1, 1}
+
+	for i := range n.Translation {
+		t[i] = n.Translation[i]
+	}
+
+	var qLenSq float32
+	for i := range n.Rotation {
+		qLenSq += n.Rotation[i] * n.Rotation[i]
+	}
+	if qLenSq > 0 {
+		for i := range n.Rotation {
+			r[i] = n.Rotation[i]
+		}
+	}
+
+	var scaleSet bool
+	for i := range n.Scale {
+		scaleSet = scaleSet || n.Scale[i] != 0
+	}
+	if scaleSet {
+		for i := range n.Scale {
+			s[i] = n.Scale[i]
+		}
+	}
+
+	return
+}
+
+// nodeLocalTransform returns the node's transform relative to its parent. Per the glTF spec, a node defines either a
+// matrix or any combination of translation, rotation, and scale, never both. A matrix that is absent (or all zeros)
+// means the TRS properties are used instead.
+func nodeLocalTransform(n *gltf.ResolvedNode) mat4 {
+	if n == nil {
+		return identity4()
+	}
+
+	var m mat4
+	var matrixSet bool
+	for i := range n.Matrix {
+		m[i] = n.Matrix[i]
+		matrixSet = matrixSet || m[i] != 0
+	}
+	if matrixSet {
+		return m
+	}
+
+	return composeTRS(nodeTRS(n))
+}
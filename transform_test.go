@@ -0,0 +1,129 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/bbredesen/gltf"
+	"github.com/chewxy/math32"
+)
+
+// rotZ90 is a quarter turn about +Z, taking +X to +Y.
+var rotZ90 = [4]float32{0, 0, math32.Sqrt(2) / 2, math32.Sqrt(2) / 2}
+
+// testNode returns a node with the given TRS properties. Zero values are absent, as in a parsed file.
+func testNode(t [3]float32, r [4]float32, s [3]float32, children ...*gltf.ResolvedNode) *gltf.ResolvedNode {
+	n := &gltf.ResolvedNode{}
+	n.Translation = t
+	n.Rotation = r
+	n.Scale = s
+	n.Children = children
+	return n
+}
+
+func testMatrixNode(m mat4, children ...*gltf.ResolvedNode) *gltf.ResolvedNode {
+	n := &gltf.ResolvedNode{}
+	n.Matrix = m
+	n.Children = children
+	return n
+}
+
+func TestNodeLocalTransform(t *testing.T) {
+	translate := identity4()
+	translate[12], translate[13], translate[14] = 1, 2, 3
+
+	tests := []struct {
+		name  string
+		node  *gltf.ResolvedNode
+		point [3]float32
+		want  [3]float32
+	}{
+		{"nil node", nil, [3]float32{1, 2, 3}, [3]float32{1, 2, 3}},
+		{"no properties", testNode([3]float32{}, [4]float32{}, [3]float32{}), [3]float32{1, 2, 3}, [3]float32{1, 2, 3}},
+		{"translation", testNode([3]float32{1, 2, 3}, [4]float32{}, [3]float32{}), [3]float32{1, 1, 1}, [3]float32{2, 3, 4}},
+		{"rotation", testNode([3]float32{}, rotZ90, [3]float32{}), [3]float32{1, 0, 0}, [3]float32{0, 1, 0}},
+		{"non-uniform scale", testNode([3]float32{}, [4]float32{}, [3]float32{2, 3, 4}), [3]float32{1, 1, 1}, [3]float32{2, 3, 4}},
+		// Scale, then rotate, then translate: (1, 1, 0) scales to (2, 1, 0), rotates to (-1, 2, 0), and moves to
+		// (0, 2, 5).
+		{"TRS order", testNode([3]float32{1, 0, 5}, rotZ90, [3]float32{2, 1, 1}), [3]float32{1, 1, 0}, [3]float32{0, 2, 5}},
+		{"matrix", testMatrixNode(translate), [3]float32{1, 1, 1}, [3]float32{2, 3, 4}},
+		// A column-major matrix with a non-uniform scale and a translation in the last column.
+		{"scaling matrix", testMatrixNode(mat4{2, 0, 0, 0, 0, 3, 0, 0, 0, 0, 4, 0, 1, 1, 1, 1}), [3]float32{1, 1, 1}, [3]float32{3, 4, 5}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := nodeLocalTransform(test.node).transformPoint(test.point)
+			if !floatsNear(got[:], test.want[:]) {
+				t.Errorf("transformed %v to %v, want %v", test.point, got, test.want)
+			}
+		})
+	}
+}
+
+func TestSceneWorldTransforms(t *testing.T) {
+	// The parent scales non-uniformly, rotates, and translates. The child has its own non-uniform scale and
+	// translation, and the grandchild is a matrix node.
+	grandchild := testMatrixNode(mat4{1, 0, 0, 0, 0, 1, 0, 0, 0, 0, 1, 0, 0, 0, 5, 1})
+	child := testNode([3]float32{0, 1, 0}, [4]float32{}, [3]float32{1, 3, 1}, grandchild)
+	parent := testNode([3]float32{1, 0, 0}, rotZ90, [3]float32{2, 1, 1}, child)
+	sibling := testNode([3]float32{0, 0, -1}, [4]float32{}, [3]float32{})
+
+	scene := &gltf.ResolvedScene{}
+	scene.Nodes = []*gltf.ResolvedNode{parent, sibling}
+	root := NewScene(scene)
+	nodes := indexSceneNodes(root)
+
+	tests := []struct {
+		name  string
+		node  *gltf.ResolvedNode
+		point [3]float32
+		want  [3]float32
+	}{
+		// (1, 1, 0) scales to (2, 1, 0), rotates to (-1, 2, 0), and moves to (0, 2, 0).
+		{"parent", parent, [3]float32{1, 1, 0}, [3]float32{0, 2, 0}},
+		// The child takes (1, 1, 0) to (1, 4, 0), then the parent takes that to (-4, 2, 0) and (-3, 2, 0).
+		{"child", child, [3]float32{1, 1, 0}, [3]float32{-3, 2, 0}},
+		// The origin moves to (0, 0, 5), then (0, 1, 5) in the child, then (-1, 0, 5) and (0, 0, 5) in the parent.
+		{"grandchild", grandchild, [3]float32{0, 0, 0}, [3]float32{0, 0, 5}},
+		{"grandchild x axis", grandchild, [3]float32{1, 0, 0}, [3]float32{0, 2, 5}},
+		{"sibling", sibling, [3]float32{1, 1, 1}, [3]float32{1, 1, 0}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			n := nodes[test.node]
+			if n == nil {
+				t.Fatal("node is not in the scene")
+			}
+			got := fromVkm(n.CurrentTransform).transformPoint(test.point)
+			if !floatsNear(got[:], test.want[:]) {
+				t.Errorf("transformed %v to %v, want %v", test.point, got, test.want)
+			}
+		})
+	}
+
+	// Moving the parent moves its descendants once the transforms are updated.
+	nodes[parent].BaseTransform = composeTRS([3]float32{1, 0, 10}, rotZ90, [3]float32{2, 1, 1}).toVkm()
+	root.UpdateTransforms()
+	got := fromVkm(nodes[grandchild].CurrentTransform).transformPoint([3]float32{})
+	if want := [3]float32{0, 0, 15}; !floatsNear(got[:], want[:]) {
+		t.Errorf("after moving the parent, grandchild origin is at %v, want %v", got, want)
+	}
+}
+
+func TestNormalMatrixNonUniformScale(t *testing.T) {
+	// Under a scale of 2 along X, the plane x = y becomes 2y = x, with normal (1, -2, 0). transformDir with a plain
+	// scale would give (2, -1, 0) instead. The normal matrix isn't normalized, so the length is not checked.
+	m := composeTRS([3]float32{5, 6, 7}, [4]float32{0, 0, 0, 1}, [3]float32{2, 1, 1})
+	n := m.normalMatrix().transformDir([3]float32{1, -1, 0})
+	want := [3]float32{1, -2, 0}
+	if math32.Abs(n[0]*want[1]-n[1]*want[0]) > animationEpsilon || n[2] != 0 || n[0] <= 0 {
+		t.Errorf("normal transformed to %v, want a positive multiple of %v", n, want)
+	}
+
+	// A mirroring scale makes the determinant negative, and the normal is mirrored along with the geometry.
+	n = composeTRS([3]float32{}, [4]float32{0, 0, 0, 1}, [3]float32{-1, 1, 1}).normalMatrix().transformDir([3]float32{1, 0, 0})
+	if want := [3]float32{-1, 0, 0}; !floatsNear(n[:], want[:]) {
+		t.Errorf("mirrored normal transformed to %v, want %v", n, want)
+	}
+}
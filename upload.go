@@ -0,0 +1,211 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/bbredesen/gltf-viewer/vkctx"
+	"github.com/bbredesen/go-vk"
+)
+
+// uploadBatch collects the copies from host data into device-local buffers and images while a model loads, so that
+// they can be submitted together. Copies run on the dedicated transfer queue if there is one. Images are then moved
+// to SHADER_READ_ONLY_OPTIMAL on the graphics queue, since transfer queues can't synchronize with shader stages.
+type uploadBatch struct {
+	transfer, graphics vk.CommandBuffer
+
+	// Staging buffers are kept until the copies out of them have completed.
+	staging []stagingBuffer
+}
+
+type stagingBuffer struct {
+	buffer vk.Buffer
+	memory *vkctx.Allocation
+}
+
+// beginUploads starts collecting uploads, until flushUploads submits them. Without a batch in progress, every upload
+// is submitted on its own.
+func (app *App) beginUploads() error {
+	var batch uploadBatch
+	var err error
+	if batch.graphics, err = app.beginCommands(app.CommandPool); err != nil {
+		return err
+	}
+	// With no transfer queue, copies are recorded straight into the graphics command buffer.
+	batch.transfer = batch.graphics
+	if app.HasTransferQueue {
+		if batch.transfer, err = app.beginCommands(app.TransferCommandPool); err != nil {
+			vk.FreeCommandBuffers(app.Device, app.CommandPool, []vk.CommandBuffer{batch.graphics})
+			return err
+		}
+	}
+	app.uploads = &batch
+	return nil
+}
+
+func (app *App) beginCommands(pool vk.CommandPool) (vk.CommandBuffer, error) {
+	bufs, err := vk.AllocateCommandBuffers(app.Device, &vk.CommandBufferAllocateInfo{
+		CommandPool:        pool,
+		Level:              vk.COMMAND_BUFFER_LEVEL_PRIMARY,
+		CommandBufferCount: 1,
+	})
+	if err != nil {
+		return vk.CommandBuffer(vk.NULL_HANDLE), fmt.Errorf("could not allocate upload command buffer: %w", err)
+	}
+	if err := vk.BeginCommandBuffer(bufs[0], &vk.CommandBufferBeginInfo{Flags: vk.COMMAND_BUFFER_USAGE_ONE_TIME_SUBMIT_BIT}); err != nil {
+		vk.FreeCommandBuffers(app.Device, pool, bufs)
+		return vk.CommandBuffer(vk.NULL_HANDLE), fmt.Errorf("could not begin upload command buffer: %w", err)
+	}
+	return bufs[0], nil
+}
+
+// flushUploads submits the collected uploads, waits for them to complete, and frees the staging buffers. Afterwards,
+// all uploaded data is visible to every shader stage and to vertex and index fetch.
+func (app *App) flushUploads() error {
+	batch := app.uploads
+	if batch == nil {
+		return nil
+	}
+	app.uploads = nil
+
+	defer func() {
+		for _, s := range batch.staging {
+			vk.DestroyBuffer(app.Device, s.buffer, nil)
+			app.Free(s.memory)
+		}
+		if app.HasTransferQueue {
+			vk.FreeCommandBuffers(app.Device, app.TransferCommandPool, []vk.CommandBuffer{batch.transfer})
+		}
+		vk.FreeCommandBuffers(app.Device, app.CommandPool, []vk.CommandBuffer{batch.graphics})
+	}()
+
+	barrier := vk.MemoryBarrier{
+		SrcAccessMask: vk.ACCESS_TRANSFER_WRITE_BIT,
+		DstAccessMask: vk.ACCESS_VERTEX_ATTRIBUTE_READ_BIT | vk.ACCESS_INDEX_READ_BIT | vk.ACCESS_UNIFORM_READ_BIT | vk.ACCESS_SHADER_READ_BIT,
+	}
+	vk.CmdPipelineBarrier(batch.graphics, vk.PIPELINE_STAGE_TRANSFER_BIT, vk.PIPELINE_STAGE_VERTEX_INPUT_BIT|vk.PIPELINE_STAGE_VERTEX_SHADER_BIT|vk.PIPELINE_STAGE_FRAGMENT_SHADER_BIT, 0, []vk.MemoryBarrier{barrier}, nil, nil)
+
+	graphicsSubmit := vk.SubmitInfo{PCommandBuffers: []vk.CommandBuffer{batch.graphics}}
+
+	if app.HasTransferQueue {
+		if err := vk.EndCommandBuffer(batch.transfer); err != nil {
+			return fmt.Errorf("could not end upload command buffer: %w", err)
+		}
+
+		done, err := vk.CreateSemaphore(app.Device, &vk.SemaphoreCreateInfo{}, nil)
+		if err != nil {
+			return fmt.Errorf("could not create upload semaphore: %w", err)
+		}
+		defer vk.DestroySemaphore(app.Device, done, nil)
+
+		transferSubmit := vk.SubmitInfo{
+			PCommandBuffers:   []vk.CommandBuffer{batch.transfer},
+			PSignalSemaphores: []vk.Semaphore{done},
+		}
+		if err := vk.QueueSubmit(app.TransferQueue, []vk.SubmitInfo{transferSubmit}, vk.Fence(vk.NULL_HANDLE)); err != nil {
+			return fmt.Errorf("could not submit uploads: %w", err)
+		}
+
+		graphicsSubmit.PWaitSemaphores = []vk.Semaphore{done}
+		graphicsSubmit.PWaitDstStageMask = []vk.PipelineStageFlags{vk.PIPELINE_STAGE_ALL_COMMANDS_BIT}
+	}
+
+	if err := vk.EndCommandBuffer(batch.graphics); err != nil {
+		return fmt.Errorf("could not end upload command buffer: %w", err)
+	}
+	if err := vk.QueueSubmit(app.GraphicsQueue, []vk.SubmitInfo{graphicsSubmit}, vk.Fence(vk.NULL_HANDLE)); err != nil {
+		return fmt.Errorf("could not submit uploads: %w", err)
+	}
+	if err := vk.QueueWaitIdle(app.GraphicsQueue); err != nil {
+		return fmt.Errorf("waiting for uploads failed: %w", err)
+	}
+	return nil
+}
+
+// stage copies data into a new host-visible staging buffer, which is freed when the batch is flushed.
+func (app *App) stage(data []byte) (vk.Buffer, error) {
+	size := vk.DeviceSize(len(data))
+	buf, mem, err := app.createBuffer(vk.BUFFER_USAGE_TRANSFER_SRC_BIT, size, vk.MEMORY_PROPERTY_HOST_VISIBLE_BIT|vk.MEMORY_PROPERTY_HOST_COHERENT_BIT)
+	if err != nil {
+		return buf, err
+	}
+	app.uploads.staging = append(app.uploads.staging, stagingBuffer{buffer: buf, memory: mem})
+
+	ptr, err := app.MapMemory(mem)
+	if err != nil {
+		return buf, fmt.Errorf("failed to map staging buffer, result code was %w", err)
+	}
+	vk.MemCopySlice(ptr, data)
+	return buf, nil
+}
+
+// createBufferWithData creates a device-local buffer and queues a copy of data into it, which completes when the
+// current upload batch is flushed. Without a batch in progress, the copy is submitted and waited for right away. If the
+// device has no device-local memory that the buffer can use, it falls back to host-visible memory and writes data
+// directly.
+func (app *App) createBufferWithData(usage vk.BufferUsageFlags, data []byte) (buffer vk.Buffer, memory *vkctx.Allocation, err error) {
+	if app.uploads == nil {
+		if err = app.beginUploads(); err != nil {
+			return
+		}
+		defer func() {
+			if flushErr := app.flushUploads(); err == nil {
+				err = flushErr
+			}
+		}()
+	}
+	return app.uploadBuffer(usage, data)
+}
+
+func (app *App) uploadBuffer(usage vk.BufferUsageFlags, data []byte) (vk.Buffer, *vkctx.Allocation, error) {
+	if len(data) == 0 {
+		return vk.Buffer(vk.NULL_HANDLE), nil, errors.New("cannot upload an empty buffer")
+	}
+
+	sharingMode, queueFamilies := app.UploadSharing()
+	bufferCI := vk.BufferCreateInfo{
+		Size:                vk.DeviceSize(len(data)),
+		Usage:               usage | vk.BUFFER_USAGE_TRANSFER_DST_BIT,
+		SharingMode:         sharingMode,
+		PQueueFamilyIndices: queueFamilies,
+	}
+	buffer, err := vk.CreateBuffer(app.Device, &bufferCI, nil)
+	if err != nil {
+		return buffer, nil, fmt.Errorf("could not create buffer: %w", err)
+	}
+
+	memory, err := app.AllocateBuffer(buffer, vk.MEMORY_PROPERTY_DEVICE_LOCAL_BIT)
+	if err != nil {
+		// There may be no device-local memory the buffer can use, or it may be exhausted.
+		vk.DestroyBuffer(app.Device, buffer, nil)
+		return app.createHostBufferWithData(usage, data)
+	}
+
+	staging, err := app.stage(data)
+	if err != nil {
+		vk.DestroyBuffer(app.Device, buffer, nil)
+		app.Free(memory)
+		return vk.Buffer(vk.NULL_HANDLE), nil, err
+	}
+
+	vk.CmdCopyBuffer(app.uploads.transfer, staging, buffer, []vk.BufferCopy{{SrcOffset: 0, DstOffset: 0, Size: vk.DeviceSize(len(data))}})
+	return buffer, memory, nil
+}
+
+// createHostBufferWithData creates a buffer in host-visible memory and copies data into it, with no staging.
+func (app *App) createHostBufferWithData(usage vk.BufferUsageFlags, data []byte) (vk.Buffer, *vkctx.Allocation, error) {
+	vkBuf, bufMem, err := app.createBuffer(usage, vk.DeviceSize(len(data)), vk.MEMORY_PROPERTY_HOST_VISIBLE_BIT|vk.MEMORY_PROPERTY_HOST_COHERENT_BIT)
+	if err != nil {
+		return vkBuf, nil, err
+	}
+	ptr, err := app.MapMemory(bufMem)
+	if err != nil {
+		vk.DestroyBuffer(app.Device, vkBuf, nil)
+		app.Free(bufMem)
+		return vk.Buffer(vk.NULL_HANDLE), nil, fmt.Errorf("failed to map memory for buffer, result code was %w", err)
+	}
+
+	vk.MemCopySlice(ptr, data)
+
+	return vkBuf, bufMem, nil
+}
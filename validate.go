@@ -0,0 +1,557 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/bbredesen/gltf"
+	"github.com/chewxy/math32"
+)
+
+// supportedExtensions are the glTF extensions the viewer implements. Files requiring any other extension can't be
+// shown correctly.
+var supportedExtensions = map[string]bool{
+	// Integer positions, normals, tangents, texture coordinates and morph target deltas, see readComponent.
+	"KHR_mesh_quantization": true,
+}
+
+// Tolerances for the validation of floating point data. Exporters write min and max with limited precision, and
+// quantized normals are only approximately unit length.
+const (
+	minMaxTolerance     = 1e-5
+	unitLengthTolerance = 0.005
+)
+
+// diagnostic is one problem found by validate. Path points at the object with the problem, in the style of a JSON
+// pointer into the glTF, e.g. /meshes/0/primitives/1.
+type diagnostic struct {
+	Severity string `json:"severity"`
+	Code     string `json:"code"`
+	Path     string `json:"path"`
+	Message  string `json:"message"`
+}
+
+const (
+	severityError   = "error"
+	severityWarning = "warning"
+)
+
+// fileDiagnostics is the validation result of one file.
+type fileDiagnostics struct {
+	File        string       `json:"file"`
+	Errors      int          `json:"errors"`
+	Warnings    int          `json:"warnings"`
+	Diagnostics []diagnostic `json:"diagnostics"`
+}
+
+// runValidate implements "gltf-viewer validate [-strict] file...", checking that files are structurally sound and
+// that the viewer can show them, and printing the diagnostics as JSON. It returns 1 if any file has errors, or
+// warnings with -strict.
+func runValidate(args []string) int {
+	fs := flag.NewFlagSet("validate", flag.ContinueOnError)
+	strict := fs.Bool("strict", false, "fail on warnings as well as errors")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s validate [-strict] model.gltf|model.glb ...\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+
+	files, ok := parseFileArgs(fs, args)
+	if !ok {
+		return 2
+	}
+
+	var result struct {
+		Files []*fileDiagnostics `json:"files"`
+	}
+	failed := false
+	for _, f := range files {
+		d := validateFile(f)
+		result.Files = append(result.Files, d)
+		if d.Errors > 0 || (*strict && d.Warnings > 0) {
+			failed = true
+		}
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(result); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		return 1
+	}
+	if failed {
+		return 1
+	}
+	return 0
+}
+
+// parseFileArgs parses the flags of a subcommand that takes a list of files, which flags may also follow. It returns
+// false, after the flag set has reported the problem, if the flags are invalid or there are no files.
+func parseFileArgs(fs *flag.FlagSet, args []string) ([]string, bool) {
+	var files []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, false
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		files = append(files, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+	if len(files) == 0 {
+		fs.Usage()
+		return nil, false
+	}
+	return files, true
+}
+
+// validator collects the diagnostics for one document.
+type validator struct {
+	doc     *gltf.ResolvedGlTF
+	baseDir string
+	result  *fileDiagnostics
+
+	accessors map[*gltf.ResolvedAccessor]int
+	// unreadable accessors failed the bounds checks, so their data must not be read.
+	unreadable map[*gltf.ResolvedAccessor]bool
+	// views holds the result of checking each buffer view against its buffer, so that it is reported only once.
+	views map[*gltf.ResolvedBufferView]bool
+}
+
+// validateFile loads and validates one file. Files are loaded on their own, so that diagnostic paths refer to the
+// file's own arrays.
+func validateFile(filename string) *fileDiagnostics {
+	result := &fileDiagnostics{File: filename, Diagnostics: []diagnostic{}}
+	v := &validator{result: result, baseDir: filepath.Dir(filename)}
+
+	doc, err := loadModels([]string{filename})
+	if err != nil {
+		v.errorf("", "load", "%s", err.Error())
+		return result
+	}
+	v.doc = doc
+	v.accessors = indexOf(doc.Accessors)
+	v.unreadable = make(map[*gltf.ResolvedAccessor]bool)
+	v.views = make(map[*gltf.ResolvedBufferView]bool)
+
+	v.checkExtensions()
+	// Bounds come first, as the other checks read accessor data.
+	for i, acc := range doc.Accessors {
+		if !v.checkAccessorBounds(fmt.Sprintf("/accessors/%d", i), acc) {
+			v.unreadable[acc] = true
+		}
+	}
+	for i, acc := range doc.Accessors {
+		v.checkMinMax(fmt.Sprintf("/accessors/%d", i), acc)
+	}
+	for m, mesh := range doc.Meshes {
+		for p, prim := range mesh.Primitives {
+			v.checkPrimitive(fmt.Sprintf("/meshes/%d/primitives/%d", m, p), prim)
+		}
+	}
+	for i, mat := range doc.Materials {
+		v.checkMaterial(fmt.Sprintf("/materials/%d", i), mat)
+	}
+	for i, tex := range doc.Textures {
+		v.checkTexture(fmt.Sprintf("/textures/%d", i), tex)
+	}
+	v.checkAnimations()
+	return result
+}
+
+func (v *validator) add(severity, path, code, format string, args ...any) {
+	v.result.Diagnostics = append(v.result.Diagnostics, diagnostic{
+		Severity: severity,
+		Code:     code,
+		Path:     path,
+		Message:  fmt.Sprintf(format, args...),
+	})
+	if severity == severityError {
+		v.result.Errors++
+	} else {
+		v.result.Warnings++
+	}
+}
+
+func (v *validator) errorf(path, code, format string, args ...any) {
+	v.add(severityError, path, code, format, args...)
+}
+
+func (v *validator) warnf(path, code, format string, args ...any) {
+	v.add(severityWarning, path, code, format, args...)
+}
+
+func (v *validator) accessorPath(acc *gltf.ResolvedAccessor) string {
+	return fmt.Sprintf("/accessors/%d", v.accessors[acc])
+}
+
+func (v *validator) checkExtensions() {
+	for _, ext := range v.doc.ExtensionsRequired {
+		if !supportedExtensions[ext] {
+			v.errorf("/extensionsRequired", "unsupported-required-extension", "required extension %s is not supported by the viewer", ext)
+		}
+	}
+	for _, ext := range v.doc.ExtensionsUsed {
+		if !supportedExtensions[ext] {
+			v.warnf("/extensionsUsed", "unsupported-extension", "extension %s is ignored by the viewer", ext)
+		}
+	}
+}
+
+// checkAccessorBounds checks that every element of an accessor, including sparse values, lies within its buffer view
+// and buffer, and that the sparse indices are in range and strictly increasing. It returns false if reading the
+// accessor could go out of range.
+func (v *validator) checkAccessorBounds(path string, acc *gltf.ResolvedAccessor) bool {
+	compSize := componentSize(acc.ComponentType)
+	size := elementSize(acc)
+	if size == 0 {
+		v.errorf(path, "accessor-type", "invalid accessor type %v or component type %s", acc.Type, componentTypeName(acc.ComponentType))
+		return false
+	}
+	if acc.Count < 1 {
+		v.errorf(path, "accessor-count", "count is %d, must be at least 1", acc.Count)
+		return false
+	}
+
+	ok := true
+	if view := acc.BufferView; view != nil {
+		stride := view.ByteStride
+		if stride == 0 {
+			stride = size
+		} else if stride < size {
+			v.errorf(path, "accessor-stride", "byteStride %d is less than the element size %d", stride, size)
+			ok = false
+		}
+		if (view.ByteOffset+acc.ByteOffset)%compSize != 0 {
+			v.errorf(path, "accessor-alignment", "data starts at buffer offset %d, which is not a multiple of the component size %d",
+				view.ByteOffset+acc.ByteOffset, compSize)
+		}
+		if !v.checkRange(path, view, acc.ByteOffset, stride*(acc.Count-1)+size) {
+			ok = false
+		}
+	}
+
+	sparse := acc.Sparse
+	if sparse == nil {
+		return ok
+	}
+	if sparse.Count < 1 || sparse.Count > acc.Count {
+		v.errorf(path+"/sparse", "sparse-count", "sparse count %d is not in 1..%d", sparse.Count, acc.Count)
+		return false
+	}
+	if sparse.Indices.BufferView == nil || sparse.Values.BufferView == nil {
+		v.errorf(path+"/sparse", "sparse-buffer-view", "sparse indices and values must both have a buffer view")
+		return false
+	}
+	switch sparse.Indices.ComponentType {
+	case gltf.UNSIGNED_BYTE, gltf.UNSIGNED_SHORT, gltf.UNSIGNED_INT:
+	default:
+		v.errorf(path+"/sparse/indices", "sparse-index-type", "sparse indices have component type %s, must be unsigned",
+			componentTypeName(sparse.Indices.ComponentType))
+		return false
+	}
+	indexSize := componentSize(sparse.Indices.ComponentType)
+	indicesInRange := v.checkRange(path+"/sparse/indices", sparse.Indices.BufferView, sparse.Indices.ByteOffset, sparse.Count*indexSize)
+	if !v.checkRange(path+"/sparse/values", sparse.Values.BufferView, sparse.Values.ByteOffset, sparse.Count*size) {
+		ok = false
+	}
+	if !indicesInRange {
+		return false
+	}
+
+	indices := readSparseIndices(v.doc, acc)
+	for k, index := range indices {
+		if index >= acc.Count {
+			v.errorf(path+"/sparse/indices", "sparse-index-range", "sparse index %d at position %d is out of range for %d elements",
+				index, k, acc.Count)
+			return false
+		}
+		if k > 0 && index <= indices[k-1] {
+			v.errorf(path+"/sparse/indices", "sparse-index-order", "sparse index %d at position %d follows %d, indices must be strictly increasing",
+				index, k, indices[k-1])
+			return false
+		}
+	}
+	return ok
+}
+
+// checkRange checks that length bytes at offset lie within a buffer view, and that the view lies within its buffer.
+func (v *validator) checkRange(path string, view *gltf.ResolvedBufferView, offset, length int) bool {
+	viewOK, checked := v.views[view]
+	if !checked {
+		viewOK = true
+		buffer := view.BufferView.Buffer
+		switch {
+		case buffer < 0 || buffer >= len(v.doc.Buffers):
+			v.errorf(path, "buffer-view-buffer", "buffer view refers to buffer %d, which does not exist", buffer)
+			viewOK = false
+		case view.ByteOffset < 0 || view.ByteLength < 0 || view.ByteOffset+view.ByteLength > len(v.doc.Buffers[buffer].Data):
+			v.errorf(path, "buffer-view-bounds", "buffer view bytes %d..%d are outside buffer %d, which has %d bytes",
+				view.ByteOffset, view.ByteOffset+view.ByteLength, buffer, len(v.doc.Buffers[buffer].Data))
+			viewOK = false
+		}
+		v.views[view] = viewOK
+	}
+	if !viewOK {
+		return false
+	}
+
+	if offset < 0 || offset+length > view.ByteLength {
+		v.errorf(path, "accessor-bounds", "data needs bytes %d..%d of its buffer view, which has %d bytes",
+			offset, offset+length, view.ByteLength)
+		return false
+	}
+	return true
+}
+
+// checkMinMax checks that an accessor's min and max, if set, match its data. They are compared to the stored values,
+// as the spec says normalization does not apply to them.
+func (v *validator) checkMinMax(path string, acc *gltf.ResolvedAccessor) {
+	if v.unreadable[acc] || (len(acc.Min) == 0 && len(acc.Max) == 0) {
+		return
+	}
+	n := componentCount(acc.Type)
+	if (len(acc.Min) != 0 && len(acc.Min) != n) || (len(acc.Max) != 0 && len(acc.Max) != n) {
+		v.errorf(path, "accessor-min-max", "min and max must have %d components, have %d and %d", n, len(acc.Min), len(acc.Max))
+		return
+	}
+
+	data, stride, err := accessorView(v.doc, acc)
+	if err != nil {
+		v.errorf(path, "accessor-bounds", "%s", err.Error())
+		return
+	}
+	min, max := make([]float32, n), make([]float32, n)
+	for i := 0; i < acc.Count; i++ {
+		elem := data[i*stride:]
+		for c := 0; c < n; c++ {
+			x := readComponent(elem, acc.ComponentType, false, c)
+			if i == 0 || x < min[c] {
+				min[c] = x
+			}
+			if i == 0 || x > max[c] {
+				max[c] = x
+			}
+		}
+	}
+
+	compare := func(name string, declared, actual []float32) {
+		for c := range declared {
+			if math32.Abs(declared[c]-actual[c]) > minMaxTolerance*math32.Max(1, math32.Abs(actual[c])) {
+				v.errorf(path, "accessor-min-max", "%s is %v, but the data has %v", name, declared, actual)
+				return
+			}
+		}
+	}
+	compare("min", acc.Min, min)
+	compare("max", acc.Max, max)
+}
+
+// checkPrimitive checks a primitive's attributes and indices against each other, and for what the viewer can render.
+func (v *validator) checkPrimitive(path string, p *gltf.ResolvedPrimitive) {
+	supported := make(map[gltf.AttributeKey]bool, len(attrKeys))
+	for _, key := range attrKeys {
+		supported[key] = true
+	}
+
+	position := p.Attributes[gltf.POSITION]
+	if position == nil {
+		v.warnf(path, "missing-position", "primitive has no POSITION attribute and is not drawn")
+	} else if len(position.Min) == 0 || len(position.Max) == 0 {
+		v.errorf(v.accessorPath(position), "position-min-max", "POSITION accessors must have min and max")
+	}
+
+	for _, key := range sortedAttributes(p.Attributes) {
+		acc := p.Attributes[key]
+		if !supported[key] {
+			v.warnf(path+"/attributes/"+string(key), "unsupported-attribute", "attribute %s is ignored by the viewer", key)
+		}
+		if position != nil && acc != nil && acc.Count != position.Count {
+			v.errorf(path+"/attributes/"+string(key), "attribute-count", "%s has %d elements, POSITION has %d", key, acc.Count, position.Count)
+		}
+	}
+	if p.Attributes[gltf.JOINTS_0] != nil && p.Attributes[gltf.WEIGHTS_0] == nil {
+		v.errorf(path, "joints-without-weights", "JOINTS_0 is set without WEIGHTS_0")
+	}
+
+	v.checkUnitVectors(path+"/attributes/NORMAL", p.Attributes[gltf.NORMAL], false)
+	v.checkUnitVectors(path+"/attributes/TANGENT", p.Attributes[gltf.TANGENT], true)
+
+	for t, target := range p.Targets {
+		for _, key := range sortedAttributes(target) {
+			if acc := target[key]; position != nil && acc != nil && acc.Count != position.Count {
+				v.errorf(fmt.Sprintf("%s/targets/%d/%s", path, t, key), "attribute-count", "morph target %s has %d elements, POSITION has %d",
+					key, acc.Count, position.Count)
+			}
+		}
+	}
+
+	count := vertexCount(p)
+	if idx := p.Indices; idx != nil {
+		count = idx.Count
+		v.checkIndices(path+"/indices", idx, vertexCount(p))
+	}
+
+	switch p.Mode {
+	case gltf.POINTS, gltf.LINES, gltf.LINE_LOOP, gltf.LINE_STRIP:
+		v.warnf(path, "software-renderer-mode", "%s primitives are not drawn by the software renderer", primitiveModeName(p))
+	case gltf.TRIANGLES:
+		if count%3 != 0 {
+			v.warnf(path, "incomplete-triangle", "%d vertices is not a multiple of 3, the last %d are ignored", count, count%3)
+		}
+	}
+}
+
+// sortedAttributes returns the attribute names of a primitive in order, so that diagnostics are stable.
+func sortedAttributes(attributes map[gltf.AttributeKey]*gltf.ResolvedAccessor) []gltf.AttributeKey {
+	keys := make([]string, 0, len(attributes))
+	for key := range attributes {
+		keys = append(keys, string(key))
+	}
+	sort.Strings(keys)
+	rval := make([]gltf.AttributeKey, len(keys))
+	for i, key := range keys {
+		rval[i] = gltf.AttributeKey(key)
+	}
+	return rval
+}
+
+// checkIndices checks that an index accessor has an unsigned component type and refers only to existing vertices.
+func (v *validator) checkIndices(path string, acc *gltf.ResolvedAccessor, vertices int) {
+	switch acc.ComponentType {
+	case gltf.UNSIGNED_BYTE, gltf.UNSIGNED_SHORT, gltf.UNSIGNED_INT:
+	default:
+		v.errorf(path, "index-type", "indices have component type %s, must be unsigned", componentTypeName(acc.ComponentType))
+		return
+	}
+	if acc.Type != gltf.SCALAR {
+		v.errorf(path, "index-type", "indices have type %v, must be SCALAR", acc.Type)
+		return
+	}
+	if v.unreadable[acc] {
+		return
+	}
+
+	indices, err := readIndices(v.doc, acc)
+	if err != nil {
+		v.errorf(path, "accessor-bounds", "%s", err.Error())
+		return
+	}
+	bad, max := 0, uint32(0)
+	for _, i := range indices {
+		if int(i) >= vertices {
+			bad++
+			if i > max {
+				max = i
+			}
+		}
+	}
+	if bad > 0 {
+		v.errorf(path, "index-out-of-range", "%d indices are out of range, up to %d, for %d vertices", bad, max, vertices)
+	}
+}
+
+// checkUnitVectors checks that a NORMAL, or the xyz of a TANGENT, is unit length, and that a TANGENT's w is 1 or -1.
+func (v *validator) checkUnitVectors(path string, acc *gltf.ResolvedAccessor, tangent bool) {
+	if acc == nil {
+		return
+	}
+	want := gltf.VEC3
+	if tangent {
+		want = gltf.VEC4
+	}
+	if acc.Type != want {
+		v.errorf(path, "attribute-type", "type is %v, must be %v", acc.Type, want)
+		return
+	}
+	if v.unreadable[acc] {
+		return
+	}
+
+	var vectors [][3]float32
+	var signs []float32
+	var err error
+	if tangent {
+		var tangents [][4]float32
+		tangents, err = readTangent(v.doc, acc)
+		for _, t := range tangents {
+			vectors = append(vectors, [3]float32{t[0], t[1], t[2]})
+			signs = append(signs, t[3])
+		}
+	} else {
+		vectors, err = readVec3(v.doc, acc)
+	}
+	if err != nil {
+		v.errorf(path, "accessor-bounds", "%s", err.Error())
+		return
+	}
+
+	notUnit, first := 0, -1
+	for i, n := range vectors {
+		if length := math32.Sqrt(n[0]*n[0] + n[1]*n[1] + n[2]*n[2]); math32.Abs(length-1) > unitLengthTolerance {
+			if notUnit == 0 {
+				first = i
+			}
+			notUnit++
+		}
+	}
+	if notUnit > 0 {
+		v.errorf(path, "not-unit-length", "%d of %d vectors are not unit length, the first is element %d", notUnit, len(vectors), first)
+	}
+
+	badSign := 0
+	for _, w := range signs {
+		if math32.Abs(math32.Abs(w)-1) > unitLengthTolerance {
+			badSign++
+		}
+	}
+	if badSign > 0 {
+		v.errorf(path, "tangent-sign", "%d tangents have a w other than 1 or -1", badSign)
+	}
+}
+
+func (v *validator) checkMaterial(path string, m *gltf.ResolvedMaterial) {
+	for slot, mt := range materialTextures(m) {
+		if mt != nil && mt.TexCoord > 1 {
+			v.warnf(path, "unsupported-texcoord", "%s texture uses TEXCOORD_%d, the viewer only has TEXCOORD_0 and TEXCOORD_1",
+				textureSlot(slot), mt.TexCoord)
+		}
+	}
+}
+
+// checkTexture checks that a texture's image can be read and decoded. The viewer shows the model without textures it
+// can't load, so these are warnings.
+func (v *validator) checkTexture(path string, tex *gltf.ResolvedTexture) {
+	if tex.Source == nil {
+		v.warnf(path, "texture-source", "texture has no image, or only one from an unsupported extension")
+		return
+	}
+	data, err := imageBytes(v.doc, tex.Source, v.baseDir)
+	if err != nil {
+		v.warnf(path, "image-unreadable", "%s", err.Error())
+		return
+	}
+	if _, format, err := image.DecodeConfig(bytes.NewReader(data)); err != nil {
+		v.warnf(path, "image-format", "image can't be decoded: %s", err.Error())
+	} else if format != "png" && format != "jpeg" {
+		v.warnf(path, "image-format", "image is %s, glTF only allows PNG and JPEG", format)
+	}
+}
+
+// checkAnimations warns about channels the viewer drops, see loadAnimations.
+func (v *validator) checkAnimations() {
+	for a, anim := range v.doc.Animations {
+		for c, ch := range anim.Channels {
+			if _, ok := parseAnimationPath(string(ch.Target.Path)); !ok {
+				v.warnf(fmt.Sprintf("/animations/%d/channels/%d", a, c), "unsupported-animation-path",
+					"channels targeting %q are ignored by the viewer", ch.Target.Path)
+			}
+		}
+	}
+}
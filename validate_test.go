@@ -0,0 +1,113 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"path/filepath"
+	"testing"
+)
+
+// validateTestBuffer holds the data of validateTestDocument: positions, normals, indices, and the sparse indices and
+// values of accessor 2, with padding to keep them aligned, then the sparse indices of accessors 4 and 5.
+var validateTestBuffer = littleEndian(
+	[]float32{0, 0, 0, 1, 1, 1},
+	[]float32{0, 0, 1},
+	[]uint16{0, 1, 5}, uint16(0),
+	uint16(1), uint16(0),
+	[]float32{2, 3},
+	[]uint16{3, 1},
+	[]uint16{1, 4},
+)
+
+// validateTestDocument has a mesh whose NORMAL accessor runs past its buffer view and whose indices refer to a
+// missing vertex, a sparse accessor with too few indices, and sparse accessors with indices out of order and out of
+// range. None of them may be read out of range.
+const validateTestDocument = `{
+	"extensionsUsed": ["KHR_mesh_quantization", "KHR_draco_mesh_compression"],
+	"bufferViews": [
+		{"buffer": 0, "byteOffset": 0, "byteLength": 24},
+		{"buffer": 0, "byteOffset": 24, "byteLength": 12},
+		{"buffer": 0, "byteOffset": 36, "byteLength": 6},
+		{"buffer": 0, "byteOffset": 44, "byteLength": 2},
+		{"buffer": 0, "byteOffset": 48, "byteLength": 8},
+		{"buffer": 0, "byteOffset": 56, "byteLength": 4},
+		{"buffer": 0, "byteOffset": 60, "byteLength": 4}
+	],
+	"accessors": [
+		{"bufferView": 0, "componentType": 5126, "type": "VEC3", "count": 2, "min": [0, 0, 0], "max": [1, 1, 1]},
+		{"bufferView": 1, "componentType": 5126, "type": "VEC3", "count": 2},
+		{"componentType": 5126, "type": "SCALAR", "count": 4,
+			"sparse": {"count": 2, "indices": {"bufferView": 3, "componentType": 5123}, "values": {"bufferView": 4}}},
+		{"bufferView": 2, "componentType": 5123, "type": "SCALAR", "count": 3},
+		{"componentType": 5126, "type": "SCALAR", "count": 4,
+			"sparse": {"count": 2, "indices": {"bufferView": 5, "componentType": 5123}, "values": {"bufferView": 4}}},
+		{"componentType": 5126, "type": "SCALAR", "count": 4,
+			"sparse": {"count": 2, "indices": {"bufferView": 6, "componentType": 5123}, "values": {"bufferView": 4}}}
+	],
+	"meshes": [{"primitives": [{"attributes": {"POSITION": 0, "NORMAL": 1}, "indices": 3, "mode": 4}]}]
+}`
+
+func TestValidateFile(t *testing.T) {
+	result := validateFile(writeTestModel(t, validateTestDocument, validateTestBuffer))
+
+	want := []string{
+		"warning /extensionsUsed unsupported-extension",
+		"error /accessors/1 accessor-bounds",
+		"error /accessors/2/sparse/indices accessor-bounds",
+		"error /accessors/4/sparse/indices sparse-index-order",
+		"error /accessors/5/sparse/indices sparse-index-range",
+		"error /meshes/0/primitives/0/indices index-out-of-range",
+	}
+	var got []string
+	for _, d := range result.Diagnostics {
+		got = append(got, fmt.Sprintf("%s %s %s", d.Severity, d.Path, d.Code))
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("diagnostics are\n%q\nwant\n%q", got, want)
+	}
+	if result.Errors != 5 || result.Warnings != 1 {
+		t.Errorf("%d errors and %d warnings, want 5 and 1", result.Errors, result.Warnings)
+	}
+}
+
+func TestValidateMissingFile(t *testing.T) {
+	result := validateFile(filepath.Join(t.TempDir(), "missing.gltf"))
+	if result.Errors != 1 || len(result.Diagnostics) != 1 || result.Diagnostics[0].Code != "load" {
+		t.Errorf("diagnostics for a missing file are %+v, want one load error", result.Diagnostics)
+	}
+}
+
+func TestParseFileArgs(t *testing.T) {
+	tests := []struct {
+		name       string
+		args       []string
+		wantFiles  []string
+		wantStrict bool
+		wantOK     bool
+	}{
+		{"files", []string{"a.gltf", "b.glb"}, []string{"a.gltf", "b.glb"}, false, true},
+		{"flag first", []string{"-strict", "a.gltf"}, []string{"a.gltf"}, true, true},
+		{"flag between", []string{"a.gltf", "-strict", "b.glb"}, []string{"a.gltf", "b.glb"}, true, true},
+		{"flag last", []string{"a.gltf", "-strict"}, []string{"a.gltf"}, true, true},
+		{"no files", []string{"-strict"}, nil, true, false},
+		{"nothing", nil, nil, false, false},
+		{"unknown flag", []string{"a.gltf", "-bogus"}, nil, false, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			fs.SetOutput(io.Discard)
+			strict := fs.Bool("strict", false, "")
+
+			files, ok := parseFileArgs(fs, test.args)
+			if ok != test.wantOK || fmt.Sprint(files) != fmt.Sprint(test.wantFiles) {
+				t.Errorf("parseFileArgs(%q) = %q, %v; want %q, %v", test.args, files, ok, test.wantFiles, test.wantOK)
+			}
+			if ok && *strict != test.wantStrict {
+				t.Errorf("strict is %v, want %v", *strict, test.wantStrict)
+			}
+		})
+	}
+}
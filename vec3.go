@@ -0,0 +1,58 @@
+package main
+
+import (
+	"github.com/chewxy/math32"
+)
+
+// Small [3]float32 helpers for the CPU-side shading and environment code, mirroring the GLSL built-ins.
+
+func add3(a, b [3]float32) [3]float32 {
+	return [3]float32{a[0] + b[0], a[1] + b[1], a[2] + b[2]}
+}
+
+func sub3(a, b [3]float32) [3]float32 {
+	return [3]float32{a[0] - b[0], a[1] - b[1], a[2] - b[2]}
+}
+
+func scale3(a [3]float32, s float32) [3]float32 {
+	return [3]float32{a[0] * s, a[1] * s, a[2] * s}
+}
+
+func mul3(a, b [3]float32) [3]float32 {
+	return [3]float32{a[0] * b[0], a[1] * b[1], a[2] * b[2]}
+}
+
+func dot3(a, b [3]float32) float32 {
+	return a[0]*b[0] + a[1]*b[1] + a[2]*b[2]
+}
+
+func cross3(a, b [3]float32) [3]float32 {
+	return [3]float32{
+		a[1]*b[2] - a[2]*b[1],
+		a[2]*b[0] - a[0]*b[2],
+		a[0]*b[1] - a[1]*b[0],
+	}
+}
+
+func length3(a [3]float32) float32 {
+	return math32.Sqrt(dot3(a, a))
+}
+
+// normalize3 returns a unit vector in the direction of a, or the zero vector if a has no length.
+func normalize3(a [3]float32) [3]float32 {
+	l := length3(a)
+	if l == 0 {
+		return a
+	}
+	return scale3(a, 1/l)
+}
+
+// mix3 linearly interpolates from a to b.
+func mix3(a, b [3]float32, t float32) [3]float32 {
+	return [3]float32{a[0] + (b[0]-a[0])*t, a[1] + (b[1]-a[1])*t, a[2] + (b[2]-a[2])*t}
+}
+
+// reflect3 reflects the incident vector i about the normal n, like GLSL reflect.
+func reflect3(i, n [3]float32) [3]float32 {
+	return sub3(i, scale3(n, 2*dot3(n, i)))
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/bbredesen/gltf"
+	"github.com/bbredesen/go-vk"
+)
+
+// maxVertexBindings is the number of attributes in attrKeys, and so the most bindings a vertex layout can need.
+const maxVertexBindings = 8
+
+// vertexLayout is the vertex input state of a primitive. It is comparable, so that primitives with the same layout
+// and topology share a pipeline, see VulkanPipeline.pipelineFor. attrs are in the order of attrKeys.
+type vertexLayout struct {
+	bindingCount uint32
+	bindings     [maxVertexBindings]vk.VertexInputBindingDescription
+	attrs        [maxVertexBindings]vk.VertexInputAttributeDescription
+}
+
+// vertexSource is the buffer and offset bound to one binding of a vertex layout.
+type vertexSource struct {
+	buffer vk.Buffer
+	offset vk.DeviceSize
+}
+
+// directVertexFormat returns the format to bind acc with straight from the glTF buffer, or false if it has to be
+// converted to float on load instead: sparse accessors and those without a buffer view have no buffer data to bind,
+// non-normalized integers would not be read as floats by the shader, and the device may not support the format for
+// vertex buffers, as is common for three component 8 and 16 bit formats.
+func (app *App) directVertexFormat(acc *gltf.ResolvedAccessor) (vk.Format, bool) {
+	if acc.Sparse != nil || acc.BufferView == nil {
+		return vk.FORMAT_UNDEFINED, false
+	}
+	if acc.ComponentType != gltf.FLOAT && !acc.Normalized {
+		return vk.FORMAT_UNDEFINED, false
+	}
+
+	format := accessorToFormat(acc.Type, acc.ComponentType, acc.Normalized)
+	if format == vk.FORMAT_UNDEFINED {
+		return format, false
+	}
+	props := vk.GetPhysicalDeviceFormatProperties(app.PhysicalDevice, format)
+	return format, props.BufferFeatures&vk.FORMAT_FEATURE_VERTEX_BUFFER_BIT != 0
+}
+
+// primitiveVertexLayout returns the vertex layout of p and the buffers to bind for it. Attributes read directly from
+// the glTF buffers use their accessor's format, and share a binding when they are interleaved in the same buffer view,
+// with the view's byteStride as the binding stride and each accessor's byteOffset, relative to the first, as the
+// attribute offset. Converted and missing attributes get a tightly packed binding of their own; missing ones are bound
+// to a null buffer.
+func (app *App) primitiveVertexLayout(p *gltf.ResolvedPrimitive, res *primitiveResources) (vertexLayout, []vertexSource) {
+	var layout vertexLayout
+	var sources []vertexSource
+
+	addBinding := func(stride uint32, src vertexSource) uint32 {
+		binding := layout.bindingCount
+		layout.bindings[binding] = vk.VertexInputBindingDescription{
+			Binding:   binding,
+			Stride:    stride,
+			InputRate: vk.VERTEX_INPUT_RATE_VERTEX,
+		}
+		layout.bindingCount++
+		sources = append(sources, src)
+		return binding
+	}
+
+	// Attributes from the glTF buffers, grouped by buffer view.
+	type direct struct {
+		index int
+		acc   *gltf.ResolvedAccessor
+	}
+	views := make(map[*gltf.ResolvedBufferView][]direct)
+	var viewOrder []*gltf.ResolvedBufferView
+
+	for i, key := range attrKeys {
+		attr := app.accessorAttrs[key]
+		ra, ok := p.Attributes[key]
+
+		switch conv, converted := res.converted[key]; {
+		case ok && converted:
+			// Converted on load, see createDrawData
+			attr.Binding = addBinding(app.accessorBindings[key].Stride, vertexSource{buffer: conv.buffer})
+		case ok && !convertedAttrKeys[key]:
+			// Formats the device can't read were converted by createDrawData, so this one can be bound as is.
+			attr.Format, _ = app.directVertexFormat(ra)
+			if _, seen := views[ra.BufferView]; !seen {
+				viewOrder = append(viewOrder, ra.BufferView)
+			}
+			views[ra.BufferView] = append(views[ra.BufferView], direct{index: i, acc: ra})
+		default:
+			// Missing, or couldn't be converted, e.g. JOINTS_0 without WEIGHTS_0. The shader doesn't read it.
+			attr.Binding = addBinding(app.accessorBindings[key].Stride, vertexSource{buffer: vk.Buffer(vk.NULL_HANDLE)})
+		}
+		layout.attrs[i] = attr
+	}
+
+	for _, bv := range viewOrder {
+		attrs := views[bv]
+		sort.Slice(attrs, func(i, j int) bool { return attrs[i].acc.ByteOffset < attrs[j].acc.ByteOffset })
+
+		stride := uint32(bv.ByteStride)
+		var base int
+		var binding uint32
+		for j, a := range attrs {
+			// Accessors within one stride of the binding's first accessor are interleaved with it. Any further along
+			// the view are laid out one after another, and get a binding of their own.
+			if j == 0 || stride == 0 || a.acc.ByteOffset-base >= int(stride) {
+				base = a.acc.ByteOffset
+				bindingStride := stride
+				if bindingStride == 0 {
+					bindingStride = uint32(elementSize(a.acc))
+				}
+				binding = addBinding(bindingStride, vertexSource{
+					buffer: app.buffers[bv.BufferView.Buffer],
+					offset: vk.DeviceSize(bv.ByteOffset + base),
+				})
+			}
+			layout.attrs[a.index].Binding = binding
+			layout.attrs[a.index].Offset = uint32(a.acc.ByteOffset - base)
+		}
+	}
+
+	return layout, sources
+}
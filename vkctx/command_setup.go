@@ -6,7 +6,7 @@ import (
 
 // Create command pool, associated command buffers, and record commands to clear
 // the screen.
-func (ctx *Context) createCommandPool() {
+func (ctx *Context) createCommandPool() error {
 	// 1) Create the command pool
 	poolCreateInfo := vk.CommandPoolCreateInfo{
 		Flags:            vk.COMMAND_POOL_CREATE_RESET_COMMAND_BUFFER_BIT,
@@ -14,59 +14,95 @@ func (ctx *Context) createCommandPool() {
 	}
 	commandPool, err := vk.CreateCommandPool(ctx.Device, &poolCreateInfo, nil)
 	if err != nil {
-		panic("Could not create command pool! " + err.Error())
+		return wrap("create command pool", err)
 	}
 	ctx.CommandPool = commandPool
 
-	// 2) Allocate primary command buffers, one for each swapchain image, from the pool
+	// 2) Allocate primary command buffers, one for each frame in flight, from the pool
 	allocInfo := vk.CommandBufferAllocateInfo{
 		CommandPool:        ctx.CommandPool,
 		Level:              vk.COMMAND_BUFFER_LEVEL_PRIMARY,
-		CommandBufferCount: uint32(len(ctx.SwapchainImages)),
+		CommandBufferCount: uint32(ctx.MaxFramesInFlight),
 	}
 	commandBuffers, err := vk.AllocateCommandBuffers(ctx.Device, &allocInfo)
 	if err != nil {
-		panic("Could not allocate command buffers! " + err.Error())
+		return wrap("allocate command buffers", err)
 	}
 	ctx.CommandBuffers = commandBuffers
+
+	// 3) Uploads record short-lived command buffers for the transfer queue, if there is one
+	if ctx.HasTransferQueue {
+		transferPoolCI := vk.CommandPoolCreateInfo{
+			Flags:            vk.COMMAND_POOL_CREATE_TRANSIENT_BIT,
+			QueueFamilyIndex: ctx.TransferQueueFamilyIndex,
+		}
+		if ctx.TransferCommandPool, err = vk.CreateCommandPool(ctx.Device, &transferPoolCI, nil); err != nil {
+			return wrap("create transfer command pool", err)
+		}
+	}
+	return nil
 }
 
 func (ctx *Context) destroyCommandPool() {
-	vk.FreeCommandBuffers(ctx.Device, ctx.CommandPool, ctx.CommandBuffers)
+	if len(ctx.CommandBuffers) > 0 {
+		vk.FreeCommandBuffers(ctx.Device, ctx.CommandPool, ctx.CommandBuffers)
+	}
 	vk.DestroyCommandPool(ctx.Device, ctx.CommandPool, nil)
+	vk.DestroyCommandPool(ctx.Device, ctx.TransferCommandPool, nil)
+	ctx.CommandBuffers = nil
+	ctx.CommandPool, ctx.TransferCommandPool = vk.CommandPool(vk.NULL_HANDLE), vk.CommandPool(vk.NULL_HANDLE)
 }
 
-func (ctx *Context) createSyncObjects() {
+// createSyncObjects creates one set of semaphores and a fence for each frame in flight. The fences are created
+// signaled so that the first wait on each returns immediately.
+func (ctx *Context) createSyncObjects() error {
 	createInfo := vk.SemaphoreCreateInfo{}
-
-	imgSem, err := vk.CreateSemaphore(ctx.Device, &createInfo, nil)
-	if err != nil {
-		panic("Could not create semaphore! " + err.Error())
-	}
-	ctx.ImageAvailableSemaphore = imgSem
-
-	renSem, err := vk.CreateSemaphore(ctx.Device, &createInfo, nil)
-	if err != nil {
-		panic("Could not create semaphore! " + err.Error())
-	}
-	ctx.RenderFinishedSemaphore = renSem
-
 	fenceCreateInfo := vk.FenceCreateInfo{
 		Flags: vk.FENCE_CREATE_SIGNALED_BIT,
 	}
-	if ctx.InFlightFence, err = vk.CreateFence(ctx.Device, &fenceCreateInfo, nil); err != nil {
-		panic("Could not create fence! " + err.Error())
+
+	ctx.ImageAvailableSemaphores = make([]vk.Semaphore, ctx.MaxFramesInFlight)
+	ctx.RenderFinishedSemaphores = make([]vk.Semaphore, ctx.MaxFramesInFlight)
+	ctx.InFlightFences = make([]vk.Fence, ctx.MaxFramesInFlight)
+
+	for i := 0; i < ctx.MaxFramesInFlight; i++ {
+		// Objects not yet created are null handles, which destroySyncObjects may be given.
+		var err error
+		if ctx.ImageAvailableSemaphores[i], err = vk.CreateSemaphore(ctx.Device, &createInfo, nil); err != nil {
+			return wrap("create semaphore", err)
+		}
+		if ctx.RenderFinishedSemaphores[i], err = vk.CreateSemaphore(ctx.Device, &createInfo, nil); err != nil {
+			return wrap("create semaphore", err)
+		}
+		if ctx.InFlightFences[i], err = vk.CreateFence(ctx.Device, &fenceCreateInfo, nil); err != nil {
+			return wrap("create fence", err)
+		}
 	}
+
+	ctx.resetImagesInFlight()
+	return nil
+}
+
+// resetImagesInFlight clears the image-to-fence table, which must be sized to the current swapchain.
+func (ctx *Context) resetImagesInFlight() {
+	ctx.ImagesInFlight = make([]vk.Fence, len(ctx.SwapchainImages))
 }
 
 func (app *Context) destroySyncObjects() {
-	vk.DestroyFence(app.Device, app.InFlightFence, nil)
+	for i := range app.InFlightFences {
+		vk.DestroyFence(app.Device, app.InFlightFences[i], nil)
 
-	vk.DestroySemaphore(app.Device, app.ImageAvailableSemaphore, nil)
-	vk.DestroySemaphore(app.Device, app.RenderFinishedSemaphore, nil)
+		vk.DestroySemaphore(app.Device, app.ImageAvailableSemaphores[i], nil)
+		vk.DestroySemaphore(app.Device, app.RenderFinishedSemaphores[i], nil)
+	}
+	app.InFlightFences = nil
+	app.ImageAvailableSemaphores, app.RenderFinishedSemaphores = nil, nil
+	app.ImagesInFlight = nil
 }
 
-func (app *Context) BeginOneTimeCommands() vk.CommandBuffer {
+// BeginOneTimeCommands allocates a command buffer from the graphics command pool and begins recording it. Submit it
+// with EndOneTimeCommands.
+func (app *Context) BeginOneTimeCommands() (vk.CommandBuffer, error) {
 	bufferAlloc := vk.CommandBufferAllocateInfo{
 		CommandPool:        app.CommandPool,
 		Level:              vk.COMMAND_BUFFER_LEVEL_PRIMARY,
@@ -77,7 +113,7 @@ func (app *Context) BeginOneTimeCommands() vk.CommandBuffer {
 	var bufs []vk.CommandBuffer
 
 	if bufs, err = vk.AllocateCommandBuffers(app.Device, &bufferAlloc); err != nil {
-		panic("Could not allocate one-time command buffer: " + err.Error())
+		return vk.CommandBuffer(vk.NULL_HANDLE), wrap("allocate one-time command buffer", err)
 	}
 
 	cbbInfo := vk.CommandBufferBeginInfo{
@@ -85,15 +121,20 @@ func (app *Context) BeginOneTimeCommands() vk.CommandBuffer {
 	}
 
 	if err = vk.BeginCommandBuffer(bufs[0], &cbbInfo); err != nil {
-		panic("Could not begin recording one-time command buffer: " + err.Error())
+		vk.FreeCommandBuffers(app.Device, app.CommandPool, bufs)
+		return vk.CommandBuffer(vk.NULL_HANDLE), wrap("begin one-time command buffer", err)
 	}
 
-	return bufs[0]
+	return bufs[0], nil
 }
 
-func (app *Context) EndOneTimeCommands(buf vk.CommandBuffer) {
+// EndOneTimeCommands submits a command buffer from BeginOneTimeCommands to the graphics queue, waits for it to
+// complete, and frees it. The buffer is freed even if this fails.
+func (app *Context) EndOneTimeCommands(buf vk.CommandBuffer) error {
+	defer vk.FreeCommandBuffers(app.Device, app.CommandPool, []vk.CommandBuffer{buf})
+
 	if err := vk.EndCommandBuffer(buf); err != nil {
-		panic("Could not end one-time command buffer: " + err.Error())
+		return wrap("end one-time command buffer", err)
 	}
 
 	submitInfo := vk.SubmitInfo{
@@ -101,11 +142,10 @@ func (app *Context) EndOneTimeCommands(buf vk.CommandBuffer) {
 	}
 
 	if err := vk.QueueSubmit(app.GraphicsQueue, []vk.SubmitInfo{submitInfo}, vk.Fence(vk.NULL_HANDLE)); err != nil {
-		panic("Could not submit one-time command buffer: " + err.Error())
+		return wrap("submit one-time command buffer", err)
 	}
 	if err := vk.QueueWaitIdle(app.GraphicsQueue); err != nil {
-		panic("QueueWaitIdle failed: " + err.Error())
+		return wrap("wait for one-time command buffer", err)
 	}
-
-	vk.FreeCommandBuffers(app.Device, app.CommandPool, []vk.CommandBuffer{buf})
+	return nil
 }
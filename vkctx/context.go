@@ -1,25 +1,68 @@
 package vkctx
 
 import (
+	"log/slog"
+
 	"github.com/bbredesen/go-vk"
-	"golang.org/x/sys/windows"
 )
 
+// SurfaceSource creates the presentation surface that the swapchain renders to, typically a platform window. See
+// shared.Window.
+type SurfaceSource interface {
+	CreateSurface(instance vk.Instance) (vk.SurfaceKHR, error)
+}
+
 type Context struct {
 	EnableApiLayers, EnableInstanceExtensions, EnableDeviceExtensions []string
 
+	// Validation enables VK_LAYER_KHRONOS_validation, with its messages sent to Logger. It is read by Initialize and
+	// InitializeHeadless, which return ErrValidationUnavailable if the layer is not installed.
+	Validation bool
+	// Logger receives validation messages, and anything else logged through Log. It defaults to slog.Default().
+	Logger *slog.Logger
+	// DebugUtils is true when VK_EXT_debug_utils is enabled on the instance. SetObjectName, BeginLabel, and EndLabel
+	// do nothing without it.
+	DebugUtils     bool
+	debugMessenger vk.DebugUtilsMessengerEXT
+
 	Instance vk.Instance
 	Surface  vk.SurfaceKHR
 
+	// DeviceSelector overrides the choice of physical device: either a device index, as reported by ListDevices, or a
+	// case-insensitive substring of the device name. A number that isn't a valid index is matched against the names.
+	// It is read by Initialize and InitializeHeadless; when it is empty, the suitable device with the highest score is
+	// used.
+	DeviceSelector string
+
 	PhysicalDevice vk.PhysicalDevice
 	Device         vk.Device
 
+	// SamplerAnisotropy is true if the selected device supports anisotropic filtering. Samplers must not enable it
+	// otherwise.
+	SamplerAnisotropy bool
+
 	GraphicsQueueFamilyIndex, PresentQueueFamilyIndex uint32
 	GraphicsQueue, PresentQueue                       vk.Queue
 
+	// HasTransferQueue is true when the device has a queue family for transfers only, see UploadSharing. Otherwise the
+	// transfer fields are unset, and uploads go through the graphics queue.
+	HasTransferQueue         bool
+	TransferQueueFamilyIndex uint32
+	TransferQueue            vk.Queue
+	TransferCommandPool      vk.CommandPool
+
+	// Device memory pools, see Allocate
+	memoryProps            vk.PhysicalDeviceMemoryProperties
+	bufferImageGranularity uint64
+	memoryPools            []memoryPool
+
+	// MaxFramesInFlight is the number of frames the CPU may record ahead of the GPU. It is read by Initialize, and
+	// defaults to DefaultMaxFramesInFlight if not set.
+	MaxFramesInFlight int
+
 	// Command pool and buffers
 	CommandPool    vk.CommandPool
-	CommandBuffers []vk.CommandBuffer // Primary buffers
+	CommandBuffers []vk.CommandBuffer // Primary buffers, one per frame in flight
 
 	// Swapchain handles
 	Swapchain             vk.SwapchainKHR
@@ -28,46 +71,138 @@ type Context struct {
 	SwapchainImages       []vk.Image
 	SwapchainImageViews   []vk.ImageView
 	DepthImage            vk.Image
-	DepthImageMemory      vk.DeviceMemory
+	DepthImageMemory      *Allocation
 	DepthImageView        vk.ImageView
 	SwapChainFramebuffers []vk.Framebuffer
 
-	// Sync objects
-	ImageAvailableSemaphore, RenderFinishedSemaphore vk.Semaphore
-	InFlightFence                                    vk.Fence
+	// Sync objects, indexed by frame in flight
+	ImageAvailableSemaphores, RenderFinishedSemaphores []vk.Semaphore
+	InFlightFences                                     []vk.Fence
+	// ImagesInFlight holds, for each swapchain image, the fence of the frame that last rendered to it, or a null
+	// handle. The swapchain may hand back an image that an earlier frame is still using, so it must be waited on.
+	ImagesInFlight []vk.Fence
+
+	// Headless is true when the context was created by InitializeHeadless. There is no surface or swapchain in that
+	// case; SwapchainImages holds a single offscreen color image instead, so that framebuffer and command buffer setup
+	// is the same in both modes.
+	Headless        bool
+	OffscreenMemory *Allocation
 }
 
-func (ctx *Context) Initialize(hInstance windows.Handle, hWnd windows.HWND) {
+const DefaultMaxFramesInFlight = 2
+
+// Initialize creates the instance, a surface for window, the device, the swapchain, and the per-frame command buffers
+// and sync objects. If any step fails, everything created so far is destroyed again.
+func (ctx *Context) Initialize(window SurfaceSource) (err error) {
+	if ctx.MaxFramesInFlight <= 0 {
+		ctx.MaxFramesInFlight = DefaultMaxFramesInFlight
+	}
+	defer func() {
+		if err != nil {
+			ctx.Teardown()
+		}
+	}()
+
+	if err = ctx.createInstance(); err != nil {
+		return err
+	}
+	if err = ctx.createSurface(window); err != nil {
+		return err
+	}
+
+	if err = ctx.selectPhysicalDevice(); err != nil {
+		return err
+	}
+	if err = ctx.createLogicalDevice(); err != nil {
+		return err
+	}
+
+	if err = ctx.createSwapchain(); err != nil {
+		return err
+	}
+	if err = ctx.createSwapchainImageViews(); err != nil {
+		return err
+	}
+	if err = ctx.createDepthResources(); err != nil {
+		return err
+	}
+
+	if err = ctx.createCommandPool(); err != nil {
+		return err
+	}
+	return ctx.createSyncObjects()
+}
 
-	ctx.createInstance()
-	ctx.createSurface(hInstance, hWnd)
+// InitializeHeadless creates a context without a window surface or swapchain. Rendering goes to a single offscreen
+// color image of the requested extent, which can be copied back to the host with CmdCopyImageToBuffer. This works
+// with software implementations like lavapipe on machines that have no display. As with Initialize, a failure destroys
+// everything created so far.
+func (ctx *Context) InitializeHeadless(extent vk.Extent2D) (err error) {
+	ctx.Headless = true
+	// Only one frame is ever rendered.
+	ctx.MaxFramesInFlight = 1
+	defer func() {
+		if err != nil {
+			ctx.Teardown()
+		}
+	}()
 
-	ctx.selectPhysicalDevice()
-	ctx.createLogicalDevice()
+	if err = ctx.createInstance(); err != nil {
+		return err
+	}
 
-	ctx.createSwapchain()
-	ctx.createSwapchainImageViews()
-	ctx.createDepthResources()
+	if err = ctx.selectPhysicalDevice(); err != nil {
+		return err
+	}
+	if err = ctx.createLogicalDevice(); err != nil {
+		return err
+	}
 
-	ctx.createCommandPool()
-	ctx.createSyncObjects()
+	if err = ctx.createOffscreenTarget(extent); err != nil {
+		return err
+	}
+	if err = ctx.createDepthResources(); err != nil {
+		return err
+	}
 
+	if err = ctx.createCommandPool(); err != nil {
+		return err
+	}
+	return ctx.createSyncObjects()
 }
 
+// Teardown destroys everything the context created. It may be called on a context that was only partly initialized,
+// and then destroys just the objects that exist.
 func (ctx *Context) Teardown() {
-	vk.QueueWaitIdle(ctx.PresentQueue)
+	if ctx.Device != vk.Device(vk.NULL_HANDLE) {
+		vk.DeviceWaitIdle(ctx.Device)
 
-	ctx.destroySyncObjects()
-	ctx.destroyCommandPool()
+		ctx.destroySyncObjects()
+		ctx.destroyCommandPool()
 
-	ctx.cleanupSwapchain()
+		if ctx.Headless {
+			ctx.destroyOffscreenTarget()
+		} else {
+			ctx.cleanupSwapchain()
+		}
 
-	vk.DestroyDevice(ctx.Device, nil)
-	vk.DestroySurfaceKHR(ctx.Instance, ctx.Surface, nil)
-	vk.DestroyInstance(ctx.Instance, nil)
+		ctx.destroyAllocator()
+		vk.DestroyDevice(ctx.Device, nil)
+		ctx.Device = vk.Device(vk.NULL_HANDLE)
+	}
+	if ctx.Surface != vk.SurfaceKHR(vk.NULL_HANDLE) {
+		vk.DestroySurfaceKHR(ctx.Instance, ctx.Surface, nil)
+		ctx.Surface = vk.SurfaceKHR(vk.NULL_HANDLE)
+	}
+	if ctx.Instance != vk.Instance(vk.NULL_HANDLE) {
+		ctx.destroyDebugMessenger()
+		vk.DestroyInstance(ctx.Instance, nil)
+		ctx.Instance = vk.Instance(vk.NULL_HANDLE)
+	}
 }
 
-func (ctx *Context) CreateImage(extent vk.Extent2D, format vk.Format, tiling vk.ImageTiling, usage vk.ImageUsageFlags, memProps vk.MemoryPropertyFlags) (image vk.Image, imageMemory vk.DeviceMemory) {
+// CreateImage creates a 2D image with a single mip level and layer, and allocates and binds memory with memProps for it.
+func (ctx *Context) CreateImage(extent vk.Extent2D, format vk.Format, tiling vk.ImageTiling, usage vk.ImageUsageFlags, memProps vk.MemoryPropertyFlags) (vk.Image, *Allocation, error) {
 
 	imageCI := vk.ImageCreateInfo{
 		ImageType: vk.IMAGE_TYPE_2D,
@@ -87,30 +222,21 @@ func (ctx *Context) CreateImage(extent vk.Extent2D, format vk.Format, tiling vk.
 		Samples:             vk.SAMPLE_COUNT_1_BIT,
 	}
 
-	var err error
-
-	if image, err = vk.CreateImage(ctx.Device, &imageCI, nil); err != nil {
-		panic("Could not create image: " + err.Error())
+	image, err := vk.CreateImage(ctx.Device, &imageCI, nil)
+	if err != nil {
+		return image, nil, wrap("create image", err)
 	}
 
-	memReq := vk.GetImageMemoryRequirements(ctx.Device, image)
-	memAlloc := vk.MemoryAllocateInfo{
-		AllocationSize:  memReq.Size,
-		MemoryTypeIndex: ctx.FindMemoryType(memReq.MemoryTypeBits, memProps),
+	imageMemory, err := ctx.AllocateImage(image, imageCI.Tiling, memProps)
+	if err != nil {
+		vk.DestroyImage(ctx.Device, image, nil)
+		return vk.Image(vk.NULL_HANDLE), nil, err
 	}
 
-	if imageMemory, err = vk.AllocateMemory(ctx.Device, &memAlloc, nil); err != nil {
-		panic("Could not allocate memory for texture image: " + err.Error())
-	}
-
-	if err = vk.BindImageMemory(ctx.Device, image, imageMemory, 0); err != nil {
-		panic("Could not bind texture image memory: " + err.Error())
-	}
-
-	return
+	return image, imageMemory, nil
 }
 
-func (ctx *Context) CreateImageView(image vk.Image, format vk.Format, aspectMask vk.ImageAspectFlags) vk.ImageView {
+func (ctx *Context) CreateImageView(image vk.Image, format vk.Format, aspectMask vk.ImageAspectFlags) (vk.ImageView, error) {
 	ivCI := vk.ImageViewCreateInfo{
 		Image:    image,
 		ViewType: vk.IMAGE_VIEW_TYPE_2D,
@@ -124,21 +250,19 @@ func (ctx *Context) CreateImageView(image vk.Image, format vk.Format, aspectMask
 		},
 	}
 
-	if iv, err := vk.CreateImageView(ctx.Device, &ivCI, nil); err != nil {
-		panic("Could not create image view: " + err.Error())
-	} else {
-		return iv
+	iv, err := vk.CreateImageView(ctx.Device, &ivCI, nil)
+	if err != nil {
+		return iv, wrap("create image view", err)
 	}
-
+	return iv, nil
 }
 
-func (ctx *Context) FindMemoryType(typeFilter uint32, flags vk.MemoryPropertyFlags) uint32 {
-	memProps := vk.GetPhysicalDeviceMemoryProperties(ctx.PhysicalDevice)
-	var i uint32
-	for i = 0; i < memProps.MemoryTypeCount; i++ {
-		if typeFilter&1<<i != 0 && memProps.MemoryTypes[i].PropertyFlags&flags == flags {
-			return i
-		}
+// UploadSharing returns the sharing mode and queue families for resources that are written by uploads and then used
+// for rendering. With a dedicated transfer queue they are shared concurrently between it and the graphics queue, so
+// that no queue family ownership transfer is needed.
+func (ctx *Context) UploadSharing() (vk.SharingMode, []uint32) {
+	if !ctx.HasTransferQueue {
+		return vk.SHARING_MODE_EXCLUSIVE, []uint32{}
 	}
-	panic("Could not find appropriate memory type.")
+	return vk.SHARING_MODE_CONCURRENT, []uint32{ctx.GraphicsQueueFamilyIndex, ctx.TransferQueueFamilyIndex}
 }
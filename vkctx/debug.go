@@ -0,0 +1,165 @@
+package vkctx
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"unsafe"
+
+	"github.com/bbredesen/go-vk"
+)
+
+const validationLayer = "VK_LAYER_KHRONOS_validation"
+
+// enableDebugUtils adds the validation layer to EnableApiLayers if Validation is set, and VK_EXT_debug_utils to
+// EnableInstanceExtensions whenever it is available, so that object names and command buffer labels show up in
+// debuggers like RenderDoc even without validation.
+func (ctx *Context) enableDebugUtils() error {
+	var extensions []vk.ExtensionProperties
+
+	if ctx.Validation {
+		layers, err := vk.EnumerateInstanceLayerProperties()
+		if err != nil {
+			return wrap("enumerate instance layers", err)
+		}
+		found := false
+		for _, layer := range layers {
+			found = found || layer.LayerName == validationLayer
+		}
+		if !found {
+			return ErrValidationUnavailable
+		}
+		ctx.EnableApiLayers = appendMissing(ctx.EnableApiLayers, validationLayer)
+
+		// The layer can provide debug utils itself when the loader doesn't.
+		if extensions, err = vk.EnumerateInstanceExtensionProperties(validationLayer); err != nil {
+			return wrap("enumerate validation layer extensions", err)
+		}
+	}
+
+	loaderExtensions, err := vk.EnumerateInstanceExtensionProperties("")
+	if err != nil {
+		return wrap("enumerate instance extensions", err)
+	}
+
+	ctx.DebugUtils = false
+	for _, ext := range append(extensions, loaderExtensions...) {
+		ctx.DebugUtils = ctx.DebugUtils || ext.ExtensionName == vk.EXT_DEBUG_UTILS_EXTENSION_NAME
+	}
+	if ctx.DebugUtils {
+		ctx.EnableInstanceExtensions = appendMissing(ctx.EnableInstanceExtensions, vk.EXT_DEBUG_UTILS_EXTENSION_NAME)
+	} else if ctx.Validation {
+		ctx.Log().Warn("VK_EXT_debug_utils is not available, validation messages will go to the layer's default output")
+	}
+	return nil
+}
+
+// createDebugMessenger routes validation messages to Logger. It does nothing unless Validation and DebugUtils are
+// both set.
+func (ctx *Context) createDebugMessenger() error {
+	if !ctx.Validation || !ctx.DebugUtils {
+		return nil
+	}
+
+	messengerCI := vk.DebugUtilsMessengerCreateInfoEXT{
+		MessageSeverity: vk.DEBUG_UTILS_MESSAGE_SEVERITY_VERBOSE_BIT_EXT | vk.DEBUG_UTILS_MESSAGE_SEVERITY_INFO_BIT_EXT |
+			vk.DEBUG_UTILS_MESSAGE_SEVERITY_WARNING_BIT_EXT | vk.DEBUG_UTILS_MESSAGE_SEVERITY_ERROR_BIT_EXT,
+		MessageType: vk.DEBUG_UTILS_MESSAGE_TYPE_GENERAL_BIT_EXT | vk.DEBUG_UTILS_MESSAGE_TYPE_VALIDATION_BIT_EXT |
+			vk.DEBUG_UTILS_MESSAGE_TYPE_PERFORMANCE_BIT_EXT,
+		PfnUserCallback: ctx.debugCallback,
+	}
+
+	var err error
+	if ctx.debugMessenger, err = vk.CreateDebugUtilsMessengerEXT(ctx.Instance, &messengerCI, nil); err != nil {
+		return wrap("create debug messenger", err)
+	}
+	return nil
+}
+
+func (ctx *Context) destroyDebugMessenger() {
+	if ctx.debugMessenger != vk.DebugUtilsMessengerEXT(vk.NULL_HANDLE) {
+		vk.DestroyDebugUtilsMessengerEXT(ctx.Instance, ctx.debugMessenger, nil)
+		ctx.debugMessenger = vk.DebugUtilsMessengerEXT(vk.NULL_HANDLE)
+	}
+}
+
+// debugCallback logs one message from the validation layer. Severities map to slog levels, except that info
+// messages, which are mostly the loader reporting what it found, are logged at debug level.
+func (ctx *Context) debugCallback(severity vk.DebugUtilsMessageSeverityFlagBitsEXT, types vk.DebugUtilsMessageTypeFlagsEXT, data *vk.DebugUtilsMessengerCallbackDataEXT, userData unsafe.Pointer) vk.Bool32 {
+	level := slog.LevelDebug - 4
+	switch {
+	case severity&vk.DEBUG_UTILS_MESSAGE_SEVERITY_ERROR_BIT_EXT != 0:
+		level = slog.LevelError
+	case severity&vk.DEBUG_UTILS_MESSAGE_SEVERITY_WARNING_BIT_EXT != 0:
+		level = slog.LevelWarn
+	case severity&vk.DEBUG_UTILS_MESSAGE_SEVERITY_INFO_BIT_EXT != 0:
+		level = slog.LevelDebug
+	}
+
+	var typeNames []string
+	if types&vk.DEBUG_UTILS_MESSAGE_TYPE_GENERAL_BIT_EXT != 0 {
+		typeNames = append(typeNames, "general")
+	}
+	if types&vk.DEBUG_UTILS_MESSAGE_TYPE_VALIDATION_BIT_EXT != 0 {
+		typeNames = append(typeNames, "validation")
+	}
+	if types&vk.DEBUG_UTILS_MESSAGE_TYPE_PERFORMANCE_BIT_EXT != 0 {
+		typeNames = append(typeNames, "performance")
+	}
+
+	ctx.Log().Log(context.Background(), level, data.PMessage,
+		slog.String("type", strings.Join(typeNames, ",")),
+		slog.String("id", data.PMessageIdName))
+
+	// Returning false lets the call that triggered the message proceed, as the spec requires.
+	return vk.Bool32(vk.FALSE)
+}
+
+// Log returns Logger, or slog.Default() if it is nil. Applications can use it for their own messages, so that they go
+// to the same place as validation messages.
+func (ctx *Context) Log() *slog.Logger {
+	if ctx.Logger != nil {
+		return ctx.Logger
+	}
+	return slog.Default()
+}
+
+// SetObjectName gives a Vulkan object a name, which validation messages and debuggers show instead of the bare
+// handle. handle is the object's handle converted to uint64. It does nothing if DebugUtils is not set.
+func (ctx *Context) SetObjectName(objectType vk.ObjectType, handle uint64, name string) {
+	if !ctx.DebugUtils || name == "" {
+		return
+	}
+	info := vk.DebugUtilsObjectNameInfoEXT{
+		ObjectType:   objectType,
+		ObjectHandle: handle,
+		PObjectName:  name,
+	}
+	if err := vk.SetDebugUtilsObjectNameEXT(ctx.Device, &info); err != nil {
+		ctx.Log().Debug("could not set object name", "name", name, "err", err)
+	}
+}
+
+// BeginLabel opens a labelled region of cb, which debuggers show as a group of commands. Regions nest, and each must
+// be closed by EndLabel in the same command buffer. It does nothing if DebugUtils is not set.
+func (ctx *Context) BeginLabel(cb vk.CommandBuffer, name string) {
+	if ctx.DebugUtils {
+		vk.CmdBeginDebugUtilsLabelEXT(cb, &vk.DebugUtilsLabelEXT{PLabelName: name})
+	}
+}
+
+// EndLabel closes the region opened by the last BeginLabel.
+func (ctx *Context) EndLabel(cb vk.CommandBuffer) {
+	if ctx.DebugUtils {
+		vk.CmdEndDebugUtilsLabelEXT(cb)
+	}
+}
+
+func appendMissing(list []string, name string) []string {
+	for _, s := range list {
+		if s == name {
+			return list
+		}
+	}
+	return append(list, name)
+}
@@ -2,13 +2,17 @@ package vkctx
 
 import (
 	"fmt"
+	"strings"
 	"unsafe"
 
 	"github.com/bbredesen/go-vk"
-	"golang.org/x/sys/windows"
 )
 
-func (ctx *Context) createInstance() {
+func (ctx *Context) createInstance() error {
+	if err := ctx.enableDebugUtils(); err != nil {
+		return err
+	}
+
 	appInfo := vk.ApplicationInfo{
 		PApplicationName:   "Context",
 		ApplicationVersion: vk.MAKE_VERSION(1, 0, 0),
@@ -24,95 +28,90 @@ func (ctx *Context) createInstance() {
 
 	var err error
 	if ctx.Instance, err = vk.CreateInstance(&icInfo, nil); err != nil {
-		fmt.Println("Could not create instance!")
-		panic(err.Error())
+		return wrap("create instance", err)
 	}
+	return ctx.createDebugMessenger()
 }
 
-func (app *Context) createSurface(hInstance windows.Handle, hWnd windows.HWND) {
-	ci := vk.Win32SurfaceCreateInfoKHR{
-		Hinstance: hInstance,
-		Hwnd:      hWnd,
-	}
-
+func (app *Context) createSurface(window SurfaceSource) error {
 	var err error
-	app.Surface, err = vk.CreateWin32SurfaceKHR(app.Instance, &ci, nil)
-	if err != nil {
-		panic("Could not create surface: " + err.Error())
+	if app.Surface, err = window.CreateSurface(app.Instance); err != nil {
+		return wrap("create surface", err)
 	}
+	return nil
 }
 
-func (app *Context) selectPhysicalDevice() {
-	devices, err := vk.EnumeratePhysicalDevices(app.Instance)
+// selectPhysicalDevice picks the device named by DeviceSelector, or else the suitable device with the highest score.
+// If there is none, the error lists why each device was rejected.
+func (app *Context) selectPhysicalDevice() error {
+	devices, infos, err := app.describeDevices()
 	if err != nil {
-		panic("Could not enumerate physical devices: " + err.Error())
+		return err
 	}
 
-	for _, dev := range devices {
-		if app.isDeviceSuitable(dev) {
-			app.PhysicalDevice = dev
-			return
-		}
+	i, err := app.chooseDevice(infos)
+	if err != nil {
+		return err
 	}
-
-	panic("Could not find a suitable physical device!")
+	app.PhysicalDevice = devices[i]
+	app.SamplerAnisotropy = infos[i].SamplerAnisotropy
+	return nil
 }
 
-func (app *Context) isDeviceSuitable(device vk.PhysicalDevice) bool {
-	// props := vk.GetPhysicalDeviceProperties(device)
-
-	// fmt.Printf("Found Physical Device:\n")
-	// fmt.Printf("  Device Name:\t\t%s\n", props.DeviceName)
-	// fmt.Printf("  Vendor/Device ID:\t0x%x / 0x%x \n", props.VendorID, props.DeviceID)
-	// fmt.Printf("  Device Type:\t\t%v\n", props.DeviceType)
-	// fmt.Printf("  Device API Version:\t%s\n", versionToString(props.ApiVersion))
-	// fmt.Printf("  Driver Version:\t%s\n", versionToString(props.DriverVersion))
-
+// unsuitableReason returns why a device can't be used, or an empty string if it can. extensions is the support for
+// each of EnableDeviceExtensions, as returned by deviceExtensionSupport.
+func (app *Context) unsuitableReason(device vk.PhysicalDevice, extensions map[string]bool, features vk.PhysicalDeviceFeatures) string {
 	/* Suitability is:
 	1) Support for the queue families we want to use (graphics)
 	2) Support for the surface presentation extensions we want to use
 	3) Support for swap chains // TODO
-	4) Support for sampler anisotropy
+	4) Support for robust buffer access. Sampler anisotropy is optional, and only counts toward the score.
 	*/
 
-	features := vk.GetPhysicalDeviceFeatures(device)
+	var missing []string
+	for _, name := range app.EnableDeviceExtensions {
+		if !extensions[name] {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return "missing extensions " + strings.Join(missing, ", ")
+	}
 
-	extSupport := app.checkDeviceExtensionSupport(device)
-	if !extSupport {
-		panic("extensions not supported!")
+	inds, err := app.analyzeQueueFamilies(device)
+	if err != nil {
+		return "could not query surface support: " + err.Error()
+	}
+	if !inds.isComplete() {
+		return "no graphics or presentation queue"
 	}
-	inds := app.analyzeQueueFamilies(device)
 
-	return extSupport && inds.graphicsIndex.HasValue() && inds.presentIndex.HasValue() && features.SamplerAnisotropy
+	if !features.RobustBufferAccess {
+		return "robust buffer access not supported"
+	}
+	return ""
 }
 
-func (app *Context) checkDeviceExtensionSupport(device vk.PhysicalDevice) bool {
+// deviceExtensionSupport reports, for each extension in EnableDeviceExtensions, whether device supports it.
+func (app *Context) deviceExtensionSupport(device vk.PhysicalDevice) (map[string]bool, error) {
 	devExtensions, err := vk.EnumerateDeviceExtensionProperties(device, "")
 	if err != nil {
-		panic(err.Error() + ": Could not enumerate device extension properties!")
-	}
-
-	foundProps := make(map[string]bool, len(app.EnableDeviceExtensions))
-	// fmt.Println("Searching for device extensions:")
-	for _, name := range app.EnableDeviceExtensions {
-		// init the found map with a false entry for each required extension
-		foundProps[name] = false
+		return nil, err
 	}
 
+	available := make(map[string]bool, len(devExtensions))
 	for _, exProp := range devExtensions {
-		if _, ok := foundProps[exProp.ExtensionName]; ok {
-			foundProps[exProp.ExtensionName] = true
-		}
+		available[exProp.ExtensionName] = true
 	}
 
-	haveAllExtensions := true
-	for _, v := range foundProps {
-		haveAllExtensions = haveAllExtensions && v
+	supported := make(map[string]bool, len(app.EnableDeviceExtensions))
+	for _, name := range app.EnableDeviceExtensions {
+		supported[name] = available[name]
 	}
-	return haveAllExtensions
+	return supported, nil
 }
 
-func (app *Context) analyzeQueueFamilies(device vk.PhysicalDevice) queueFamIndices {
+func (app *Context) analyzeQueueFamilies(device vk.PhysicalDevice) (queueFamIndices, error) {
 	qfp := vk.GetPhysicalDeviceQueueFamilyProperties(device)
 
 	var inds queueFamIndices
@@ -121,9 +120,20 @@ func (app *Context) analyzeQueueFamilies(device vk.PhysicalDevice) queueFamIndic
 		if (p.QueueFlags & vk.QUEUE_GRAPHICS_BIT) != 0 {
 			inds.graphicsIndex.Set(uint32(i))
 		}
+
+		if app.Surface == vk.SurfaceKHR(vk.NULL_HANDLE) {
+			// Nothing is presented in headless mode, so "present" work is submitted to the graphics queue. ListDevices
+			// has no surface either, and judges devices the same way.
+			if inds.graphicsIndex.HasValue() {
+				inds.presentIndex.Set(inds.graphicsIndex.Value())
+				break
+			}
+			continue
+		}
+
 		surf, err := vk.GetPhysicalDeviceSurfaceSupportKHR(device, uint32(i), app.Surface)
 		if err != nil {
-			panic(err)
+			return inds, err
 		}
 
 		if surf {
@@ -134,24 +144,38 @@ func (app *Context) analyzeQueueFamilies(device vk.PhysicalDevice) queueFamIndic
 			break
 		}
 	}
-	return inds
+	for i, p := range qfp {
+		if p.QueueFlags&vk.QUEUE_TRANSFER_BIT != 0 && p.QueueFlags&vk.QUEUE_GRAPHICS_BIT == 0 {
+			inds.transferIndex.Set(uint32(i))
+			// Prefer a transfer-only family over one that can also compute.
+			if p.QueueFlags&vk.QUEUE_COMPUTE_BIT == 0 {
+				break
+			}
+		}
+	}
+
+	return inds, nil
 }
 
-func (app *Context) createLogicalDevice() {
+func (app *Context) createLogicalDevice() error {
 	// Re-analyze for the selected device
-	qfInds := app.analyzeQueueFamilies(app.PhysicalDevice)
+	qfInds, err := app.analyzeQueueFamilies(app.PhysicalDevice)
+	if err != nil {
+		return wrap("query surface support", err)
+	}
+	// selectPhysicalDevice only picks devices with both queues.
+	if !qfInds.isComplete() {
+		return fmt.Errorf("%w (no graphics or presentation queue)", ErrNoSuitableDevice)
+	}
 
 	// creates one or two entries, depending on how many queue families are needed
 	uniqueQueueFams := make(map[uint32]bool)
-	if !qfInds.graphicsIndex.HasValue() {
-		panic("no graphics index found!")
-	}
 	uniqueQueueFams[qfInds.graphicsIndex.Value()] = true
+	uniqueQueueFams[qfInds.presentIndex.Value()] = true
 
-	if !qfInds.presentIndex.HasValue() {
-		panic("no presentation index found!")
+	if qfInds.transferIndex.HasValue() {
+		uniqueQueueFams[qfInds.transferIndex.Value()] = true
 	}
-	uniqueQueueFams[qfInds.presentIndex.Value()] = true
 
 	var dqCreateInfos []vk.DeviceQueueCreateInfo
 	for k, v := range uniqueQueueFams {
@@ -167,7 +191,7 @@ func (app *Context) createLogicalDevice() {
 
 	deviceFeatures := vk.PhysicalDeviceFeatures{
 		RobustBufferAccess: true,
-		SamplerAnisotropy:  true,
+		SamplerAnisotropy:  app.SamplerAnisotropy,
 		FillModeNonSolid:   true,
 	}
 
@@ -178,10 +202,8 @@ func (app *Context) createLogicalDevice() {
 		// EnabledLayerNames:     (deprecated)
 	}
 
+	// Robust buffer access was checked by selectPhysicalDevice.
 	f2 := vk.GetPhysicalDeviceFeatures2(app.PhysicalDevice)
-	if !f2.Features.RobustBufferAccess {
-		panic("robust buffer access not supported")
-	}
 	f2n := vk.PhysicalDeviceRobustness2FeaturesEXT{
 		// PNext:               nil,
 		// RobustBufferAccess2: false,
@@ -194,10 +216,8 @@ func (app *Context) createLogicalDevice() {
 	createInfo.PEnabledFeatures = nil
 
 	device, err := vk.CreateDevice(app.PhysicalDevice, &createInfo, nil)
-
 	if err != nil {
-		fmt.Printf("Logical device creation failed! (%s)\n", err.Error())
-		panic(err)
+		return wrap("create logical device", err)
 	}
 	app.Device = device
 
@@ -205,6 +225,15 @@ func (app *Context) createLogicalDevice() {
 	app.GraphicsQueue = vk.GetDeviceQueue(app.Device, qfInds.graphicsIndex.Value(), 0)
 	app.PresentQueueFamilyIndex = qfInds.presentIndex.Value()
 	app.PresentQueue = vk.GetDeviceQueue(app.Device, qfInds.presentIndex.Value(), 0)
+
+	app.HasTransferQueue = qfInds.transferIndex.HasValue()
+	if app.HasTransferQueue {
+		app.TransferQueueFamilyIndex = qfInds.transferIndex.Value()
+		app.TransferQueue = vk.GetDeviceQueue(app.Device, app.TransferQueueFamilyIndex, 0)
+	}
+
+	app.createAllocator()
+	return nil
 }
 
 // ---------------------
@@ -232,6 +261,9 @@ func (s *optUint32) HasValue() bool {
 type queueFamIndices struct {
 	graphicsIndex optUint32
 	presentIndex  optUint32
+	// transferIndex is a family that supports transfers but not graphics, which is usually backed by a DMA engine on
+	// discrete GPUs. It is optional.
+	transferIndex optUint32
 }
 
 func (q *queueFamIndices) isComplete() bool {
@@ -0,0 +1,236 @@
+package vkctx
+
+import (
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/bbredesen/go-vk"
+)
+
+// DeviceInfo describes a physical device as device selection sees it.
+type DeviceInfo struct {
+	// Index is the position of the device in enumeration order, which DeviceSelector accepts.
+	Index    int
+	Name     string
+	Type     vk.PhysicalDeviceType
+	VendorID uint32
+	DeviceID uint32
+
+	APIVersion, DriverVersion uint32
+
+	// DeviceLocalMemory is the total size of the device local memory heaps, in bytes. On integrated and CPU devices
+	// this is shared with the host.
+	DeviceLocalMemory uint64
+
+	// Extensions reports, for each name in EnableDeviceExtensions, whether the device supports it.
+	Extensions        map[string]bool
+	SamplerAnisotropy bool
+
+	// Unsuitable is why the device can't be used, or empty if it can. Score ranks the suitable devices, see
+	// deviceScore.
+	Unsuitable string
+	Score      int
+
+	// Selected is set by ListDevices on the device that Initialize would use.
+	Selected bool
+}
+
+// TypeName returns a short name for the device type, like "discrete" or "cpu".
+func (d DeviceInfo) TypeName() string {
+	switch d.Type {
+	case vk.PHYSICAL_DEVICE_TYPE_DISCRETE_GPU:
+		return "discrete"
+	case vk.PHYSICAL_DEVICE_TYPE_INTEGRATED_GPU:
+		return "integrated"
+	case vk.PHYSICAL_DEVICE_TYPE_VIRTUAL_GPU:
+		return "virtual"
+	case vk.PHYSICAL_DEVICE_TYPE_CPU:
+		return "cpu"
+	default:
+		return "other"
+	}
+}
+
+// ListDevices describes every physical device in enumeration order. If the context has no instance yet, a temporary
+// one is created, so this can be called on a zero Context with only EnableDeviceExtensions set. Without a surface,
+// presentation support can't be checked, so suitability is judged as for InitializeHeadless.
+func (ctx *Context) ListDevices() ([]DeviceInfo, error) {
+	if ctx.Instance == vk.Instance(vk.NULL_HANDLE) {
+		if err := ctx.createInstance(); err != nil {
+			return nil, err
+		}
+		defer func() {
+			ctx.destroyDebugMessenger()
+			vk.DestroyInstance(ctx.Instance, nil)
+			ctx.Instance = vk.Instance(vk.NULL_HANDLE)
+		}()
+	}
+
+	_, infos, err := ctx.describeDevices()
+	if err != nil {
+		return nil, err
+	}
+	if i, err := ctx.chooseDevice(infos); err == nil {
+		infos[i].Selected = true
+	}
+	return infos, nil
+}
+
+// describeDevices enumerates the physical devices and returns them along with their descriptions.
+func (ctx *Context) describeDevices() ([]vk.PhysicalDevice, []DeviceInfo, error) {
+	devices, err := vk.EnumeratePhysicalDevices(ctx.Instance)
+	if err != nil {
+		return nil, nil, wrap("enumerate physical devices", err)
+	}
+
+	infos := make([]DeviceInfo, len(devices))
+	for i, dev := range devices {
+		props := vk.GetPhysicalDeviceProperties(dev)
+		features := vk.GetPhysicalDeviceFeatures(dev)
+
+		info := DeviceInfo{
+			Index:             i,
+			Name:              props.DeviceName,
+			Type:              props.DeviceType,
+			VendorID:          props.VendorID,
+			DeviceID:          props.DeviceID,
+			APIVersion:        props.ApiVersion,
+			DriverVersion:     props.DriverVersion,
+			DeviceLocalMemory: deviceLocalMemory(dev),
+			SamplerAnisotropy: features.SamplerAnisotropy,
+		}
+
+		if info.Extensions, err = ctx.deviceExtensionSupport(dev); err != nil {
+			info.Unsuitable = "could not enumerate device extensions: " + err.Error()
+		} else {
+			info.Unsuitable = ctx.unsuitableReason(dev, info.Extensions, features)
+		}
+		info.Score = deviceScore(info)
+		infos[i] = info
+	}
+	return devices, infos, nil
+}
+
+// chooseDevice returns the index of the device named by DeviceSelector, or else of the suitable device with the
+// highest score. Ties go to the device enumerated first.
+func (ctx *Context) chooseDevice(infos []DeviceInfo) (int, error) {
+	if ctx.DeviceSelector != "" {
+		i, err := matchDevice(infos, ctx.DeviceSelector)
+		if err != nil {
+			return -1, err
+		}
+		if infos[i].Unsuitable != "" {
+			return -1, fmt.Errorf("%w (%s: %s)", ErrNoSuitableDevice, infos[i].Name, infos[i].Unsuitable)
+		}
+		return i, nil
+	}
+
+	best := -1
+	reasons := []string{"no devices found"}
+	if len(infos) > 0 {
+		reasons = nil
+	}
+	for i, info := range infos {
+		if info.Unsuitable != "" {
+			reasons = append(reasons, fmt.Sprintf("%s: %s", info.Name, info.Unsuitable))
+			continue
+		}
+		if best < 0 || info.Score > infos[best].Score {
+			best = i
+		}
+	}
+	if best < 0 {
+		return -1, fmt.Errorf("%w (%s)", ErrNoSuitableDevice, strings.Join(reasons, "; "))
+	}
+	return best, nil
+}
+
+// matchDevice finds the device that sel refers to: by index if sel is a number in range, otherwise as a
+// case-insensitive substring of exactly one device name, so that a model number like "3090" still matches by name.
+func matchDevice(infos []DeviceInfo, sel string) (int, error) {
+	i, err := strconv.Atoi(sel)
+	isIndex := err == nil
+	if isIndex && i >= 0 && i < len(infos) {
+		return i, nil
+	}
+
+	found := -1
+	var names []string
+	for i, info := range infos {
+		if strings.Contains(strings.ToLower(info.Name), strings.ToLower(sel)) {
+			found = i
+			names = append(names, info.Name)
+		}
+	}
+	switch {
+	case len(names) == 0 && isIndex:
+		return -1, fmt.Errorf("%w (no device %d, %d found, and no device name contains %q)", ErrDeviceNotFound, i, len(infos), sel)
+	case len(names) == 0:
+		return -1, fmt.Errorf("%w (no device name contains %q)", ErrDeviceNotFound, sel)
+	case len(names) == 1:
+		return found, nil
+	default:
+		return -1, fmt.Errorf("%w (%q matches %s)", ErrDeviceNotFound, sel, strings.Join(names, ", "))
+	}
+}
+
+// deviceScore ranks suitable devices. The device type decides first: discrete GPUs, then integrated, virtual, and
+// CPU devices. Within a type, each GiB of device local memory adds a point, and support for optional features adds
+// a few more, but neither can lift a device above the next type.
+func deviceScore(info DeviceInfo) int {
+	score := 0
+	switch info.Type {
+	case vk.PHYSICAL_DEVICE_TYPE_DISCRETE_GPU:
+		score = 4000
+	case vk.PHYSICAL_DEVICE_TYPE_INTEGRATED_GPU:
+		score = 3000
+	case vk.PHYSICAL_DEVICE_TYPE_VIRTUAL_GPU:
+		score = 2000
+	case vk.PHYSICAL_DEVICE_TYPE_CPU:
+		score = 1000
+	}
+
+	gib := int(info.DeviceLocalMemory >> 30)
+	if gib > 900 {
+		gib = 900
+	}
+	score += gib
+
+	if info.SamplerAnisotropy {
+		score += 50
+	}
+	return score
+}
+
+// deviceLocalMemory returns the total size of the device local heaps of dev.
+func deviceLocalMemory(dev vk.PhysicalDevice) uint64 {
+	memProps := vk.GetPhysicalDeviceMemoryProperties(dev)
+
+	var total uint64
+	for i := uint32(0); i < memProps.MemoryHeapCount; i++ {
+		if memProps.MemoryHeaps[i].Flags&vk.MEMORY_HEAP_DEVICE_LOCAL_BIT != 0 {
+			total += uint64(memProps.MemoryHeaps[i].Size)
+		}
+	}
+	return total
+}
+
+// VersionString formats a Vulkan version number, like an API version, as major.minor.patch.
+func VersionString(v uint32) string {
+	return fmt.Sprintf("%d.%d.%d", v>>22&0x7f, v>>12&0x3ff, v&0xfff)
+}
+
+// DriverVersionString formats a driver version. Most drivers, including Mesa's, encode it like an API version, but
+// NVIDIA and Intel's Windows driver use their own layouts.
+func DriverVersionString(vendorID, v uint32) string {
+	switch {
+	case vendorID == 0x10de:
+		return fmt.Sprintf("%d.%d.%d.%d", v>>22&0x3ff, v>>14&0xff, v>>6&0xff, v&0x3f)
+	case vendorID == 0x8086 && runtime.GOOS == "windows":
+		return fmt.Sprintf("%d.%d", v>>14, v&0x3fff)
+	default:
+		return VersionString(v)
+	}
+}
@@ -0,0 +1,98 @@
+package vkctx
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/bbredesen/go-vk"
+)
+
+func TestDeviceScore(t *testing.T) {
+	const gib = 1 << 30
+
+	// Each device scores less than the one before it.
+	ranked := []struct {
+		name string
+		info DeviceInfo
+	}{
+		{"discrete", DeviceInfo{Type: vk.PHYSICAL_DEVICE_TYPE_DISCRETE_GPU, DeviceLocalMemory: 8 * gib, SamplerAnisotropy: true}},
+		{"discrete, less memory", DeviceInfo{Type: vk.PHYSICAL_DEVICE_TYPE_DISCRETE_GPU, DeviceLocalMemory: 4 * gib, SamplerAnisotropy: true}},
+		{"discrete, no anisotropy", DeviceInfo{Type: vk.PHYSICAL_DEVICE_TYPE_DISCRETE_GPU, DeviceLocalMemory: 4 * gib}},
+		// Neither memory nor features lift a device above the next type.
+		{"integrated, maximal", DeviceInfo{Type: vk.PHYSICAL_DEVICE_TYPE_INTEGRATED_GPU, DeviceLocalMemory: 4096 * gib, SamplerAnisotropy: true}},
+		{"integrated", DeviceInfo{Type: vk.PHYSICAL_DEVICE_TYPE_INTEGRATED_GPU, DeviceLocalMemory: 2 * gib}},
+		{"virtual, maximal", DeviceInfo{Type: vk.PHYSICAL_DEVICE_TYPE_VIRTUAL_GPU, DeviceLocalMemory: 4096 * gib, SamplerAnisotropy: true}},
+		{"cpu, maximal", DeviceInfo{Type: vk.PHYSICAL_DEVICE_TYPE_CPU, DeviceLocalMemory: 4096 * gib, SamplerAnisotropy: true}},
+		{"other", DeviceInfo{DeviceLocalMemory: 2 * gib}},
+	}
+	for i := 1; i < len(ranked); i++ {
+		prev, cur := deviceScore(ranked[i-1].info), deviceScore(ranked[i].info)
+		if cur >= prev {
+			t.Errorf("%s scores %d, not below %s at %d", ranked[i].name, cur, ranked[i-1].name, prev)
+		}
+	}
+
+	tests := []struct {
+		name string
+		info DeviceInfo
+		want int
+	}{
+		// Part of a GiB doesn't count.
+		{"memory rounds down", DeviceInfo{Type: vk.PHYSICAL_DEVICE_TYPE_DISCRETE_GPU, DeviceLocalMemory: 3*gib - 1}, 4002},
+		{"memory cap", DeviceInfo{Type: vk.PHYSICAL_DEVICE_TYPE_DISCRETE_GPU, DeviceLocalMemory: 901 * gib}, 4900},
+		{"anisotropy", DeviceInfo{Type: vk.PHYSICAL_DEVICE_TYPE_CPU, SamplerAnisotropy: true}, 1050},
+	}
+	for _, test := range tests {
+		if got := deviceScore(test.info); got != test.want {
+			t.Errorf("%s: score is %d, want %d", test.name, got, test.want)
+		}
+	}
+}
+
+func TestMatchDevice(t *testing.T) {
+	infos := []DeviceInfo{
+		{Index: 0, Name: "NVIDIA GeForce RTX 3090"},
+		{Index: 1, Name: "AMD Radeon RX 6800"},
+		{Index: 2, Name: "llvmpipe (LLVM 15.0.7, 256 bits)"},
+		{Index: 3, Name: "NVIDIA GeForce RTX 2080"},
+	}
+
+	tests := []struct {
+		sel  string
+		want int
+	}{
+		{"1", 1},
+		{"3", 3},
+		// An index wins over a name that contains the same digits.
+		{"0", 0},
+		{"llvmpipe", 2},
+		{"radeon", 1},
+		{"GEFORCE RTX 2", 3},
+		// A number that isn't an index is part of a name.
+		{"3090", 0},
+		{"6800", 1},
+		{"15", 2},
+		// Ambiguous, or matching nothing.
+		{"nvidia", -1},
+		{"80", -1},
+		{"intel", -1},
+		{"4", -1},
+		{"-1", -1},
+	}
+	for _, test := range tests {
+		got, err := matchDevice(infos, test.sel)
+		if test.want < 0 {
+			if !errors.Is(err, ErrDeviceNotFound) {
+				t.Errorf("matchDevice(%q) = %d, %v; want ErrDeviceNotFound", test.sel, got, err)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("matchDevice(%q) = %d, %v; want %d", test.sel, got, err, test.want)
+		}
+	}
+
+	if _, err := matchDevice(nil, "0"); !errors.Is(err, ErrDeviceNotFound) {
+		t.Errorf("matchDevice with no devices: %v, want ErrDeviceNotFound", err)
+	}
+}
@@ -0,0 +1,39 @@
+package vkctx
+
+import (
+	"errors"
+)
+
+// Error is returned when a Vulkan call made by the context fails. Op says what the context was doing, and Err is the
+// cause, usually a vk.Result, which can be matched with errors.Is.
+type Error struct {
+	Op  string
+	Err error
+}
+
+func (e *Error) Error() string {
+	return "vkctx: could not " + e.Op + ": " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func wrap(op string, err error) error {
+	return &Error{Op: op, Err: err}
+}
+
+var (
+	// ErrNoSuitableDevice is returned by Initialize and InitializeHeadless when no physical device has the extensions,
+	// queues, and features the context needs. The error wrapping it gives the reason each device was rejected.
+	ErrNoSuitableDevice = errors.New("vkctx: no suitable physical device")
+
+	// ErrDeviceNotFound is returned when DeviceSelector does not name exactly one physical device.
+	ErrDeviceNotFound = errors.New("vkctx: requested device not found")
+
+	// ErrValidationUnavailable is returned when Validation is set but the Khronos validation layer is not installed.
+	ErrValidationUnavailable = errors.New("vkctx: validation was requested, but VK_LAYER_KHRONOS_validation is not installed")
+
+	// ErrNoMemoryType is returned when no memory type allowed for a resource has the requested properties.
+	ErrNoMemoryType = errors.New("vkctx: no memory type has the requested properties")
+)
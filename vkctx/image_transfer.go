@@ -0,0 +1,126 @@
+package vkctx
+
+import (
+	"github.com/bbredesen/go-vk"
+)
+
+// CreateSampledImage creates a device-local image for sampling, with room for mip levels and array layers, which
+// CreateImage does not support. Pass IMAGE_CREATE_CUBE_COMPATIBLE_BIT in flags (with 6 layers) for a cube map. The
+// image can be the destination of a buffer copy, on the transfer queue if there is one, see UploadSharing.
+func (ctx *Context) CreateSampledImage(extent vk.Extent2D, format vk.Format, mipLevels, arrayLayers uint32, flags vk.ImageCreateFlags) (vk.Image, *Allocation, error) {
+	sharingMode, queueFamilies := ctx.UploadSharing()
+	imageCI := vk.ImageCreateInfo{
+		Flags:     flags,
+		ImageType: vk.IMAGE_TYPE_2D,
+		Format:    format,
+		Extent: vk.Extent3D{
+			Width:  extent.Width,
+			Height: extent.Height,
+			Depth:  1,
+		},
+		MipLevels:           mipLevels,
+		ArrayLayers:         arrayLayers,
+		Tiling:              vk.IMAGE_TILING_OPTIMAL,
+		Usage:               vk.IMAGE_USAGE_TRANSFER_DST_BIT | vk.IMAGE_USAGE_SAMPLED_BIT,
+		SharingMode:         sharingMode,
+		PQueueFamilyIndices: queueFamilies,
+		InitialLayout:       vk.IMAGE_LAYOUT_UNDEFINED,
+		Samples:             vk.SAMPLE_COUNT_1_BIT,
+	}
+
+	image, err := vk.CreateImage(ctx.Device, &imageCI, nil)
+	if err != nil {
+		return image, nil, wrap("create image", err)
+	}
+
+	imageMemory, err := ctx.AllocateImage(image, imageCI.Tiling, vk.MEMORY_PROPERTY_DEVICE_LOCAL_BIT)
+	if err != nil {
+		vk.DestroyImage(ctx.Device, image, nil)
+		return vk.Image(vk.NULL_HANDLE), nil, err
+	}
+
+	return image, imageMemory, nil
+}
+
+// CreateSampledImageView creates a color view of every mip level and layer of an image made by CreateSampledImage.
+func (ctx *Context) CreateSampledImageView(image vk.Image, format vk.Format, viewType vk.ImageViewType, mipLevels, arrayLayers uint32) (vk.ImageView, error) {
+	ivCI := vk.ImageViewCreateInfo{
+		Image:    image,
+		ViewType: viewType,
+		Format:   format,
+		SubresourceRange: vk.ImageSubresourceRange{
+			AspectMask:     vk.IMAGE_ASPECT_COLOR_BIT,
+			BaseMipLevel:   0,
+			LevelCount:     mipLevels,
+			BaseArrayLayer: 0,
+			LayerCount:     arrayLayers,
+		},
+	}
+
+	iv, err := vk.CreateImageView(ctx.Device, &ivCI, nil)
+	if err != nil {
+		return iv, wrap("create image view", err)
+	}
+	return iv, nil
+}
+
+// TransitionImageLayout records a pipeline barrier moving all mip levels and layers of a color image from oldLayout to
+// newLayout. Only the transitions needed to upload and sample a texture are supported:
+// UNDEFINED -> TRANSFER_DST_OPTIMAL and TRANSFER_DST_OPTIMAL -> SHADER_READ_ONLY_OPTIMAL.
+func (ctx *Context) TransitionImageLayout(cb vk.CommandBuffer, image vk.Image, mipLevels, arrayLayers uint32, oldLayout, newLayout vk.ImageLayout) {
+	barrier := vk.ImageMemoryBarrier{
+		OldLayout:           oldLayout,
+		NewLayout:           newLayout,
+		SrcQueueFamilyIndex: vk.QUEUE_FAMILY_IGNORED,
+		DstQueueFamilyIndex: vk.QUEUE_FAMILY_IGNORED,
+		Image:               image,
+		SubresourceRange: vk.ImageSubresourceRange{
+			AspectMask:     vk.IMAGE_ASPECT_COLOR_BIT,
+			BaseMipLevel:   0,
+			LevelCount:     mipLevels,
+			BaseArrayLayer: 0,
+			LayerCount:     arrayLayers,
+		},
+	}
+
+	var srcStage, dstStage vk.PipelineStageFlags
+
+	switch {
+	case oldLayout == vk.IMAGE_LAYOUT_UNDEFINED && newLayout == vk.IMAGE_LAYOUT_TRANSFER_DST_OPTIMAL:
+		barrier.SrcAccessMask = 0
+		barrier.DstAccessMask = vk.ACCESS_TRANSFER_WRITE_BIT
+		srcStage = vk.PIPELINE_STAGE_TOP_OF_PIPE_BIT
+		dstStage = vk.PIPELINE_STAGE_TRANSFER_BIT
+
+	case oldLayout == vk.IMAGE_LAYOUT_TRANSFER_DST_OPTIMAL && newLayout == vk.IMAGE_LAYOUT_SHADER_READ_ONLY_OPTIMAL:
+		barrier.SrcAccessMask = vk.ACCESS_TRANSFER_WRITE_BIT
+		barrier.DstAccessMask = vk.ACCESS_SHADER_READ_BIT
+		srcStage = vk.PIPELINE_STAGE_TRANSFER_BIT
+		dstStage = vk.PIPELINE_STAGE_FRAGMENT_SHADER_BIT
+
+	default:
+		panic("unsupported image layout transition")
+	}
+
+	vk.CmdPipelineBarrier(cb, srcStage, dstStage, 0, nil, nil, []vk.ImageMemoryBarrier{barrier})
+}
+
+// CopyBufferToImage records a copy of tightly packed pixel data, starting at offset in buffer, into one mip level and
+// layer of a color image. The image must be in TRANSFER_DST_OPTIMAL layout. extent is the size of that mip level.
+func (ctx *Context) CopyBufferToImage(cb vk.CommandBuffer, buffer vk.Buffer, offset vk.DeviceSize, image vk.Image, mipLevel, arrayLayer uint32, extent vk.Extent2D) {
+	region := vk.BufferImageCopy{
+		BufferOffset:      offset,
+		BufferRowLength:   0,
+		BufferImageHeight: 0,
+		ImageSubresource: vk.ImageSubresourceLayers{
+			AspectMask:     vk.IMAGE_ASPECT_COLOR_BIT,
+			MipLevel:       mipLevel,
+			BaseArrayLayer: arrayLayer,
+			LayerCount:     1,
+		},
+		ImageOffset: vk.Offset3D{X: 0, Y: 0, Z: 0},
+		ImageExtent: vk.Extent3D{Width: extent.Width, Height: extent.Height, Depth: 1},
+	}
+
+	vk.CmdCopyBufferToImage(cb, buffer, image, vk.IMAGE_LAYOUT_TRANSFER_DST_OPTIMAL, []vk.BufferImageCopy{region})
+}
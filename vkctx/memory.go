@@ -0,0 +1,262 @@
+package vkctx
+
+import (
+	"fmt"
+	"unsafe"
+
+	"github.com/bbredesen/go-vk"
+)
+
+// DefaultMemoryBlockSize is the size of the device memory blocks that buffers and images are sub-allocated from.
+// Devices limit the number of allocations, to as few as 4096, so one per resource does not scale to large scenes.
+const DefaultMemoryBlockSize = 64 << 20
+
+// Allocation is a range of device memory holding one buffer or image, made by Allocate. Memory and Offset are what
+// to bind the resource to. Release it with Free.
+type Allocation struct {
+	Memory vk.DeviceMemory
+	Offset vk.DeviceSize
+	Size   vk.DeviceSize
+
+	memoryType uint32
+	block      *deviceBlock
+}
+
+// deviceBlock is one device memory allocation, with the bookkeeping for the ranges allocated from it.
+type deviceBlock struct {
+	*memoryBlock
+	memory vk.DeviceMemory
+	// mapped is the host address of the whole block, set the first time an allocation from it is mapped.
+	mapped unsafe.Pointer
+	// dedicated blocks hold a single allocation too big to share a block, and are freed along with it.
+	dedicated bool
+}
+
+// memoryPool is the blocks of one memory type.
+type memoryPool struct {
+	blocks    []*deviceBlock
+	blockSize uint64
+}
+
+// MemoryTypeStats is the usage of one memory type, see MemoryStats.
+type MemoryTypeStats struct {
+	MemoryType uint32
+	Flags      vk.MemoryPropertyFlags
+
+	Blocks, Allocations int
+	// BlockBytes is the size of all blocks, of which AllocatedBytes are in use.
+	BlockBytes, AllocatedBytes uint64
+	// FreeRanges is the number of unused ranges in all blocks. It grows as memory fragments.
+	FreeRanges int
+}
+
+func (s MemoryTypeStats) String() string {
+	return fmt.Sprintf("memory type %d: %d allocations, %d of %d bytes in %d blocks, %d free ranges",
+		s.MemoryType, s.Allocations, s.AllocatedBytes, s.BlockBytes, s.Blocks, s.FreeRanges)
+}
+
+// createAllocator sets up an empty pool for each memory type of the device. Blocks are allocated on demand.
+func (ctx *Context) createAllocator() {
+	ctx.memoryProps = vk.GetPhysicalDeviceMemoryProperties(ctx.PhysicalDevice)
+	ctx.bufferImageGranularity = uint64(vk.GetPhysicalDeviceProperties(ctx.PhysicalDevice).Limits.BufferImageGranularity)
+
+	ctx.memoryPools = make([]memoryPool, ctx.memoryProps.MemoryTypeCount)
+	for i := range ctx.memoryPools {
+		// Small heaps, like the 256MB of host-visible device memory without resizable BAR, get smaller blocks so that
+		// one block doesn't take a large share of them.
+		heap := ctx.memoryProps.MemoryHeaps[ctx.memoryProps.MemoryTypes[i].HeapIndex]
+		ctx.memoryPools[i].blockSize = DefaultMemoryBlockSize
+		if size := uint64(heap.Size) / 8; size > 0 && size < ctx.memoryPools[i].blockSize {
+			ctx.memoryPools[i].blockSize = size
+		}
+	}
+}
+
+// destroyAllocator frees all device memory, including any allocations that were never freed.
+func (ctx *Context) destroyAllocator() {
+	for i := range ctx.memoryPools {
+		for _, b := range ctx.memoryPools[i].blocks {
+			vk.FreeMemory(ctx.Device, b.memory, nil)
+		}
+	}
+	ctx.memoryPools = nil
+}
+
+// Allocate sub-allocates memory meeting req, from the first memory type with all of flags that has room. linear must
+// be false for images with optimal tiling, and true for anything else, so that the two are kept apart as
+// bufferImageGranularity requires.
+func (ctx *Context) Allocate(req vk.MemoryRequirements, flags vk.MemoryPropertyFlags, linear bool) (*Allocation, error) {
+	err := ErrNoMemoryType
+	for i := uint32(0); i < ctx.memoryProps.MemoryTypeCount; i++ {
+		if req.MemoryTypeBits&(1<<i) == 0 || ctx.memoryProps.MemoryTypes[i].PropertyFlags&flags != flags {
+			continue
+		}
+		var a *Allocation
+		// A heap may be full even though another with the same flags is not, so keep looking on failure.
+		if a, err = ctx.allocateFromType(i, uint64(req.Size), uint64(req.Alignment), linear); err == nil {
+			return a, nil
+		}
+	}
+	return nil, wrap("allocate device memory", err)
+}
+
+func (ctx *Context) allocateFromType(memType uint32, size, alignment uint64, linear bool) (*Allocation, error) {
+	b, offset, err := ctx.memoryPools[memType].allocate(size, alignment, linear, ctx.bufferImageGranularity,
+		func(blockSize uint64) (vk.DeviceMemory, error) {
+			return vk.AllocateMemory(ctx.Device, &vk.MemoryAllocateInfo{
+				AllocationSize:  vk.DeviceSize(blockSize),
+				MemoryTypeIndex: memType,
+			}, nil)
+		})
+	if err != nil {
+		return nil, err
+	}
+	return ctx.newAllocation(memType, b, offset, size), nil
+}
+
+// allocate finds room for size bytes in the first shared block that has it, or else in a new block, for which
+// newMemory allocates the device memory. Allocations of more than half the block size get a dedicated block.
+func (pool *memoryPool) allocate(size, alignment uint64, linear bool, granularity uint64,
+	newMemory func(blockSize uint64) (vk.DeviceMemory, error)) (*deviceBlock, uint64, error) {
+	dedicated := size > pool.blockSize/2
+	if !dedicated {
+		for _, b := range pool.blocks {
+			if b.dedicated {
+				continue
+			}
+			if offset, ok := b.allocate(size, alignment, linear); ok {
+				return b, offset, nil
+			}
+		}
+	}
+
+	blockSize := pool.blockSize
+	if dedicated {
+		blockSize = size
+	}
+	memory, err := newMemory(blockSize)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	b := &deviceBlock{
+		memoryBlock: newMemoryBlock(blockSize, granularity),
+		memory:      memory,
+		dedicated:   dedicated,
+	}
+	pool.blocks = append(pool.blocks, b)
+
+	// A new block is empty, and device memory is aligned for any resource, so this can't fail.
+	offset, _ := b.allocate(size, alignment, linear)
+	return b, offset, nil
+}
+
+func (ctx *Context) newAllocation(memType uint32, b *deviceBlock, offset, size uint64) *Allocation {
+	return &Allocation{
+		Memory:     b.memory,
+		Offset:     vk.DeviceSize(offset),
+		Size:       vk.DeviceSize(size),
+		memoryType: memType,
+		block:      b,
+	}
+}
+
+// Free releases an allocation. A block left empty is returned to the device, unless it is the last block of its
+// memory type, which is kept for the next allocation. Free does nothing if a is nil. An allocation the block has no
+// record of is logged and otherwise ignored, leaving the block as it was.
+func (ctx *Context) Free(a *Allocation) {
+	if a == nil || a.block == nil {
+		return
+	}
+	b := a.block
+	if err := b.free(uint64(a.Offset)); err != nil {
+		ctx.Log().Error("could not free memory", "memoryType", a.memoryType, "err", err)
+		return
+	}
+	a.block = nil
+
+	if !b.empty() {
+		return
+	}
+	pool := &ctx.memoryPools[a.memoryType]
+	shared := 0
+	for _, pb := range pool.blocks {
+		if !pb.dedicated {
+			shared++
+		}
+	}
+	if !b.dedicated && shared == 1 {
+		return
+	}
+
+	for i, pb := range pool.blocks {
+		if pb == b {
+			pool.blocks = append(pool.blocks[:i], pool.blocks[i+1:]...)
+			break
+		}
+	}
+	if b.mapped != nil {
+		vk.UnmapMemory(ctx.Device, b.memory)
+	}
+	vk.FreeMemory(ctx.Device, b.memory, nil)
+}
+
+// AllocateBuffer allocates memory for buffer with all of flags, and binds it.
+func (ctx *Context) AllocateBuffer(buffer vk.Buffer, flags vk.MemoryPropertyFlags) (*Allocation, error) {
+	a, err := ctx.Allocate(vk.GetBufferMemoryRequirements(ctx.Device, buffer), flags, true)
+	if err != nil {
+		return nil, err
+	}
+	if err := vk.BindBufferMemory(ctx.Device, buffer, a.Memory, a.Offset); err != nil {
+		ctx.Free(a)
+		return nil, wrap("bind buffer memory", err)
+	}
+	return a, nil
+}
+
+// AllocateImage allocates memory for an image with the given tiling and all of flags, and binds it.
+func (ctx *Context) AllocateImage(image vk.Image, tiling vk.ImageTiling, flags vk.MemoryPropertyFlags) (*Allocation, error) {
+	a, err := ctx.Allocate(vk.GetImageMemoryRequirements(ctx.Device, image), flags, tiling == vk.IMAGE_TILING_LINEAR)
+	if err != nil {
+		return nil, err
+	}
+	if err := vk.BindImageMemory(ctx.Device, image, a.Memory, a.Offset); err != nil {
+		ctx.Free(a)
+		return nil, wrap("bind image memory", err)
+	}
+	return a, nil
+}
+
+// MapMemory returns the host address of a host-visible allocation. Blocks stay mapped until they are freed, as Vulkan
+// allows only one mapping of a memory object at a time, so there is no unmap.
+func (ctx *Context) MapMemory(a *Allocation) (unsafe.Pointer, error) {
+	b := a.block
+	if b.mapped == nil {
+		ptr, err := vk.MapMemory(ctx.Device, b.memory, 0, vk.DeviceSize(b.size), 0)
+		if err != nil {
+			return nil, wrap("map memory", err)
+		}
+		b.mapped = unsafe.Pointer(ptr)
+	}
+	return unsafe.Add(b.mapped, a.Offset), nil
+}
+
+// MemoryStats returns the usage of each memory type that has any blocks allocated.
+func (ctx *Context) MemoryStats() []MemoryTypeStats {
+	var rval []MemoryTypeStats
+	for i, pool := range ctx.memoryPools {
+		if len(pool.blocks) == 0 {
+			continue
+		}
+		s := MemoryTypeStats{MemoryType: uint32(i), Flags: ctx.memoryProps.MemoryTypes[i].PropertyFlags}
+		for _, b := range pool.blocks {
+			s.Blocks++
+			s.BlockBytes += b.size
+			s.Allocations += b.allocations
+			s.AllocatedBytes += b.used
+			s.FreeRanges += b.freeRanges()
+		}
+		rval = append(rval, s)
+	}
+	return rval
+}
@@ -0,0 +1,40 @@
+package vkctx
+
+import (
+	"github.com/bbredesen/go-vk"
+)
+
+// OffscreenImageFormat is the color format used for headless rendering. It matches the byte order of image.RGBA, so
+// the copied-back pixels can be encoded without swizzling, and is sRGB like the preferred swapchain format, so shaders
+// write linear color either way.
+const OffscreenImageFormat = vk.FORMAT_R8G8B8A8_SRGB
+
+// createOffscreenTarget stands in for createSwapchain and createSwapchainImageViews when running headless. The image
+// can be used as a color attachment and as the source of a copy back to host memory.
+func (ctx *Context) createOffscreenTarget(extent vk.Extent2D) error {
+	ctx.SwapchainExtent = extent
+	ctx.SwapchainImageFormat = OffscreenImageFormat
+
+	img, mem, err := ctx.CreateImage(extent, OffscreenImageFormat, vk.IMAGE_TILING_OPTIMAL, vk.IMAGE_USAGE_COLOR_ATTACHMENT_BIT|vk.IMAGE_USAGE_TRANSFER_SRC_BIT, vk.MEMORY_PROPERTY_DEVICE_LOCAL_BIT)
+	if err != nil {
+		return err
+	}
+
+	ctx.SwapchainImages = []vk.Image{img}
+	ctx.OffscreenMemory = mem
+
+	return ctx.createSwapchainImageViews()
+}
+
+func (ctx *Context) destroyOffscreenTarget() {
+	ctx.destroyImageViews()
+	ctx.destroyDepthResources()
+
+	for _, img := range ctx.SwapchainImages {
+		vk.DestroyImage(ctx.Device, img, nil)
+	}
+	ctx.SwapchainImages = nil
+
+	ctx.Free(ctx.OffscreenMemory)
+	ctx.OffscreenMemory = nil
+}
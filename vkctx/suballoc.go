@@ -0,0 +1,166 @@
+package vkctx
+
+import "fmt"
+
+// This file has the bookkeeping for sub-allocating memory blocks. It does not call Vulkan, see memory.go for that.
+
+// blockRange is a range of a memoryBlock, either free or holding one allocation.
+type blockRange struct {
+	offset, size uint64
+	free         bool
+	// linear is true for buffers and linear images, and false for optimal tiling images. Ranges of different kinds
+	// may not share a bufferImageGranularity page.
+	linear bool
+}
+
+func (r *blockRange) end() uint64 {
+	return r.offset + r.size
+}
+
+// memoryBlock tracks which ranges of one device memory allocation are in use. ranges covers the whole block in order
+// of offset, and adjacent free ranges are always merged.
+type memoryBlock struct {
+	size        uint64
+	granularity uint64
+	ranges      []blockRange
+	used        uint64
+	allocations int
+}
+
+func newMemoryBlock(size, granularity uint64) *memoryBlock {
+	if granularity == 0 {
+		granularity = 1
+	}
+	return &memoryBlock{
+		size:        size,
+		granularity: granularity,
+		ranges:      []blockRange{{offset: 0, size: size, free: true}},
+	}
+}
+
+func alignUp(offset, alignment uint64) uint64 {
+	if alignment <= 1 {
+		return offset
+	}
+	return (offset + alignment - 1) / alignment * alignment
+}
+
+// samePage reports whether the bytes at offsets a and b, with a <= b, are on the same page of size pageSize.
+func samePage(a, b, pageSize uint64) bool {
+	return a/pageSize == b/pageSize
+}
+
+// allocate finds room for size bytes at a multiple of alignment, first fit, and returns the offset. ok is false if the
+// block has no free range big enough.
+func (b *memoryBlock) allocate(size, alignment uint64, linear bool) (offset uint64, ok bool) {
+	if size == 0 {
+		size = 1
+	}
+	for i := range b.ranges {
+		r := &b.ranges[i]
+		if !r.free || r.size < size {
+			continue
+		}
+
+		offset = alignUp(r.offset, alignment)
+		// A free range never follows another, so the one before, if any, is in use.
+		if i > 0 {
+			prev := &b.ranges[i-1]
+			if prev.linear != linear && samePage(prev.end()-1, offset, b.granularity) {
+				offset = alignUp(offset, b.granularity)
+			}
+		}
+		if offset+size > r.end() {
+			continue
+		}
+		if i+1 < len(b.ranges) {
+			next := &b.ranges[i+1]
+			if next.linear != linear && samePage(offset+size-1, next.offset, b.granularity) {
+				continue
+			}
+		}
+
+		b.split(i, offset, size, linear)
+		b.used += size
+		b.allocations++
+		return offset, true
+	}
+	return 0, false
+}
+
+// split replaces free range i with the allocation at offset, and free ranges for whatever is left before and after.
+func (b *memoryBlock) split(i int, offset, size uint64, linear bool) {
+	r := b.ranges[i]
+	var parts []blockRange
+	if offset > r.offset {
+		parts = append(parts, blockRange{offset: r.offset, size: offset - r.offset, free: true})
+	}
+	parts = append(parts, blockRange{offset: offset, size: size, linear: linear})
+	if end := offset + size; end < r.end() {
+		parts = append(parts, blockRange{offset: end, size: r.end() - end, free: true})
+	}
+
+	rest := append(parts, b.ranges[i+1:]...)
+	b.ranges = append(b.ranges[:i], rest...)
+}
+
+// free releases the allocation at offset, merging it with the free ranges on either side. It returns an error, and
+// changes nothing, if there is no allocation at offset, which means it was freed twice or never allocated from this
+// block.
+func (b *memoryBlock) free(offset uint64) error {
+	i := b.find(offset)
+	if i < 0 {
+		return fmt.Errorf("vkctx: no allocation at offset %d to free", offset)
+	}
+
+	b.used -= b.ranges[i].size
+	b.allocations--
+	b.ranges[i].free = true
+	b.ranges[i].linear = false
+
+	if i+1 < len(b.ranges) && b.ranges[i+1].free {
+		b.ranges[i].size += b.ranges[i+1].size
+		b.ranges = append(b.ranges[:i+1], b.ranges[i+2:]...)
+	}
+	if i > 0 && b.ranges[i-1].free {
+		b.ranges[i-1].size += b.ranges[i].size
+		b.ranges = append(b.ranges[:i], b.ranges[i+1:]...)
+	}
+	return nil
+}
+
+// find returns the index of the allocation at offset, or -1.
+func (b *memoryBlock) find(offset uint64) int {
+	lo, hi := 0, len(b.ranges)
+	for lo < hi {
+		mid := (lo + hi) / 2
+		switch {
+		case b.ranges[mid].offset < offset:
+			lo = mid + 1
+		case b.ranges[mid].offset > offset:
+			hi = mid
+		default:
+			if b.ranges[mid].free {
+				return -1
+			}
+			return mid
+		}
+	}
+	return -1
+}
+
+// empty reports whether nothing is allocated from the block.
+func (b *memoryBlock) empty() bool {
+	return b.allocations == 0
+}
+
+// freeRanges returns the number of free ranges, which is a measure of fragmentation.
+func (b *memoryBlock) freeRanges() int {
+	n := 0
+	for _, r := range b.ranges {
+		if r.free {
+			n++
+		}
+	}
+	return n
+}
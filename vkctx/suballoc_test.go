@@ -0,0 +1,200 @@
+package vkctx
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/bbredesen/go-vk"
+)
+
+// allocateAll allocates each size from b with the given alignment and kind, and returns the offsets.
+func allocateAll(t *testing.T, b *memoryBlock, alignment uint64, linear bool, sizes ...uint64) []uint64 {
+	t.Helper()
+	var offsets []uint64
+	for _, size := range sizes {
+		offset, ok := b.allocate(size, alignment, linear)
+		if !ok {
+			t.Fatalf("could not allocate %d bytes", size)
+		}
+		offsets = append(offsets, offset)
+	}
+	return offsets
+}
+
+func TestBlockAlignment(t *testing.T) {
+	b := newMemoryBlock(1024, 1)
+
+	tests := []struct {
+		size, alignment, want uint64
+	}{
+		{10, 1, 0},
+		{16, 256, 256},
+		// The gap left by the previous alignment is used, rounded up to the alignment.
+		{8, 4, 12},
+		// What is left of the gap is used by the next allocations that fit in it.
+		{1, 0, 10},
+		// A zero size allocation still takes a byte, so that it has an offset of its own.
+		{0, 1, 11},
+		{100, 512, 512},
+	}
+	for _, test := range tests {
+		offset, ok := b.allocate(test.size, test.alignment, true)
+		if !ok || offset != test.want {
+			t.Errorf("allocate(%d, %d) = %d, %v; want %d", test.size, test.alignment, offset, ok, test.want)
+		}
+	}
+
+	if _, ok := b.allocate(500, 1, true); ok {
+		t.Error("allocated 500 bytes, but no free range is that big")
+	}
+	if b.allocations != len(tests) || b.used != 10+16+8+1+1+100 {
+		t.Errorf("block has %d allocations of %d bytes", b.allocations, b.used)
+	}
+}
+
+func TestBlockSplitAndCoalesce(t *testing.T) {
+	b := newMemoryBlock(1000, 1)
+	offsets := allocateAll(t, b, 1, true, 100, 100, 100)
+	if offsets[0] != 0 || offsets[1] != 100 || offsets[2] != 200 {
+		t.Fatalf("allocated at %v, want [0 100 200]", offsets)
+	}
+	if len(b.ranges) != 4 || b.freeRanges() != 1 {
+		t.Fatalf("after three allocations, %d ranges of which %d free; want 4 and 1", len(b.ranges), b.freeRanges())
+	}
+
+	steps := []struct {
+		free             uint64
+		ranges, freeOnes int
+	}{
+		// Between two allocations, so there is nothing to merge with.
+		{100, 4, 2},
+		// Merges with the range after it.
+		{0, 3, 2},
+		// Merges with the ranges on both sides, leaving the whole block free.
+		{200, 1, 1},
+	}
+	for _, step := range steps {
+		if err := b.free(step.free); err != nil {
+			t.Fatalf("free(%d): %s", step.free, err)
+		}
+		if len(b.ranges) != step.ranges || b.freeRanges() != step.freeOnes {
+			t.Errorf("after free(%d), %d ranges of which %d free; want %d and %d",
+				step.free, len(b.ranges), b.freeRanges(), step.ranges, step.freeOnes)
+		}
+	}
+
+	if !b.empty() || b.used != 0 || b.ranges[0] != (blockRange{offset: 0, size: 1000, free: true}) {
+		t.Errorf("block is not empty after freeing everything: %+v", b.ranges)
+	}
+	if offset, ok := b.allocate(1000, 1, true); !ok || offset != 0 {
+		t.Errorf("could not allocate the whole block after freeing everything")
+	}
+}
+
+func TestBlockBadFree(t *testing.T) {
+	b := newMemoryBlock(1000, 1)
+	allocateAll(t, b, 1, true, 100, 100)
+	if err := b.free(0); err != nil {
+		t.Fatalf("free(0): %s", err)
+	}
+
+	// A double free, an offset inside an allocation, the start of a free range, and an offset past the block.
+	for _, offset := range []uint64{0, 150, 200, 5000} {
+		if err := b.free(offset); err == nil {
+			t.Errorf("free(%d) succeeded, want an error", offset)
+		}
+	}
+	if b.allocations != 1 || b.used != 100 || len(b.ranges) != 3 {
+		t.Errorf("bad frees changed the block: %d allocations of %d bytes in %+v", b.allocations, b.used, b.ranges)
+	}
+}
+
+func TestBlockBufferImageGranularity(t *testing.T) {
+	b := newMemoryBlock(4096, 1024)
+
+	steps := []struct {
+		size   uint64
+		linear bool
+		want   uint64
+	}{
+		{100, true, 0},
+		// An optimal image can't share the buffer's page, so it moves to the next one.
+		{100, false, 1024},
+		// Another buffer can share the first page with the first one.
+		{10, true, 100},
+		// An image can't go straight after the buffers, so it is put after the other image, which it may share a page
+		// with.
+		{10, false, 1124},
+		// A buffer too big for the first page goes after the images, on a page of its own.
+		{1000, true, 2048},
+	}
+	for _, step := range steps {
+		offset, ok := b.allocate(step.size, 1, step.linear)
+		if !ok || offset != step.want {
+			t.Errorf("allocate(%d, linear %v) = %d, %v; want %d", step.size, step.linear, offset, ok, step.want)
+		}
+	}
+
+	// The free range before an image can hold a buffer only if the buffer ends on an earlier page than the image
+	// starts on.
+	b = newMemoryBlock(4096, 1024)
+	offsets := allocateAll(t, b, 1, false, 1500, 100)
+	if err := b.free(offsets[0]); err != nil {
+		t.Fatal(err)
+	}
+	if offset, ok := b.allocate(100, 1, true); !ok || offset != 0 {
+		t.Errorf("buffer before the image allocated at %d, %v; want 0", offset, ok)
+	}
+	// [100, 1300) would end on the image's page, so it goes after the image, on the next page.
+	if offset, ok := b.allocate(1200, 1, true); !ok || offset != 2048 {
+		t.Errorf("buffer ending on the image's page allocated at %d, %v; want 2048", offset, ok)
+	}
+}
+
+func TestPoolGrowth(t *testing.T) {
+	pool := &memoryPool{blockSize: 1024}
+	var sizes []uint64
+	newMemory := func(blockSize uint64) (vk.DeviceMemory, error) {
+		sizes = append(sizes, blockSize)
+		return vk.DeviceMemory(vk.NULL_HANDLE), nil
+	}
+
+	steps := []struct {
+		size        uint64
+		block       int
+		offset      uint64
+		blocks      int
+		dedicated   bool
+		description string
+	}{
+		{600, 0, 0, 1, true, "more than half a block gets a dedicated block"},
+		{400, 1, 0, 2, false, "dedicated blocks aren't shared, so a new block is allocated"},
+		{400, 1, 400, 2, false, "there is room in the shared block"},
+		{400, 2, 0, 3, false, "the shared block is full, so another is allocated"},
+		{100, 1, 800, 3, false, "the first shared block with room is used"},
+	}
+	for _, step := range steps {
+		b, offset, err := pool.allocate(step.size, 1, true, 1, newMemory)
+		if err != nil {
+			t.Fatalf("%s: %s", step.description, err)
+		}
+		if len(pool.blocks) != step.blocks || pool.blocks[step.block] != b || offset != step.offset ||
+			b.dedicated != step.dedicated {
+			t.Errorf("%s: allocated %d bytes at %d in a block of %d, with %d blocks",
+				step.description, step.size, offset, b.size, len(pool.blocks))
+		}
+	}
+	if fmt.Sprint(sizes) != "[600 1024 1024]" {
+		t.Errorf("allocated device memory blocks of %v, want [600 1024 1024]", sizes)
+	}
+
+	errOutOfMemory := errors.New("out of memory")
+	_, _, err := pool.allocate(1000, 1, true, 1, func(uint64) (vk.DeviceMemory, error) {
+		return vk.DeviceMemory(vk.NULL_HANDLE), errOutOfMemory
+	})
+	if !errors.Is(err, errOutOfMemory) || len(pool.blocks) != 3 {
+		t.Errorf("failed block allocation returned %v and left %d blocks, want the error and 3 blocks",
+			err, len(pool.blocks))
+	}
+}
@@ -1,12 +1,12 @@
 package vkctx
 
 import (
-	"fmt"
+	"errors"
 
 	"github.com/bbredesen/go-vk"
 )
 
-func (ctx *Context) createSwapchain() {
+func (ctx *Context) createSwapchain() error {
 	/*
 		This is a big function:
 		1) Determine general surface capabilities, like extents and swapchain limits
@@ -28,7 +28,7 @@ func (ctx *Context) createSwapchain() {
 	// 1) General capabilities
 	surfaceCapabilities, err := vk.GetPhysicalDeviceSurfaceCapabilitiesKHR(ctx.PhysicalDevice, ctx.Surface)
 	if err != nil {
-		panic("Could not get device surface capabilities: " + err.Error())
+		return wrap("get device surface capabilities", err)
 	}
 
 	// 2) Image formats
@@ -36,13 +36,16 @@ func (ctx *Context) createSwapchain() {
 		ctx.PhysicalDevice, ctx.Surface,
 	)
 	if err != nil {
-		panic("Could not get device surface formats: " + err.Error())
+		return wrap("get device surface formats", err)
+	}
+	if len(surfaceFormats) == 0 {
+		return wrap("get device surface formats", errors.New("the surface has no formats"))
 	}
 
 	// 3) Supported present modes
 	presentModes, err := vk.GetPhysicalDeviceSurfacePresentModesKHR(ctx.PhysicalDevice, ctx.Surface)
 	if err != nil {
-		panic("Could not get device surface present modes: " + err.Error())
+		return wrap("get device surface present modes", err)
 	}
 
 	// 4) Decide on swapchain size
@@ -140,8 +143,7 @@ func (ctx *Context) createSwapchain() {
 
 	swapchain, err := vk.CreateSwapchainKHR(ctx.Device, &createInfo, nil)
 	if err != nil {
-		fmt.Printf("Failed to create swapchain!")
-		panic(err)
+		return wrap("create swapchain", err)
 	}
 	ctx.Swapchain = swapchain
 	ctx.SwapchainImageFormat = selectedSurfaceFormat.Format
@@ -150,32 +152,40 @@ func (ctx *Context) createSwapchain() {
 	// 10) Finally, get the swapchain images and save them
 	images, err := vk.GetSwapchainImagesKHR(ctx.Device, ctx.Swapchain)
 	if err != nil {
-		fmt.Printf("Could not get images after createing swapchain!\n")
-		panic(err)
+		return wrap("get swapchain images", err)
 	}
 	ctx.SwapchainImages = images
-
+	return nil
 }
 
-func (app *Context) createSwapchainImageViews() {
+func (app *Context) createSwapchainImageViews() error {
 	// Careful...if image views already exist, this will cause a leak. Call destroyImageViews() first if you are
 	// rebuilding the swapchain!
 	app.SwapchainImageViews = make([]vk.ImageView, len(app.SwapchainImages))
 
 	for i, img := range app.SwapchainImages {
-		app.SwapchainImageViews[i] = app.createImageView(img, app.SwapchainImageFormat, vk.IMAGE_ASPECT_COLOR_BIT)
+		var err error
+		if app.SwapchainImageViews[i], err = app.CreateImageView(img, app.SwapchainImageFormat, vk.IMAGE_ASPECT_COLOR_BIT); err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
-func (app *Context) createDepthResources() {
-	app.DepthImage, app.DepthImageMemory = app.CreateImage(app.SwapchainExtent, vk.FORMAT_D32_SFLOAT, 0, vk.IMAGE_USAGE_DEPTH_STENCIL_ATTACHMENT_BIT, vk.MEMORY_PROPERTY_DEVICE_LOCAL_BIT)
-	app.DepthImageView = app.CreateImageView(app.DepthImage, vk.FORMAT_D32_SFLOAT, vk.IMAGE_ASPECT_DEPTH_BIT)
+func (app *Context) createDepthResources() error {
+	var err error
+	if app.DepthImage, app.DepthImageMemory, err = app.CreateImage(app.SwapchainExtent, vk.FORMAT_D32_SFLOAT, 0, vk.IMAGE_USAGE_DEPTH_STENCIL_ATTACHMENT_BIT, vk.MEMORY_PROPERTY_DEVICE_LOCAL_BIT); err != nil {
+		return err
+	}
+	app.DepthImageView, err = app.CreateImageView(app.DepthImage, vk.FORMAT_D32_SFLOAT, vk.IMAGE_ASPECT_DEPTH_BIT)
+	return err
 }
 
 func (app *Context) destroyDepthResources() {
 	vk.DestroyImageView(app.Device, app.DepthImageView, nil)
-	vk.FreeMemory(app.Device, app.DepthImageMemory, nil)
+	app.Free(app.DepthImageMemory)
 	vk.DestroyImage(app.Device, app.DepthImage, nil)
+	app.DepthImageView, app.DepthImageMemory, app.DepthImage = vk.ImageView(vk.NULL_HANDLE), nil, vk.Image(vk.NULL_HANDLE)
 }
 
 func (app *Context) destroyImageViews() {
@@ -187,44 +197,35 @@ func (app *Context) destroyImageViews() {
 
 func (app *Context) cleanupSwapchain() {
 
-	for _, iv := range app.SwapchainImageViews {
-		vk.DestroyImageView(app.Device, iv, nil)
-	}
+	app.destroyImageViews()
 
 	app.destroyDepthResources()
 
 	vk.DestroySwapchainKHR(app.Device, app.Swapchain, nil)
+	app.Swapchain = vk.SwapchainKHR(vk.NULL_HANDLE)
 }
 
-func (app *Context) recreateSwapchain() {
+// RecreateSwapchain rebuilds the swapchain, image views, and depth buffer at the surface's current extent, e.g. after
+// the window is resized. Framebuffers refer to the old image views, so the caller must wait for the device to be idle
+// and destroy them before calling this, and create new ones after. If it fails, the swapchain is left destroyed, and Teardown is the only valid call.
+func (app *Context) RecreateSwapchain() error {
 	if err := vk.DeviceWaitIdle(app.Device); err != nil {
-		panic(err)
+		return wrap("wait for device idle", err)
 	}
 
 	app.cleanupSwapchain()
 
-	app.createSwapchain()
-	app.createSwapchainImageViews()
-}
-
-func (app *Context) createImageView(image vk.Image, format vk.Format, aspectMask vk.ImageAspectFlags) vk.ImageView {
-	ivCI := vk.ImageViewCreateInfo{
-		Image:    image,
-		ViewType: vk.IMAGE_VIEW_TYPE_2D,
-		Format:   format,
-		SubresourceRange: vk.ImageSubresourceRange{
-			AspectMask:     aspectMask,
-			BaseMipLevel:   0,
-			LevelCount:     1,
-			BaseArrayLayer: 0,
-			LayerCount:     1,
-		},
-	}
-
-	if iv, err := vk.CreateImageView(app.Device, &ivCI, nil); err != nil {
-		panic("Could not create image view: " + err.Error())
-	} else {
-		return iv
+	if err := app.createSwapchain(); err != nil {
+		return err
+	}
+	if err := app.createSwapchainImageViews(); err != nil {
+		return err
+	}
+	if err := app.createDepthResources(); err != nil {
+		return err
 	}
 
+	// The device is idle, so no image is in use, and the new swapchain may have a different image count.
+	app.resetImagesInFlight()
+	return nil
 }